@@ -1,7 +1,21 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/varungujarathi9/job-queue/internal/auth"
+	"github.com/varungujarathi9/job-queue/internal/certs"
+	"github.com/varungujarathi9/job-queue/internal/config"
 	"github.com/varungujarathi9/job-queue/internal/handlers"
+	"github.com/varungujarathi9/job-queue/internal/logging"
+	"github.com/varungujarathi9/job-queue/internal/storage"
+	"github.com/varungujarathi9/job-queue/internal/tracing"
 	"github.com/varungujarathi9/job-queue/internal/utils"
 )
 
@@ -13,8 +27,84 @@ import (
 // @host localhost:8080
 // @BasePath /jobs
 func main() {
+	// settings come from the flags, then the environment, then the config file
+	cfg, err := config.Load(os.Args[0], os.Args[1:], os.Getenv, os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(2)
+	}
+
 	// create a logger and start the handler mux
-	utils.InitLogger()
-	handlers.Init()
+	logFile, err := logging.Setup(utils.Logger, cfg.Log.Options())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to open log: "+err.Error())
+		os.Exit(2)
+	}
+	defer logFile.Close()
+
+	var authenticator *auth.Authenticator
+	if cfg.Auth.Enabled {
+		keys, err := auth.LoadKeys(cfg.Auth.KeysFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(2)
+		}
+		authenticator = auth.NewAuthenticator(keys, []byte(cfg.Auth.TokenSecret))
+	}
+
+	quotas, defaultQuota := cfg.Quotas()
+
+	// SIGTERM and interrupts drain the queue and shut the server down gracefully
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	err = handlers.Init(ctx, handlers.Options{
+		Addr:            cfg.Addr,
+		Primary:         cfg.Replication.Primary,
+		SyncInterval:    cfg.Replication.SyncInterval,
+		Node:            cfg.Cluster.Node,
+		Peers:           cfg.Peers(),
+		EnqueueTimeout:  cfg.Timeouts.Enqueue,
+		DequeueTimeout:  cfg.Timeouts.Dequeue,
+		ReadTimeout:     cfg.Timeouts.Read,
+		WriteTimeout:    cfg.Timeouts.Write,
+		MaxWait:         cfg.Limits.MaxWait,
+		MaxPayloadBytes: cfg.Limits.MaxPayloadBytes,
+		MaxQueueDepth:   cfg.Limits.MaxQueueDepth,
+		Tenants:         quotas,
+		DefaultQuota:    defaultQuota,
+		Storage: storage.Options{
+			Backend:      cfg.Storage.Backend,
+			Path:         cfg.Storage.Path,
+			SyncInterval: cfg.Storage.SyncInterval,
+		},
+		ShutdownTimeout: cfg.Timeouts.Shutdown,
+		LeaseGrace:      cfg.Timeouts.LeaseGrace,
+		TLS: certs.Options{
+			CertFile:     cfg.TLS.CertFile,
+			KeyFile:      cfg.TLS.KeyFile,
+			ClientCAFile: cfg.TLS.ClientCAFile,
+			ClientAuth:   cfg.TLS.ClientAuth,
+		},
+		Consumers: cfg.Consumers(),
+		Auth:      authenticator,
+		PeerToken: cfg.Auth.PeerToken,
+		Tracing: tracing.Options{
+			Exporter: cfg.Tracing.Exporter,
+			Endpoint: cfg.Tracing.Endpoint,
+			Path:     cfg.Tracing.Path,
+		},
+		Webhooks: cfg.Webhooks.Options(),
+		Push:     cfg.Push.Options(),
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		stop()
+		logFile.Close()
+		os.Exit(1)
+	}
 
 }
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/varungujarathi9/job-queue/pkg/client"
+	"github.com/varungujarathi9/job-queue/pkg/jobqueue"
+)
+
+// stringList collects a flag that may be repeated
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// parseFlags parses the flags of a command, flags may come before the positional arguments
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	fs.SetOutput(io.Discard)
+	if err := fs.Parse(args); err != nil {
+		return usageError{err.Error()}
+	}
+	return nil
+}
+
+// jobIDArg reads the single job ID argument of a command
+func jobIDArg(fs *flag.FlagSet) (int, error) {
+	if fs.NArg() != 1 {
+		return 0, usageError{"expected exactly one job ID"}
+	}
+	id, err := strconv.Atoi(fs.Arg(0))
+	if err != nil {
+		return 0, usageError{"invalid job ID " + fs.Arg(0)}
+	}
+	return id, nil
+}
+
+func enqueueCommand(ctx context.Context, a *app, args []string) error {
+	fs := flag.NewFlagSet("enqueue", flag.ContinueOnError)
+	var files stringList
+	fs.Var(&files, "f", "file holding one or more JSON jobs, - reads stdin, may be repeated")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	// every argument is a JSON job, "-" reads a stream of jobs from stdin
+	var sources []io.Reader
+	for _, arg := range fs.Args() {
+		if arg == "-" {
+			sources = append(sources, os.Stdin)
+		} else {
+			sources = append(sources, strings.NewReader(arg))
+		}
+	}
+	for _, name := range files {
+		if name == "-" {
+			sources = append(sources, os.Stdin)
+			continue
+		}
+		file, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		sources = append(sources, file)
+	}
+	if len(sources) == 0 {
+		return usageError{"expected a JSON job, -f FILE or - for stdin"}
+	}
+
+	var ids []int
+	for _, source := range sources {
+		decoder := json.NewDecoder(source)
+		for {
+			var job jobqueue.Job
+			err := decoder.Decode(&job)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return fmt.Errorf("invalid job JSON: %v", err)
+			}
+			if job.Status == "" {
+				job.Status = jobqueue.StatusQueued
+			}
+			id, err := a.client.Enqueue(ctx, job)
+			if err != nil {
+				return err
+			}
+			ids = append(ids, id)
+		}
+	}
+	return a.out.ids(ids)
+}
+
+func dequeueCommand(ctx context.Context, a *app, args []string) error {
+	fs := flag.NewFlagSet("dequeue", flag.ContinueOnError)
+	consumer := fs.Int("consumer", 0, "queue consumer ID")
+	wait := fs.Duration("wait", 0, "how long to wait for a job when the queue is empty")
+	var types stringList
+	fs.Var(&types, "type", "only dequeue jobs of this type, may be repeated")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *consumer == 0 {
+		return usageError{"-consumer is required"}
+	}
+
+	job, err := a.client.Dequeue(ctx, *consumer, *wait, types...)
+	if err != nil {
+		return err
+	}
+	return a.out.job(job)
+}
+
+func concludeCommand(ctx context.Context, a *app, args []string) error {
+	fs := flag.NewFlagSet("conclude", flag.ContinueOnError)
+	result := fs.String("result", "", "JSON result to store with the job")
+	consumer := fs.Int("consumer", 0, "only conclude the job if this consumer dequeued it")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	id, err := jobIDArg(fs)
+	if err != nil {
+		return err
+	}
+
+	var value interface{}
+	if *result != "" {
+		if err := json.Unmarshal([]byte(*result), &value); err != nil {
+			return usageError{"invalid -result JSON: " + err.Error()}
+		}
+	}
+	if err := a.client.ConcludeAs(ctx, id, *consumer, value); err != nil {
+		return err
+	}
+	return a.out.status(id, "concluded")
+}
+
+func cancelCommand(ctx context.Context, a *app, args []string) error {
+	fs := flag.NewFlagSet("cancel", flag.ContinueOnError)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	id, err := jobIDArg(fs)
+	if err != nil {
+		return err
+	}
+	if err := a.client.Cancel(ctx, id); err != nil {
+		return err
+	}
+	return a.out.status(id, "cancelled")
+}
+
+func retryCommand(ctx context.Context, a *app, args []string) error {
+	fs := flag.NewFlagSet("retry", flag.ContinueOnError)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	id, err := jobIDArg(fs)
+	if err != nil {
+		return err
+	}
+	if err := a.client.Retry(ctx, id); err != nil {
+		return err
+	}
+	return a.out.status(id, "queued for retry")
+}
+
+func redriveCommand(ctx context.Context, a *app, args []string) error {
+	fs := flag.NewFlagSet("redrive", flag.ContinueOnError)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	id, err := jobIDArg(fs)
+	if err != nil {
+		return err
+	}
+	copyID, err := a.client.Redrive(ctx, id)
+	if err != nil {
+		return err
+	}
+	return a.out.ids([]int{copyID})
+}
+
+func getCommand(ctx context.Context, a *app, args []string) error {
+	fs := flag.NewFlagSet("get", flag.ContinueOnError)
+	wait := fs.Duration("wait", 0, "wait this long for the job to be concluded, failed or cancelled")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	id, err := jobIDArg(fs)
+	if err != nil {
+		return err
+	}
+	var job jobqueue.Job
+	if *wait > 0 {
+		job, err = a.client.Result(ctx, id, *wait)
+	} else {
+		job, err = a.client.Job(ctx, id)
+	}
+	if err != nil {
+		return err
+	}
+	return a.out.job(job)
+}
+
+func historyCommand(ctx context.Context, a *app, args []string) error {
+	fs := flag.NewFlagSet("history", flag.ContinueOnError)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	id, err := jobIDArg(fs)
+	if err != nil {
+		return err
+	}
+	history, err := a.client.History(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := a.out.changeHeader(); err != nil {
+		return err
+	}
+	for _, change := range history {
+		if err := a.out.change(change); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func listCommand(ctx context.Context, a *app, args []string) error {
+	fs := flag.NewFlagSet("list", flag.ContinueOnError)
+	status := fs.String("status", "", "only list jobs with this status")
+	jobType := fs.String("type", "", "only list jobs of this type")
+	queue := fs.String("queue", "", "only list jobs of this queue")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	jobs, err := a.client.List(ctx, strings.ToUpper(*status), *jobType)
+	if err != nil {
+		return err
+	}
+	if *queue != "" {
+		filtered := jobs[:0]
+		for _, job := range jobs {
+			if job.Queue == *queue {
+				filtered = append(filtered, job)
+			}
+		}
+		jobs = filtered
+	}
+	return a.out.jobs(jobs)
+}
+
+func statsCommand(ctx context.Context, a *app, args []string) error {
+	fs := flag.NewFlagSet("stats", flag.ContinueOnError)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	stats, err := a.client.Stats(ctx)
+	if err != nil {
+		return err
+	}
+	return a.out.stats(stats)
+}
+
+func tailCommand(ctx context.Context, a *app, args []string) error {
+	fs := flag.NewFlagSet("tail", flag.ContinueOnError)
+	since := fs.Int("since", -1, "sequence number to start after, -1 starts at the end of the stream")
+	var filter client.EventFilter
+	var queues, types, ops stringList
+	fs.Var(&queues, "queue", "only show changes of jobs in this queue, may be repeated")
+	fs.Var(&types, "type", "only show changes of jobs of this type, may be repeated")
+	fs.Var(&ops, "op", "only show changes with this operation, e.g. CONCLUDE, may be repeated")
+	jobID := fs.Int("job", 0, "only show changes of this job")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	filter.Queues, filter.Types, filter.Ops = queues, types, ops
+	if *jobID != 0 {
+		filter.JobIDs = []int{*jobID}
+	}
+
+	if err := a.out.changeHeader(); err != nil {
+		return err
+	}
+	// the server pushes the changes as they are recorded and the client resumes dropped streams
+	err := a.client.Events(ctx, *since, filter, a.out.change)
+	if errors.Is(err, context.Canceled) {
+		return nil
+	}
+	return err
+}
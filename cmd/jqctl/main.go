@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/varungujarathi9/job-queue/pkg/client"
+)
+
+const usage = `jqctl is the command-line admin tool for the job queue.
+
+Usage:
+  jqctl [global flags] <command> [command flags] [arguments]
+
+Commands:
+  enqueue    enqueue jobs given as JSON arguments, files (-f) or stdin (-)
+  dequeue    dequeue the next job as a consumer
+  conclude   conclude a dequeued job
+  cancel     cancel a job
+  retry      put a job that left the queue back into it
+  redrive    enqueue a copy of a concluded, failed or cancelled job
+  get        show a job, optionally once it is done (-wait)
+  history    show the changes recorded for a job
+  list       list jobs, optionally filtered by status and type
+  stats      count jobs by status and type
+  tail       follow the queue's event stream, optionally filtered
+
+Global flags:
+`
+
+// command runs one subcommand with its arguments
+type command func(ctx context.Context, app *app, args []string) error
+
+var commands = map[string]command{
+	"enqueue":  enqueueCommand,
+	"dequeue":  dequeueCommand,
+	"conclude": concludeCommand,
+	"cancel":   cancelCommand,
+	"retry":    retryCommand,
+	"redrive":  redriveCommand,
+	"get":      getCommand,
+	"history":  historyCommand,
+	"list":     listCommand,
+	"stats":    statsCommand,
+	"tail":     tailCommand,
+}
+
+// app holds what every command needs
+type app struct {
+	client *client.Client
+	out    *printer
+}
+
+// usageError is reported with exit status 2
+type usageError struct {
+	message string
+}
+
+func (e usageError) Error() string {
+	return e.message
+}
+
+func main() {
+	global := flag.NewFlagSet("jqctl", flag.ContinueOnError)
+	server := global.String("server", envOr("JQ_SERVER", "http://localhost:8080"), "base URL of the job queue server, defaults to $JQ_SERVER")
+	output := global.String("o", "table", "output format, table or json")
+	timeout := global.Duration("timeout", 30*time.Second, "timeout of each request")
+	retries := global.Int("retries", 2, "retries of idempotent requests on network errors and 5xx responses")
+	token := global.String("token", os.Getenv("JQ_TOKEN"), "API key or signed token, defaults to $JQ_TOKEN")
+	tenant := global.String("tenant", os.Getenv("JQ_TENANT"), "only see the jobs of this tenant, defaults to $JQ_TENANT")
+	caFile := global.String("ca", os.Getenv("JQ_CA"), "CA file the server certificate is verified against, defaults to $JQ_CA")
+	certFile := global.String("cert", os.Getenv("JQ_CERT"), "client certificate file for mutual TLS, defaults to $JQ_CERT")
+	keyFile := global.String("key", os.Getenv("JQ_KEY"), "client private key file for mutual TLS, defaults to $JQ_KEY")
+	global.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		global.PrintDefaults()
+	}
+	if err := global.Parse(os.Args[1:]); err != nil {
+		os.Exit(2)
+	}
+	if global.NArg() == 0 {
+		global.Usage()
+		os.Exit(2)
+	}
+
+	name := global.Arg(0)
+	run, ok := commands[name]
+	if !ok {
+		fmt.Fprintln(os.Stderr, "unknown command "+name+", expected one of "+strings.Join(commandNames(), ", "))
+		os.Exit(2)
+	}
+	out, err := newPrinter(os.Stdout, *output)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(2)
+	}
+
+	httpClient, err := newHTTPClient(*caFile, *certFile, *keyFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	a := &app{
+		client: client.New(*server, client.WithHTTPClient(httpClient), client.WithToken(*token), client.WithTenant(*tenant),
+			client.WithTimeout(*timeout), client.WithRetries(*retries, 200*time.Millisecond)),
+		out: out,
+	}
+	if err := run(ctx, a, global.Args()[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, name+": "+err.Error())
+		if _, ok := err.(usageError); ok {
+			os.Exit(2)
+		}
+		os.Exit(1)
+	}
+}
+
+func commandNames() []string {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// newHTTPClient returns the HTTP client for the server, trusting caFile and presenting the
+// client certificate when they are given
+func newHTTPClient(caFile, certFile, keyFile string) (*http.Client, error) {
+	if caFile == "" && certFile == "" {
+		return http.DefaultClient, nil
+	}
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", caFile)
+		}
+	}
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config
+	return &http.Client{Transport: transport}, nil
+}
+
+func envOr(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
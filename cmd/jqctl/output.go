@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/varungujarathi9/job-queue/pkg/jobqueue"
+)
+
+// printer writes command results as an aligned table or as JSON
+type printer struct {
+	w    io.Writer
+	json bool
+}
+
+func newPrinter(w io.Writer, format string) (*printer, error) {
+	switch format {
+	case "table":
+		return &printer{w: w}, nil
+	case "json":
+		return &printer{w: w, json: true}, nil
+	}
+	return nil, fmt.Errorf("unknown output format %s, expected table or json", format)
+}
+
+func (p *printer) encode(v interface{}) error {
+	encoder := json.NewEncoder(p.w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// table writes the header and rows with aligned columns
+func (p *printer) table(header string, rows []string) error {
+	tw := tabwriter.NewWriter(p.w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, header)
+	for _, row := range rows {
+		fmt.Fprintln(tw, row)
+	}
+	return tw.Flush()
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format("2006-01-02 15:04:05")
+}
+
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+const jobHeader = "ID\tTYPE\tSTATUS\tQUEUE\tKEY\tCONSUMER\tCANCELLED\tENQUEUED\tDEQUEUED"
+
+func jobRow(job jobqueue.Job) string {
+	consumer := "-"
+	if job.ConsumedBy != 0 {
+		consumer = strconv.Itoa(job.ConsumedBy)
+	}
+	return fmt.Sprintf("%d\t%s\t%s\t%s\t%s\t%s\t%t\t%s\t%s", job.ID, job.Type, job.Status,
+		orDash(job.Queue), orDash(job.Key), consumer, job.Cancel, formatTime(job.EnqueueTime), formatTime(job.DequeueTime))
+}
+
+func (p *printer) ids(ids []int) error {
+	if p.json {
+		return p.encode(ids)
+	}
+	rows := make([]string, len(ids))
+	for i, id := range ids {
+		rows[i] = strconv.Itoa(id)
+	}
+	return p.table("ID", rows)
+}
+
+func (p *printer) status(id int, status string) error {
+	if p.json {
+		return p.encode(map[string]interface{}{"ID": id, "Status": status})
+	}
+	_, err := fmt.Fprintf(p.w, "job %d %s\n", id, status)
+	return err
+}
+
+// job prints one job, the table form also shows its payload, result and error
+func (p *printer) job(job jobqueue.Job) error {
+	if p.json {
+		return p.encode(job)
+	}
+	if err := p.table(jobHeader, []string{jobRow(job)}); err != nil {
+		return err
+	}
+	for _, field := range []struct {
+		name  string
+		value interface{}
+	}{{"Payload", job.Payload}, {"Result", job.Result}} {
+		if field.value == nil {
+			continue
+		}
+		data, _ := json.MarshalIndent(field.value, "", "  ")
+		fmt.Fprintf(p.w, "\n%s:\n%s\n", field.name, data)
+	}
+	if job.Error != "" {
+		fmt.Fprintf(p.w, "\nError: %s\n", job.Error)
+	}
+	return nil
+}
+
+func (p *printer) jobs(jobs []jobqueue.Job) error {
+	if p.json {
+		return p.encode(jobs)
+	}
+	rows := make([]string, len(jobs))
+	for i, job := range jobs {
+		rows[i] = jobRow(job)
+	}
+	return p.table(jobHeader, rows)
+}
+
+func (p *printer) stats(stats jobqueue.Stats) error {
+	if p.json {
+		return p.encode(stats)
+	}
+	rows := []string{
+		"total\t\t" + strconv.Itoa(stats.Total),
+		"cancelled\t\t" + strconv.Itoa(stats.Cancelled),
+	}
+	for _, group := range []struct {
+		name   string
+		counts map[string]int
+	}{{"status", stats.ByStatus}, {"type", stats.ByType}} {
+		keys := make([]string, 0, len(group.counts))
+		for key := range group.counts {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			rows = append(rows, group.name+"\t"+key+"\t"+strconv.Itoa(group.counts[key]))
+		}
+	}
+	return p.table("GROUP\tVALUE\tJOBS", rows)
+}
+
+// changeHeader starts a stream of changes, JSON output has one change per line instead
+func (p *printer) changeHeader() error {
+	if p.json {
+		return nil
+	}
+	_, err := fmt.Fprintln(p.w, "SEQ  TIME                 OP          ID   TYPE               STATUS")
+	return err
+}
+
+// change prints one change of a stream, lines are written as they arrive so no alignment is done across them
+func (p *printer) change(change jobqueue.Change) error {
+	if p.json {
+		return json.NewEncoder(p.w).Encode(change)
+	}
+	_, err := fmt.Fprintf(p.w, "%-4d %s  %-10s  %-4d %-17s  %s\n", change.Seq, formatTime(change.Time),
+		change.Op, change.Job.ID, change.Job.Type, change.Job.Status)
+	return err
+}
@@ -16,9 +16,92 @@ const docTemplate = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
+        "/": {
+            "get": {
+                "description": "Lists Jobs, optionally filtered by status and type",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "List Jobs",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Job status",
+                        "name": "status",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Job type",
+                        "name": "type",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only act on the Jobs of this tenant",
+                        "name": "QUEUE_TENANT",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/jobqueue.Job"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/changes": {
+            "get": {
+                "description": "Returns the changes recorded after the given sequence number",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Change stream",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Last sequence number already seen",
+                        "name": "since",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Maximum number of changes to return",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only act on the Jobs of this tenant",
+                        "name": "QUEUE_TENANT",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/services.ChangeFeed"
+                        }
+                    },
+                    "400": {
+                        "description": "Malformed request",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    }
+                }
+            }
+        },
         "/dequeue": {
             "get": {
-                "description": "Dequeues a Job from the queue",
+                "description": "Dequeues a Job from the queue, optionally waiting for one to be enqueued",
                 "produces": [
                     "application/json"
                 ],
@@ -30,25 +113,56 @@ const docTemplate = `{
                         "name": "QUEUE_CONSUMER",
                         "in": "header",
                         "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "How long to wait for a job, e.g. 10s",
+                        "name": "wait",
+                        "in": "query"
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "type": "string"
+                        },
+                        "collectionFormat": "multi",
+                        "description": "Only dequeue Jobs of these types",
+                        "name": "type",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only act on the Jobs of this tenant",
+                        "name": "QUEUE_TENANT",
+                        "in": "header"
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/models.Job"
+                            "$ref": "#/definitions/jobqueue.Job"
+                        },
+                        "headers": {
+                            "traceparent": {
+                                "type": "string",
+                                "description": "W3C trace context stored with the Job"
+                            }
                         }
                     },
+                    "204": {
+                        "description": "No job available"
+                    },
                     "400": {
-                        "description": "Bad Request",
+                        "description": "Malformed request",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/apierror.Error"
                         }
                     },
-                    "404": {
-                        "description": "Not Found",
+                    "403": {
+                        "description": "Identity does not match the headers",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/apierror.Error"
                         }
                     }
                 }
@@ -68,8 +182,20 @@ const docTemplate = `{
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/models.Job"
+                            "$ref": "#/definitions/jobqueue.Job"
                         }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only act on the Jobs of this tenant",
+                        "name": "QUEUE_TENANT",
+                        "in": "header"
+                    },
+                    {
+                        "type": "string",
+                        "description": "W3C trace context stored with the Job",
+                        "name": "traceparent",
+                        "in": "header"
                     }
                 ],
                 "responses": {
@@ -80,9 +206,231 @@ const docTemplate = `{
                         }
                     },
                     "400": {
-                        "description": "Bad Request",
+                        "description": "Malformed request",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "403": {
+                        "description": "Identity does not match the headers",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "413": {
+                        "description": "Payload too large",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "422": {
+                        "description": "Missing fields or invalid Type",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "429": {
+                        "description": "Queue full or rate limited",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "503": {
+                        "description": "Queue is draining",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    }
+                }
+            }
+        },
+        "/events": {
+            "get": {
+                "description": "Streams the changes as server-sent events, one change event per change with its sequence number as event ID. The stream starts after the Last-Event-ID header, the since query parameter or the newest change, in that order.",
+                "produces": [
+                    "text/event-stream"
+                ],
+                "summary": "Event stream",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Sequence number of the last change seen, resumes the stream after it",
+                        "name": "Last-Event-ID",
+                        "in": "header"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Sequence number to start after when there is no Last-Event-ID",
+                        "name": "since",
+                        "in": "query"
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "type": "string"
+                        },
+                        "collectionFormat": "multi",
+                        "description": "Only stream the changes of Jobs in these queues, default for Jobs without one",
+                        "name": "queue",
+                        "in": "query"
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "type": "string"
+                        },
+                        "collectionFormat": "multi",
+                        "description": "Only stream the changes of Jobs of these types",
+                        "name": "type",
+                        "in": "query"
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "type": "integer"
+                        },
+                        "collectionFormat": "multi",
+                        "description": "Only stream the changes of these Jobs",
+                        "name": "job_id",
+                        "in": "query"
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "type": "string"
+                        },
+                        "collectionFormat": "multi",
+                        "description": "Only stream these operations, e.g. ENQUEUE or EXPIRE",
+                        "name": "op",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only act on the Jobs of this tenant",
+                        "name": "QUEUE_TENANT",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/jobqueue.Change"
+                        }
+                    },
+                    "400": {
+                        "description": "Malformed request",
                         "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    }
+                }
+            }
+        },
+        "/push": {
+            "get": {
+                "description": "Upgrades to a WebSocket that pushes Jobs to the consumer as they arrive, in JOB frames. The consumer holds up to prefetch Jobs at once, each ACK or FAIL frame for one of them lets the server push the next. ACK, FAIL and HEARTBEAT frames are answered with a frame of the same Op, holding an Error when it could not be applied. Jobs still held when the connection closes go back into the queue. In a cluster only the Jobs of the node the connection is made to are pushed.",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Push Jobs",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Queue Consumer ID",
+                        "name": "QUEUE_CONSUMER",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "type": "array",
+                        "items": {
                             "type": "string"
+                        },
+                        "collectionFormat": "multi",
+                        "description": "Only push Jobs of these types",
+                        "name": "type",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only push Jobs of this queue, default for Jobs without one",
+                        "name": "queue",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "How many Jobs the consumer may hold without acknowledging them, 1 by default",
+                        "name": "prefetch",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only act on the Jobs of this tenant",
+                        "name": "QUEUE_TENANT",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "101": {
+                        "description": "Switching Protocols",
+                        "schema": {
+                            "$ref": "#/definitions/services.PushFrame"
+                        }
+                    },
+                    "400": {
+                        "description": "Malformed request",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "403": {
+                        "description": "Identity does not match the headers",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    }
+                }
+            }
+        },
+        "/replication": {
+            "get": {
+                "description": "Reports the replication role of this node",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Replication status",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/stats": {
+            "get": {
+                "description": "Counts Jobs by status and type",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Job Stats",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Only act on the Jobs of this tenant",
+                        "name": "QUEUE_TENANT",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/jobqueue.Stats"
                         }
                     }
                 }
@@ -108,27 +456,86 @@ const docTemplate = `{
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/models.Job"
+                            "$ref": "#/definitions/jobqueue.Job"
                         }
                     },
                     "400": {
-                        "description": "Bad Request",
+                        "description": "Malformed request",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "403": {
+                        "description": "Identity does not match the headers",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
                         }
                     },
                     "404": {
-                        "description": "Not Found",
+                        "description": "Job not found",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    }
+                }
+            }
+        },
+        "/{job_id}/cancel": {
+            "delete": {
+                "description": "Cancels a Job by ID",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Cancel Job",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Job ID",
+                        "name": "job_id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Job cancelled successfully",
                         "schema": {
                             "type": "string"
                         }
+                    },
+                    "400": {
+                        "description": "Malformed request",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "403": {
+                        "description": "Identity does not match the headers",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "404": {
+                        "description": "Job not found",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "409": {
+                        "description": "Job is in the wrong state",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
                     }
                 }
             }
         },
         "/{job_id}/conclude": {
             "put": {
-                "description": "Concludes a Job by ID",
+                "description": "Concludes a Job by ID, optionally storing its result. With a consumer, only the consumer that dequeued the Job may conclude it.",
+                "consumes": [
+                    "application/json"
+                ],
                 "produces": [
                     "text/plain"
                 ],
@@ -140,6 +547,20 @@ const docTemplate = `{
                         "name": "job_id",
                         "in": "path",
                         "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Queue Consumer ID",
+                        "name": "QUEUE_CONSUMER",
+                        "in": "header"
+                    },
+                    {
+                        "description": "Job result",
+                        "name": "result",
+                        "in": "body",
+                        "schema": {
+                            "$ref": "#/definitions/services.ConcludeRequest"
+                        }
                     }
                 ],
                 "responses": {
@@ -150,38 +571,547 @@ const docTemplate = `{
                         }
                     },
                     "400": {
-                        "description": "Bad Request",
+                        "description": "Malformed request",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "403": {
+                        "description": "Identity does not match the headers",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
                         }
                     },
                     "404": {
-                        "description": "Not Found",
+                        "description": "Job not found",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "409": {
+                        "description": "Job is in the wrong state",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
                         }
                     }
                 }
             }
-        }
-    },
-    "definitions": {
-        "models.Job": {
-            "type": "object",
-            "properties": {
-                "ConsumedBy": {
-                    "type": "integer"
-                },
-                "ID": {
-                    "type": "integer"
-                },
-                "Payload": {},
-                "Result": {},
-                "Status": {
-                    "type": "string"
+        },
+        "/{job_id}/fail": {
+            "put": {
+                "description": "Marks a dequeued Job as failed, a failed Job can be retried",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Fail Job",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Job ID",
+                        "name": "job_id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Queue Consumer ID",
+                        "name": "QUEUE_CONSUMER",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "description": "Failure reason",
+                        "name": "reason",
+                        "in": "body",
+                        "schema": {
+                            "$ref": "#/definitions/services.FailRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Job failed",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "400": {
+                        "description": "Malformed request",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "403": {
+                        "description": "Identity does not match the headers",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "404": {
+                        "description": "Job not found",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "409": {
+                        "description": "Job is in the wrong state",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    }
+                }
+            }
+        },
+        "/{job_id}/heartbeat": {
+            "put": {
+                "description": "Extends the lease the consumer holds on a dequeued Job, fails once the Job is cancelled",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Job heartbeat",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Job ID",
+                        "name": "job_id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Queue Consumer ID",
+                        "name": "QUEUE_CONSUMER",
+                        "in": "header",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Heartbeat received",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "400": {
+                        "description": "Malformed request",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "403": {
+                        "description": "Identity does not match the headers",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "404": {
+                        "description": "Job not found",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "409": {
+                        "description": "Job is in the wrong state",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    }
+                }
+            }
+        },
+        "/{job_id}/history": {
+            "get": {
+                "description": "Returns the changes recorded for a Job, oldest first",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Job history",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Job ID",
+                        "name": "job_id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/jobqueue.Change"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Malformed request",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "403": {
+                        "description": "Identity does not match the headers",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "404": {
+                        "description": "Job not found",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    }
+                }
+            }
+        },
+        "/{job_id}/redrive": {
+            "post": {
+                "description": "Enqueues a copy of a Job that is concluded, failed or cancelled and returns the ID of the copy",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Re-drive Job",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Job ID",
+                        "name": "job_id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "400": {
+                        "description": "Malformed request",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "403": {
+                        "description": "Identity does not match the headers",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "404": {
+                        "description": "Job not found",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "409": {
+                        "description": "Job is in the wrong state",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "429": {
+                        "description": "Queue full or rate limited",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "503": {
+                        "description": "Queue is draining",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    }
+                }
+            }
+        },
+        "/{job_id}/result": {
+            "get": {
+                "description": "Returns a Job once it is concluded, failed or cancelled, with its Result or Error. With a wait the request is held until then, capped at the server's maximum wait.",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Job result",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Job ID",
+                        "name": "job_id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "How long to wait for the Job to be done, e.g. 30s",
+                        "name": "wait",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/jobqueue.Job"
+                        }
+                    },
+                    "204": {
+                        "description": "Job not done by the end of the wait"
+                    },
+                    "400": {
+                        "description": "Malformed request",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "403": {
+                        "description": "Identity does not match the headers",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "404": {
+                        "description": "Job not found",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    }
+                }
+            }
+        },
+        "/{job_id}/retry": {
+            "put": {
+                "description": "Puts a Job that left the queue back into it",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Retry Job",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Job ID",
+                        "name": "job_id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Job enqueued for retry",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "400": {
+                        "description": "Malformed request",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "403": {
+                        "description": "Identity does not match the headers",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "404": {
+                        "description": "Job not found",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    },
+                    "409": {
+                        "description": "Job is in the wrong state",
+                        "schema": {
+                            "$ref": "#/definitions/apierror.Error"
+                        }
+                    }
+                }
+            }
+        }
+    },
+    "definitions": {
+        "apierror.Error": {
+            "type": "object",
+            "properties": {
+                "Code": {
+                    "type": "string"
+                },
+                "Details": {
+                    "type": "object",
+                    "additionalProperties": true
+                },
+                "Message": {
+                    "type": "string"
+                }
+            }
+        },
+        "jobqueue.Change": {
+            "type": "object",
+            "properties": {
+                "Job": {
+                    "$ref": "#/definitions/jobqueue.Job"
+                },
+                "Op": {
+                    "type": "string"
+                },
+                "Seq": {
+                    "type": "integer"
+                },
+                "Time": {
+                    "type": "string"
+                }
+            }
+        },
+        "jobqueue.Job": {
+            "type": "object",
+            "properties": {
+                "Attempts": {
+                    "description": "Attempts counts how often the job was dequeued",
+                    "type": "integer"
+                },
+                "Callback": {
+                    "description": "Callback is a URL that is sent a signed POST when the job goes through one of the\nCallbackEvents, by default when it is concluded, failed, cancelled or expired, or its lease expires",
+                    "type": "string"
+                },
+                "CallbackEvents": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "Cancel": {
+                    "type": "boolean"
+                },
+                "ConsumedBy": {
+                    "type": "integer"
+                },
+                "Error": {
+                    "type": "string"
+                },
+                "ID": {
+                    "type": "integer"
+                },
+                "Key": {
+                    "type": "string"
+                },
+                "Payload": {},
+                "Queue": {
+                    "type": "string"
+                },
+                "Result": {},
+                "Status": {
+                    "type": "string"
+                },
+                "Tenant": {
+                    "type": "string"
+                },
+                "TraceParent": {
+                    "description": "TraceParent and TraceState hold the W3C trace context of the request that enqueued the job",
+                    "type": "string"
+                },
+                "TraceState": {
+                    "type": "string"
                 },
                 "Type": {
                     "type": "string"
+                },
+                "dequeueTime": {
+                    "type": "string"
+                },
+                "enqueueTime": {
+                    "type": "string"
+                },
+                "heartbeatTime": {
+                    "description": "HeartbeatTime is when the consumer last reported progress, a job whose heartbeat\nis older than the dequeue timeout goes back into the queue",
+                    "type": "string"
+                }
+            }
+        },
+        "jobqueue.Stats": {
+            "type": "object",
+            "properties": {
+                "ByStatus": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "integer"
+                    }
+                },
+                "ByType": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "integer"
+                    }
+                },
+                "Cancelled": {
+                    "type": "integer"
+                },
+                "Total": {
+                    "type": "integer"
+                }
+            }
+        },
+        "services.ChangeFeed": {
+            "type": "object",
+            "properties": {
+                "Changes": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/jobqueue.Change"
+                    }
+                },
+                "Epoch": {
+                    "description": "Epoch identifies the stream, it changes when the server restarts",
+                    "type": "string"
+                },
+                "Seq": {
+                    "type": "integer"
+                }
+            }
+        },
+        "services.ConcludeRequest": {
+            "type": "object",
+            "properties": {
+                "Result": {}
+            }
+        },
+        "services.FailRequest": {
+            "type": "object",
+            "properties": {
+                "Error": {
+                    "type": "string"
+                }
+            }
+        },
+        "services.PushFrame": {
+            "type": "object",
+            "properties": {
+                "Error": {
+                    "$ref": "#/definitions/apierror.Error"
+                },
+                "Job": {
+                    "$ref": "#/definitions/jobqueue.Job"
+                },
+                "JobID": {
+                    "type": "integer"
+                },
+                "Op": {
+                    "type": "string"
+                },
+                "Reason": {
+                    "type": "string"
+                },
+                "Result": {
+                    "description": "Result is stored with an acknowledged job and Reason with a failed one"
+                },
+                "Status": {
+                    "description": "Error is set on the answer to a frame that could not be applied, along with the\nStatus the REST API answers the same error with",
+                    "type": "integer"
                 }
             }
         }
@@ -0,0 +1,93 @@
+// Package apierror writes the errors of the REST API as one JSON envelope.
+//
+// Every error response has a Content-Type of application/json and a body like
+//
+//	{"Code": "not_found", "Message": "Job not found", "Details": {"JobID": 7}}
+//
+// Code is stable and meant for programs, Message is meant for people and may change,
+// and Details holds what else is known about the error, e.g. the job ID.
+package apierror
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Codes of the errors, the comment of each one names the status it is answered with
+const (
+	CodeInvalidRequest   = "invalid_request"     // 400, malformed IDs, headers, query parameters and bodies
+	CodeUnauthenticated  = "unauthenticated"     // 401
+	CodeForbidden        = "forbidden"           // 403
+	CodeNotFound         = "not_found"           // 404, unknown jobs, keys, nodes and routes
+	CodeMethodNotAllowed = "method_not_allowed"  // 405
+	CodeJobNotDequeued   = "job_not_dequeued"    // 409, the job must be dequeued first
+	CodeJobConcluded     = "job_concluded"       // 409
+	CodeJobQueued        = "job_queued"          // 409
+	CodeJobNotInProgress = "job_not_in_progress" // 409
+	CodeJobNotOwner      = "job_not_owner"       // 409, another consumer holds the job
+	CodeJobCancelled     = "job_cancelled"       // 409
+	CodeJobNotDone       = "job_not_done"        // 409, the job is queued or in progress
+	CodeConflict         = "conflict"            // 409, e.g. a key or node that exists
+	CodePayloadTooLarge  = "payload_too_large"   // 413
+	CodeMissingFields    = "missing_fields"      // 422
+	CodeInvalidType      = "invalid_type"        // 422
+	CodeRateLimited      = "rate_limited"        // 429, the tenant enqueues faster than its quota
+	CodeQueueFull        = "queue_full"          // 429, the queue or the tenant's share of it is full
+	CodeInternal         = "internal"            // 500
+	CodeBadGateway       = "bad_gateway"         // 502, another node cannot be reached
+	CodeDraining         = "draining"            // 503
+	CodeUnavailable      = "unavailable"         // 503, the server recovers or is not ready
+)
+
+// Error is the body of an error response
+type Error struct {
+	// Status is the HTTP status the error is answered with
+	Status  int                    `json:"-"`
+	Code    string                 `json:"Code"`
+	Message string                 `json:"Message"`
+	Details map[string]interface{} `json:"Details,omitempty"`
+}
+
+// New creates an error answered with status
+func New(status int, code, message string) *Error {
+	return &Error{Status: status, Code: code, Message: message}
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// With returns a copy of e with a detail added
+func (e *Error) With(key string, value interface{}) *Error {
+	details := make(map[string]interface{}, len(e.Details)+1)
+	for k, v := range e.Details {
+		details[k] = v
+	}
+	details[key] = value
+	copied := *e
+	copied.Details = details
+	return &copied
+}
+
+// Write answers the request with err
+func Write(w http.ResponseWriter, err *Error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(err.Status)
+	json.NewEncoder(w).Encode(err)
+}
+
+// InvalidRequest answers with 400 for a malformed request
+func InvalidRequest(w http.ResponseWriter, message string) {
+	Write(w, New(http.StatusBadRequest, CodeInvalidRequest, message))
+}
+
+// NotFoundService answers requests for unknown routes
+func NotFoundService(w http.ResponseWriter, r *http.Request) {
+	Write(w, New(http.StatusNotFound, CodeNotFound, "Route not found").With("Path", r.URL.Path))
+}
+
+// MethodNotAllowedService answers requests with a method the route does not serve
+func MethodNotAllowedService(w http.ResponseWriter, r *http.Request) {
+	Write(w, New(http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed").With("Method", r.Method))
+}
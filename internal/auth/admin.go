@@ -0,0 +1,116 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/varungujarathi9/job-queue/internal/apierror"
+	"github.com/varungujarathi9/job-queue/internal/logging"
+	"github.com/varungujarathi9/job-queue/internal/utils"
+)
+
+// KeyRequest is the body of a request for a new key or token
+type KeyRequest struct {
+	Identity
+	// TTL is how long a token is valid, e.g. 24h, empty never expires
+	TTL string `json:"TTL,omitempty"`
+}
+
+// KeyResponse returns a new key or token, the secret is only ever shown once
+type KeyResponse struct {
+	Identity
+	Key     string `json:"Key,omitempty"`
+	Token   string `json:"Token,omitempty"`
+	Expires int64  `json:"Expires,omitempty"`
+}
+
+func (a *Authenticator) decodeRequest(w http.ResponseWriter, r *http.Request) (KeyRequest, bool) {
+	var req KeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.InvalidRequest(w, "Invalid body: "+err.Error())
+		return req, false
+	}
+	if req.Name == "" || !ValidRole(req.Role) || (req.Role == RoleConsumer && req.Consumer <= 0) {
+		apierror.InvalidRequest(w, "Name, a valid Role and a positive Consumer for consumers are required")
+		return req, false
+	}
+	if req.Role != RoleConsumer {
+		req.Consumer = 0
+	}
+	return req, true
+}
+
+// ListKeysService lists the names, roles and consumer IDs of the API keys
+func (a *Authenticator) ListKeysService(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(a.keys.List())
+}
+
+// CreateKeyService creates an API key and returns its secret
+func (a *Authenticator) CreateKeyService(w http.ResponseWriter, r *http.Request) {
+	req, ok := a.decodeRequest(w, r)
+	if !ok {
+		return
+	}
+	secret, err := a.keys.Add(req.Identity)
+	if errors.Is(err, ErrKeyExists) {
+		apierror.Write(w, apierror.New(http.StatusConflict, apierror.CodeConflict, "Key already exists").With("Name", req.Name))
+		return
+	}
+	if err != nil {
+		logging.FromContext(r.Context(), utils.Logger).Error("Error in saving keys: " + err.Error())
+		apierror.Write(w, apierror.New(http.StatusInternalServerError, apierror.CodeInternal, "Failed to save keys"))
+		return
+	}
+	logging.FromContext(r.Context(), utils.Logger).Info("Key created for " + req.Name)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(KeyResponse{Identity: req.Identity, Key: secret})
+}
+
+// DeleteKeyService revokes an API key
+func (a *Authenticator) DeleteKeyService(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	err := a.keys.Remove(name)
+	if errors.Is(err, ErrKeyNotFound) {
+		apierror.Write(w, apierror.New(http.StatusNotFound, apierror.CodeNotFound, "Key not found").With("Name", name))
+		return
+	}
+	if err != nil {
+		logging.FromContext(r.Context(), utils.Logger).Error("Error in saving keys: " + err.Error())
+		apierror.Write(w, apierror.New(http.StatusInternalServerError, apierror.CodeInternal, "Failed to save keys"))
+		return
+	}
+	logging.FromContext(r.Context(), utils.Logger).Info("Key deleted for " + name)
+	w.Write([]byte(`{"status" : "Key deleted"}`))
+}
+
+// CreateTokenService signs a bearer token, tokens cannot be revoked before they expire
+func (a *Authenticator) CreateTokenService(w http.ResponseWriter, r *http.Request) {
+	if len(a.secret) == 0 {
+		apierror.Write(w, apierror.New(http.StatusNotFound, apierror.CodeNotFound, "Signed tokens are not enabled"))
+		return
+	}
+	req, ok := a.decodeRequest(w, r)
+	if !ok {
+		return
+	}
+	claims := Claims{Identity: req.Identity}
+	if req.TTL != "" {
+		ttl, err := time.ParseDuration(req.TTL)
+		if err != nil || ttl <= 0 {
+			apierror.InvalidRequest(w, "Invalid TTL: "+req.TTL)
+			return
+		}
+		claims.Expires = time.Now().Add(ttl).Unix()
+	}
+	token, err := SignToken(a.secret, claims)
+	if err != nil {
+		apierror.Write(w, apierror.New(http.StatusInternalServerError, apierror.CodeInternal, err.Error()))
+		return
+	}
+	logging.FromContext(r.Context(), utils.Logger).Info("Token signed for " + req.Name)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(KeyResponse{Identity: req.Identity, Token: token, Expires: claims.Expires})
+}
@@ -0,0 +1,101 @@
+// Package auth identifies the clients of the REST API.
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Roles a client can have
+const (
+	// RoleProducer may only enqueue jobs
+	RoleProducer = "producer"
+	// RoleConsumer may dequeue jobs and report on the jobs it holds
+	RoleConsumer = "consumer"
+	// RoleViewer may read jobs, stats and the change stream
+	RoleViewer = "viewer"
+	// RoleAdmin may use every endpoint
+	RoleAdmin = "admin"
+)
+
+// Roles lists every role
+var Roles = []string{RoleProducer, RoleConsumer, RoleViewer, RoleAdmin}
+
+// ValidRole reports whether role is one of Roles
+func ValidRole(role string) bool {
+	for _, known := range Roles {
+		if role == known {
+			return true
+		}
+	}
+	return false
+}
+
+// Identity is an authenticated client
+type Identity struct {
+	// Name identifies the client, e.g. the common name of its certificate or the name of its key
+	Name string `json:"Name" yaml:"name"`
+	// Role is one of Roles
+	Role string `json:"Role" yaml:"role"`
+	// Consumer is the queue consumer ID the client dequeues as, zero when it is not a consumer
+	Consumer int `json:"Consumer,omitempty" yaml:"consumer,omitempty"`
+	// Tenant confines the client to the jobs of one tenant, empty lets it see every tenant
+	Tenant string `json:"Tenant,omitempty" yaml:"tenant,omitempty"`
+}
+
+type identityKey struct{}
+
+// WithIdentity returns a copy of ctx carrying the client identity
+func WithIdentity(ctx context.Context, identity Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, identity)
+}
+
+// FromContext returns the identity of the client that sent a request, if it was authenticated
+func FromContext(ctx context.Context) (Identity, bool) {
+	identity, ok := ctx.Value(identityKey{}).(Identity)
+	return identity, ok
+}
+
+// ParseConsumers reads a list of NAME=CONSUMER_ID pairs separated by commas
+func ParseConsumers(spec string) (map[string]int, error) {
+	consumers := make(map[string]int)
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		name, idPart, found := strings.Cut(pair, "=")
+		if !found || name == "" {
+			return nil, fmt.Errorf("invalid identity %q, expected NAME=CONSUMER_ID", pair)
+		}
+		id, err := strconv.Atoi(idPart)
+		if err != nil || id <= 0 {
+			return nil, fmt.Errorf("invalid consumer ID %q of identity %s", idPart, name)
+		}
+		consumers[name] = id
+	}
+	return consumers, nil
+}
+
+// ClientCertificates identifies clients by the common name of their verified certificate.
+// A name listed in consumers is a consumer with the ID it maps to, any other name is a
+// producer. Requests without a client certificate pass through unidentified.
+func ClientCertificates(consumers map[string]int) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			name := r.TLS.VerifiedChains[0][0].Subject.CommonName
+			identity := Identity{Name: name, Role: RoleProducer}
+			if consumer, ok := consumers[name]; ok {
+				identity.Role, identity.Consumer = RoleConsumer, consumer
+			}
+			next.ServeHTTP(w, r.WithContext(WithIdentity(r.Context(), identity)))
+		})
+	}
+}
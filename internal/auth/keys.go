@@ -0,0 +1,207 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	// ErrKeyExists is returned when a key is added under a name that is taken
+	ErrKeyExists = errors.New("key already exists")
+	// ErrKeyNotFound is returned when a key to remove does not exist
+	ErrKeyNotFound = errors.New("key not found")
+)
+
+// Key is an entry of the keys file. Hand-written entries may hold the plain Key, keys
+// added through the admin API only store its SHA-256 hash.
+type Key struct {
+	Identity  `yaml:",inline"`
+	Key       string `json:"-" yaml:"key,omitempty"`
+	KeySHA256 string `json:"-" yaml:"key_sha256,omitempty"`
+}
+
+// keysFile is the layout of the keys file, YAML or JSON
+type keysFile struct {
+	Keys []Key `yaml:"keys"`
+}
+
+// KeyStore holds the API keys, it is safe for concurrent use
+type KeyStore struct {
+	path string
+
+	mutex  sync.Mutex
+	keys   map[string]Key
+	hashes map[string]string
+}
+
+// LoadKeys reads the keys file at path, an empty path keeps the keys in memory only and
+// a missing file starts with no keys
+func LoadKeys(path string) (*KeyStore, error) {
+	s := &KeyStore{path: path, keys: make(map[string]Key), hashes: make(map[string]string)}
+	if path == "" {
+		return s, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var file keysFile
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&file); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("invalid keys file %s: %v", path, err)
+	}
+	for _, key := range file.Keys {
+		if key.KeySHA256 == "" && key.Key != "" {
+			key.KeySHA256 = hashKey(key.Key)
+		}
+		if err := s.add(key); err != nil {
+			return nil, fmt.Errorf("invalid keys file %s: key %q: %v", path, key.Name, err)
+		}
+	}
+	return s, nil
+}
+
+func hashKey(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// add validates and stores key, the caller must hold mutex
+func (s *KeyStore) add(key Key) error {
+	switch {
+	case key.Name == "":
+		return errors.New("name is required")
+	case !ValidRole(key.Role):
+		return fmt.Errorf("unknown role %q", key.Role)
+	case key.Role == RoleConsumer && key.Consumer <= 0:
+		return errors.New("a consumer needs a positive consumer ID")
+	case key.KeySHA256 == "":
+		return errors.New("key or key_sha256 is required")
+	}
+	if _, exists := s.keys[key.Name]; exists {
+		return ErrKeyExists
+	}
+	if key.Role != RoleConsumer {
+		key.Consumer = 0
+	}
+	key.Key = ""
+	s.keys[key.Name] = key
+	s.hashes[key.KeySHA256] = key.Name
+	return nil
+}
+
+// Lookup returns the identity of the key secret
+func (s *KeyStore) Lookup(secret string) (Identity, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	name, ok := s.hashes[hashKey(secret)]
+	if !ok {
+		return Identity{}, false
+	}
+	return s.keys[name].Identity, true
+}
+
+// List returns the identities of the keys ordered by name
+func (s *KeyStore) List() []Identity {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	identities := make([]Identity, 0, len(s.keys))
+	for _, key := range s.keys {
+		identities = append(identities, key.Identity)
+	}
+	sort.Slice(identities, func(i, j int) bool { return identities[i].Name < identities[j].Name })
+	return identities
+}
+
+// Add creates a key for identity and returns its secret, which is not stored
+func (s *KeyStore) Add(identity Identity) (string, error) {
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	secret := "jqk_" + hex.EncodeToString(random)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err := s.add(Key{Identity: identity, KeySHA256: hashKey(secret)}); err != nil {
+		return "", err
+	}
+	if err := s.save(); err != nil {
+		s.remove(identity.Name)
+		return "", err
+	}
+	return secret, nil
+}
+
+// Remove deletes the key with the given name
+func (s *KeyStore) Remove(name string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	key, exists := s.keys[name]
+	if !exists {
+		return ErrKeyNotFound
+	}
+	s.remove(name)
+	if err := s.save(); err != nil {
+		s.keys[name] = key
+		s.hashes[key.KeySHA256] = name
+		return err
+	}
+	return nil
+}
+
+// remove drops a key, the caller must hold mutex
+func (s *KeyStore) remove(name string) {
+	delete(s.hashes, s.keys[name].KeySHA256)
+	delete(s.keys, name)
+}
+
+// save rewrites the keys file with hashed keys only, the caller must hold mutex
+func (s *KeyStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+	var file keysFile
+	for _, key := range s.keys {
+		file.Keys = append(file.Keys, key)
+	}
+	sort.Slice(file.Keys, func(i, j int) bool { return file.Keys[i].Name < file.Keys[j].Name })
+	data, err := yaml.Marshal(file)
+	if err != nil {
+		return err
+	}
+
+	// write a temporary file and rename it so a crash never leaves a truncated keys file
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
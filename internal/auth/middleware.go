@@ -0,0 +1,150 @@
+package auth
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	"github.com/varungujarathi9/job-queue/internal/apierror"
+	"github.com/varungujarathi9/job-queue/internal/logging"
+	"github.com/varungujarathi9/job-queue/internal/utils"
+)
+
+// Anyone in a Policy opens a route to every client, authenticated or not
+const Anyone = "*"
+
+// Policy maps route names to the roles that may use them. Admins may use every route,
+// routes missing from the policy are open to admins only.
+type Policy map[string][]string
+
+// allows reports whether the role may use the named route
+func (p Policy) allows(route, role string) bool {
+	if role == RoleAdmin {
+		return true
+	}
+	for _, allowed := range p[route] {
+		if allowed == role || allowed == Anyone {
+			return true
+		}
+	}
+	return false
+}
+
+// public reports whether the named route is open to unauthenticated clients
+func (p Policy) public(route string) bool {
+	for _, allowed := range p[route] {
+		if allowed == Anyone {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticator identifies clients by the API key or signed token they send as a bearer token
+type Authenticator struct {
+	keys   *KeyStore
+	secret []byte
+}
+
+// NewAuthenticator creates an authenticator for the keys, signed tokens are only accepted when secret is set
+func NewAuthenticator(keys *KeyStore, secret []byte) *Authenticator {
+	return &Authenticator{keys: keys, secret: secret}
+}
+
+// Keys returns the key store
+func (a *Authenticator) Keys() *KeyStore {
+	return a.keys
+}
+
+// identify returns the identity of a bearer token
+func (a *Authenticator) identify(token string) (Identity, bool) {
+	if strings.HasPrefix(token, tokenPrefix) {
+		if len(a.secret) == 0 {
+			return Identity{}, false
+		}
+		claims, err := VerifyToken(a.secret, token, time.Now())
+		if err != nil {
+			utils.Logger.Info("Rejected token: " + err.Error())
+			return Identity{}, false
+		}
+		return claims.Identity, true
+	}
+	return a.keys.Lookup(token)
+}
+
+// Authenticate identifies the clients that send an Authorization bearer token, it
+// overrides the identity of a client certificate. An unknown token is refused. GET
+// requests may pass the token in the access_token query parameter instead, since
+// browsers cannot set headers on an event stream.
+func (a *Authenticator) Authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("Authorization")
+		if token := r.URL.Query().Get("access_token"); header == "" && token != "" && r.Method == http.MethodGet {
+			header = "Bearer " + token
+		}
+		if header == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		token, found := strings.CutPrefix(header, "Bearer ")
+		identity, ok := a.identify(strings.TrimSpace(token))
+		if !found || !ok {
+			logging.FromContext(r.Context(), utils.Logger).Info("Invalid credentials")
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			apierror.Write(w, apierror.New(http.StatusUnauthorized, apierror.CodeUnauthenticated, "Invalid credentials"))
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(WithIdentity(r.Context(), identity)))
+	})
+}
+
+// Authorize refuses requests from clients whose role may not use the matched route
+func Authorize(policy Policy) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			route := ""
+			if current := mux.CurrentRoute(r); current != nil {
+				route = current.GetName()
+			}
+			if policy.public(route) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			identity, ok := FromContext(r.Context())
+			if !ok {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				apierror.Write(w, apierror.New(http.StatusUnauthorized, apierror.CodeUnauthenticated, "Authentication required"))
+				return
+			}
+			logging.AddFields(r.Context(), logrus.Fields{"client": identity.Name})
+			if !policy.allows(route, identity.Role) {
+				logging.FromContext(r.Context(), utils.Logger).WithField("role", identity.Role).Info("Request forbidden")
+				apierror.Write(w, apierror.New(http.StatusForbidden, apierror.CodeForbidden, "Forbidden for role "+identity.Role).With("Role", identity.Role))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// BearerTransport adds a bearer token to every request it sends, e.g. from one node to another
+type BearerTransport struct {
+	Token string
+	Base  http.RoundTripper
+}
+
+func (t *BearerTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if t.Token == "" || r.Header.Get("Authorization") != "" {
+		return base.RoundTrip(r)
+	}
+	r = r.Clone(r.Context())
+	r.Header.Set("Authorization", "Bearer "+t.Token)
+	return base.RoundTrip(r)
+}
@@ -0,0 +1,68 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// tokenPrefix starts every signed token, telling them apart from API keys
+const tokenPrefix = "jqt."
+
+var (
+	// ErrInvalidToken is returned for a token that is malformed or not signed with the secret
+	ErrInvalidToken = errors.New("invalid token")
+	// ErrExpiredToken is returned for a token past its expiry
+	ErrExpiredToken = errors.New("token expired")
+)
+
+// Claims is the content of a signed token
+type Claims struct {
+	Identity
+	// Expires is the Unix time the token expires at, zero never expires
+	Expires int64 `json:"Expires,omitempty"`
+}
+
+// SignToken returns a bearer token for claims signed with HMAC-SHA256, it has the form
+// jqt.<base64url claims>.<base64url signature>
+func SignToken(secret []byte, claims Claims) (string, error) {
+	data, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return tokenPrefix + payload + "." + sign(secret, payload), nil
+}
+
+func sign(secret []byte, payload string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(tokenPrefix + payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyToken checks the signature and expiry of a token and returns its claims
+func VerifyToken(secret []byte, token string, now time.Time) (Claims, error) {
+	payload, signature, found := strings.Cut(strings.TrimPrefix(token, tokenPrefix), ".")
+	if !strings.HasPrefix(token, tokenPrefix) || !found {
+		return Claims{}, ErrInvalidToken
+	}
+	if !hmac.Equal([]byte(signature), []byte(sign(secret, payload))) {
+		return Claims{}, ErrInvalidToken
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return Claims{}, ErrInvalidToken
+	}
+	var claims Claims
+	if err := json.Unmarshal(data, &claims); err != nil || !ValidRole(claims.Role) {
+		return Claims{}, ErrInvalidToken
+	}
+	if claims.Expires != 0 && now.Unix() >= claims.Expires {
+		return Claims{}, ErrExpiredToken
+	}
+	return claims, nil
+}
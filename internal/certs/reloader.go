@@ -0,0 +1,176 @@
+// Package certs serves TLS certificates that are reloaded when their files change, so
+// rotated certificates are picked up without a restart.
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/varungujarathi9/job-queue/internal/utils"
+)
+
+// checkInterval is how often the files are checked for changes, at most
+const checkInterval = time.Second
+
+// ClientAuth values
+const (
+	// ClientAuthNone does not ask clients for a certificate
+	ClientAuthNone = "none"
+	// ClientAuthOptional verifies the certificate of the clients that send one
+	ClientAuthOptional = "optional"
+	// ClientAuthRequire only accepts clients with a valid certificate
+	ClientAuthRequire = "require"
+)
+
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	ClientAuthNone:     tls.NoClientCert,
+	ClientAuthOptional: tls.VerifyClientCertIfGiven,
+	ClientAuthRequire:  tls.RequireAndVerifyClientCert,
+}
+
+// Options locates the certificate files
+type Options struct {
+	CertFile string
+	KeyFile  string
+	// ClientCAFile holds the CAs client certificates are verified against, needed unless ClientAuth is none
+	ClientCAFile string
+	// ClientAuth is none, optional or require
+	ClientAuth string
+}
+
+// Reloader builds the TLS configuration from files and rebuilds it when they change
+type Reloader struct {
+	opts       Options
+	clientAuth tls.ClientAuthType
+
+	mutex    sync.Mutex
+	config   *tls.Config
+	modTimes []time.Time
+	checked  time.Time
+}
+
+// New loads the certificate files
+func New(opts Options) (*Reloader, error) {
+	if opts.ClientAuth == "" {
+		opts.ClientAuth = ClientAuthNone
+	}
+	clientAuth, ok := clientAuthTypes[opts.ClientAuth]
+	if !ok {
+		return nil, fmt.Errorf("unknown client auth %q", opts.ClientAuth)
+	}
+	if clientAuth != tls.NoClientCert && opts.ClientCAFile == "" {
+		return nil, fmt.Errorf("client auth %s needs a client CA file", opts.ClientAuth)
+	}
+
+	r := &Reloader{opts: opts, clientAuth: clientAuth}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// files returns the files the configuration is built from
+func (r *Reloader) files() []string {
+	files := []string{r.opts.CertFile, r.opts.KeyFile}
+	if r.opts.ClientCAFile != "" {
+		files = append(files, r.opts.ClientCAFile)
+	}
+	return files
+}
+
+// modTimesOf returns the modification times of the files
+func (r *Reloader) modTimesOf() ([]time.Time, error) {
+	files := r.files()
+	modTimes := make([]time.Time, len(files))
+	for i, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, err
+		}
+		modTimes[i] = info.ModTime()
+	}
+	return modTimes, nil
+}
+
+// Reload rebuilds the configuration from the files, on error the previous one is kept
+func (r *Reloader) Reload() error {
+	modTimes, err := r.modTimesOf()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(r.opts.CertFile, r.opts.KeyFile)
+	if err != nil {
+		return fmt.Errorf("loading certificate: %v", err)
+	}
+	config := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   r.clientAuth,
+	}
+	if r.opts.ClientCAFile != "" {
+		pem, err := os.ReadFile(r.opts.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("loading client CA: %v", err)
+		}
+		config.ClientCAs = x509.NewCertPool()
+		if !config.ClientCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("loading client CA: no certificate found in %s", r.opts.ClientCAFile)
+		}
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.config = config
+	r.modTimes = modTimes
+	return nil
+}
+
+// changed reports whether a file was modified since the last reload, checking at most once per checkInterval
+func (r *Reloader) changed() bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if time.Since(r.checked) < checkInterval {
+		return false
+	}
+	r.checked = time.Now()
+	modTimes, err := r.modTimesOf()
+	if err != nil {
+		return false
+	}
+	for i := range modTimes {
+		if !modTimes[i].Equal(r.modTimes[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// current returns the configuration built on the last successful reload
+func (r *Reloader) current() *tls.Config {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.config
+}
+
+// TLSConfig returns the server configuration, each handshake uses the latest certificates
+func (r *Reloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			if r.changed() {
+				// a half-written rotation fails to load and is retried on a later handshake
+				if err := r.Reload(); err != nil {
+					utils.Logger.Error("Failed to reload TLS certificates: " + err.Error())
+				} else {
+					utils.Logger.Info("Reloaded TLS certificates")
+				}
+			}
+			return r.current(), nil
+		},
+	}
+}
@@ -0,0 +1,842 @@
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	"github.com/varungujarathi9/job-queue/internal/apierror"
+	"github.com/varungujarathi9/job-queue/internal/logging"
+	"github.com/varungujarathi9/job-queue/internal/utils"
+	"github.com/varungujarathi9/job-queue/pkg/jobqueue"
+)
+
+const (
+	// ForwardedHeader marks a request forwarded by another node, such requests are served locally
+	ForwardedHeader = "X-Job-Queue-Forwarded"
+	// PeerHeader carries the peer token on the requests between nodes, a forwarded request
+	// without it is routed like any other
+	PeerHeader = "X-Job-Queue-Peer"
+
+	// MaxNodes bounds the node IDs. Every node hands out job IDs with this stride starting
+	// at its own node ID, so the node that created a job can be told from the job ID.
+	MaxNodes = 256
+
+	// maxMoved bounds how many of the jobs it moved away a node remembers the new node of,
+	// the jobs it forgot are looked for on every node
+	maxMoved = 10000
+	// transferAttempts is how often a job is sent to its new node before the transfer is
+	// left for transferRetry
+	transferAttempts = 3
+	// transferRetry is how long the transfers without an answer wait before they are sent again
+	transferRetry = 5 * time.Second
+)
+
+// errRefused marks a transfer the other node did not apply
+var errRefused = errors.New("job not taken")
+
+// Node is a member of the cluster
+type Node struct {
+	ID  int    `json:"ID"`
+	URL string `json:"URL"`
+}
+
+// Cluster partitions jobs across nodes by their queue name or job key
+type Cluster struct {
+	self      int
+	engine    *jobqueue.Engine
+	client    *http.Client
+	maxWait   time.Duration
+	peerToken string
+
+	mu    sync.Mutex
+	nodes map[int]string
+	ring  *Ring
+	moved map[int]int
+	// movedOrder holds the IDs of moved in the order they were moved, oldest first
+	movedOrder []int
+	cursor     int
+	// unsent holds the jobs exported to another node that did not answer, by job ID
+	unsent map[int]transfer
+}
+
+// transfer is a job on its way to another node
+type transfer struct {
+	node int
+	job  jobqueue.Job
+}
+
+// ParseNodes parses a member list of the form "1=http://host-a:8080,2=http://host-b:8080"
+func ParseNodes(spec string) (map[int]string, error) {
+	nodes := make(map[int]string)
+	for _, member := range strings.Split(spec, ",") {
+		member = strings.TrimSpace(member)
+		if member == "" {
+			continue
+		}
+		idPart, urlPart, found := strings.Cut(member, "=")
+		if !found {
+			return nil, fmt.Errorf("invalid member %q, expected ID=URL", member)
+		}
+		id, err := strconv.Atoi(idPart)
+		if err != nil {
+			return nil, fmt.Errorf("invalid member ID %q: %v", idPart, err)
+		}
+		nodes[id] = strings.TrimRight(urlPart, "/")
+	}
+	return nodes, nil
+}
+
+// IDSpace returns the engine option that makes node self hand out job IDs from its own ID space
+func IDSpace(self int) jobqueue.Option {
+	return jobqueue.WithIDSpace(self, MaxNodes)
+}
+
+// Option configures a Cluster
+type Option func(*Cluster)
+
+// WithHTTPClient sets the HTTP client used to notify the other nodes, e.g. one that authenticates
+func WithHTTPClient(client *http.Client) Option {
+	return func(c *Cluster) {
+		c.client = client
+	}
+}
+
+// WithMaxWait caps the wait of a dequeue across all nodes, it should match the nodes' own cap
+func WithMaxWait(wait time.Duration) Option {
+	return func(c *Cluster) {
+		c.maxWait = wait
+	}
+}
+
+// WithPeerToken sets the token the nodes send each other, only the requests carrying it
+// are trusted to be forwarded by another node. Without a token every request marked as
+// forwarded is, which is only safe when the API is open to everyone anyway.
+func WithPeerToken(token string) Option {
+	return func(c *Cluster) {
+		c.peerToken = token
+	}
+}
+
+// New creates the cluster view of node self, engine must be built with the IDSpace option of the same node
+func New(self int, nodes map[int]string, engine *jobqueue.Engine, opts ...Option) (*Cluster, error) {
+	if _, exists := nodes[self]; !exists {
+		return nil, fmt.Errorf("node %d is not a member of the cluster", self)
+	}
+	for id, base := range nodes {
+		if err := validateNode(Node{ID: id, URL: base}); err != nil {
+			return nil, err
+		}
+	}
+
+	c := &Cluster{
+		self:   self,
+		engine: engine,
+		client: &http.Client{Timeout: 10 * time.Second},
+		nodes:  nodes,
+		moved:  make(map[int]int),
+		unsent: make(map[int]transfer),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.ring = NewRing(c.members())
+	return c, nil
+}
+
+func validateNode(node Node) error {
+	if node.ID <= 0 || node.ID >= MaxNodes {
+		return fmt.Errorf("node ID %d out of range, must be between 1 and %d", node.ID, MaxNodes-1)
+	}
+	if _, err := url.ParseRequestURI(node.URL); err != nil {
+		return fmt.Errorf("invalid URL for node %d: %v", node.ID, err)
+	}
+	return nil
+}
+
+// members returns the sorted node IDs, the caller must hold mu unless the cluster is being built
+func (c *Cluster) members() []int {
+	ids := make([]int, 0, len(c.nodes))
+	for id := range c.nodes {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// shardKey returns the key a job is partitioned by, jobs without a key or queue name stay on the node that received them
+func shardKey(job *jobqueue.Job) string {
+	if job.Key != "" {
+		return job.Key
+	}
+	return job.Queue
+}
+
+// forwarded reports whether r was forwarded by another node
+func (c *Cluster) forwarded(r *http.Request) bool {
+	if r.Header.Get(ForwardedHeader) == "" {
+		return false
+	}
+	return c.peerToken == "" || subtle.ConstantTimeCompare([]byte(r.Header.Get(PeerHeader)), []byte(c.peerToken)) == 1
+}
+
+// markForwarded marks a request to another node as sent by this one
+func (c *Cluster) markForwarded(header http.Header) {
+	header.Set(ForwardedHeader, strconv.Itoa(c.self))
+	if c.peerToken != "" {
+		header.Set(PeerHeader, c.peerToken)
+	}
+}
+
+// remember records the node a job was moved to, forgetting the oldest move past maxMoved.
+// The caller must hold mu.
+func (c *Cluster) remember(id, node int) {
+	if _, known := c.moved[id]; !known {
+		c.movedOrder = append(c.movedOrder, id)
+	}
+	c.moved[id] = node
+	for len(c.movedOrder) > maxMoved {
+		delete(c.moved, c.movedOrder[0])
+		c.movedOrder = c.movedOrder[1:]
+	}
+}
+
+// ownerOfJob returns the node a queued job should live on
+func (c *Cluster) ownerOfJob(job *jobqueue.Job) (int, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := shardKey(job)
+	if key == "" {
+		if _, member := c.nodes[c.self]; member {
+			return c.self, true
+		}
+		// a node that left the cluster spreads its unkeyed jobs by ID
+		key = strconv.Itoa(job.ID)
+	}
+	return c.ring.Owner(key)
+}
+
+// forward proxies the request to the given node and marks it as forwarded
+func (c *Cluster) forward(w http.ResponseWriter, r *http.Request, node int) {
+	c.mu.Lock()
+	base := c.nodes[node]
+	c.mu.Unlock()
+
+	target, err := url.Parse(base)
+	if err != nil || base == "" {
+		utils.Logger.Error("Cannot forward to unknown node " + strconv.Itoa(node))
+		apierror.Write(w, apierror.New(http.StatusBadGateway, apierror.CodeBadGateway, "Unknown node").With("Node", node))
+		return
+	}
+	logging.AddFields(r.Context(), logrus.Fields{"forwarded_to": node})
+	logging.FromContext(r.Context(), utils.Logger).Info("Request forwarded to owning node")
+
+	c.markForwarded(r.Header)
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		// a dequeue that got a job elsewhere cancels its other forwards, which is no error
+		if r.Context().Err() == nil {
+			logging.FromContext(r.Context(), utils.Logger).WithError(err).Error("Cannot forward to node " + strconv.Itoa(node))
+		}
+		apierror.Write(w, apierror.New(http.StatusBadGateway, apierror.CodeBadGateway, "Node cannot be reached").With("Node", node))
+	}
+	proxy.ServeHTTP(w, r)
+}
+
+// EnqueueHandler forwards an enqueue to the node owning the job's shard key
+func (c *Cluster) EnqueueHandler(local http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if c.forwarded(r) {
+			local(w, r)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			utils.Logger.Error("Error in reading body: " + err.Error())
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				apierror.Write(w, apierror.New(http.StatusRequestEntityTooLarge, apierror.CodePayloadTooLarge, "Payload too large").With("Limit", tooLarge.Limit))
+				return
+			}
+			apierror.InvalidRequest(w, "Invalid body: "+err.Error())
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		// a body that cannot be decoded is left to the local handler to reject
+		var job jobqueue.Job
+		if json.Unmarshal(body, &job) == nil && shardKey(&job) != "" {
+			if owner, ok := c.ownerOfJob(&job); ok && owner != c.self {
+				c.forward(w, r, owner)
+				return
+			}
+		}
+		local(w, r)
+	}
+}
+
+// dequeueOrder returns the members rotated by one position on every call so that
+// consumers pull from all shards in a fair round-robin order
+func (c *Cluster) dequeueOrder() []int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ids := c.members()
+	if len(ids) == 0 {
+		return []int{c.self}
+	}
+	start := c.cursor % len(ids)
+	c.cursor++
+	return append(ids[start:], ids[:start]...)
+}
+
+// DequeueHandler pulls a job from the first shard in round-robin order that has one. Every
+// node is polled without waiting first, one after the other, so a waiting job is taken
+// from one node only. While the wait of the request lasts, all nodes then wait at once
+// until the same deadline. The first job won cancels the other waits, and a job another
+// node handed out before its wait was cancelled goes back into its queue.
+func (c *Cluster) DequeueHandler(local http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if c.forwarded(r) {
+			local(w, r)
+			return
+		}
+
+		// every node is sent the same body, a body that cannot be read is left to the local handler
+		var body []byte
+		if r.Body != nil {
+			body, _ = io.ReadAll(r.Body)
+		}
+		wait, ok := waitOf(r, body)
+		if !ok {
+			// the local handler rejects the wait
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			local(w, r)
+			return
+		}
+		if c.maxWait > 0 && wait > c.maxWait {
+			wait = c.maxWait
+		}
+		deadline := time.Now().Add(wait)
+		nodes := c.dequeueOrder()
+
+		var fallback *bufferedResponse
+		for _, node := range nodes {
+			resp := c.dequeueFrom(node, local, withWait(r, body, 0))
+			if resp.dequeued() {
+				resp.copyTo(w)
+				return
+			}
+			// prefer the local answer, it is the one a single node would have given
+			if fallback == nil || node == c.self {
+				fallback = resp
+			}
+		}
+		// a refused request is not retried, and neither is one whose wait is over
+		if fallback.status != http.StatusNoContent || time.Until(deadline) <= 0 {
+			fallback.copyTo(w)
+			return
+		}
+
+		ctx, cancel := context.WithDeadline(r.Context(), deadline)
+		defer cancel()
+		type answer struct {
+			node int
+			resp *bufferedResponse
+		}
+		answers := make(chan answer, len(nodes))
+		for _, node := range nodes {
+			go func(node int) {
+				wait := time.Until(deadline)
+				if wait < 0 {
+					wait = 0
+				}
+				answers <- answer{node, c.dequeueFrom(node, local, withWait(r.WithContext(ctx), body, wait))}
+			}(node)
+		}
+
+		var won *bufferedResponse
+		for range nodes {
+			a := <-answers
+			switch {
+			case a.resp.dequeued() && won == nil:
+				won = a.resp
+				cancel()
+			case a.resp.dequeued():
+				c.release(a.node, a.resp)
+			case a.node == c.self && won == nil:
+				fallback = a.resp
+			}
+		}
+		if won != nil {
+			won.copyTo(w)
+			return
+		}
+		fallback.copyTo(w)
+	}
+}
+
+// dequeueFrom sends a dequeue request to node, or serves it locally, and returns the answer
+func (c *Cluster) dequeueFrom(node int, local http.HandlerFunc, r *http.Request) *bufferedResponse {
+	resp := newBufferedResponse()
+	if node == c.self {
+		local(resp, r)
+	} else {
+		c.forward(resp, r, node)
+	}
+	return resp
+}
+
+// release puts a job a dequeue won on node back into the queue there, after the dequeue
+// got its job from another node
+func (c *Cluster) release(node int, resp *bufferedResponse) {
+	// /jobs/dequeue answers with the job and a v2 lease request with the lease
+	var won struct {
+		ID, JobID, ConsumedBy, Consumer int
+	}
+	json.Unmarshal(resp.body.Bytes(), &won)
+	release := Release{JobID: won.ID, Consumer: won.ConsumedBy}
+	if release.JobID == 0 {
+		release.JobID, release.Consumer = won.JobID, won.Consumer
+	}
+
+	var err error
+	if node == c.self {
+		err = c.engine.Release(release.JobID, release.Consumer)
+	} else {
+		c.mu.Lock()
+		base := c.nodes[node]
+		c.mu.Unlock()
+		body, _ := json.Marshal(release)
+		err = c.send(http.MethodPost, base+"/cluster/release", body)
+	}
+	if err != nil {
+		utils.Logger.Error("Error in releasing job " + strconv.Itoa(release.JobID) + " on node " + strconv.Itoa(node) + ": " + err.Error())
+	}
+}
+
+// waitOf returns the wait of a dequeue, read from the wait query of /jobs/dequeue or the
+// Wait field of a v2 lease request. It reports false for a wait that does not parse.
+func waitOf(r *http.Request, body []byte) (time.Duration, bool) {
+	value := r.URL.Query().Get("wait")
+	if value == "" {
+		var lease struct {
+			Wait string `json:"Wait"`
+		}
+		if json.Unmarshal(body, &lease) != nil || lease.Wait == "" {
+			return 0, true
+		}
+		value = lease.Wait
+	}
+	wait, err := time.ParseDuration(value)
+	return wait, err == nil && wait >= 0
+}
+
+// withWait returns a copy of a dequeue request that waits for wait, in the same place
+// waitOf read the wait from
+func withWait(r *http.Request, body []byte, wait time.Duration) *http.Request {
+	attempt := r.Clone(r.Context())
+	if query := attempt.URL.Query(); query.Get("wait") != "" || len(body) == 0 {
+		query.Set("wait", wait.String())
+		attempt.URL.RawQuery = query.Encode()
+	} else {
+		var fields map[string]json.RawMessage
+		if json.Unmarshal(body, &fields) == nil {
+			fields["Wait"], _ = json.Marshal(wait.String())
+			body, _ = json.Marshal(fields)
+		}
+	}
+	attempt.Body = io.NopCloser(bytes.NewReader(body))
+	attempt.ContentLength = int64(len(body))
+	return attempt
+}
+
+// JobHandler forwards a request for a single job to the node storing it. That is the node
+// the job was moved to, or else the node that created it, which is told by the job ID. A
+// node that created the job but forgot where it moved it, or a request for a job whose
+// creator left, asks every other node.
+func (c *Cluster) JobHandler(local http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["job_id"])
+		if err != nil || c.engine.Has(id) {
+			local(w, r)
+			return
+		}
+		forwarded := c.forwarded(r)
+		origin := id % MaxNodes
+
+		c.mu.Lock()
+		node, moved := c.moved[id]
+		_, member := c.nodes[node]
+		_, originMember := c.nodes[origin]
+		c.mu.Unlock()
+
+		switch {
+		case moved && member && node != c.self:
+			c.forward(w, r, node)
+		case forwarded && origin != c.self:
+			// forwarded requests only follow moves, so that a request reaches the job's creator at most once
+			local(w, r)
+		case !forwarded && origin != c.self && originMember:
+			c.forward(w, r, origin)
+		default:
+			c.search(w, r, local, id)
+		}
+	}
+}
+
+// search sends a request for a single job to every other node until one of them does not
+// answer with 404, and to the local handler when none has the job
+func (c *Cluster) search(w http.ResponseWriter, r *http.Request, local http.HandlerFunc, id int) {
+	var body []byte
+	if r.Body != nil {
+		body, _ = io.ReadAll(r.Body)
+	}
+	for _, node := range c.dequeueOrder() {
+		if node == c.self {
+			continue
+		}
+		resp := newBufferedResponse()
+		attempt := r.Clone(r.Context())
+		attempt.Body = io.NopCloser(bytes.NewReader(body))
+		c.forward(resp, attempt, node)
+		if resp.status != http.StatusNotFound {
+			c.mu.Lock()
+			c.remember(id, node)
+			c.mu.Unlock()
+			resp.copyTo(w)
+			return
+		}
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	local(w, r)
+}
+
+// ClusterService returns this node's view of the cluster
+func (c *Cluster) ClusterService(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	nodes := []Node{}
+	for _, id := range c.members() {
+		nodes = append(nodes, Node{ID: id, URL: c.nodes[id]})
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"Self":  c.self,
+		"Nodes": nodes,
+	})
+}
+
+// JoinService adds a node to the cluster and rebalances the queued jobs
+func (c *Cluster) JoinService(w http.ResponseWriter, r *http.Request) {
+	var node Node
+	if err := json.NewDecoder(r.Body).Decode(&node); err != nil {
+		utils.Logger.Error("Error in decoding body flow: " + err.Error())
+		apierror.InvalidRequest(w, "Invalid body: "+err.Error())
+		return
+	}
+	node.URL = strings.TrimRight(node.URL, "/")
+	if err := validateNode(node); err != nil {
+		utils.Logger.Info("Invalid node: " + err.Error())
+		apierror.InvalidRequest(w, "Invalid node: "+err.Error())
+		return
+	}
+
+	c.mu.Lock()
+	c.nodes[node.ID] = node.URL
+	c.ring = NewRing(c.members())
+	c.mu.Unlock()
+
+	if !c.forwarded(r) {
+		body, _ := json.Marshal(node)
+		c.broadcast(http.MethodPost, "/cluster/members", body)
+	}
+	go c.Rebalance()
+
+	utils.Logger.Info("Node " + strconv.Itoa(node.ID) + " joined the cluster")
+	fmt.Fprintf(w, `{"status" : "Node joined"}`)
+}
+
+// LeaveService removes a node from the cluster and rebalances the queued jobs
+func (c *Cluster) LeaveService(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["node_id"])
+	if err != nil {
+		utils.Logger.Error("Error in converting node_id: " + err.Error())
+		apierror.InvalidRequest(w, "Invalid node_id: "+mux.Vars(r)["node_id"])
+		return
+	}
+
+	c.mu.Lock()
+	if _, exists := c.nodes[id]; !exists {
+		c.mu.Unlock()
+		utils.Logger.Info("Node not found")
+		apierror.Write(w, apierror.New(http.StatusNotFound, apierror.CodeNotFound, "Node not found").With("Node", id))
+		return
+	}
+	if len(c.nodes) == 1 {
+		c.mu.Unlock()
+		utils.Logger.Info("Last node cannot leave the cluster")
+		apierror.Write(w, apierror.New(http.StatusConflict, apierror.CodeConflict, "Last node cannot leave the cluster").With("Node", id))
+		return
+	}
+	c.mu.Unlock()
+
+	// tell every node, including the leaving one, before it is forgotten here
+	if !c.forwarded(r) {
+		c.broadcast(http.MethodDelete, "/cluster/members/"+strconv.Itoa(id), nil)
+	}
+
+	c.mu.Lock()
+	delete(c.nodes, id)
+	c.ring = NewRing(c.members())
+	// the jobs moved to the node are handed over to the others, and are looked for there
+	for job, node := range c.moved {
+		if node == id {
+			delete(c.moved, job)
+		}
+	}
+	c.mu.Unlock()
+	go c.Rebalance()
+
+	utils.Logger.Info("Node " + strconv.Itoa(id) + " left the cluster")
+	fmt.Fprintf(w, `{"status" : "Node left"}`)
+}
+
+// Release names a job a consumer won on this node while its dequeue was answered by another node
+type Release struct {
+	JobID    int `json:"JobID"`
+	Consumer int `json:"Consumer"`
+}
+
+// ReleaseService puts a job back into the queue that a dequeue forwarded from another node
+// won after it got its job elsewhere
+func (c *Cluster) ReleaseService(w http.ResponseWriter, r *http.Request) {
+	var release Release
+	if err := json.NewDecoder(r.Body).Decode(&release); err != nil {
+		utils.Logger.Error("Error in decoding body flow: " + err.Error())
+		apierror.InvalidRequest(w, "Invalid body: "+err.Error())
+		return
+	}
+	if err := c.engine.Release(release.JobID, release.Consumer); err != nil {
+		utils.Logger.Info("Job not released: " + err.Error())
+		apierror.Write(w, apierror.New(http.StatusConflict, apierror.CodeConflict, "Job not in progress for the consumer").With("JobID", release.JobID))
+		return
+	}
+	fmt.Fprintf(w, `{"id" : `+strconv.Itoa(release.JobID)+`}`)
+}
+
+// ImportService accepts a queued job moved here from another node
+func (c *Cluster) ImportService(w http.ResponseWriter, r *http.Request) {
+	var job jobqueue.Job
+	if err := json.NewDecoder(r.Body).Decode(&job); err != nil {
+		utils.Logger.Error("Error in decoding body flow: " + err.Error())
+		apierror.InvalidRequest(w, "Invalid body: "+err.Error())
+		return
+	}
+	if err := c.engine.Import(job); err != nil {
+		utils.Logger.Info("Job not imported: " + err.Error())
+		apierror.Write(w, apierror.New(http.StatusConflict, apierror.CodeConflict, "Job ID already in use").With("JobID", job.ID))
+		return
+	}
+	// a job that comes back is found locally again
+	c.mu.Lock()
+	delete(c.moved, job.ID)
+	c.mu.Unlock()
+	fmt.Fprintf(w, `{"id" : `+strconv.Itoa(job.ID)+`}`)
+}
+
+// broadcast sends a membership change to every other node
+func (c *Cluster) broadcast(method, path string, body []byte) {
+	c.mu.Lock()
+	targets := make(map[int]string)
+	for id, base := range c.nodes {
+		if id != c.self {
+			targets[id] = base
+		}
+	}
+	c.mu.Unlock()
+
+	for id, base := range targets {
+		if err := c.send(method, base+path, body); err != nil {
+			utils.Logger.Error("Error in notifying node " + strconv.Itoa(id) + ": " + err.Error())
+		}
+	}
+}
+
+// send issues a forwarded request and fails unless the node answers with 200
+func (c *Cluster) send(method, target string, body []byte) error {
+	req, err := http.NewRequest(method, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	c.markForwarded(req.Header)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("node returned %s", resp.Status)
+	}
+	return nil
+}
+
+// Rebalance moves every queued job whose shard now belongs to another node over to that node
+func (c *Cluster) Rebalance() {
+	// the transfers left without an answer go to the same node again, which may hold the job already
+	c.mu.Lock()
+	unsent := c.unsent
+	c.unsent = make(map[int]transfer)
+	c.mu.Unlock()
+	for _, t := range unsent {
+		c.move(t.node, t.job)
+	}
+
+	for _, job := range c.engine.Queued() {
+		owner, ok := c.ownerOfJob(&job)
+		if !ok || owner == c.self {
+			continue
+		}
+
+		// the job is taken out of the local queue first so it cannot be dequeued twice
+		exported, ok := c.engine.Export(job.ID)
+		if !ok {
+			continue
+		}
+		c.move(owner, exported)
+	}
+
+	c.mu.Lock()
+	retry := len(c.unsent) > 0
+	c.mu.Unlock()
+	if retry {
+		time.AfterFunc(transferRetry, c.Rebalance)
+	}
+}
+
+// move hands a job exported from the local queue over to node. The job is taken back
+// when the node certainly did not take it. A transfer that got no answer may have been
+// applied, so it is kept and sent again later rather than taken back, which would leave
+// the job on two nodes.
+func (c *Cluster) move(node int, job jobqueue.Job) {
+	body, _ := json.Marshal(job)
+	c.mu.Lock()
+	base, member := c.nodes[node]
+	c.mu.Unlock()
+
+	// a node that left hands its own jobs over and refuses a second copy as ErrIDInUse, so
+	// the job can come back here
+	err := fmt.Errorf("%w: node %d left the cluster", errRefused, node)
+	for attempt := 0; member && attempt < transferAttempts; attempt++ {
+		if err = c.transfer(base, body); err == nil || errors.Is(err, errRefused) {
+			break
+		}
+	}
+
+	switch {
+	case err == nil:
+		c.mu.Lock()
+		c.remember(job.ID, node)
+		c.mu.Unlock()
+	case errors.Is(err, errRefused):
+		utils.Logger.Error("Error in moving job " + strconv.Itoa(job.ID) + ": " + err.Error())
+		if err := c.engine.Import(job); err != nil {
+			utils.Logger.Error("Error in taking back job " + strconv.Itoa(job.ID) + ": " + err.Error())
+		}
+	default:
+		utils.Logger.Error("Error in moving job " + strconv.Itoa(job.ID) + ", sending it again later: " + err.Error())
+		c.mu.Lock()
+		c.unsent[job.ID] = transfer{node: node, job: job}
+		c.mu.Unlock()
+	}
+}
+
+// transfer sends an exported job to the node at base. It returns nil once the node holds the
+// job, also when the node answers that it has it already because an earlier attempt got
+// through, and an error wrapping errRefused when the node certainly did not take it.
+func (c *Cluster) transfer(base string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, base+"/cluster/import", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("%w: %v", errRefused, err)
+	}
+	c.markForwarded(req.Header)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.client.Do(req)
+	// a request that could not connect was never sent
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return fmt.Errorf("%w: %v", errRefused, err)
+	}
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusConflict {
+		return fmt.Errorf("%w: node returned %s", errRefused, resp.Status)
+	}
+	return nil
+}
+
+// bufferedResponse holds a response in memory so the dequeue fan-out can pick which one to send
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newBufferedResponse() *bufferedResponse {
+	return &bufferedResponse{header: make(http.Header)}
+}
+
+func (b *bufferedResponse) Header() http.Header {
+	return b.header
+}
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	return b.body.Write(p)
+}
+
+func (b *bufferedResponse) WriteHeader(status int) {
+	if b.status == 0 {
+		b.status = status
+	}
+}
+
+// dequeued reports whether the response hands out a job, an empty queue answers with 204,
+// a dequeue with 200 and a lease with 201
+func (b *bufferedResponse) dequeued() bool {
+	return b.status >= 200 && b.status < 300 && b.status != http.StatusNoContent
+}
+
+func (b *bufferedResponse) copyTo(w http.ResponseWriter) {
+	for key, values := range b.header {
+		w.Header()[key] = values
+	}
+	if b.status != 0 {
+		w.WriteHeader(b.status)
+	}
+	w.Write(b.body.Bytes())
+}
@@ -0,0 +1,55 @@
+package cluster
+
+import (
+	"hash/crc32"
+	"sort"
+	"strconv"
+)
+
+// virtualNodes is the number of points each node gets on the ring, more points spread keys more evenly
+const virtualNodes = 64
+
+// Ring assigns keys to nodes with consistent hashing, so that adding or
+// removing a node only moves the keys of the ring segments next to it
+type Ring struct {
+	hashes []uint32
+	owners map[uint32]int
+}
+
+// NewRing builds a ring over the given node IDs
+func NewRing(nodes []int) *Ring {
+	ring := &Ring{owners: make(map[uint32]int)}
+	nodes = append([]int(nil), nodes...)
+	sort.Ints(nodes)
+	for _, node := range nodes {
+		for i := 0; i < virtualNodes; i++ {
+			hash := crc32.ChecksumIEEE([]byte(strconv.Itoa(node) + "#" + strconv.Itoa(i)))
+			// a point that hashes onto a taken one moves to the next free hash, so no node
+			// loses a point to another. The nodes are added in the order of their IDs, so
+			// every member probes alike.
+			for {
+				if _, taken := ring.owners[hash]; !taken {
+					break
+				}
+				hash++
+			}
+			ring.hashes = append(ring.hashes, hash)
+			ring.owners[hash] = node
+		}
+	}
+	sort.Slice(ring.hashes, func(i, j int) bool { return ring.hashes[i] < ring.hashes[j] })
+	return ring
+}
+
+// Owner returns the node the key belongs to, which is the first node clockwise from the key's hash
+func (ring *Ring) Owner(key string) (int, bool) {
+	if len(ring.hashes) == 0 {
+		return 0, false
+	}
+	hash := crc32.ChecksumIEEE([]byte(key))
+	i := sort.Search(len(ring.hashes), func(i int) bool { return ring.hashes[i] >= hash })
+	if i == len(ring.hashes) {
+		i = 0
+	}
+	return ring.owners[ring.hashes[i]], true
+}
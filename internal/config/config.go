@@ -0,0 +1,655 @@
+// Package config loads the server configuration.
+//
+// Every setting has a command-line flag, an environment variable named after it
+// (-log-level is JQ_LOG_LEVEL) and a key in the YAML or JSON file given with -config.
+// Flags take precedence over the environment, which takes precedence over the file.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/varungujarathi9/job-queue/internal/auth"
+	"github.com/varungujarathi9/job-queue/internal/certs"
+	"github.com/varungujarathi9/job-queue/internal/cluster"
+	"github.com/varungujarathi9/job-queue/internal/logging"
+	"github.com/varungujarathi9/job-queue/internal/push"
+	"github.com/varungujarathi9/job-queue/internal/storage"
+	"github.com/varungujarathi9/job-queue/internal/tracing"
+	"github.com/varungujarathi9/job-queue/internal/webhooks"
+	"github.com/varungujarathi9/job-queue/pkg/jobqueue"
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix starts the names of the environment variables
+const EnvPrefix = "JQ_"
+
+// Config holds every setting of the server
+type Config struct {
+	Addr        string      `yaml:"addr"`
+	Log         Log         `yaml:"log"`
+	Timeouts    Timeouts    `yaml:"timeouts"`
+	Limits      Limits      `yaml:"limits"`
+	Storage     Storage     `yaml:"storage"`
+	Replication Replication `yaml:"replication"`
+	Cluster     Cluster     `yaml:"cluster"`
+	TLS         TLS         `yaml:"tls"`
+	Auth        Auth        `yaml:"auth"`
+	Tracing     Tracing     `yaml:"tracing"`
+	Webhooks    Webhooks    `yaml:"webhooks"`
+	Push        Push        `yaml:"push"`
+	// Tenants holds the quotas of the tenants by name, the one named default applies to
+	// every tenant that is not listed. Tenants can only be configured in the file.
+	Tenants map[string]Tenant `yaml:"tenants"`
+}
+
+// Log configures the server log
+type Log struct {
+	// Output is file, stdout or stderr
+	Output string `yaml:"output"`
+	// Path is the log file, empty logs to stderr
+	Path string `yaml:"path"`
+	// Format is json or text
+	Format string `yaml:"format"`
+	// Level is one of the logrus levels, e.g. error or info
+	Level string `yaml:"level"`
+	// RotateSize rotates the log file past this many megabytes, 0 never does
+	RotateSize int `yaml:"rotate_size_mb"`
+	// RotateEvery rotates the log file at this interval, 0 never does
+	RotateEvery time.Duration `yaml:"rotate_every"`
+	// MaxBackups is how many rotated files are kept, 0 keeps them all
+	MaxBackups int `yaml:"max_backups"`
+	// MaxAge removes rotated files older than this, 0 keeps them
+	MaxAge time.Duration `yaml:"max_age"`
+	// Compress gzips rotated files
+	Compress bool `yaml:"compress"`
+}
+
+// Options returns the logging options of the log settings
+func (l Log) Options() logging.Options {
+	return logging.Options{
+		Output:      l.Output,
+		Path:        l.Path,
+		Format:      l.Format,
+		Level:       l.Level,
+		RotateSize:  l.RotateSize,
+		RotateEvery: l.RotateEvery,
+		MaxBackups:  l.MaxBackups,
+		MaxAge:      l.MaxAge,
+		Compress:    l.Compress,
+	}
+}
+
+// Timeouts configures how long jobs and requests may take
+type Timeouts struct {
+	// Enqueue is how long a job may wait in the queue before it expires
+	Enqueue time.Duration `yaml:"enqueue"`
+	// Dequeue is how long a dequeued job may go without a heartbeat before it is queued again
+	Dequeue time.Duration `yaml:"dequeue"`
+	// Read bounds reading a request, zero means no limit
+	Read time.Duration `yaml:"read"`
+	// Write bounds handling a request and writing its response, zero means no limit
+	Write time.Duration `yaml:"write"`
+	// Shutdown bounds a graceful shutdown, zero means no limit
+	Shutdown time.Duration `yaml:"shutdown"`
+	// LeaseGrace is how long jobs in progress get to finish on shutdown before they are queued again
+	LeaseGrace time.Duration `yaml:"lease_grace"`
+}
+
+// Limits bounds what clients may ask of the server, zero means no limit
+type Limits struct {
+	// MaxWait caps the wait of a long-polling dequeue or result
+	MaxWait time.Duration `yaml:"max_wait"`
+	// MaxPayloadBytes caps the size of a request body
+	MaxPayloadBytes int64 `yaml:"max_payload_bytes"`
+	// MaxQueueDepth caps how many jobs may wait in the queue
+	MaxQueueDepth int `yaml:"max_queue_depth"`
+}
+
+// Tracing selects where spans are exported
+type Tracing struct {
+	// Exporter is none, otlp or file
+	Exporter string `yaml:"exporter"`
+	// Endpoint is the URL of the OTLP/HTTP collector
+	Endpoint string `yaml:"endpoint"`
+	// Path is the file the file exporter appends JSON spans to
+	Path string `yaml:"path"`
+}
+
+// Webhooks sends signed callbacks when jobs change state
+type Webhooks struct {
+	// Secret signs the callbacks, empty disables webhooks
+	Secret string `yaml:"secret"`
+	// MaxAttempts is how many times a callback is sent before it fails
+	MaxAttempts int `yaml:"max_attempts"`
+	// Backoff is the wait before the first retry, it doubles for each retry after it
+	Backoff time.Duration `yaml:"backoff"`
+	// Timeout bounds one attempt
+	Timeout time.Duration `yaml:"timeout"`
+	// Subscriptions are sent the changes of every job they match. Subscriptions can only
+	// be configured in the file.
+	Subscriptions []WebhookSubscription `yaml:"subscriptions"`
+}
+
+// WebhookSubscription sends the changes of the jobs it matches to a URL
+type WebhookSubscription struct {
+	URL string `yaml:"url"`
+	// Events are the operations sent, e.g. CONCLUDE or FAIL
+	Events []string `yaml:"events"`
+	// Queue, Types and Tenant narrow down the jobs, empty matches every job
+	Queue  string   `yaml:"queue"`
+	Types  []string `yaml:"types"`
+	Tenant string   `yaml:"tenant"`
+	// Secret signs the callbacks instead of the webhook secret
+	Secret string `yaml:"secret"`
+}
+
+// Options returns the dispatcher options of the webhook settings
+func (wh Webhooks) Options() webhooks.Options {
+	subs := make([]webhooks.Subscription, 0, len(wh.Subscriptions))
+	for _, sub := range wh.Subscriptions {
+		subs = append(subs, webhooks.Subscription{
+			URL:    sub.URL,
+			Events: sub.Events,
+			Queue:  sub.Queue,
+			Types:  sub.Types,
+			Tenant: sub.Tenant,
+			Secret: sub.Secret,
+		})
+	}
+	return webhooks.Options{
+		Secret:        wh.Secret,
+		MaxAttempts:   wh.MaxAttempts,
+		Backoff:       wh.Backoff,
+		Timeout:       wh.Timeout,
+		Subscriptions: subs,
+	}
+}
+
+// Push sends jobs to HTTP workers that cannot poll the queue
+type Push struct {
+	// Secret signs the pushed jobs, empty sends them unsigned
+	Secret string `yaml:"secret"`
+	// MaxAttempts is how many attempts a pushed job gets before it stays failed
+	MaxAttempts int `yaml:"max_attempts"`
+	// Backoff is the wait before the first retry, it doubles for each retry after it
+	Backoff time.Duration `yaml:"backoff"`
+	// Timeout bounds one POST
+	Timeout time.Duration `yaml:"timeout"`
+	// Endpoints are sent the jobs they match. Endpoints can only be configured in the file.
+	Endpoints []PushEndpoint `yaml:"endpoints"`
+}
+
+// PushEndpoint is sent the jobs of some types or of a queue
+type PushEndpoint struct {
+	URL string `yaml:"url"`
+	// Consumer is the consumer ID the jobs are dequeued as
+	Consumer int `yaml:"consumer"`
+	// Types and Queue choose the jobs, Tenant narrows them down
+	Types  []string `yaml:"types"`
+	Queue  string   `yaml:"queue"`
+	Tenant string   `yaml:"tenant"`
+	// MaxConcurrency is how many jobs are sent at once
+	MaxConcurrency int `yaml:"max_concurrency"`
+}
+
+// Options returns the pusher options of the push settings
+func (p Push) Options() push.Options {
+	endpoints := make([]push.Endpoint, 0, len(p.Endpoints))
+	for _, e := range p.Endpoints {
+		endpoints = append(endpoints, push.Endpoint{
+			URL:            e.URL,
+			Consumer:       e.Consumer,
+			Types:          e.Types,
+			Queue:          e.Queue,
+			Tenant:         e.Tenant,
+			MaxConcurrency: e.MaxConcurrency,
+		})
+	}
+	return push.Options{
+		Secret:      p.Secret,
+		MaxAttempts: p.MaxAttempts,
+		Backoff:     p.Backoff,
+		Timeout:     p.Timeout,
+		Endpoints:   endpoints,
+	}
+}
+
+// DefaultTenant names the quota of the tenants that are not listed
+const DefaultTenant = "default"
+
+// Tenant holds the quota of one tenant, zero means no limit
+type Tenant struct {
+	// MaxQueueDepth caps how many of the tenant's jobs may wait in the queue
+	MaxQueueDepth int `yaml:"max_queue_depth"`
+	// EnqueueRate caps how many jobs per second the tenant may enqueue, EnqueueBurst of them at once
+	EnqueueRate  float64 `yaml:"enqueue_rate"`
+	EnqueueBurst int     `yaml:"enqueue_burst"`
+	// MaxPayloadBytes caps the size of a job's payload
+	MaxPayloadBytes int64 `yaml:"max_payload_bytes"`
+	// Weight is the tenant's share of the dequeues, 1 by default
+	Weight int `yaml:"weight"`
+}
+
+// Storage selects where jobs are kept
+type Storage struct {
+	// Backend is memory or file
+	Backend string `yaml:"backend"`
+	// Path is the journal file of the file backend
+	Path string `yaml:"path"`
+	// SyncInterval is how often the file backend writes new changes
+	SyncInterval time.Duration `yaml:"sync_interval"`
+}
+
+// Replication runs the server as a follower of a primary
+type Replication struct {
+	// Primary is the base URL of the primary, empty runs the server as a primary
+	Primary string `yaml:"primary"`
+	// SyncInterval is how often a follower polls the primary
+	SyncInterval time.Duration `yaml:"sync_interval"`
+}
+
+// Cluster makes the server a member of a sharded cluster
+type Cluster struct {
+	// Node is the ID of this server in the cluster
+	Node int `yaml:"node"`
+	// Peers lists the members as ID=URL pairs separated by commas, including this node
+	Peers string `yaml:"peers"`
+}
+
+// TLS makes the server speak HTTPS and optionally authenticate clients by certificate
+type TLS struct {
+	// CertFile and KeyFile hold the server certificate, they are reloaded when they change
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// ClientCAFile holds the CAs that sign client certificates
+	ClientCAFile string `yaml:"client_ca_file"`
+	// ClientAuth is none, optional or require
+	ClientAuth string `yaml:"client_auth"`
+	// Consumers maps certificate common names to consumer IDs as NAME=ID pairs separated by commas
+	Consumers string `yaml:"consumers"`
+}
+
+// Auth requires clients to authenticate and limits what they may do by role
+type Auth struct {
+	// Enabled refuses requests from unauthenticated clients and enforces roles
+	Enabled bool `yaml:"enabled"`
+	// KeysFile holds the API keys, keys added through the admin API are saved to it
+	KeysFile string `yaml:"keys_file"`
+	// TokenSecret signs bearer tokens with HMAC-SHA256, empty disables signed tokens
+	TokenSecret string `yaml:"token_secret"`
+	// PeerToken is the bearer token sent to the primary and the other cluster nodes
+	PeerToken string `yaml:"peer_token"`
+}
+
+// Default returns the configuration used for settings that are not given
+func Default() Config {
+	return Config{
+		Addr: "localhost:8080",
+		Log: Log{
+			Output:      logging.OutputFile,
+			Path:        "job-queue.log",
+			Format:      logging.FormatJSON,
+			Level:       "info",
+			RotateSize:  100,
+			RotateEvery: 24 * time.Hour,
+			MaxBackups:  7,
+			MaxAge:      30 * 24 * time.Hour,
+			Compress:    true,
+		},
+		Timeouts: Timeouts{
+			Enqueue:    60 * time.Second,
+			Dequeue:    30 * time.Second,
+			Read:       30 * time.Second,
+			Shutdown:   30 * time.Second,
+			LeaseGrace: 20 * time.Second,
+		},
+		Limits: Limits{
+			MaxWait:         60 * time.Second,
+			MaxPayloadBytes: 1 << 20,
+		},
+		Storage: Storage{
+			Backend:      storage.Memory,
+			Path:         "job-queue.journal",
+			SyncInterval: time.Second,
+		},
+		Replication: Replication{
+			SyncInterval: time.Second,
+		},
+		TLS: TLS{
+			ClientAuth: certs.ClientAuthNone,
+		},
+		Tracing: Tracing{
+			Exporter: tracing.None,
+			Endpoint: tracing.DefaultEndpoint,
+			Path:     "job-queue.traces",
+		},
+		Webhooks: Webhooks{
+			MaxAttempts: 5,
+			Backoff:     time.Second,
+			Timeout:     10 * time.Second,
+		},
+		Push: Push{
+			MaxAttempts: 3,
+			Backoff:     time.Second,
+			Timeout:     30 * time.Second,
+		},
+	}
+}
+
+// flagSet binds a flag to every setting of cfg
+func flagSet(name string, cfg *Config, path *string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.StringVar(path, "config", "", "YAML or JSON configuration file")
+	fs.StringVar(&cfg.Addr, "addr", cfg.Addr, "address to listen on")
+	fs.StringVar(&cfg.Log.Output, "log-output", cfg.Log.Output, "log output, one of "+strings.Join(logging.Outputs, ", "))
+	fs.StringVar(&cfg.Log.Path, "log-path", cfg.Log.Path, "log file, empty logs to stderr")
+	fs.StringVar(&cfg.Log.Format, "log-format", cfg.Log.Format, "log format, json or text")
+	fs.StringVar(&cfg.Log.Level, "log-level", cfg.Log.Level, "log level, e.g. error, info or debug")
+	fs.IntVar(&cfg.Log.RotateSize, "log-rotate-size", cfg.Log.RotateSize, "rotate the log file past this many megabytes, 0 never does")
+	fs.DurationVar(&cfg.Log.RotateEvery, "log-rotate-every", cfg.Log.RotateEvery, "rotate the log file at this interval, 0 never does")
+	fs.IntVar(&cfg.Log.MaxBackups, "log-max-backups", cfg.Log.MaxBackups, "rotated log files to keep, 0 keeps them all")
+	fs.DurationVar(&cfg.Log.MaxAge, "log-max-age", cfg.Log.MaxAge, "remove rotated log files older than this, 0 keeps them")
+	fs.BoolVar(&cfg.Log.Compress, "log-compress", cfg.Log.Compress, "gzip rotated log files")
+	fs.DurationVar(&cfg.Timeouts.Enqueue, "enqueue-timeout", cfg.Timeouts.Enqueue, "how long a job may wait in the queue before it expires")
+	fs.DurationVar(&cfg.Timeouts.Dequeue, "dequeue-timeout", cfg.Timeouts.Dequeue, "how long a dequeued job may go without a heartbeat before it is queued again")
+	fs.DurationVar(&cfg.Timeouts.Read, "read-timeout", cfg.Timeouts.Read, "how long reading a request may take, 0 for no limit")
+	fs.DurationVar(&cfg.Timeouts.Write, "write-timeout", cfg.Timeouts.Write, "how long handling a request may take, 0 for no limit")
+	fs.DurationVar(&cfg.Timeouts.Shutdown, "shutdown-timeout", cfg.Timeouts.Shutdown, "how long a graceful shutdown may take, 0 for no limit")
+	fs.DurationVar(&cfg.Timeouts.LeaseGrace, "lease-grace", cfg.Timeouts.LeaseGrace, "how long jobs in progress get to finish on shutdown before they are queued again")
+	fs.DurationVar(&cfg.Limits.MaxWait, "max-wait", cfg.Limits.MaxWait, "longest wait of a long-polling dequeue, 0 for no limit")
+	fs.Int64Var(&cfg.Limits.MaxPayloadBytes, "max-payload-bytes", cfg.Limits.MaxPayloadBytes, "largest request body, 0 for no limit")
+	fs.IntVar(&cfg.Limits.MaxQueueDepth, "max-queue-depth", cfg.Limits.MaxQueueDepth, "most jobs that may wait in the queue, 0 for no limit")
+	fs.StringVar(&cfg.Storage.Backend, "storage", cfg.Storage.Backend, "storage backend, "+strings.Join(storage.Backends, " or "))
+	fs.StringVar(&cfg.Storage.Path, "storage-path", cfg.Storage.Path, "journal file of the file storage backend")
+	fs.DurationVar(&cfg.Storage.SyncInterval, "storage-sync-interval", cfg.Storage.SyncInterval, "how often the file storage backend writes new changes")
+	fs.StringVar(&cfg.Replication.Primary, "primary", cfg.Replication.Primary, "base URL of the primary node, runs this node as a read-only follower")
+	fs.DurationVar(&cfg.Replication.SyncInterval, "sync-interval", cfg.Replication.SyncInterval, "how often a follower polls the primary")
+	fs.IntVar(&cfg.Cluster.Node, "node", cfg.Cluster.Node, "ID of this node in the cluster")
+	fs.StringVar(&cfg.Cluster.Peers, "peers", cfg.Cluster.Peers, "cluster members as ID=URL pairs separated by commas, including this node")
+	fs.StringVar(&cfg.TLS.CertFile, "tls-cert", cfg.TLS.CertFile, "server certificate file, serves HTTPS when set")
+	fs.StringVar(&cfg.TLS.KeyFile, "tls-key", cfg.TLS.KeyFile, "server private key file")
+	fs.StringVar(&cfg.TLS.ClientCAFile, "tls-client-ca", cfg.TLS.ClientCAFile, "CA file client certificates are verified against")
+	fs.StringVar(&cfg.TLS.ClientAuth, "tls-client-auth", cfg.TLS.ClientAuth, "client certificates, none, optional or require")
+	fs.StringVar(&cfg.TLS.Consumers, "tls-consumers", cfg.TLS.Consumers, "certificate common names of consumers as NAME=CONSUMER_ID pairs separated by commas")
+	fs.BoolVar(&cfg.Auth.Enabled, "auth", cfg.Auth.Enabled, "require clients to authenticate and enforce their roles")
+	fs.StringVar(&cfg.Auth.KeysFile, "auth-keys", cfg.Auth.KeysFile, "YAML or JSON file holding the API keys")
+	fs.StringVar(&cfg.Auth.TokenSecret, "auth-token-secret", cfg.Auth.TokenSecret, "secret signing bearer tokens, at least 32 bytes")
+	fs.StringVar(&cfg.Auth.PeerToken, "auth-peer-token", cfg.Auth.PeerToken, "bearer token sent to the primary and the other cluster nodes")
+	fs.StringVar(&cfg.Tracing.Exporter, "trace-exporter", cfg.Tracing.Exporter, "span exporter, one of "+strings.Join(tracing.Exporters, ", "))
+	fs.StringVar(&cfg.Tracing.Endpoint, "trace-endpoint", cfg.Tracing.Endpoint, "URL of the OTLP/HTTP collector")
+	fs.StringVar(&cfg.Tracing.Path, "trace-path", cfg.Tracing.Path, "file the file exporter appends JSON spans to")
+	fs.StringVar(&cfg.Webhooks.Secret, "webhook-secret", cfg.Webhooks.Secret, "secret signing job callbacks with HMAC-SHA256, at least 32 bytes, enables webhooks")
+	fs.IntVar(&cfg.Webhooks.MaxAttempts, "webhook-max-attempts", cfg.Webhooks.MaxAttempts, "how many times a callback is sent before it fails")
+	fs.DurationVar(&cfg.Webhooks.Backoff, "webhook-backoff", cfg.Webhooks.Backoff, "wait before the first retry of a callback, doubling for each retry after it")
+	fs.DurationVar(&cfg.Webhooks.Timeout, "webhook-timeout", cfg.Webhooks.Timeout, "how long one attempt at a callback may take")
+	fs.StringVar(&cfg.Push.Secret, "push-secret", cfg.Push.Secret, "secret signing pushed jobs with HMAC-SHA256, at least 32 bytes")
+	fs.IntVar(&cfg.Push.MaxAttempts, "push-max-attempts", cfg.Push.MaxAttempts, "how many attempts a pushed job gets before it stays failed")
+	fs.DurationVar(&cfg.Push.Backoff, "push-backoff", cfg.Push.Backoff, "wait before the first retry of a pushed job, doubling for each retry after it")
+	fs.DurationVar(&cfg.Push.Timeout, "push-timeout", cfg.Push.Timeout, "how long one POST of a pushed job may take")
+	return fs
+}
+
+// EnvName returns the environment variable of a flag
+func EnvName(flagName string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// Load builds the configuration from the command-line arguments, the environment looked
+// up with getenv and the configuration file, then validates it. It returns flag.ErrHelp
+// when the arguments ask for usage.
+func Load(name string, args []string, getenv func(string) string, usage io.Writer) (Config, error) {
+	// the flags are parsed first to find the file, and applied again last to take precedence
+	var path string
+	cfg := Default()
+	fs := flagSet(name, &cfg, &path)
+	fs.SetOutput(usage)
+	if err := fs.Parse(args); err != nil {
+		return Config{}, err
+	}
+	if fs.NArg() > 0 {
+		return Config{}, fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+	set := map[string]string{}
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = f.Value.String()
+	})
+
+	cfg = Default()
+	if value := getenv(EnvName("config")); value != "" && set["config"] == "" {
+		set["config"] = value
+	}
+	if file := set["config"]; file != "" {
+		if err := cfg.loadFile(file); err != nil {
+			return Config{}, err
+		}
+	}
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		value := getenv(EnvName(f.Name))
+		if err != nil || f.Name == "config" || value == "" {
+			return
+		}
+		if setErr := f.Value.Set(value); setErr != nil {
+			err = fmt.Errorf("invalid %s %q: %v", EnvName(f.Name), value, setErr)
+		}
+	})
+	if err != nil {
+		return Config{}, err
+	}
+	for flagName, value := range set {
+		if flagName != "config" {
+			fs.Set(flagName, value)
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+// loadFile reads a YAML or JSON configuration file over cfg, JSON being a subset of YAML
+func (cfg *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config file: %v", err)
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(cfg); err != nil && err != io.EOF {
+		return fmt.Errorf("invalid config file %s: %v", path, err)
+	}
+	return nil
+}
+
+// Validate reports every invalid setting
+func (cfg Config) Validate() error {
+	var problems []string
+	invalid := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if cfg.Addr == "" {
+		invalid("addr must not be empty")
+	}
+	switch cfg.Log.Output {
+	case logging.OutputFile, logging.OutputStdout, logging.OutputStderr:
+	default:
+		invalid("log output %q must be one of %s", cfg.Log.Output, strings.Join(logging.Outputs, ", "))
+	}
+	if cfg.Log.Format != logging.FormatJSON && cfg.Log.Format != logging.FormatText {
+		invalid("log format %q must be json or text", cfg.Log.Format)
+	}
+	if cfg.Log.RotateSize < 0 || cfg.Log.RotateEvery < 0 || cfg.Log.MaxBackups < 0 || cfg.Log.MaxAge < 0 {
+		invalid("log rotation settings must not be negative")
+	}
+	if _, err := logrus.ParseLevel(cfg.Log.Level); err != nil {
+		invalid("log level %q is not one of panic, fatal, error, warn, info, debug or trace", cfg.Log.Level)
+	}
+	if cfg.Timeouts.Enqueue <= 0 {
+		invalid("enqueue timeout must be positive")
+	}
+	if cfg.Timeouts.Dequeue <= 0 {
+		invalid("dequeue timeout must be positive")
+	}
+	if cfg.Timeouts.Read < 0 || cfg.Timeouts.Write < 0 || cfg.Timeouts.Shutdown < 0 || cfg.Timeouts.LeaseGrace < 0 {
+		invalid("read, write, shutdown and lease grace timeouts must not be negative")
+	}
+	if cfg.Timeouts.Shutdown > 0 && cfg.Timeouts.LeaseGrace >= cfg.Timeouts.Shutdown {
+		invalid("lease grace %s must be shorter than shutdown timeout %s", cfg.Timeouts.LeaseGrace, cfg.Timeouts.Shutdown)
+	}
+	if cfg.Limits.MaxWait < 0 || cfg.Limits.MaxPayloadBytes < 0 || cfg.Limits.MaxQueueDepth < 0 {
+		invalid("limits must not be negative")
+	}
+	if cfg.Timeouts.Write > 0 && (cfg.Limits.MaxWait == 0 || cfg.Limits.MaxWait >= cfg.Timeouts.Write) {
+		invalid("write timeout %s must be longer than max wait %s, or long-polling dequeues are cut off", cfg.Timeouts.Write, cfg.Limits.MaxWait)
+	}
+
+	switch cfg.Storage.Backend {
+	case storage.Memory:
+	case storage.File:
+		if cfg.Storage.Path == "" {
+			invalid("storage path must be set for the file backend")
+		}
+		if cfg.Storage.SyncInterval <= 0 {
+			invalid("storage sync interval must be positive")
+		}
+	default:
+		invalid("storage backend %q must be one of %s", cfg.Storage.Backend, strings.Join(storage.Backends, ", "))
+	}
+
+	if cfg.Replication.Primary != "" && cfg.Replication.SyncInterval <= 0 {
+		invalid("sync interval must be positive")
+	}
+	if nodes, err := cluster.ParseNodes(cfg.Cluster.Peers); err != nil {
+		invalid("peers: %v", err)
+	} else if len(nodes) > 0 {
+		if _, ok := nodes[cfg.Cluster.Node]; !ok {
+			invalid("node %d is not one of the peers", cfg.Cluster.Node)
+		}
+	}
+
+	if (cfg.TLS.CertFile == "") != (cfg.TLS.KeyFile == "") {
+		invalid("tls cert and key files must be set together")
+	}
+	switch cfg.TLS.ClientAuth {
+	case certs.ClientAuthNone:
+	case certs.ClientAuthOptional, certs.ClientAuthRequire:
+		if cfg.TLS.CertFile == "" {
+			invalid("tls client auth %s needs a server certificate", cfg.TLS.ClientAuth)
+		}
+		if cfg.TLS.ClientCAFile == "" {
+			invalid("tls client auth %s needs a client CA file", cfg.TLS.ClientAuth)
+		}
+	default:
+		invalid("tls client auth %q must be none, optional or require", cfg.TLS.ClientAuth)
+	}
+	if _, err := auth.ParseConsumers(cfg.TLS.Consumers); err != nil {
+		invalid("tls consumers: %v", err)
+	}
+
+	if cfg.Auth.TokenSecret != "" && len(cfg.Auth.TokenSecret) < 32 {
+		invalid("auth token secret must be at least 32 bytes")
+	}
+	if cfg.Auth.Enabled && cfg.Auth.KeysFile == "" && cfg.Auth.TokenSecret == "" && cfg.TLS.ClientAuth == certs.ClientAuthNone {
+		invalid("auth needs a keys file, a token secret or tls client auth")
+	}
+	// the nodes of a cluster tell the requests of their peers by the peer token
+	if nodes, _ := cluster.ParseNodes(cfg.Cluster.Peers); cfg.Auth.Enabled && len(nodes) > 1 && cfg.Auth.PeerToken == "" {
+		invalid("a cluster with auth needs a peer token")
+	}
+
+	switch cfg.Tracing.Exporter {
+	case tracing.None:
+	case tracing.OTLP:
+		if u, err := url.Parse(cfg.Tracing.Endpoint); err != nil || u.Host == "" {
+			invalid("trace endpoint %q must be a URL", cfg.Tracing.Endpoint)
+		}
+	case tracing.File:
+		if cfg.Tracing.Path == "" {
+			invalid("trace path must be set for the file exporter")
+		}
+	default:
+		invalid("trace exporter %q must be one of %s", cfg.Tracing.Exporter, strings.Join(tracing.Exporters, ", "))
+	}
+
+	if cfg.Webhooks.Secret != "" && len(cfg.Webhooks.Secret) < 32 {
+		invalid("webhook secret must be at least 32 bytes")
+	}
+	if cfg.Webhooks.MaxAttempts <= 0 || cfg.Webhooks.Backoff <= 0 || cfg.Webhooks.Timeout <= 0 {
+		invalid("webhook max attempts, backoff and timeout must be positive")
+	}
+	if len(cfg.Webhooks.Subscriptions) > 0 && cfg.Webhooks.Secret == "" {
+		invalid("webhook subscriptions need a webhook secret")
+	}
+	for i, sub := range cfg.Webhooks.Options().Subscriptions {
+		if err := sub.Validate(); err != nil {
+			invalid("webhook subscription %d: %v", i+1, err)
+		}
+	}
+	if cfg.Push.Secret != "" && len(cfg.Push.Secret) < 32 {
+		invalid("push secret must be at least 32 bytes")
+	}
+	if cfg.Push.MaxAttempts <= 0 || cfg.Push.Backoff <= 0 || cfg.Push.Timeout <= 0 {
+		invalid("push max attempts, backoff and timeout must be positive")
+	}
+	for i, e := range cfg.Push.Options().Endpoints {
+		if err := e.Validate(); err != nil {
+			invalid("push endpoint %d: %v", i+1, err)
+		}
+	}
+
+	for name, tenant := range cfg.Tenants {
+		if tenant.MaxQueueDepth < 0 || tenant.EnqueueRate < 0 || tenant.EnqueueBurst < 0 || tenant.MaxPayloadBytes < 0 || tenant.Weight < 0 {
+			invalid("tenant %s: quotas must not be negative", name)
+		}
+	}
+
+	if len(problems) > 0 {
+		return errors.New("invalid configuration: " + strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// Peers returns the cluster members, Validate has checked they parse
+func (cfg Config) Peers() map[int]string {
+	nodes, _ := cluster.ParseNodes(cfg.Cluster.Peers)
+	return nodes
+}
+
+// Consumers returns the consumer IDs of certificate common names, Validate has checked they parse
+func (cfg Config) Consumers() map[string]int {
+	consumers, _ := auth.ParseConsumers(cfg.TLS.Consumers)
+	return consumers
+}
+
+// Quotas returns the quotas of the listed tenants and the quota of every other tenant
+func (cfg Config) Quotas() (map[string]jobqueue.Quota, jobqueue.Quota) {
+	quotas := make(map[string]jobqueue.Quota)
+	var fallback jobqueue.Quota
+	for name, tenant := range cfg.Tenants {
+		quota := jobqueue.Quota{
+			MaxDepth:        tenant.MaxQueueDepth,
+			Rate:            tenant.EnqueueRate,
+			Burst:           tenant.EnqueueBurst,
+			MaxPayloadBytes: tenant.MaxPayloadBytes,
+			Weight:          tenant.Weight,
+		}
+		if name == DefaultTenant {
+			fallback = quota
+			continue
+		}
+		quotas[name] = quota
+	}
+	return quotas, fallback
+}
@@ -0,0 +1,30 @@
+// Package dashboard serves the web admin dashboard, a single page embedded in the binary.
+//
+// The page only talks to the REST API: it charts the queue depth over time and the jobs
+// by status and type from /jobs/stats, lists and inspects jobs, cancels, retries and
+// re-drives them, and refreshes whenever the /events stream reports a change. When the
+// server requires authentication the page asks for an API key or token.
+package dashboard
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+// Prefix is the path the dashboard is served under
+const Prefix = "/dashboard/"
+
+//go:embed static
+var static embed.FS
+
+// Handler serves the files of the dashboard under Prefix
+func Handler() http.Handler {
+	files, _ := fs.Sub(static, "static")
+	return http.StripPrefix(Prefix, http.FileServer(http.FS(files)))
+}
+
+// RedirectService sends requests for the bare dashboard path to Prefix
+func RedirectService(w http.ResponseWriter, r *http.Request) {
+	http.Redirect(w, r, Prefix, http.StatusMovedPermanently)
+}
@@ -0,0 +1,228 @@
+// The dashboard keeps no state on the server: it samples /jobs/stats for the depth chart
+// and refreshes the stats, the job list and the selected job when /events reports a change.
+(function () {
+  "use strict";
+
+  // SAMPLES points of the depth chart are kept, one every SAMPLE_MS and one per refresh
+  var SAMPLES = 300;
+  var SAMPLE_MS = 5000;
+  // REFRESH_MS throttles the refreshes caused by a burst of events
+  var REFRESH_MS = 1000;
+  var TOKEN_KEY = "job-queue-token";
+
+  var samples = [];
+  var selected = null;
+  var refreshTimer = null;
+  var stream = null;
+
+  function $(id) { return document.getElementById(id); }
+
+  function token() { return sessionStorage.getItem(TOKEN_KEY) || ""; }
+
+  // api calls the REST API and resolves with the decoded JSON body
+  function api(method, path) {
+    var headers = {};
+    if (token()) { headers.Authorization = "Bearer " + token(); }
+    return fetch(path, { method: method, headers: headers }).then(function (resp) {
+      if (resp.status === 401) {
+        $("login").hidden = false;
+      }
+      return resp.text().then(function (text) {
+        var body = text ? JSON.parse(text) : null;
+        if (!resp.ok) {
+          throw new Error(body && body.Message ? body.Message : resp.statusText);
+        }
+        return body;
+      });
+    });
+  }
+
+  function text(value) {
+    if (value === undefined || value === null) { return ""; }
+    return String(value);
+  }
+
+  function cell(row, value, className) {
+    var td = row.insertCell();
+    td.textContent = text(value);
+    if (className) { td.className = className; }
+    return td;
+  }
+
+  function time(value) {
+    if (!value || value.indexOf("0001-") === 0) { return ""; }
+    return new Date(value).toLocaleString();
+  }
+
+  function status(job) { return job.Cancel ? "CANCELLED" : job.Status; }
+
+  function counts(table, byKey) {
+    table.textContent = "";
+    Object.keys(byKey).sort().forEach(function (key) {
+      var row = table.insertRow();
+      cell(row, key, "status-" + key);
+      cell(row, byKey[key], "count");
+    });
+  }
+
+  function drawDepth() {
+    var svg = $("depth");
+    svg.textContent = "";
+    if (samples.length < 2) { return; }
+    var max = 1;
+    samples.forEach(function (s) { max = Math.max(max, s.queued, s.inProgress); });
+    var first = samples[0].time, span = Math.max(samples[samples.length - 1].time - first, 1);
+    ["queued", "inProgress"].forEach(function (key) {
+      var line = document.createElementNS("http://www.w3.org/2000/svg", "polyline");
+      line.setAttribute("class", key === "queued" ? "queued" : "in-progress");
+      line.setAttribute("points", samples.map(function (s) {
+        return ((s.time - first) / span * 600).toFixed(1) + "," + (155 - s[key] / max * 150).toFixed(1);
+      }).join(" "));
+      svg.appendChild(line);
+    });
+    $("depth-range").textContent = "max " + max + " over " + Math.round(span / 1000) + "s";
+  }
+
+  function loadStats() {
+    return api("GET", "/jobs/stats").then(function (stats) {
+      var byStatus = Object.assign({}, stats.ByStatus);
+      if (stats.Cancelled) { byStatus.CANCELLED = stats.Cancelled; }
+      counts($("by-status"), byStatus);
+      counts($("by-type"), stats.ByType || {});
+
+      samples.push({ time: Date.now(), queued: byStatus.QUEUED || 0, inProgress: byStatus.IN_PROGRESS || 0 });
+      if (samples.length > SAMPLES) { samples.shift(); }
+      drawDepth();
+    });
+  }
+
+  function loadJobs() {
+    var query = new URLSearchParams();
+    if ($("filter-status").value) { query.set("status", $("filter-status").value); }
+    if ($("filter-type").value) { query.set("type", $("filter-type").value); }
+    return api("GET", "/jobs?" + query).then(function (jobs) {
+      var body = $("jobs").tBodies[0];
+      body.textContent = "";
+      // the newest jobs come first
+      (jobs || []).slice().reverse().forEach(function (job) {
+        var row = body.insertRow();
+        row.dataset.id = job.ID;
+        if (job.ID === selected) { row.className = "selected"; }
+        cell(row, job.ID);
+        cell(row, job.Type);
+        cell(row, job.Queue);
+        cell(row, status(job), "status-" + status(job));
+        cell(row, job.ConsumedBy || "");
+        cell(row, time(job.EnqueueTime));
+      });
+    });
+  }
+
+  function json(value) {
+    return value === undefined ? "" : JSON.stringify(value, null, 2);
+  }
+
+  function loadDetail() {
+    if (selected === null) { return Promise.resolve(); }
+    var id = selected;
+    return Promise.all([api("GET", "/jobs/" + id), api("GET", "/jobs/" + id + "/history")]).then(function (results) {
+      if (id !== selected) { return; }
+      var job = results[0], history = results[1] || [];
+      $("detail").hidden = false;
+      $("detail-id").textContent = job.ID;
+      $("detail-payload").textContent = json(job.Payload);
+      $("detail-result").textContent = json(job.Result) || job.Error || "";
+      var rest = Object.assign({}, job);
+      delete rest.Payload;
+      delete rest.Result;
+      $("detail-job").textContent = json(rest);
+
+      var body = $("history").tBodies[0];
+      body.textContent = "";
+      history.forEach(function (change) {
+        var row = body.insertRow();
+        cell(row, change.Seq);
+        cell(row, time(change.Time));
+        cell(row, change.Op);
+        cell(row, status(change.Job), "status-" + status(change.Job));
+        cell(row, change.Job.ConsumedBy || "");
+      });
+    });
+  }
+
+  function refresh() {
+    return Promise.all([loadStats(), loadJobs(), loadDetail()]).catch(function (err) {
+      $("action-status").textContent = err.message;
+    });
+  }
+
+  // scheduleRefresh refreshes at most once every REFRESH_MS
+  function scheduleRefresh() {
+    if (refreshTimer) { return; }
+    refreshTimer = setTimeout(function () {
+      refreshTimer = null;
+      refresh();
+    }, REFRESH_MS);
+  }
+
+  function connect() {
+    if (stream) { stream.close(); }
+    var url = "/events" + (token() ? "?access_token=" + encodeURIComponent(token()) : "");
+    stream = new EventSource(url);
+    stream.onopen = function () {
+      $("live").textContent = "live";
+      $("live").className = "badge on";
+    };
+    // the browser reconnects on its own, the dashboard only shows that it is offline meanwhile
+    stream.onerror = function () {
+      $("live").textContent = "offline";
+      $("live").className = "badge off";
+    };
+    stream.addEventListener("change", scheduleRefresh);
+  }
+
+  function act(method, path, done) {
+    $("action-status").textContent = "";
+    api(method, path).then(function (body) {
+      $("action-status").textContent = done(body);
+      refresh();
+    }, function (err) {
+      $("action-status").textContent = err.message;
+    });
+  }
+
+  $("jobs").tBodies[0].addEventListener("click", function (event) {
+    var row = event.target.closest("tr");
+    if (!row) { return; }
+    selected = Number(row.dataset.id);
+    $("action-status").textContent = "";
+    Array.prototype.forEach.call(row.parentNode.rows, function (r) { r.className = r === row ? "selected" : ""; });
+    loadDetail().catch(function (err) { $("action-status").textContent = err.message; });
+  });
+
+  $("cancel").addEventListener("click", function () {
+    act("DELETE", "/jobs/" + selected + "/cancel", function () { return "Job cancelled"; });
+  });
+  $("retry").addEventListener("click", function () {
+    act("PUT", "/jobs/" + selected + "/retry", function () { return "Job enqueued for retry"; });
+  });
+  $("redrive").addEventListener("click", function () {
+    act("POST", "/jobs/" + selected + "/redrive", function (body) { return "Job re-driven as " + body.id; });
+  });
+
+  $("filter-status").addEventListener("change", refresh);
+  $("filter-type").addEventListener("change", refresh);
+
+  $("login").addEventListener("submit", function (event) {
+    event.preventDefault();
+    sessionStorage.setItem(TOKEN_KEY, $("token").value);
+    $("token").value = "";
+    $("login").hidden = true;
+    connect();
+    refresh();
+  });
+
+  setInterval(function () { loadStats().catch(function () {}); }, SAMPLE_MS);
+  connect();
+  refresh();
+})();
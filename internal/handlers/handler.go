@@ -1,31 +1,518 @@
 package handlers
 
 import (
+	"context"
+	"errors"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/gorilla/mux"
 	httpSwagger "github.com/swaggo/http-swagger/v2"
 	_ "github.com/varungujarathi9/job-queue/docs"
+	"github.com/varungujarathi9/job-queue/internal/apierror"
+	"github.com/varungujarathi9/job-queue/internal/auth"
+	"github.com/varungujarathi9/job-queue/internal/certs"
+	"github.com/varungujarathi9/job-queue/internal/cluster"
+	"github.com/varungujarathi9/job-queue/internal/dashboard"
+	"github.com/varungujarathi9/job-queue/internal/health"
+	"github.com/varungujarathi9/job-queue/internal/logging"
+	"github.com/varungujarathi9/job-queue/internal/metrics"
+	"github.com/varungujarathi9/job-queue/internal/push"
+	"github.com/varungujarathi9/job-queue/internal/replica"
 	"github.com/varungujarathi9/job-queue/internal/services"
+	"github.com/varungujarathi9/job-queue/internal/storage"
+	"github.com/varungujarathi9/job-queue/internal/tracing"
 	"github.com/varungujarathi9/job-queue/internal/utils"
+	"github.com/varungujarathi9/job-queue/internal/webhooks"
+	"github.com/varungujarathi9/job-queue/pkg/jobqueue"
 )
 
-func Init() {
-	utils.Logger.Info("Starting REST API server")
+// Options controls how the REST API server is started
+type Options struct {
+	// Addr is the address the server listens on
+	Addr string
+	// Primary is the base URL of the primary node, when set this node runs as a read-only follower
+	Primary string
+	// SyncInterval is how often a follower polls the primary's change stream
+	SyncInterval time.Duration
+	// Node is the ID of this node in the cluster
+	Node int
+	// Peers maps the node IDs of the cluster members to their base URLs, empty runs a single node
+	Peers map[int]string
+	// EnqueueTimeout and DequeueTimeout override the engine's timeouts when set
+	EnqueueTimeout time.Duration
+	DequeueTimeout time.Duration
+	// ReadTimeout and WriteTimeout bound requests, zero means no limit
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	// MaxWait, MaxPayloadBytes and MaxQueueDepth limit clients, zero means no limit
+	MaxWait         time.Duration
+	MaxPayloadBytes int64
+	MaxQueueDepth   int
+	// Tenants holds the quotas of single tenants and DefaultQuota the quota of every other tenant
+	Tenants      map[string]jobqueue.Quota
+	DefaultQuota jobqueue.Quota
+	// Storage selects where Init keeps the jobs, in memory by default
+	Storage storage.Options
+	// ShutdownTimeout bounds a graceful shutdown, zero waits for as long as it takes
+	ShutdownTimeout time.Duration
+	// LeaseGrace is how long jobs in progress get to finish on shutdown before they are queued again
+	LeaseGrace time.Duration
+	// TLS serves HTTPS when a certificate file is set
+	TLS certs.Options
+	// Consumers maps the common names of client certificates to the consumer IDs they dequeue as
+	Consumers map[string]int
+	// Auth requires every request to be authenticated and authorized by role, nil leaves the API open
+	Auth *auth.Authenticator
+	// PeerToken is the bearer token sent to the primary and the other cluster nodes
+	PeerToken string
+	// Tracing selects where the spans of requests and queue waits are exported, none by default
+	Tracing tracing.Options
+	// Webhooks sends signed callbacks when jobs change state, disabled without a secret and on followers
+	Webhooks webhooks.Options
+	// Push sends jobs to HTTP workers, only on primaries
+	Push push.Options
+}
+
+// newEngine creates the queue engine that backs every route
+func newEngine(opts Options) *jobqueue.Engine {
+	var engineOpts []jobqueue.Option
+	if len(opts.Peers) > 0 {
+		engineOpts = append(engineOpts, cluster.IDSpace(opts.Node))
+	}
+	if opts.EnqueueTimeout > 0 {
+		engineOpts = append(engineOpts, jobqueue.WithEnqueueTimeout(opts.EnqueueTimeout))
+	}
+	if opts.DequeueTimeout > 0 {
+		engineOpts = append(engineOpts, jobqueue.WithDequeueTimeout(opts.DequeueTimeout))
+	}
+	if opts.MaxQueueDepth > 0 {
+		engineOpts = append(engineOpts, jobqueue.WithMaxDepth(opts.MaxQueueDepth))
+	}
+	for tenant, quota := range opts.Tenants {
+		engineOpts = append(engineOpts, jobqueue.WithTenantQuota(tenant, quota))
+	}
+	engineOpts = append(engineOpts, jobqueue.WithDefaultQuota(opts.DefaultQuota))
+	return jobqueue.New(engineOpts...)
+}
+
+// NewRouter builds the REST API routes on top of a new in-memory queue engine
+func NewRouter(opts Options) (*mux.Router, error) {
+	tracer, err := tracing.New(opts.Tracing)
+	if err != nil {
+		return nil, err
+	}
+	router, _, err := newRouter(opts, newEngine(opts), tracer, health.New())
+	return router, err
+}
+
+// policy lists the roles that may use each route, admins may use every route
+var policy = auth.Policy{
+	"enqueue":          {auth.RoleProducer},
+	"dequeue":          {auth.RoleConsumer},
+	"push":             {auth.RoleConsumer},
+	"conclude":         {auth.RoleConsumer},
+	"heartbeat":        {auth.RoleConsumer},
+	"fail":             {auth.RoleConsumer},
+	"list":             {auth.RoleViewer},
+	"stats":            {auth.RoleViewer},
+	"changes":          {auth.RoleViewer},
+	"replication":      {auth.RoleViewer},
+	"job":              {auth.RoleViewer},
+	"history":          {auth.RoleViewer},
+	"result":           {auth.RoleProducer, auth.RoleViewer},
+	"events":           {auth.RoleViewer},
+	"cluster":          {auth.RoleViewer},
+	"drain-status":     {auth.RoleViewer},
+	"log-level-status": {auth.RoleViewer},
+	"metrics":          {auth.RoleViewer},
+	"swagger":          {auth.Anyone},
+	"healthz":          {auth.Anyone},
+	"readyz":           {auth.Anyone},
+	"dashboard":        {auth.Anyone},
+	"cancel":           nil,
+	"retry":            nil,
+	"redrive":          nil,
+	"drain":            nil,
+	"resume":           nil,
+	"log-level":        nil,
+	"cluster-members":  nil,
+	"cluster-import":   nil,
+	"cluster-release":  nil,
+	"keys":             nil,
+	"webhooks":         {auth.RoleViewer},
+	"webhooks-manage":  nil,
+	"endpoints":        {auth.RoleViewer},
+	"endpoints-manage": nil,
+	// v2 API
+	"v2-queues":         {auth.RoleViewer},
+	"v2-queue":          {auth.RoleViewer},
+	"v2-lease-acquire":  {auth.RoleConsumer},
+	"v2-jobs":           {auth.RoleViewer},
+	"v2-job-create":     {auth.RoleProducer},
+	"v2-job":            {auth.RoleViewer},
+	"v2-job-update":     nil,
+	"v2-job-copy":       nil,
+	"v2-job-changes":    {auth.RoleViewer},
+	"v2-attempts":       {auth.RoleViewer},
+	"v2-attempt":        {auth.RoleViewer},
+	"v2-attempt-update": {auth.RoleConsumer},
+	"v2-lease":          {auth.RoleViewer},
+	"v2-lease-renew":    {auth.RoleConsumer},
+	"v2-openapi":        {auth.Anyone},
+}
+
+// background holds the parts of a router that keep working between requests, shutdown
+// stops them. Each is nil when the router does not run it.
+type background struct {
+	services   *services.Server
+	dispatcher *webhooks.Dispatcher
+	pusher     *push.Pusher
+}
+
+// newRouter builds the REST API routes along with the parts working in the background
+func newRouter(opts Options, engine *jobqueue.Engine, tracer *tracing.Tracer, checks *health.Checker) (*mux.Router, background, error) {
 	router := mux.NewRouter()
+	// unknown routes and methods are answered with the JSON error envelope as well
+	router.NotFoundHandler = http.HandlerFunc(apierror.NotFoundService)
+	router.MethodNotAllowedHandler = http.HandlerFunc(apierror.MethodNotAllowedService)
+	// logging, metrics and tracing come first so requests refused by the other middleware are seen too
+	metric := metrics.New(engine)
+	router.Use(logging.Middleware(utils.Logger), metric.Middleware, tracer.Middleware, auth.ClientCertificates(opts.Consumers))
+	if opts.Auth != nil {
+		router.Use(opts.Auth.Authenticate, auth.Authorize(policy))
+	}
+	// followers leave the callbacks to the primary, which records the changes first
+	var dispatcher *webhooks.Dispatcher
+	if opts.Webhooks.Secret != "" && opts.Primary == "" {
+		dispatcher = webhooks.New(engine, opts.Webhooks)
+		go dispatcher.Run()
+	}
+	// followers hold no leases, so only the primary pushes jobs
+	var pusher *push.Pusher
+	if opts.Primary == "" {
+		pusher = push.New(engine, opts.Push)
+	}
+	server := services.New(
+		services.WithEngine(engine),
+		services.WithMaxWait(opts.MaxWait),
+		services.WithMaxPayloadBytes(opts.MaxPayloadBytes),
+		services.WithTracer(tracer),
+		services.WithCallbacks(dispatcher != nil),
+	)
+
+	// requests to other nodes carry the peer token when the nodes require authentication
+	peerClient := &http.Client{Timeout: 10 * time.Second}
+	if opts.PeerToken != "" {
+		peerClient.Transport = &auth.BearerTransport{Token: opts.PeerToken}
+	}
+
+	// writes go to the local queue on a primary and are redirected to the primary on a follower,
+	// which only serves reads once it synced
+	write := func(handler http.HandlerFunc) http.HandlerFunc { return handler }
+	read := write
+	replication := server.ReplicationService
+	if opts.Primary != "" {
+		follower := replica.NewFollower(engine, opts.Primary, opts.SyncInterval, replica.WithHTTPClient(peerClient))
+		go follower.Run()
+		write = func(http.HandlerFunc) http.HandlerFunc { return follower.RedirectService }
+		read = follower.ReadService
+		replication = follower.StatusService
+		checks.Add("replication", follower.Check)
+	}
+	checks.Add("drain", func(context.Context) error {
+		if engine.Draining() {
+			return jobqueue.ErrDraining
+		}
+		return nil
+	})
+
+	// in a cluster jobs are routed to the node owning their shard
+	unrouted := func(handler http.HandlerFunc) http.HandlerFunc { return handler }
+	enqueueRouting, dequeueRouting, job := unrouted, unrouted, unrouted
+	if len(opts.Peers) > 0 {
+		cl, err := cluster.New(opts.Node, opts.Peers, engine, cluster.WithHTTPClient(peerClient),
+			cluster.WithMaxWait(opts.MaxWait), cluster.WithPeerToken(opts.PeerToken))
+		if err != nil {
+			return nil, background{}, err
+		}
+		enqueueRouting, dequeueRouting, job = cl.EnqueueHandler, cl.DequeueHandler, cl.JobHandler
+
+		router.HandleFunc("/cluster", cl.ClusterService).Methods("GET").Name("cluster")
+		router.HandleFunc("/cluster/members", cl.JoinService).Methods("POST").Name("cluster-members")
+		router.HandleFunc("/cluster/members/{node_id}", cl.LeaveService).Methods("DELETE").Name("cluster-members")
+		router.HandleFunc("/cluster/import", write(cl.ImportService)).Methods("POST").Name("cluster-import")
+		router.HandleFunc("/cluster/release", write(cl.ReleaseService)).Methods("POST").Name("cluster-release")
+	}
 
 	// create routes for handling various job queue functions
 	subrouter := router.PathPrefix("/jobs").Subrouter()
-	subrouter.HandleFunc("/enqueue", services.EnqueueService).Methods("POST")
-	subrouter.HandleFunc("/dequeue", services.DequeueService).Methods("GET")
-	subrouter.HandleFunc("/{job_id}/conclude", services.ConcludeService).Methods("PUT")
-	subrouter.HandleFunc("/{job_id}/cancel", services.CancelService).Methods("DELETE")
-	subrouter.HandleFunc("/{job_id}", services.JobService).Methods("GET")
-	subrouter.HandleFunc("/{job_id}/retry", services.RetryService).Methods("PUT")
+	subrouter.HandleFunc("", read(server.ListService)).Methods("GET").Name("list")
+	subrouter.HandleFunc("/", read(server.ListService)).Methods("GET").Name("list")
+	subrouter.HandleFunc("/stats", read(server.StatsService)).Methods("GET").Name("stats")
+	subrouter.HandleFunc("/changes", read(server.ChangesService)).Methods("GET").Name("changes")
+	subrouter.HandleFunc("/replication", replication).Methods("GET").Name("replication")
+	subrouter.HandleFunc("/enqueue", write(enqueueRouting(server.EnqueueService))).Methods("POST").Name("enqueue")
+	subrouter.HandleFunc("/dequeue", write(dequeueRouting(server.DequeueService))).Methods("GET").Name("dequeue")
+	// a push connection only gets the jobs of the node it is connected to
+	subrouter.HandleFunc("/push", write(server.PushService)).Methods("GET").Name("push")
+	subrouter.HandleFunc("/{job_id}/conclude", write(job(server.ConcludeService))).Methods("PUT").Name("conclude")
+	subrouter.HandleFunc("/{job_id}/cancel", write(job(server.CancelService))).Methods("DELETE").Name("cancel")
+	subrouter.HandleFunc("/{job_id}", read(job(server.JobService))).Methods("GET").Name("job")
+	subrouter.HandleFunc("/{job_id}/retry", write(job(server.RetryService))).Methods("PUT").Name("retry")
+	subrouter.HandleFunc("/{job_id}/redrive", write(job(server.RedriveService))).Methods("POST").Name("redrive")
+	subrouter.HandleFunc("/{job_id}/history", read(job(server.HistoryService))).Methods("GET").Name("history")
+	subrouter.HandleFunc("/{job_id}/result", read(job(server.ResultService))).Methods("GET").Name("result")
+	subrouter.HandleFunc("/{job_id}/heartbeat", write(job(server.HeartbeatService))).Methods("PUT").Name("heartbeat")
+	subrouter.HandleFunc("/{job_id}/fail", write(job(server.FailService))).Methods("PUT").Name("fail")
+
+	// the v2 API serves the same engine with resource-oriented routes, /jobs stays for compatibility
+	for _, route := range server.V2().Routes() {
+		handler := route.Handler
+		switch route.Name {
+		case "v2-job-create":
+			handler = enqueueRouting(handler)
+		case "v2-lease-acquire":
+			handler = dequeueRouting(handler)
+		}
+		if strings.Contains(route.Path, "{job_id}") {
+			handler = job(handler)
+		}
+		switch {
+		case route.Method != http.MethodGet:
+			handler = write(handler)
+		case route.Path != services.OpenAPIPath:
+			handler = read(handler)
+		}
+		router.HandleFunc(route.Path, handler).Methods(route.Method).Name(route.Name)
+	}
+
+	router.HandleFunc("/admin/drain", server.DrainStatusService).Methods("GET").Name("drain-status")
+	router.HandleFunc("/admin/drain", server.DrainService).Methods("POST").Name("drain")
+	router.HandleFunc("/admin/drain", server.ResumeService).Methods("DELETE").Name("resume")
+	levels := logging.NewLevels(utils.Logger)
+	router.HandleFunc("/admin/log-level", levels.LevelService).Methods("GET").Name("log-level-status")
+	router.HandleFunc("/admin/log-level", levels.SetLevelService).Methods("PUT").Name("log-level")
+	if opts.Auth != nil {
+		router.HandleFunc("/admin/keys", opts.Auth.ListKeysService).Methods("GET").Name("keys")
+		router.HandleFunc("/admin/keys", opts.Auth.CreateKeyService).Methods("POST").Name("keys")
+		router.HandleFunc("/admin/keys/{name}", opts.Auth.DeleteKeyService).Methods("DELETE").Name("keys")
+		router.HandleFunc("/admin/tokens", opts.Auth.CreateTokenService).Methods("POST").Name("keys")
+	}
+
+	if dispatcher != nil {
+		router.HandleFunc("/webhooks", dispatcher.SubscriptionsService).Methods("GET").Name("webhooks")
+		router.HandleFunc("/webhooks", dispatcher.CreateSubscriptionService).Methods("POST").Name("webhooks-manage")
+		router.HandleFunc("/webhooks/deliveries", dispatcher.DeliveriesService).Methods("GET").Name("webhooks")
+		router.HandleFunc("/webhooks/deliveries/{delivery_id}", dispatcher.DeliveryService).Methods("GET").Name("webhooks")
+		router.HandleFunc("/webhooks/deliveries/{delivery_id}/redeliver", dispatcher.RedeliverService).Methods("POST").Name("webhooks-manage")
+		router.HandleFunc("/webhooks/{webhook_id}", dispatcher.SubscriptionService).Methods("GET").Name("webhooks")
+		router.HandleFunc("/webhooks/{webhook_id}", dispatcher.DeleteSubscriptionService).Methods("DELETE").Name("webhooks-manage")
+	}
+
+	if pusher != nil {
+		router.HandleFunc("/push/endpoints", pusher.EndpointsService).Methods("GET").Name("endpoints")
+		router.HandleFunc("/push/endpoints", pusher.CreateEndpointService).Methods("POST").Name("endpoints-manage")
+		router.HandleFunc("/push/endpoints/{endpoint_id}", pusher.EndpointService).Methods("GET").Name("endpoints")
+		router.HandleFunc("/push/endpoints/{endpoint_id}", pusher.DeleteEndpointService).Methods("DELETE").Name("endpoints-manage")
+	}
+
+	router.HandleFunc("/events", read(server.EventsService)).Methods("GET").Name("events")
+	router.Handle("/metrics", metric.Handler()).Methods("GET").Name("metrics")
+	router.HandleFunc("/healthz", checks.LiveService).Methods("GET").Name("healthz")
+	router.HandleFunc("/readyz", checks.ReadyService).Methods("GET").Name("readyz")
+	router.PathPrefix("/swagger/").Handler(httpSwagger.WrapHandler).Name("swagger")
+	// the dashboard's files are public, the API calls it makes are authorized as usual
+	router.HandleFunc("/dashboard", dashboard.RedirectService).Methods("GET").Name("dashboard")
+	router.PathPrefix(dashboard.Prefix).Handler(dashboard.Handler()).Methods("GET").Name("dashboard")
+	return router, background{services: server, dispatcher: dispatcher, pusher: pusher}, nil
+}
+
+// Init serves the REST API until ctx is done, then shuts the server down gracefully.
+// The server starts listening before the store has restored the jobs of the previous
+// run, until then only /healthz and /readyz are served and every other request fails
+// with 503.
+func Init(ctx context.Context, opts Options) error {
+	utils.Logger.Info("Starting REST API server")
+
+	engine := newEngine(opts)
+	tracer, err := tracing.New(opts.Tracing)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// an unreachable collector must not hold up the exit
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		tracer.Shutdown(ctx)
+	}()
+
+	// the store is only set once recovery is done, the checks read it after that
+	var store storage.Store
+	recovered := make(chan struct{})
+	checks := health.New()
+	checks.Add("recovery", func(context.Context) error {
+		select {
+		case <-recovered:
+			return nil
+		default:
+			return errRecovering
+		}
+	})
+	checks.Add("storage", func(context.Context) error {
+		select {
+		case <-recovered:
+			return store.Check()
+		default:
+			return errRecovering
+		}
+	})
+
+	// requests run in a context that is cancelled on shutdown so event streams end
+	base, cancelBase := context.WithCancel(context.Background())
+	defer cancelBase()
+	handler := &swappableHandler{}
+	handler.Store(recoveryRouter(checks))
+	server := &http.Server{
+		Addr:         opts.Addr,
+		Handler:      handler,
+		ReadTimeout:  opts.ReadTimeout,
+		WriteTimeout: opts.WriteTimeout,
+		BaseContext:  func(net.Listener) context.Context { return base },
+	}
+	server.RegisterOnShutdown(cancelBase)
+	served := make(chan error, 1)
+	if opts.TLS.CertFile != "" {
+		reloader, err := certs.New(opts.TLS)
+		if err != nil {
+			return err
+		}
+		server.TLSConfig = reloader.TLSConfig()
+		go func() {
+			served <- server.ListenAndServeTLS("", "")
+		}()
+	} else {
+		go func() {
+			served <- server.ListenAndServe()
+		}()
+	}
+	utils.Logger.Info("Started server at " + opts.Addr)
+
+	// the store restores the jobs of the previous run before any job request is served
+	store, err = storage.Open(opts.Storage, engine)
+	if err != nil {
+		server.Close()
+		return err
+	}
+	go store.Run()
+	defer store.Close()
+
+	router, workers, err := newRouter(opts, engine, tracer, checks)
+	if err != nil {
+		server.Close()
+		return err
+	}
+	handler.Store(router)
+	close(recovered)
+	utils.Logger.Info("Restored jobs, serving requests")
+
+	select {
+	case err := <-served:
+		return err
+	case <-ctx.Done():
+	}
+	return shutdown(server, engine, store, workers, opts)
+}
+
+// errRecovering is reported by the checks and requests that arrive before the jobs are restored
+var errRecovering = errors.New("journal recovery in progress")
+
+// recoveryRouter serves the health endpoints while the store restores the jobs
+func recoveryRouter(checks *health.Checker) *mux.Router {
+	router := mux.NewRouter()
+	router.Use(logging.Middleware(utils.Logger))
+	router.HandleFunc("/healthz", checks.LiveService).Methods("GET").Name("healthz")
+	router.HandleFunc("/readyz", checks.ReadyService).Methods("GET").Name("readyz")
+	router.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		apierror.Write(w, apierror.New(http.StatusServiceUnavailable, apierror.CodeUnavailable, "Service is recovering"))
+	})
+	router.MethodNotAllowedHandler = router.NotFoundHandler
+	return router
+}
+
+// swappableHandler serves requests with the handler stored last
+type swappableHandler struct {
+	handler atomic.Value
+}
+
+func (h *swappableHandler) Store(handler http.Handler) {
+	h.handler.Store(&handler)
+}
+
+func (h *swappableHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	(*h.handler.Load().(*http.Handler)).ServeHTTP(w, r)
+}
+
+// shutdown drains the engine and stops the server within opts.ShutdownTimeout. Jobs in
+// progress get opts.LeaseGrace to finish and are queued again after it, then in-flight
+// requests are finished, push connections closed, the last webhooks sent and the store
+// flushed.
+func shutdown(server *http.Server, engine *jobqueue.Engine, store storage.Store, workers background, opts Options) error {
+	utils.Logger.Info("Shutting down REST API server")
+	ctx := context.Background()
+	if opts.ShutdownTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.ShutdownTimeout)
+		defer cancel()
+	}
 
-	router.PathPrefix("/swagger/").Handler(httpSwagger.WrapHandler)
+	// draining refuses new jobs and releases the blocked dequeues
+	engine.Drain()
 
-	utils.Logger.Info("Started server at port 8080")
+	grace := time.NewTimer(opts.LeaseGrace)
+	defer grace.Stop()
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+wait:
+	for engine.InProgress() > 0 {
+		select {
+		case <-ctx.Done():
+			break wait
+		case <-grace.C:
+			break wait
+		case <-ticker.C:
+		}
+	}
+	if requeued := engine.RequeueInProgress(); requeued > 0 {
+		utils.Logger.Info("Queued " + strconv.Itoa(requeued) + " unfinished jobs again")
+	}
 
-	http.ListenAndServe("localhost:8080", router)
+	err := server.Shutdown(ctx)
+	// upgraded push connections are left open by Shutdown, their jobs go back into the queue
+	// before the store is flushed
+	if workers.services != nil {
+		if closeErr := workers.services.ClosePush(ctx); err == nil {
+			err = closeErr
+		}
+	}
+	// the pusher stops before the dispatcher, so the webhooks of the jobs it reports are still sent
+	if workers.pusher != nil {
+		if closeErr := workers.pusher.Close(ctx); err == nil {
+			err = closeErr
+		}
+	}
+	if workers.dispatcher != nil {
+		if closeErr := workers.dispatcher.Close(ctx); err == nil {
+			err = closeErr
+		}
+	}
+	if closeErr := store.Close(); err == nil {
+		err = closeErr
+	}
+	utils.Logger.Info("Stopped REST API server")
+	return err
 }
@@ -0,0 +1,139 @@
+// Package health serves the liveness and readiness endpoints of the server.
+//
+// Liveness only says the process serves requests. Readiness runs the checks of the
+// subsystems, e.g. storage, journal recovery, replication and drain mode, and fails
+// while any of them fails. Adding verbose to the query of either endpoint lists the
+// result and latency of every check.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/varungujarathi9/job-queue/internal/apierror"
+)
+
+const (
+	statusOK   = "ok"
+	statusFail = "fail"
+
+	// checkTimeout bounds a single check
+	checkTimeout = 2 * time.Second
+)
+
+// CheckFunc reports why a subsystem is not ready, nil when it is
+type CheckFunc func(ctx context.Context) error
+
+// Result is the outcome of one check
+type Result struct {
+	Name    string `json:"Name"`
+	Status  string `json:"Status"`
+	Error   string `json:"Error,omitempty"`
+	Latency string `json:"Latency"`
+}
+
+// Report is the verbose answer of an endpoint
+type Report struct {
+	Status string   `json:"Status"`
+	Checks []Result `json:"Checks"`
+}
+
+// Checker holds the readiness checks of a server, it is safe for concurrent use
+type Checker struct {
+	mutex  sync.Mutex
+	names  []string
+	checks map[string]CheckFunc
+}
+
+// New creates a checker without checks
+func New() *Checker {
+	return &Checker{checks: make(map[string]CheckFunc)}
+}
+
+// Add adds a readiness check, a check added under a name that is taken replaces it
+func (c *Checker) Add(name string, check CheckFunc) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if _, exists := c.checks[name]; !exists {
+		c.names = append(c.names, name)
+	}
+	c.checks[name] = check
+}
+
+// Run runs every check at once and returns their results in the order they were added
+func (c *Checker) Run(ctx context.Context) []Result {
+	c.mutex.Lock()
+	names := append([]string(nil), c.names...)
+	checks := make([]CheckFunc, len(names))
+	for i, name := range names {
+		checks[i] = c.checks[name]
+	}
+	c.mutex.Unlock()
+
+	results := make([]Result, len(names))
+	var wg sync.WaitGroup
+	for i := range names {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+			defer cancel()
+
+			start := time.Now()
+			err := checks[i](ctx)
+			results[i] = Result{Name: names[i], Status: statusOK, Latency: time.Since(start).String()}
+			if err != nil {
+				results[i].Status = statusFail
+				results[i].Error = err.Error()
+			}
+		}(i)
+	}
+	wg.Wait()
+	return results
+}
+
+// LiveService reports that the process serves requests
+func (c *Checker) LiveService(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.URL.Query().Has("verbose") {
+		json.NewEncoder(w).Encode(Report{Status: statusOK, Checks: []Result{}})
+		return
+	}
+	w.Write([]byte(`{"status" : "ok"}`))
+}
+
+// ReadyService reports whether every check passes, with 503 when one fails
+func (c *Checker) ReadyService(w http.ResponseWriter, r *http.Request) {
+	results := c.Run(r.Context())
+	var failed []string
+	for _, result := range results {
+		if result.Status != statusOK {
+			failed = append(failed, result.Name)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	status := http.StatusOK
+	if len(failed) > 0 {
+		status = http.StatusServiceUnavailable
+	}
+	if r.URL.Query().Has("verbose") {
+		report := Report{Status: statusOK, Checks: results}
+		if len(failed) > 0 {
+			report.Status = statusFail
+		}
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(report)
+		return
+	}
+	if len(failed) > 0 {
+		apierror.Write(w, apierror.New(status, apierror.CodeUnavailable, "not ready: "+strings.Join(failed, ", ")).With("Checks", failed))
+		return
+	}
+	w.Write([]byte(`{"status" : "ready"}`))
+}
@@ -0,0 +1,48 @@
+package logging
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+	"github.com/varungujarathi9/job-queue/internal/apierror"
+)
+
+// LevelStatus reports the level of a logger
+type LevelStatus struct {
+	Level string `json:"Level"`
+}
+
+// Levels reads and changes the level of a logger at runtime
+type Levels struct {
+	logger *logrus.Logger
+}
+
+// NewLevels serves the level of logger
+func NewLevels(logger *logrus.Logger) *Levels {
+	return &Levels{logger: logger}
+}
+
+// LevelService reports the current log level
+func (l *Levels) LevelService(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(LevelStatus{Level: l.logger.GetLevel().String()})
+}
+
+// SetLevelService changes the log level until the next restart, the body names the new
+// level, e.g. {"Level": "debug"}
+func (l *Levels) SetLevelService(w http.ResponseWriter, r *http.Request) {
+	var req LevelStatus
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.InvalidRequest(w, "Invalid body: "+err.Error())
+		return
+	}
+	level, err := logrus.ParseLevel(req.Level)
+	if err != nil {
+		apierror.Write(w, apierror.New(http.StatusBadRequest, apierror.CodeInvalidRequest, "Invalid log level").With("Level", req.Level))
+		return
+	}
+	previous := l.logger.GetLevel()
+	l.logger.SetLevel(level)
+	FromContext(r.Context(), l.logger).WithField("previous", previous.String()).Info("Log level changed to " + level.String())
+	json.NewEncoder(w).Encode(LevelStatus{Level: level.String()})
+}
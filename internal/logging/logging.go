@@ -0,0 +1,149 @@
+// Package logging logs the requests of the REST API and hands their handlers a logger
+// scoped to the request.
+//
+// The middleware takes the request ID from the X-Request-ID header, or makes one up,
+// and returns it in the same response header. Every line logged through FromContext
+// carries the request ID, and handlers add the fields they learn while serving, e.g.
+// the job ID of a dequeue, with AddFields. When the request is done one access line
+// reports its status and latency along with those fields.
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	"github.com/varungujarathi9/job-queue/internal/utils"
+)
+
+const (
+	// RequestIDHeader carries the ID of a request, in requests and responses
+	RequestIDHeader = "X-Request-ID"
+
+	// maxRequestIDLength bounds the request IDs taken from clients
+	maxRequestIDLength = 128
+)
+
+type contextKey struct{}
+
+// requestLog is the logger of one request, the fields added by handlers go to every
+// line logged after them and to the access line
+type requestLog struct {
+	id    string
+	mutex sync.Mutex
+	entry *logrus.Entry
+}
+
+// Middleware logs one access line per request served by the routes of a mux router and
+// puts a logger scoped to the request into its context
+func Middleware(logger *logrus.Logger) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			id := r.Header.Get(RequestIDHeader)
+			if !validRequestID(id) {
+				id = newRequestID()
+				// requests forwarded to other nodes keep the ID
+				r.Header.Set(RequestIDHeader, id)
+			}
+			w.Header().Set(RequestIDHeader, id)
+
+			route := ""
+			if current := mux.CurrentRoute(r); current != nil {
+				route = current.GetName()
+			}
+			fields := logrus.Fields{
+				"request_id": id,
+				"method":     r.Method,
+				"url":        redact(r.URL),
+				"route":      route,
+			}
+			if jobID, err := strconv.Atoi(mux.Vars(r)["job_id"]); err == nil {
+				fields["job_id"] = jobID
+			}
+			log := &requestLog{id: id, entry: logger.WithFields(fields)}
+
+			recorder := utils.NewStatusRecorder(w)
+			next.ServeHTTP(recorder, r.WithContext(context.WithValue(r.Context(), contextKey{}, log)))
+
+			log.mutex.Lock()
+			entry := log.entry
+			log.mutex.Unlock()
+			entry = entry.WithFields(logrus.Fields{
+				"status":     recorder.Status,
+				"latency_ms": float64(time.Since(start).Microseconds()) / 1000,
+			})
+			if recorder.Status >= http.StatusInternalServerError {
+				entry.Error("Request served")
+			} else {
+				entry.Info("Request served")
+			}
+		})
+	}
+}
+
+// FromContext returns the logger of the request ctx belongs to, or fallback when the
+// request did not pass the middleware
+func FromContext(ctx context.Context, fallback *logrus.Logger) *logrus.Entry {
+	if log, ok := ctx.Value(contextKey{}).(*requestLog); ok {
+		log.mutex.Lock()
+		defer log.mutex.Unlock()
+		return log.entry
+	}
+	return logrus.NewEntry(fallback)
+}
+
+// AddFields adds fields to the logger of the request ctx belongs to and to its access line
+func AddFields(ctx context.Context, fields logrus.Fields) {
+	if log, ok := ctx.Value(contextKey{}).(*requestLog); ok {
+		log.mutex.Lock()
+		defer log.mutex.Unlock()
+		log.entry = log.entry.WithFields(fields)
+	}
+}
+
+// RequestID returns the ID of the request ctx belongs to, empty when it has none
+func RequestID(ctx context.Context) string {
+	if log, ok := ctx.Value(contextKey{}).(*requestLog); ok {
+		return log.id
+	}
+	return ""
+}
+
+// validRequestID accepts the IDs of clients that are short and printable
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// redact hides the token of requests authenticated with the access_token query parameter
+func redact(u *url.URL) string {
+	query := u.Query()
+	if !query.Has("access_token") {
+		return u.String()
+	}
+	query.Set("access_token", "REDACTED")
+	redacted := *u
+	redacted.RawQuery = query.Encode()
+	return redacted.String()
+}
@@ -0,0 +1,155 @@
+package logging
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+const (
+	// OutputFile writes to a file that is rotated by size and age
+	OutputFile = "file"
+	// OutputStdout writes to the standard output
+	OutputStdout = "stdout"
+	// OutputStderr writes to the standard error
+	OutputStderr = "stderr"
+
+	// FormatJSON writes one JSON object per line
+	FormatJSON = "json"
+	// FormatText writes logfmt-like lines
+	FormatText = "text"
+)
+
+// Outputs lists the supported log outputs
+var Outputs = []string{OutputFile, OutputStdout, OutputStderr}
+
+// Options selects where and how a logger writes
+type Options struct {
+	// Output is one of Outputs, empty means OutputFile
+	Output string
+	// Path is the log file of OutputFile, the log goes to stderr when it is empty
+	Path string
+	// Format is FormatJSON or FormatText, empty means FormatJSON
+	Format string
+	// Level is one of the logrus levels
+	Level string
+	// RotateSize rotates the file once it grows past this many megabytes, 0 never does
+	RotateSize int
+	// RotateEvery rotates the file at this interval, 0 never does
+	RotateEvery time.Duration
+	// MaxBackups is how many rotated files are kept, 0 keeps them all
+	MaxBackups int
+	// MaxAge removes rotated files older than this, rounded up to whole days, 0 keeps them
+	MaxAge time.Duration
+	// Compress gzips rotated files
+	Compress bool
+}
+
+// Setup points logger at the output of opts with its format and level. The returned
+// closer stops the rotation and closes the log file.
+func Setup(logger *logrus.Logger, opts Options) (io.Closer, error) {
+	level, err := logrus.ParseLevel(opts.Level)
+	if err != nil {
+		return nil, err
+	}
+	switch opts.Format {
+	case "", FormatJSON:
+		logger.SetFormatter(&logrus.JSONFormatter{})
+	case FormatText:
+		logger.SetFormatter(&logrus.TextFormatter{})
+	default:
+		return nil, fmt.Errorf("unknown log format %q", opts.Format)
+	}
+
+	var out io.Writer
+	closer := io.Closer(nopCloser{})
+	switch opts.Output {
+	case "", OutputFile:
+		if opts.Path == "" {
+			out = os.Stderr
+			break
+		}
+		file, err := openRotatingFile(opts)
+		if err != nil {
+			return nil, err
+		}
+		out, closer = file, file
+	case OutputStdout:
+		out = os.Stdout
+	case OutputStderr:
+		out = os.Stderr
+	default:
+		return nil, fmt.Errorf("unknown log output %q", opts.Output)
+	}
+	logger.SetOutput(out)
+	logger.SetLevel(level)
+	return closer, nil
+}
+
+type nopCloser struct{}
+
+func (nopCloser) Close() error { return nil }
+
+// rotatingFile is a log file rotated by size and, on a timer, by age
+type rotatingFile struct {
+	*lumberjack.Logger
+	stop chan struct{}
+	once sync.Once
+}
+
+func openRotatingFile(opts Options) (*rotatingFile, error) {
+	size := opts.RotateSize
+	if size <= 0 {
+		// lumberjack treats 0 as its default of 100 megabytes
+		size = math.MaxInt32
+	}
+	days := 0
+	if opts.MaxAge > 0 {
+		days = int(math.Ceil(opts.MaxAge.Hours() / 24))
+	}
+	f := &rotatingFile{
+		Logger: &lumberjack.Logger{
+			Filename:   opts.Path,
+			MaxSize:    size,
+			MaxBackups: opts.MaxBackups,
+			MaxAge:     days,
+			Compress:   opts.Compress,
+			LocalTime:  true,
+		},
+		stop: make(chan struct{}),
+	}
+	// the file is opened now so a path that cannot be written fails the setup
+	if _, err := f.Write(nil); err != nil {
+		return nil, err
+	}
+	if opts.RotateEvery > 0 {
+		go f.rotateEvery(opts.RotateEvery)
+	}
+	return f, nil
+}
+
+func (f *rotatingFile) rotateEvery(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-f.stop:
+			return
+		case <-ticker.C:
+			if err := f.Rotate(); err != nil {
+				fmt.Fprintln(os.Stderr, "Failed to rotate log: "+err.Error())
+			}
+		}
+	}
+}
+
+func (f *rotatingFile) Close() error {
+	f.once.Do(func() { close(f.stop) })
+	return f.Logger.Close()
+}
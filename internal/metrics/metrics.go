@@ -0,0 +1,199 @@
+// Package metrics exposes the state of a queue engine and of the REST API in the
+// Prometheus text exposition format.
+//
+// Job counters and histograms are derived from the engine's change stream when the
+// metrics are scraped, so the engine itself keeps no metrics. Changes recorded before
+// New is called, e.g. the ones restored from a journal, are not counted.
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/varungujarathi9/job-queue/internal/utils"
+	"github.com/varungujarathi9/job-queue/pkg/jobqueue"
+)
+
+const (
+	namespace = "job_queue"
+
+	// statusCancelled labels cancelled jobs, whatever status they had when cancelled
+	statusCancelled = "CANCELLED"
+
+	changesPage = 1000
+)
+
+// buckets spans a millisecond to a little over four minutes, long enough for long-polling dequeues
+var buckets = prometheus.ExponentialBuckets(0.001, 4, 10)
+
+// operations maps the operations of the change stream to the label they are counted under
+var operations = map[string]string{
+	jobqueue.OpEnqueue:  "enqueue",
+	jobqueue.OpDequeue:  "dequeue",
+	jobqueue.OpConclude: "conclude",
+	jobqueue.OpFail:     "fail",
+	jobqueue.OpCancel:   "cancel",
+	jobqueue.OpRetry:    "retry",
+	jobqueue.OpExpire:   "expire",
+}
+
+// Metrics holds the metrics of one server
+type Metrics struct {
+	registry *prometheus.Registry
+	requests *prometheus.HistogramVec
+	errors   *prometheus.CounterVec
+}
+
+// New creates the metrics of the engine and of the HTTP requests passed through Middleware
+func New(engine *jobqueue.Engine) *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Time taken to serve HTTP requests by route and method.",
+			Buckets:   buckets,
+		}, []string{"route", "method"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_request_errors_total",
+			Help:      "HTTP requests answered with a 4xx or 5xx status by route, method and status.",
+		}, []string{"route", "method", "code"}),
+	}
+	m.registry.MustRegister(
+		newEngineCollector(engine),
+		m.requests,
+		m.errors,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// Handler serves the metrics
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// Middleware records the latency and errors of the requests served by the routes of a mux router
+func (m *Metrics) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := ""
+		if current := mux.CurrentRoute(r); current != nil {
+			route = current.GetName()
+		}
+		recorder := utils.NewStatusRecorder(w)
+		start := time.Now()
+		next.ServeHTTP(recorder, r)
+
+		m.requests.WithLabelValues(route, r.Method).Observe(time.Since(start).Seconds())
+		if recorder.Status >= 400 {
+			m.errors.WithLabelValues(route, r.Method, strconv.Itoa(recorder.Status)).Inc()
+		}
+	})
+}
+
+// engineCollector reads the engine's change stream on every scrape
+type engineCollector struct {
+	engine *jobqueue.Engine
+	jobs   *prometheus.Desc
+
+	mutex      sync.Mutex
+	seq        int
+	operations *prometheus.CounterVec
+	wait       *prometheus.HistogramVec
+	processing *prometheus.HistogramVec
+}
+
+func newEngineCollector(engine *jobqueue.Engine) *engineCollector {
+	return &engineCollector{
+		engine: engine,
+		jobs: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "jobs"),
+			"Jobs known to the queue by status and type.",
+			[]string{"status", "type"}, nil,
+		),
+		seq: engine.LastSeq(),
+		operations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "operations_total",
+			Help:      "Jobs enqueued, dequeued, concluded, failed, cancelled, retried and expired by operation and type.",
+		}, []string{"operation", "type"}),
+		wait: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "wait_seconds",
+			Help:      "Time jobs waited in the queue before they were dequeued, by type.",
+			Buckets:   buckets,
+		}, []string{"type"}),
+		processing: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "processing_seconds",
+			Help:      "Time from dequeue to conclude or fail, by type and outcome.",
+			Buckets:   buckets,
+		}, []string{"type", "outcome"}),
+	}
+}
+
+func (c *engineCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.jobs
+	c.operations.Describe(ch)
+	c.wait.Describe(ch)
+	c.processing.Describe(ch)
+}
+
+func (c *engineCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for {
+		_, changes := c.engine.Changes(c.seq, changesPage)
+		if len(changes) == 0 {
+			break
+		}
+		for _, change := range changes {
+			c.observe(change)
+			c.seq = change.Seq
+		}
+	}
+
+	// cancelled jobs are counted together whatever their status
+	jobs := map[[2]string]int{}
+	for _, count := range c.engine.Counts() {
+		status := count.Status
+		if count.Cancelled {
+			status = statusCancelled
+		}
+		jobs[[2]string{status, count.Type}] += count.Jobs
+	}
+	for labels, count := range jobs {
+		ch <- prometheus.MustNewConstMetric(c.jobs, prometheus.GaugeValue, float64(count), labels[0], labels[1])
+	}
+	c.operations.Collect(ch)
+	c.wait.Collect(ch)
+	c.processing.Collect(ch)
+}
+
+// observe counts one change, the caller must hold mutex
+func (c *engineCollector) observe(change jobqueue.Change) {
+	job := change.Job
+	operation, ok := operations[change.Op]
+	if !ok {
+		return
+	}
+	c.operations.WithLabelValues(operation, job.Type).Inc()
+
+	switch change.Op {
+	case jobqueue.OpDequeue:
+		c.wait.WithLabelValues(job.Type).Observe(job.DequeueTime.Sub(job.EnqueueTime).Seconds())
+	case jobqueue.OpConclude, jobqueue.OpFail:
+		if !job.DequeueTime.IsZero() {
+			c.processing.WithLabelValues(job.Type, operation).Observe(change.Time.Sub(job.DequeueTime).Seconds())
+		}
+	}
+}
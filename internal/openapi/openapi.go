@@ -0,0 +1,226 @@
+// Package openapi builds an OpenAPI 3 document from a list of operations at runtime, so the
+// document served by the server always matches the routes it registered.
+//
+// Request and response bodies are given as Go values, their schemas are read from the
+// types with reflection and the json tags of their fields. Named struct types become
+// components that the operations refer to.
+package openapi
+
+import (
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// where a parameter is read from
+const (
+	InPath   = "path"
+	InQuery  = "query"
+	InHeader = "header"
+)
+
+// Param is a path, query or header parameter of an operation
+type Param struct {
+	Name        string
+	In          string
+	Description string
+	Required    bool
+	// Type is the JSON type of the parameter, string when empty
+	Type string
+	// Array makes the parameter a list of Type that may be repeated
+	Array bool
+}
+
+// Response is one possible answer of an operation
+type Response struct {
+	Description string
+	// Body is a value of the type of the body, nil for a response without one
+	Body interface{}
+}
+
+// Operation describes one route
+type Operation struct {
+	Method  string
+	Path    string
+	Name    string
+	Summary string
+	Params  []Param
+	// Body is a value of the type of the request body, nil for a request without one
+	Body      interface{}
+	Responses map[int]Response
+}
+
+// Document is an OpenAPI document
+type Document map[string]interface{}
+
+// Build returns the document describing ops
+func Build(title, version string, ops []Operation) Document {
+	components := schemas{}
+	paths := map[string]map[string]interface{}{}
+	for _, op := range ops {
+		item, ok := paths[op.Path]
+		if !ok {
+			item = map[string]interface{}{}
+			paths[op.Path] = item
+		}
+		item[strings.ToLower(op.Method)] = components.operation(op)
+	}
+	return Document{
+		"openapi": "3.0.3",
+		"info":    map[string]interface{}{"title": title, "version": version},
+		"paths":   paths,
+		"components": map[string]interface{}{
+			"schemas": components,
+		},
+	}
+}
+
+// schemas holds the components found while building the document, by name
+type schemas map[string]interface{}
+
+func (c schemas) operation(op Operation) map[string]interface{} {
+	operation := map[string]interface{}{
+		"operationId": op.Name,
+		"summary":     op.Summary,
+	}
+	if len(op.Params) > 0 {
+		params := make([]interface{}, 0, len(op.Params))
+		for _, param := range op.Params {
+			params = append(params, parameter(param))
+		}
+		operation["parameters"] = params
+	}
+	if op.Body != nil {
+		operation["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content":  c.content(op.Body),
+		}
+	}
+
+	codes := make([]int, 0, len(op.Responses))
+	for code := range op.Responses {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	responses := map[string]interface{}{}
+	for _, code := range codes {
+		resp := op.Responses[code]
+		description := resp.Description
+		if description == "" {
+			description = http.StatusText(code)
+		}
+		entry := map[string]interface{}{"description": description}
+		if resp.Body != nil {
+			entry["content"] = c.content(resp.Body)
+		}
+		responses[strconv.Itoa(code)] = entry
+	}
+	operation["responses"] = responses
+	return operation
+}
+
+func (c schemas) content(body interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"application/json": map[string]interface{}{"schema": c.schema(reflect.TypeOf(body))},
+	}
+}
+
+func parameter(param Param) map[string]interface{} {
+	paramType := param.Type
+	if paramType == "" {
+		paramType = "string"
+	}
+	schema := map[string]interface{}{"type": paramType}
+	if param.Array {
+		schema = map[string]interface{}{"type": "array", "items": schema}
+	}
+	entry := map[string]interface{}{
+		"name":     param.Name,
+		"in":       param.In,
+		"required": param.Required || param.In == InPath,
+		"schema":   schema,
+	}
+	if param.Description != "" {
+		entry["description"] = param.Description
+	}
+	return entry
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schema returns the schema of t, named structs are added to the components and referred to
+func (c schemas) schema(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == timeType {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": c.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": c.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return c.object(t)
+		}
+		ref := map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+		if _, known := c[t.Name()]; !known {
+			// the placeholder stops recursive types from being walked again
+			c[t.Name()] = nil
+			c[t.Name()] = c.object(t)
+		}
+		return ref
+	}
+	// interfaces hold any JSON value
+	return map[string]interface{}{}
+}
+
+// object returns the schema of a struct, the fields of embedded structs are inlined
+func (c schemas) object(t reflect.Type) map[string]interface{} {
+	properties := map[string]interface{}{}
+	var required []string
+	var collect func(t reflect.Type)
+	collect = func(t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			name, options, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if name == "-" {
+				continue
+			}
+			if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+				collect(field.Type)
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			properties[name] = c.schema(field.Type)
+			if !strings.Contains(options, "omitempty") && field.Type.Kind() != reflect.Pointer {
+				required = append(required, name)
+			}
+		}
+	}
+	collect(t)
+	object := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		object["required"] = required
+	}
+	return object
+}
@@ -0,0 +1,83 @@
+package push
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/varungujarathi9/job-queue/internal/apierror"
+	"github.com/varungujarathi9/job-queue/internal/logging"
+	"github.com/varungujarathi9/job-queue/internal/utils"
+)
+
+// EndpointList is the body listing the endpoints
+type EndpointList struct {
+	Endpoints []Endpoint `json:"Endpoints"`
+}
+
+// endpointID reads the endpoint ID of the route
+func endpointID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["endpoint_id"])
+	if err != nil || id <= 0 {
+		apierror.InvalidRequest(w, "Invalid endpoint_id: "+mux.Vars(r)["endpoint_id"])
+		return 0, false
+	}
+	return id, true
+}
+
+func notFound(w http.ResponseWriter, id int) {
+	apierror.Write(w, apierror.New(http.StatusNotFound, apierror.CodeNotFound, "Push endpoint not found").With("ID", id))
+}
+
+// EndpointsService lists the endpoints
+func (p *Pusher) EndpointsService(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(EndpointList{Endpoints: p.Endpoints()})
+}
+
+// EndpointService returns one endpoint
+func (p *Pusher) EndpointService(w http.ResponseWriter, r *http.Request) {
+	id, ok := endpointID(w, r)
+	if !ok {
+		return
+	}
+	for _, e := range p.Endpoints() {
+		if e.ID == id {
+			json.NewEncoder(w).Encode(e)
+			return
+		}
+	}
+	notFound(w, id)
+}
+
+// CreateEndpointService adds an endpoint, it lasts until the server restarts
+func (p *Pusher) CreateEndpointService(w http.ResponseWriter, r *http.Request) {
+	var e Endpoint
+	if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+		apierror.InvalidRequest(w, "Invalid body: "+err.Error())
+		return
+	}
+	if err := e.Validate(); err != nil {
+		apierror.InvalidRequest(w, err.Error())
+		return
+	}
+	e = p.Add(e)
+	logging.FromContext(r.Context(), utils.Logger).Info("Push endpoint " + strconv.Itoa(e.ID) + " created for " + e.URL)
+	w.Header().Set("Location", "/push/endpoints/"+strconv.Itoa(e.ID))
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(e)
+}
+
+// DeleteEndpointService removes an endpoint
+func (p *Pusher) DeleteEndpointService(w http.ResponseWriter, r *http.Request) {
+	id, ok := endpointID(w, r)
+	if !ok {
+		return
+	}
+	if err := p.Remove(id); err != nil {
+		notFound(w, id)
+		return
+	}
+	logging.FromContext(r.Context(), utils.Logger).Info("Push endpoint " + strconv.Itoa(id) + " deleted")
+	w.Write([]byte(`{"status" : "Push endpoint deleted"}`))
+}
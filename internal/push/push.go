@@ -0,0 +1,437 @@
+// Package push delivers jobs to HTTP workers that cannot poll the queue.
+//
+// An endpoint maps job types or a queue to a URL. The pusher dequeues the jobs it matches
+// as the endpoint's consumer and POSTs each one there as JSON, holding the lease with
+// heartbeats while the request runs. A 2xx answer concludes the job, its JSON body becoming
+// the job's Result, and any other answer fails it. A failed job is retried with a doubling
+// backoff until it made MaxAttempts attempts, counted by the job's Attempts. The retries
+// are scheduled from the failed jobs, so they wait while the queue is drained and those of
+// the endpoints in Options are picked up again after a restart. When a Secret is set the jobs are signed the same way
+// as webhooks:
+//
+//	X-Queue-Timestamp: 1714557600
+//	X-Queue-Signature: sha256=<hex HMAC-SHA256 of "1714557600." + body>
+package push
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/varungujarathi9/job-queue/internal/utils"
+	"github.com/varungujarathi9/job-queue/internal/webhooks"
+	"github.com/varungujarathi9/job-queue/pkg/jobqueue"
+	"github.com/varungujarathi9/job-queue/pkg/webhook"
+)
+
+// headers of a pushed job, besides the signature headers of the webhook package
+const (
+	JobHeader     = "X-Queue-Job"
+	AttemptHeader = "X-Queue-Attempt"
+)
+
+// ErrNotFound is returned for an unknown endpoint
+var ErrNotFound = errors.New("not found")
+
+const (
+	defaultMaxAttempts = 3
+	defaultBackoff     = time.Second
+	defaultTimeout     = 30 * time.Second
+
+	// maxResult caps how much of an answer is kept as the job's result
+	maxResult = 1 << 20
+	// drainRetry is how often a drained queue is checked for having resumed
+	drainRetry = time.Second
+)
+
+// Options configures a Pusher
+type Options struct {
+	// Secret signs the pushed jobs, they are not signed when it is empty
+	Secret string
+	// MaxAttempts is how many attempts a job gets before it stays failed, 3 by default
+	MaxAttempts int
+	// Backoff is the wait before the first retry, it doubles for each retry after it, 1s by default
+	Backoff time.Duration
+	// Timeout bounds one POST, 30s by default
+	Timeout time.Duration
+	// Endpoints are created along with the pusher
+	Endpoints []Endpoint
+}
+
+// Endpoint is sent the jobs it matches, one POST per job
+type Endpoint struct {
+	ID  int    `json:"ID"`
+	URL string `json:"URL"`
+	// Consumer is the consumer ID the endpoint's jobs are dequeued as
+	Consumer int `json:"Consumer"`
+	// Types and Queue choose the jobs, at least one of them is needed, and Tenant narrows them down
+	Types  []string `json:"Types,omitempty"`
+	Queue  string   `json:"Queue,omitempty"`
+	Tenant string   `json:"Tenant,omitempty"`
+	// MaxConcurrency is how many jobs are sent to the endpoint at once, 1 by default
+	MaxConcurrency int `json:"MaxConcurrency,omitempty"`
+}
+
+// Validate checks the URL, consumer and jobs of an endpoint
+func (e Endpoint) Validate() error {
+	if err := webhooks.ValidateURL(e.URL); err != nil {
+		return err
+	}
+	if e.Consumer <= 0 {
+		return fmt.Errorf("endpoint %s needs a positive consumer ID", e.URL)
+	}
+	if len(e.Types) == 0 && e.Queue == "" {
+		return fmt.Errorf("endpoint %s needs types or a queue", e.URL)
+	}
+	if e.MaxConcurrency < 0 {
+		return fmt.Errorf("endpoint %s: max concurrency must not be negative", e.URL)
+	}
+	return nil
+}
+
+func (e Endpoint) filter() jobqueue.Filter {
+	filter := jobqueue.Filter{Types: e.Types}
+	if e.Queue != "" {
+		filter.Queue = &e.Queue
+	}
+	if e.Tenant != "" {
+		filter.Tenant = &e.Tenant
+	}
+	return filter
+}
+
+// endpoint is a registered endpoint along with how to stop its workers
+type endpoint struct {
+	Endpoint
+	stop context.CancelFunc
+}
+
+// Pusher dequeues the jobs of its endpoints and POSTs them there
+type Pusher struct {
+	engine *jobqueue.Engine
+	opts   Options
+	client *http.Client
+
+	// ctx is cancelled by Close, which waits for wg, i.e. the workers and the retries
+	ctx  context.Context
+	stop context.CancelFunc
+	wg   sync.WaitGroup
+
+	mu        sync.Mutex
+	endpoints []endpoint
+	nextID    int
+	closed    bool
+	// retries holds the failed jobs with attempts left along with when they are due
+	retries map[int]time.Time
+}
+
+// New creates a pusher and starts sending jobs to the endpoints of opts, each of which
+// Validate must have accepted
+func New(engine *jobqueue.Engine, opts Options) *Pusher {
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = defaultMaxAttempts
+	}
+	if opts.Backoff <= 0 {
+		opts.Backoff = defaultBackoff
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaultTimeout
+	}
+	p := &Pusher{
+		engine:  engine,
+		opts:    opts,
+		client:  &http.Client{Timeout: opts.Timeout},
+		nextID:  1,
+		retries: map[int]time.Time{},
+	}
+	p.ctx, p.stop = context.WithCancel(context.Background())
+	p.pickUp()
+	for _, e := range opts.Endpoints {
+		p.Add(e)
+	}
+	p.wg.Add(1)
+	go p.retry()
+	return p
+}
+
+// Close stops sending jobs and retrying the failed ones, and waits until the jobs being
+// sent are delivered or ctx is done. The jobs dequeued but not sent yet go back into the
+// queue.
+func (p *Pusher) Close(ctx context.Context) error {
+	p.mu.Lock()
+	p.closed = true
+	p.mu.Unlock()
+	p.stop()
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// pickUp schedules the retries of the failed jobs of the endpoints in opts, so that the
+// retries due before a restart are not lost
+func (p *Pusher) pickUp() {
+	consumers := map[int]bool{}
+	for _, e := range p.opts.Endpoints {
+		consumers[e.Consumer] = true
+	}
+	for _, job := range p.engine.List(jobqueue.StatusFailed, "") {
+		if !consumers[job.ConsumedBy] || job.Attempts == 0 || job.Attempts >= p.opts.MaxAttempts {
+			continue
+		}
+		// the history only tells when the job failed until it is compacted, then the
+		// retry is due right away
+		due := time.Now()
+		if attempts, _ := p.engine.Attempts(job.ID); len(attempts) > 0 && attempts[len(attempts)-1].Ended != nil {
+			due = attempts[len(attempts)-1].Ended.Add(p.backoff(job.Attempts))
+		}
+		p.retries[job.ID] = due
+	}
+}
+
+// backoff returns the wait before retrying a job that failed the given attempt
+func (p *Pusher) backoff(attempt int) time.Duration {
+	return p.opts.Backoff << (attempt - 1)
+}
+
+// retry puts the failed jobs back into the queue once they are due until the pusher is
+// closed, a job that cannot be retried while the queue is drained stays due
+func (p *Pusher) retry() {
+	defer p.wg.Done()
+	interval := p.opts.Backoff
+	if interval > drainRetry {
+		interval = drainRetry
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		now := time.Now()
+		p.mu.Lock()
+		due := []int{}
+		for id, at := range p.retries {
+			if !at.After(now) {
+				due = append(due, id)
+			}
+		}
+		p.mu.Unlock()
+
+		for _, id := range due {
+			if !p.retryJob(id) {
+				continue
+			}
+			p.mu.Lock()
+			delete(p.retries, id)
+			p.mu.Unlock()
+		}
+	}
+}
+
+// retryJob puts a failed job back into the queue and reports whether it is done with it,
+// a job retried or cancelled by someone else in the meantime is left alone
+func (p *Pusher) retryJob(id int) bool {
+	job, err := p.engine.Job(id)
+	if err != nil || job.Status != jobqueue.StatusFailed || job.Cancel {
+		return true
+	}
+	err = p.engine.Retry(id)
+	if errors.Is(err, jobqueue.ErrDraining) {
+		return false
+	}
+	if err != nil {
+		utils.Logger.WithField("job_id", id).Warn("Pushed job not retried: " + err.Error())
+	}
+	return true
+}
+
+// Add registers an endpoint and starts sending jobs to it, Validate must have accepted it.
+// It returns the endpoint with its ID.
+func (p *Pusher) Add(e Endpoint) Endpoint {
+	if e.MaxConcurrency == 0 {
+		e.MaxConcurrency = 1
+	}
+	ctx, stop := context.WithCancel(p.ctx)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	e.ID = p.nextID
+	p.nextID++
+	p.endpoints = append(p.endpoints, endpoint{Endpoint: e, stop: stop})
+	if p.closed {
+		return e
+	}
+
+	for i := 0; i < e.MaxConcurrency; i++ {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			p.work(ctx, e)
+		}()
+	}
+	return e
+}
+
+// Remove stops sending jobs to an endpoint, the jobs it is sent already are still delivered
+func (p *Pusher) Remove(id int) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, e := range p.endpoints {
+		if e.ID == id {
+			e.stop()
+			p.endpoints = append(p.endpoints[:i], p.endpoints[i+1:]...)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+// Endpoints returns the endpoints ordered by ID
+func (p *Pusher) Endpoints() []Endpoint {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	endpoints := make([]Endpoint, 0, len(p.endpoints))
+	for _, e := range p.endpoints {
+		endpoints = append(endpoints, e.Endpoint)
+	}
+	return endpoints
+}
+
+// work sends one job at a time to an endpoint until ctx is done, i.e. the endpoint is
+// removed or the pusher closed. The endpoint runs MaxConcurrency of them.
+func (p *Pusher) work(ctx context.Context, e Endpoint) {
+	filter := e.filter()
+	for {
+		job, err := p.engine.DequeueMatching(ctx, e.Consumer, filter)
+		switch {
+		case ctx.Err() != nil:
+			if err == nil {
+				// the job was dequeued as the work stopped, it is not sent and goes back into the queue
+				p.engine.Release(job.ID, e.Consumer)
+			}
+			return
+		case errors.Is(err, jobqueue.ErrDraining):
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(drainRetry):
+			}
+			continue
+		case err != nil:
+			utils.Logger.Error("Push to " + e.URL + " stopped: " + err.Error())
+			return
+		}
+		p.deliver(e, job)
+	}
+}
+
+// deliver sends a dequeued job to an endpoint and concludes or fails it by the answer
+func (p *Pusher) deliver(e Endpoint, job jobqueue.Job) {
+	attempt := job.Attempts
+	log := utils.Logger.WithFields(logrus.Fields{"job_id": job.ID, "endpoint": e.ID, "attempt": attempt})
+
+	result, err := p.post(e, job, attempt)
+	if err == nil {
+		if err := p.engine.ConcludeAs(job.ID, e.Consumer, result); err != nil {
+			log.Warn("Pushed job not concluded: " + err.Error())
+		}
+		return
+	}
+	if err := p.engine.Fail(job.ID, e.Consumer, err.Error()); err != nil {
+		// e.g. the job was cancelled or its lease expired while it was sent
+		log.Warn("Pushed job not failed: " + err.Error())
+		return
+	}
+	if attempt >= p.opts.MaxAttempts {
+		log.Warn("Push to " + e.URL + " failed: " + err.Error())
+		return
+	}
+	p.mu.Lock()
+	p.retries[job.ID] = time.Now().Add(p.backoff(attempt))
+	p.mu.Unlock()
+}
+
+// post sends job to an endpoint and returns the body of a 2xx answer, it sends heartbeats
+// for the job until the answer arrives and gives up once the job is cancelled
+func (p *Pusher) post(e Endpoint, job jobqueue.Job, attempt int) (interface{}, error) {
+	body, err := json.Marshal(job)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(JobHeader, strconv.Itoa(job.ID))
+	req.Header.Set(AttemptHeader, strconv.Itoa(attempt))
+	if p.opts.Secret != "" {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set(webhook.TimestampHeader, timestamp)
+		req.Header.Set(webhook.SignatureHeader, webhook.Sign(p.opts.Secret, timestamp, body))
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go p.heartbeat(job.ID, e.Consumer, cancel, done)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	answer, _ := io.ReadAll(io.LimitReader(resp.Body, maxResult))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("endpoint answered %s", resp.Status)
+	}
+	var result interface{}
+	if err := json.Unmarshal(answer, &result); err != nil {
+		// an answer that is not JSON is kept as text
+		if text := strings.TrimSpace(string(answer)); text != "" {
+			result = text
+		}
+	}
+	return result, nil
+}
+
+// heartbeat extends the lease on a job until done is closed, a cancelled job cancels the request
+func (p *Pusher) heartbeat(id, consumer int, cancel context.CancelFunc, done chan struct{}) {
+	interval := p.engine.LeaseTimeout() / 3
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := p.engine.Heartbeat(id, consumer); errors.Is(err, jobqueue.ErrCancelled) {
+				cancel()
+				return
+			}
+		}
+	}
+}
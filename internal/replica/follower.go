@@ -0,0 +1,208 @@
+package replica
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/varungujarathi9/job-queue/internal/apierror"
+	"github.com/varungujarathi9/job-queue/internal/logging"
+	"github.com/varungujarathi9/job-queue/internal/services"
+	"github.com/varungujarathi9/job-queue/internal/utils"
+	"github.com/varungujarathi9/job-queue/pkg/jobqueue"
+)
+
+const changesPageSize = 1000
+
+// Follower tails the change stream of a primary node and applies it to the
+// local job store, so that read-only requests can be served without competing
+// for the primary's lock.
+type Follower struct {
+	engine   *jobqueue.Engine
+	primary  string
+	interval time.Duration
+	client   *http.Client
+
+	mu         sync.Mutex
+	epoch      string
+	primarySeq int
+	caughtUp   time.Time
+	synced     bool
+	lastErr    error
+}
+
+// Option configures a Follower
+type Option func(*Follower)
+
+// WithHTTPClient sets the HTTP client used to poll the primary, e.g. one that authenticates
+func WithHTTPClient(client *http.Client) Option {
+	return func(f *Follower) {
+		f.client = client
+	}
+}
+
+// NewFollower creates a follower that keeps engine in sync with the primary at the
+// given base URL, polling it every interval
+func NewFollower(engine *jobqueue.Engine, primary string, interval time.Duration, opts ...Option) *Follower {
+	f := &Follower{
+		engine:   engine,
+		primary:  strings.TrimRight(primary, "/"),
+		interval: interval,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		caughtUp: time.Now(),
+	}
+	for _, opt := range opts {
+		opt(f)
+	}
+	return f
+}
+
+// Run keeps the local job store in sync with the primary, it never returns
+func (f *Follower) Run() {
+	utils.Logger.Info("Following primary at " + f.primary)
+	for {
+		err := f.sync()
+		f.mu.Lock()
+		f.lastErr = err
+		f.synced = f.synced || err == nil
+		f.mu.Unlock()
+		if err != nil {
+			utils.Logger.Error("Error in syncing with primary: " + err.Error())
+		}
+		time.Sleep(f.interval)
+	}
+}
+
+// sync applies every change the primary recorded after the last applied one
+func (f *Follower) sync() error {
+	for {
+		feed, err := f.fetch(f.engine.LastSeq())
+		if err != nil {
+			return err
+		}
+		// a restarted primary starts a new stream, so the local copy is rebuilt from the
+		// start and reads are refused until then
+		f.mu.Lock()
+		restarted := f.epoch != "" && feed.Epoch != f.epoch
+		f.epoch = feed.Epoch
+		f.mu.Unlock()
+		if restarted || feed.Seq < f.engine.LastSeq() {
+			utils.Logger.Warn("Primary started a new change stream at change " + strconv.Itoa(feed.Seq) + ", syncing again from the start")
+			f.mu.Lock()
+			f.synced = false
+			f.mu.Unlock()
+			f.engine.Reset()
+			continue
+		}
+		for _, change := range feed.Changes {
+			f.engine.Apply(change)
+		}
+
+		applied := f.engine.LastSeq()
+		f.mu.Lock()
+		f.primarySeq = feed.Seq
+		if applied >= feed.Seq {
+			f.caughtUp = time.Now()
+		}
+		f.mu.Unlock()
+
+		if applied >= feed.Seq || len(feed.Changes) == 0 {
+			return nil
+		}
+	}
+}
+
+// fetch reads one page of the primary's change stream
+func (f *Follower) fetch(since int) (*services.ChangeFeed, error) {
+	url := f.primary + "/jobs/changes?since=" + strconv.Itoa(since) + "&limit=" + strconv.Itoa(changesPageSize)
+	resp, err := f.client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("primary returned %s", resp.Status)
+	}
+	var feed services.ChangeFeed
+	if err := json.NewDecoder(resp.Body).Decode(&feed); err != nil {
+		return nil, err
+	}
+	return &feed, nil
+}
+
+// Status describes how far this follower is behind its primary
+type Status struct {
+	Role       string  `json:"Role"`
+	Primary    string  `json:"Primary"`
+	AppliedSeq int     `json:"AppliedSeq"`
+	PrimarySeq int     `json:"PrimarySeq"`
+	LagSeconds float64 `json:"LagSeconds"`
+	LastError  string  `json:"LastError,omitempty"`
+}
+
+// Status returns the current replication status. The lag is the time elapsed
+// since the follower last matched the head of the primary's change stream.
+func (f *Follower) Status() Status {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	status := Status{
+		Role:       "follower",
+		Primary:    f.primary,
+		AppliedSeq: f.engine.LastSeq(),
+		PrimarySeq: f.primarySeq,
+		LagSeconds: time.Since(f.caughtUp).Seconds(),
+	}
+	if f.lastErr != nil {
+		status.LastError = f.lastErr.Error()
+	}
+	return status
+}
+
+// Check reports why the follower cannot serve reads, i.e. it never synced or its last sync failed
+func (f *Follower) Check(ctx context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case f.lastErr != nil:
+		return fmt.Errorf("syncing with primary: %v", f.lastErr)
+	case !f.synced:
+		return errors.New("not synced with primary yet")
+	}
+	return nil
+}
+
+// ReadService serves a read with handler once the follower synced with its primary, the
+// local copy is incomplete before that
+func (f *Follower) ReadService(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		synced := f.synced
+		f.mu.Unlock()
+		if !synced {
+			apierror.Write(w, apierror.New(http.StatusServiceUnavailable, apierror.CodeUnavailable, "Not synced with the primary yet"))
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// StatusService reports the replication status of this follower
+func (f *Follower) StatusService(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(f.Status())
+}
+
+// RedirectService rejects a write by redirecting it to the primary, the 307
+// status makes clients repeat the same method and body there
+func (f *Follower) RedirectService(w http.ResponseWriter, r *http.Request) {
+	logging.FromContext(r.Context(), utils.Logger).Info("Write request redirected to primary")
+
+	http.Redirect(w, r, f.primary+r.URL.RequestURI(), http.StatusTemporaryRedirect)
+}
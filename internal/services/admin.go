@@ -0,0 +1,36 @@
+package services
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// DrainStatus reports whether a node is draining and how many of its jobs are still in progress
+type DrainStatus struct {
+	Draining   bool `json:"Draining"`
+	InProgress int  `json:"InProgress"`
+}
+
+func (s *Server) drainStatus() DrainStatus {
+	return DrainStatus{Draining: s.engine.Draining(), InProgress: s.engine.InProgress()}
+}
+
+// DrainService puts the node into drain mode: enqueues and dequeues are refused while
+// the jobs in progress can still be concluded
+func (s *Server) DrainService(w http.ResponseWriter, r *http.Request) {
+	s.engine.Drain()
+	s.log(r).Info("Node is draining")
+	json.NewEncoder(w).Encode(s.drainStatus())
+}
+
+// ResumeService takes the node out of drain mode
+func (s *Server) ResumeService(w http.ResponseWriter, r *http.Request) {
+	s.engine.Resume()
+	s.log(r).Info("Node resumed")
+	json.NewEncoder(w).Encode(s.drainStatus())
+}
+
+// DrainStatusService reports whether the node is draining
+func (s *Server) DrainStatusService(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(s.drainStatus())
+}
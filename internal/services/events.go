@@ -0,0 +1,147 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/varungujarathi9/job-queue/pkg/jobqueue"
+)
+
+const (
+	// eventsBatch is how many changes the event stream reads from the engine at once
+	eventsBatch = 100
+	// eventsKeepAlive is how long an event stream may stay silent before a comment is sent,
+	// which stops proxies from closing streams that have no changes to report
+	eventsKeepAlive = 15 * time.Second
+	// lastEventIDHeader is sent by clients resuming an event stream
+	lastEventIDHeader = "Last-Event-ID"
+)
+
+// eventFilter holds the query parameters narrowing down an event stream, each may be
+// repeated and an empty one matches every change
+type eventFilter struct {
+	queues []string
+	types  []string
+	ops    []string
+	jobIDs map[int]bool
+}
+
+func (f eventFilter) matches(change jobqueue.Change) bool {
+	return oneOf(f.queues, queueName(change.Job.Queue)) && oneOf(f.types, change.Job.Type) &&
+		oneOf(f.ops, change.Op) && (len(f.jobIDs) == 0 || f.jobIDs[change.Job.ID])
+}
+
+// oneOf reports whether values is empty or holds value
+func oneOf(values []string, value string) bool {
+	if len(values) == 0 {
+		return true
+	}
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// eventsSince returns the sequence number an event stream starts after, the Last-Event-ID
+// header of a reconnecting client takes precedence over the since query parameter
+func (s *Server) eventsSince(w http.ResponseWriter, r *http.Request) (int, bool) {
+	name, value := lastEventIDHeader, r.Header.Get(lastEventIDHeader)
+	if value == "" {
+		name, value = "since", r.URL.Query().Get("since")
+	}
+	if value == "" {
+		return s.engine.LastSeq(), true
+	}
+	since, err := strconv.Atoi(value)
+	if err != nil || since < 0 {
+		s.invalidRequest(w, r, "Invalid "+name+": "+value)
+		return 0, false
+	}
+	// an ID past the end comes from before a restart that lost the changes, so every
+	// change recorded since then is new to the client
+	if since > s.engine.LastSeq() {
+		since = 0
+	}
+	return since, true
+}
+
+// EventsService godoc
+// @Summary      Event stream
+// @Description  Streams the changes as server-sent events, one change event per change with its sequence number as event ID. The stream starts after the Last-Event-ID header, the since query parameter or the newest change, in that order.
+// @Produce      text/event-stream
+// @Param        Last-Event-ID    header   int       false  "Sequence number of the last change seen, resumes the stream after it"
+// @Param        since            query    int       false  "Sequence number to start after when there is no Last-Event-ID"
+// @Param        queue            query    []string  false  "Only stream the changes of Jobs in these queues, default for Jobs without one" collectionFormat(multi)
+// @Param        type             query    []string  false  "Only stream the changes of Jobs of these types" collectionFormat(multi)
+// @Param        job_id           query    []int     false  "Only stream the changes of these Jobs" collectionFormat(multi)
+// @Param        op               query    []string  false  "Only stream these operations, e.g. ENQUEUE or EXPIRE" collectionFormat(multi)
+// @Param        QUEUE_TENANT     header   string    false  "Only act on the Jobs of this tenant"
+// @Success      200  {object}  jobqueue.Change
+// @Failure      400  {object}  apierror.Error  "Malformed request"
+// @Router       /events [get]
+func (s *Server) EventsService(w http.ResponseWriter, r *http.Request) {
+	tenant, ok := s.tenant(w, r)
+	if !ok {
+		return
+	}
+	query := r.URL.Query()
+	filter := eventFilter{queues: query["queue"], types: query["type"], ops: query["op"], jobIDs: map[int]bool{}}
+	for _, v := range query["job_id"] {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			s.invalidRequest(w, r, "Invalid job_id: "+v)
+			return
+		}
+		filter.jobIDs[id] = true
+	}
+	since, ok := s.eventsSince(w, r)
+	if !ok {
+		return
+	}
+
+	// the stream outlives the write timeout of the server
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Time{})
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	// an event without data sets where a client reconnecting before the first change resumes
+	fmt.Fprintf(w, "id: %d\n\n", since)
+	if err := rc.Flush(); err != nil {
+		s.log(r).Error("Event stream cannot be flushed: " + err.Error())
+		return
+	}
+
+	for {
+		ctx, cancel := context.WithTimeout(r.Context(), eventsKeepAlive)
+		_, changes, err := s.engine.WaitChanges(ctx, since, eventsBatch)
+		cancel()
+		switch {
+		case r.Context().Err() != nil:
+			s.log(r).Debug("Event stream closed")
+			return
+		case err != nil:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		default:
+			since = changes[len(changes)-1].Seq
+			for _, change := range changes {
+				if (tenant != nil && change.Job.Tenant != *tenant) || !filter.matches(change) {
+					continue
+				}
+				data, _ := json.Marshal(change)
+				fmt.Fprintf(w, "id: %d\nevent: change\ndata: %s\n\n", change.Seq, data)
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			s.log(r).Debug("Event stream closed: " + err.Error())
+			return
+		}
+	}
+}
@@ -0,0 +1,361 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+	"github.com/varungujarathi9/job-queue/internal/apierror"
+	"github.com/varungujarathi9/job-queue/internal/logging"
+	"github.com/varungujarathi9/job-queue/pkg/jobqueue"
+)
+
+// operations of the push frames, the server sends JOB frames and the consumer ACK, FAIL
+// and HEARTBEAT frames, which the server answers with a frame of the same operation
+const (
+	PushJob       = "JOB"
+	PushAck       = "ACK"
+	PushFail      = "FAIL"
+	PushHeartbeat = "HEARTBEAT"
+	// PushError answers a frame that cannot be read
+	PushError = "ERROR"
+)
+
+const (
+	// maxPrefetch caps how many jobs a push connection may hold without acknowledging them
+	maxPrefetch = 1000
+	// pushKeepAlive is how often a push connection is pinged, a consumer that does not answer
+	// two pings in a row is disconnected
+	pushKeepAlive = 15 * time.Second
+	// pushWriteTimeout bounds writing one frame to a push connection
+	pushWriteTimeout = 10 * time.Second
+	// pushDrainRetry is how often a push connection checks whether a drained queue resumed
+	pushDrainRetry = time.Second
+)
+
+// PushFrame is one message of a push connection
+type PushFrame struct {
+	Op    string        `json:"Op"`
+	JobID int           `json:"JobID,omitempty"`
+	Job   *jobqueue.Job `json:"Job,omitempty"`
+	// Result is stored with an acknowledged job and Reason with a failed one
+	Result interface{} `json:"Result,omitempty"`
+	Reason string      `json:"Reason,omitempty"`
+	// Error is set on the answer to a frame that could not be applied, along with the
+	// Status the REST API answers the same error with
+	Status int             `json:"Status,omitempty"`
+	Error  *apierror.Error `json:"Error,omitempty"`
+}
+
+// pushUpgrader keeps the same-origin check of the WebSocket handshake, consumers are
+// rarely browsers
+var pushUpgrader = websocket.Upgrader{}
+
+// pushConn is the push connection of one consumer
+type pushConn struct {
+	s        *Server
+	r        *http.Request
+	conn     *websocket.Conn
+	consumer int
+	filter   jobqueue.Filter
+	// credits holds a token for each job the consumer may still be pushed, an acknowledged
+	// or failed job gives its token back
+	credits chan struct{}
+
+	writeMutex sync.Mutex
+	mutex      sync.Mutex
+	pushed     map[int]bool
+}
+
+// PushService godoc
+// @Summary      Push Jobs
+// @Description  Upgrades to a WebSocket that pushes Jobs to the consumer as they arrive, in JOB frames. The consumer holds up to prefetch Jobs at once, each ACK or FAIL frame for one of them lets the server push the next. ACK, FAIL and HEARTBEAT frames are answered with a frame of the same Op, holding an Error when it could not be applied. Jobs still held when the connection closes go back into the queue. In a cluster only the Jobs of the node the connection is made to are pushed.
+// @Produce      json
+// @Param        QUEUE_CONSUMER   header   int       true   "Queue Consumer ID"
+// @Param        type             query    []string  false  "Only push Jobs of these types" collectionFormat(multi)
+// @Param        queue            query    string    false  "Only push Jobs of this queue, default for Jobs without one"
+// @Param        prefetch         query    int       false  "How many Jobs the consumer may hold without acknowledging them, 1 by default"
+// @Param        QUEUE_TENANT     header   string    false  "Only act on the Jobs of this tenant"
+// @Success      101  {object}  services.PushFrame
+// @Failure      400  {object}  apierror.Error  "Malformed request"
+// @Failure      403  {object}  apierror.Error  "Identity does not match the headers"
+// @Router       /push [get]
+func (s *Server) PushService(w http.ResponseWriter, r *http.Request) {
+	consumer, ok := s.consumer(w, r)
+	if !ok {
+		return
+	}
+	tenant, ok := s.tenant(w, r)
+	if !ok {
+		return
+	}
+	query := r.URL.Query()
+	filter := jobqueue.Filter{Tenant: tenant, Types: query["type"]}
+	if query.Has("queue") {
+		queue := queueOf(query.Get("queue"))
+		filter.Queue = &queue
+	}
+	prefetch := 1
+	if value := query.Get("prefetch"); value != "" {
+		var err error
+		prefetch, err = strconv.Atoi(value)
+		if err != nil || prefetch < 1 || prefetch > maxPrefetch {
+			s.invalidRequest(w, r, "Invalid prefetch: "+value+", must be between 1 and "+strconv.Itoa(maxPrefetch))
+			return
+		}
+	}
+
+	conn, err := pushUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// the upgrader already answered the request
+		logging.AddFields(r.Context(), logrus.Fields{"error": err.Error()})
+		return
+	}
+	defer conn.Close()
+	logging.AddFields(r.Context(), logrus.Fields{"prefetch": prefetch})
+	s.log(r).Info("Push connection opened")
+
+	p := &pushConn{s: s, r: r, conn: conn, consumer: consumer, filter: filter, credits: make(chan struct{}, prefetch), pushed: map[int]bool{}}
+	for i := 0; i < prefetch; i++ {
+		p.credits <- struct{}{}
+	}
+	if !s.openPush(p) {
+		p.goingAway()
+		s.log(r).Info("Push connection refused, the server is shutting down")
+		return
+	}
+	defer s.closedPush(p)
+	p.run()
+	s.log(r).Info("Push connection closed")
+}
+
+// openPush registers a push connection so ClosePush closes it, and reports false once
+// ClosePush was called
+func (s *Server) openPush(p *pushConn) bool {
+	s.pushMutex.Lock()
+	defer s.pushMutex.Unlock()
+	if s.pushClosed {
+		return false
+	}
+	s.pushConns[p] = true
+	s.pushWG.Add(1)
+	return true
+}
+
+// closedPush forgets a push connection whose jobs are back in the queue
+func (s *Server) closedPush(p *pushConn) {
+	s.pushMutex.Lock()
+	delete(s.pushConns, p)
+	s.pushMutex.Unlock()
+	s.pushWG.Done()
+}
+
+// ClosePush closes the push connections, which the HTTP server does not track once they
+// are upgraded, and waits until the jobs they held are back in the queue or ctx is done.
+// Push connections opened after it are closed at once.
+func (s *Server) ClosePush(ctx context.Context) error {
+	s.pushMutex.Lock()
+	s.pushClosed = true
+	for p := range s.pushConns {
+		p.goingAway()
+	}
+	s.pushMutex.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.pushWG.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// run serves the connection until it is closed, the server stops pinging and pushing
+// once the consumer is gone
+func (p *pushConn) run() {
+	// the server's timeouts stop applying to a hijacked connection
+	p.conn.SetWriteDeadline(time.Time{})
+	p.conn.SetReadDeadline(time.Now().Add(2 * pushKeepAlive))
+	p.conn.SetPongHandler(func(string) error {
+		return p.conn.SetReadDeadline(time.Now().Add(2 * pushKeepAlive))
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		p.push(ctx)
+	}()
+	go func() {
+		defer wg.Done()
+		p.ping(ctx)
+	}()
+	p.read()
+	cancel()
+	wg.Wait()
+
+	// the jobs the consumer held without acknowledging them go back into the queue at once
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for id := range p.pushed {
+		p.requeue(id)
+	}
+}
+
+// read applies the frames of the consumer until the connection fails
+func (p *pushConn) read() {
+	for {
+		_, data, err := p.conn.ReadMessage()
+		if err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				p.s.log(p.r).Debug("Push connection failed: " + err.Error())
+			}
+			return
+		}
+		p.conn.SetReadDeadline(time.Now().Add(2 * pushKeepAlive))
+		var frame PushFrame
+		if err := json.Unmarshal(data, &frame); err != nil {
+			p.write(PushFrame{Op: PushError, Status: http.StatusBadRequest, Error: apierror.New(http.StatusBadRequest, apierror.CodeInvalidRequest, "Invalid frame: "+err.Error())})
+			continue
+		}
+		if err := p.apply(frame); err != nil {
+			p.write(PushFrame{Op: frame.Op, JobID: frame.JobID, Status: err.Status, Error: err})
+			continue
+		}
+		p.write(PushFrame{Op: frame.Op, JobID: frame.JobID})
+	}
+}
+
+// apply applies one frame of the consumer to the engine
+func (p *pushConn) apply(frame PushFrame) *apierror.Error {
+	var err error
+	switch frame.Op {
+	case PushAck:
+		err = p.s.engine.ConcludeAs(frame.JobID, p.consumer, frame.Result)
+		p.release(frame.JobID)
+	case PushFail:
+		err = p.s.engine.Fail(frame.JobID, p.consumer, frame.Reason)
+		p.release(frame.JobID)
+	case PushHeartbeat:
+		err = p.s.engine.Heartbeat(frame.JobID, p.consumer)
+	default:
+		return apierror.New(http.StatusBadRequest, apierror.CodeInvalidRequest, "Invalid Op: "+frame.Op)
+	}
+	if err != nil {
+		return apiError(err)
+	}
+	return nil
+}
+
+// release gives back the credit of a pushed job once the consumer is done with it,
+// whether or not the engine accepted its outcome
+func (p *pushConn) release(id int) {
+	p.mutex.Lock()
+	pushed := p.pushed[id]
+	delete(p.pushed, id)
+	p.mutex.Unlock()
+	if pushed {
+		p.credits <- struct{}{}
+	}
+}
+
+// push dequeues and sends a job for every credit of the consumer until ctx is done
+func (p *pushConn) push(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-p.credits:
+		}
+		job, err := p.s.engine.DequeueMatching(ctx, p.consumer, p.filter)
+		switch {
+		case ctx.Err() != nil:
+			if err == nil {
+				p.requeue(job.ID)
+			}
+			return
+		case errors.Is(err, jobqueue.ErrDraining):
+			// the consumer keeps its connection for the jobs it holds while the queue is drained
+			p.credits <- struct{}{}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(pushDrainRetry):
+			}
+			continue
+		case err != nil:
+			p.s.log(p.r).Error("Push dequeue failed: " + err.Error())
+			p.conn.Close()
+			return
+		}
+
+		p.s.tracer.RecordWait(job)
+		p.mutex.Lock()
+		p.pushed[job.ID] = true
+		p.mutex.Unlock()
+		if err := p.write(PushFrame{Op: PushJob, JobID: job.ID, Job: &job}); err != nil {
+			// run requeues the job along with the others the consumer holds
+			return
+		}
+		p.s.log(p.r).WithField("job_id", job.ID).Debug("Job pushed")
+	}
+}
+
+// requeue puts a job that the consumer holds back into the queue, without waiting for its
+// lease to expire. A job the consumer already reported some other way is left alone.
+func (p *pushConn) requeue(id int) {
+	err := p.s.engine.Release(id, p.consumer)
+	switch {
+	case err == nil:
+		p.s.log(p.r).WithField("job_id", id).Debug("Job put back into the queue")
+	case !errors.Is(err, jobqueue.ErrNotInProgress) && !errors.Is(err, jobqueue.ErrNotOwner) && !errors.Is(err, jobqueue.ErrCancelled):
+		p.s.log(p.r).Warn("Job " + strconv.Itoa(id) + " not put back into the queue: " + err.Error())
+	}
+}
+
+// ping keeps the connection alive through proxies and finds consumers that are gone
+func (p *pushConn) ping(ctx context.Context) {
+	ticker := time.NewTicker(pushKeepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := p.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(pushWriteTimeout)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// goingAway tells the consumer that the server is shutting down and closes the connection,
+// which ends run
+func (p *pushConn) goingAway() {
+	message := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down")
+	p.conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(pushWriteTimeout))
+	p.conn.Close()
+}
+
+// write sends one frame, a failed write closes the connection so that read returns
+func (p *pushConn) write(frame PushFrame) error {
+	p.writeMutex.Lock()
+	defer p.writeMutex.Unlock()
+	p.conn.SetWriteDeadline(time.Now().Add(pushWriteTimeout))
+	err := p.conn.WriteJSON(frame)
+	if err != nil {
+		p.conn.Close()
+	}
+	return err
+}
@@ -1,8 +1,11 @@
 package services
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"strconv"
 	"sync"
@@ -10,177 +13,543 @@ import (
 
 	"github.com/gorilla/mux"
 	"github.com/sirupsen/logrus"
-	"github.com/varungujarathi9/job-queue/internal/models"
+	"github.com/varungujarathi9/job-queue/internal/apierror"
+	"github.com/varungujarathi9/job-queue/internal/auth"
+	"github.com/varungujarathi9/job-queue/internal/logging"
+	"github.com/varungujarathi9/job-queue/internal/tracing"
 	"github.com/varungujarathi9/job-queue/internal/utils"
+	"github.com/varungujarathi9/job-queue/internal/webhooks"
+	"github.com/varungujarathi9/job-queue/pkg/jobqueue"
 )
 
 const (
 	consumerHeader = "QUEUE_CONSUMER"
-	QUEUED         = "QUEUED"
-	IN_PROGRESS    = "IN_PROGRESS"
-	CONCLUDED      = "CONCLUDED"
-)
+	tenantHeader   = "QUEUE_TENANT"
 
-var (
-	queue                              = models.JobQueue{}
-	mutex                              = &sync.Mutex{}
-	nextID                             = 1
-	jobStore       map[int]*models.Job = make(map[int]*models.Job)
-	enqueueTimeout                     = 60000
-	dequeueTimeout                     = 30000
+	defaultChangesLimit = 1000
 )
 
+// engineErrors holds how the REST API reports the engine errors
+var engineErrors = []struct {
+	err     error
+	status  int
+	code    string
+	message string
+}{
+	{jobqueue.ErrMissingFields, http.StatusUnprocessableEntity, apierror.CodeMissingFields, "Missing required fields"},
+	{jobqueue.ErrInvalidType, http.StatusUnprocessableEntity, apierror.CodeInvalidType, "Invalid Type value"},
+	{jobqueue.ErrNotFound, http.StatusNotFound, apierror.CodeNotFound, "Job not found"},
+	{jobqueue.ErrNotDequeued, http.StatusConflict, apierror.CodeJobNotDequeued, "Dequeue job first in order to conclude"},
+	{jobqueue.ErrConcluded, http.StatusConflict, apierror.CodeJobConcluded, "Job already concluded"},
+	{jobqueue.ErrQueued, http.StatusConflict, apierror.CodeJobQueued, "Job already queued"},
+	{jobqueue.ErrNotInProgress, http.StatusConflict, apierror.CodeJobNotInProgress, "Job not in progress"},
+	{jobqueue.ErrNotOwner, http.StatusConflict, apierror.CodeJobNotOwner, "Job consumed by another consumer"},
+	{jobqueue.ErrNotDone, http.StatusConflict, apierror.CodeJobNotDone, "Job not done yet"},
+	{jobqueue.ErrCancelled, http.StatusConflict, apierror.CodeJobCancelled, "Job already cancelled"},
+	{jobqueue.ErrQueueFull, http.StatusTooManyRequests, apierror.CodeQueueFull, "Queue is full"},
+	{jobqueue.ErrRateLimited, http.StatusTooManyRequests, apierror.CodeRateLimited, "Enqueue rate limit exceeded"},
+	{jobqueue.ErrPayloadTooLarge, http.StatusRequestEntityTooLarge, apierror.CodePayloadTooLarge, "Payload too large"},
+	{jobqueue.ErrDraining, http.StatusServiceUnavailable, apierror.CodeDraining, "Queue is draining"},
+}
+
+// Server exposes a job queue engine over the REST API, its handlers are thin adapters on top of the engine
+type Server struct {
+	engine     *jobqueue.Engine
+	logger     *logrus.Logger
+	maxWait    time.Duration
+	maxPayload int64
+	tracer     *tracing.Tracer
+	callbacks  bool
+
+	// pushMutex guards the open push connections, which ClosePush closes
+	pushMutex  sync.Mutex
+	pushConns  map[*pushConn]bool
+	pushClosed bool
+	pushWG     sync.WaitGroup
+}
+
+// Option configures a Server
+type Option func(*Server)
+
+// WithEngine sets the engine the server exposes, a new empty engine is used by default
+func WithEngine(engine *jobqueue.Engine) Option {
+	return func(s *Server) {
+		s.engine = engine
+	}
+}
+
+// WithLogger sets the logger the server writes to, utils.Logger is used by default
+func WithLogger(logger *logrus.Logger) Option {
+	return func(s *Server) {
+		s.logger = logger
+	}
+}
+
+// WithMaxWait caps the wait of a long-polling dequeue or result, zero leaves it unbounded
+func WithMaxWait(wait time.Duration) Option {
+	return func(s *Server) {
+		s.maxWait = wait
+	}
+}
+
+// WithMaxPayloadBytes caps the size of request bodies, zero leaves them unbounded
+func WithMaxPayloadBytes(size int64) Option {
+	return func(s *Server) {
+		s.maxPayload = size
+	}
+}
+
+// WithTracer sets the tracer that records how long dequeued jobs waited, none is recorded by default
+func WithTracer(tracer *tracing.Tracer) Option {
+	return func(s *Server) {
+		s.tracer = tracer
+	}
+}
+
+// WithCallbacks accepts jobs with a Callback URL, they are refused by default since nothing would deliver them
+func WithCallbacks(enabled bool) Option {
+	return func(s *Server) {
+		s.callbacks = enabled
+	}
+}
+
+// New creates a server for a job queue engine
+func New(opts ...Option) *Server {
+	s := &Server{
+		logger:    utils.Logger,
+		pushConns: map[*pushConn]bool{},
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.engine == nil {
+		s.engine = jobqueue.New()
+	}
+	if s.tracer == nil {
+		s.tracer, _ = tracing.New(tracing.Options{})
+	}
+	return s
+}
+
+// Engine returns the engine behind the server
+func (s *Server) Engine() *jobqueue.Engine {
+	return s.engine
+}
+
+// log returns the logger of the request, it carries the request ID and what the
+// handlers learned about the request so far
+func (s *Server) log(r *http.Request) *logrus.Entry {
+	return logging.FromContext(r.Context(), s.logger)
+}
+
+// writeError reports an engine error with the status, code and message the REST API uses for it
+func (s *Server) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	s.writeAPIError(w, r, apiError(err))
+}
+
+// apiError returns the API error reporting an engine error
+func apiError(err error) *apierror.Error {
+	apiErr := apierror.New(http.StatusInternalServerError, apierror.CodeInternal, err.Error())
+	for _, known := range engineErrors {
+		if errors.Is(err, known.err) {
+			apiErr = apierror.New(known.status, known.code, known.message)
+			break
+		}
+	}
+	var jobErr *jobqueue.JobError
+	if errors.As(err, &jobErr) {
+		if errors.Is(err, jobqueue.ErrCancelled) {
+			apiErr.Message = "Job already cancelled so cannot " + jobErr.Op
+		}
+		apiErr = apiErr.With("JobID", jobErr.ID)
+	}
+	return apiErr
+}
+
+// writeAPIError answers with err, its message goes to the access line of the request
+func (s *Server) writeAPIError(w http.ResponseWriter, r *http.Request, err *apierror.Error) {
+	logging.AddFields(r.Context(), logrus.Fields{"error": err.Message})
+	apierror.Write(w, err)
+}
+
+// invalidRequest answers with 400 for a malformed request
+func (s *Server) invalidRequest(w http.ResponseWriter, r *http.Request, message string) {
+	s.writeAPIError(w, r, apierror.New(http.StatusBadRequest, apierror.CodeInvalidRequest, message))
+}
+
+// jobID reads the job ID from the URI path, the jobs of other tenants are reported as not found
+func (s *Server) jobID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["job_id"])
+	if err != nil {
+		s.invalidRequest(w, r, "Invalid job_id: "+vars["job_id"])
+		return 0, false
+	}
+	tenant, ok := s.tenant(w, r)
+	if !ok {
+		return 0, false
+	}
+	if tenant != nil {
+		// the tenant of a job never changes, so it can be checked ahead of the operation
+		job, err := s.engine.Job(id)
+		if err == nil && job.Tenant != *tenant {
+			err = &jobqueue.JobError{ID: id, Op: "get", Err: jobqueue.ErrNotFound}
+		}
+		if err != nil {
+			s.writeError(w, r, err)
+			return 0, false
+		}
+	}
+	return id, true
+}
+
+// tenant reads the tenant a request is confined to from the client identity, or from the
+// QUEUE_TENANT header when the client did not authenticate. It returns nil when the
+// request may see every tenant.
+func (s *Server) tenant(w http.ResponseWriter, r *http.Request) (*string, bool) {
+	header, set := r.Header[http.CanonicalHeaderKey(tenantHeader)]
+	if identity, ok := auth.FromContext(r.Context()); ok {
+		// an authenticated client gets its tenant from its identity only, the header
+		// cannot name a tenant the client was not given
+		if set && header[0] != identity.Tenant {
+			s.writeAPIError(w, r, apierror.New(http.StatusForbidden, apierror.CodeForbidden, "QUEUE_TENANT does not match client identity").With("Tenant", header[0]))
+			return nil, false
+		}
+		if identity.Tenant == "" {
+			return nil, true
+		}
+		logging.AddFields(r.Context(), logrus.Fields{"tenant": identity.Tenant})
+		return &identity.Tenant, true
+	}
+	if !set {
+		return nil, true
+	}
+	logging.AddFields(r.Context(), logrus.Fields{"tenant": header[0]})
+	return &header[0], true
+}
+
+// consumer reads the queue consumer ID from the QUEUE_CONSUMER header, or from the client
+// identity when the client authenticated
+func (s *Server) consumer(w http.ResponseWriter, r *http.Request) (int, bool) {
+	// admins without a consumer ID of their own may act as any consumer
+	if identity, ok := auth.FromContext(r.Context()); ok && (identity.Consumer != 0 || identity.Role != auth.RoleAdmin) {
+		header := r.Header.Get(consumerHeader)
+		switch {
+		case identity.Consumer == 0:
+			s.writeAPIError(w, r, apierror.New(http.StatusForbidden, apierror.CodeForbidden, "Client is not a consumer").With("Client", identity.Name))
+			return 0, false
+		case header != "" && header != strconv.Itoa(identity.Consumer):
+			s.writeAPIError(w, r, apierror.New(http.StatusForbidden, apierror.CodeForbidden, "QUEUE_CONSUMER does not match client identity").With("Consumer", header))
+			return 0, false
+		}
+		logging.AddFields(r.Context(), logrus.Fields{"consumer": identity.Consumer})
+		return identity.Consumer, true
+	}
+
+	queueConsumer, err := strconv.Atoi(r.Header.Get(consumerHeader))
+	if err != nil {
+		s.writeAPIError(w, r, apierror.New(http.StatusBadRequest, apierror.CodeInvalidRequest, "Invalid QUEUE_CONSUMER").With("Consumer", r.Header.Get(consumerHeader)))
+		return 0, false
+	}
+	logging.AddFields(r.Context(), logrus.Fields{"consumer": queueConsumer})
+	return queueConsumer, true
+}
+
+// decode decodes the JSON request body into v, an empty body is reported as io.EOF
+func (s *Server) decode(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	if r.Body == nil {
+		return io.EOF
+	}
+	body := r.Body
+	if s.maxPayload > 0 {
+		body = http.MaxBytesReader(w, r.Body, s.maxPayload)
+	}
+	return json.NewDecoder(body).Decode(v)
+}
+
+// decodeError reports a request body that could not be decoded
+func (s *Server) decodeError(w http.ResponseWriter, r *http.Request, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		s.writeAPIError(w, r, apierror.New(http.StatusRequestEntityTooLarge, apierror.CodePayloadTooLarge, "Payload too large").With("Limit", tooLarge.Limit))
+		return
+	}
+	s.invalidRequest(w, r, "Invalid body: "+err.Error())
+}
+
+// decodeOptional decodes an optional JSON request body into v, an empty body leaves v untouched
+func (s *Server) decodeOptional(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	err := s.decode(w, r, v)
+	if err != nil && err != io.EOF {
+		s.decodeError(w, r, err)
+		return false
+	}
+	return true
+}
+
+// checkCallback refuses a job whose callback cannot be delivered
+func (s *Server) checkCallback(w http.ResponseWriter, r *http.Request, job jobqueue.Job) bool {
+	if job.Callback == "" && len(job.CallbackEvents) == 0 {
+		return true
+	}
+	if !s.callbacks {
+		s.invalidRequest(w, r, "Callbacks are not enabled on this server")
+		return false
+	}
+	if err := webhooks.ValidateURL(job.Callback); err != nil {
+		s.invalidRequest(w, r, err.Error())
+		return false
+	}
+	if err := webhooks.ValidateEvents(job.CallbackEvents); err != nil {
+		s.invalidRequest(w, r, err.Error())
+		return false
+	}
+	return true
+}
+
 // EnqueueService godoc
 // @Summary      Enqueue Job
 // @Description  Enqueue Job by ID
 // @Accept       json
-// @Param        job   body   models.Job   true   "Job object"
-// @Success      200  string  models.Job.ID
-// @Failure      400  string  http.StatusBadRequest
+// @Param        job   body   jobqueue.Job   true   "Job object"
+// @Param        QUEUE_TENANT     header   string    false  "Only act on the Jobs of this tenant"
+// @Param        traceparent      header   string    false  "W3C trace context stored with the Job"
+// @Success      200  string  jobqueue.Job.ID
+// @Failure      400  {object}  apierror.Error  "Malformed request"
+// @Failure      403  {object}  apierror.Error  "Identity does not match the headers"
+// @Failure      413  {object}  apierror.Error  "Payload too large"
+// @Failure      422  {object}  apierror.Error  "Missing fields or invalid Type"
+// @Failure      429  {object}  apierror.Error  "Queue full or rate limited"
+// @Failure      503  {object}  apierror.Error  "Queue is draining"
 // @Router       /enqueue [post]
-func EnqueueService(w http.ResponseWriter, r *http.Request) {
-	mutex.Lock()
-	defer mutex.Unlock()
-
-	utils.Logger.WithFields(logrus.Fields{
-		"method": r.Method,
-		"url":    r.URL,
-	}).Info("Enqueue request received")
-
-	// marshal incoming request body to models.Job
-	var job models.Job
-	err := json.NewDecoder(r.Body).Decode(&job)
+func (s *Server) EnqueueService(w http.ResponseWriter, r *http.Request) {
+	// marshal incoming request body to jobqueue.Job
+	var job jobqueue.Job
+	err := s.decode(w, r, &job)
 	if err != nil {
-		utils.Logger.Error("Error in decoding body flow: " + err.Error())
-		http.Error(w, `{"status" : "`+err.Error()+`"}`, http.StatusBadRequest)
+		s.decodeError(w, r, err)
 		return
 	}
-
-	// request body validation
-	if job.Type == "" || job.Status == "" {
-		utils.Logger.Info("Missing required fields")
-		http.Error(w, `{"status" : "Missing required fields"}`, http.StatusBadRequest)
+	if !s.checkCallback(w, r, job) {
 		return
 	}
 
-	// field Type validation
-	if job.Type != "TIME_CRITICAL" && job.Type != "NOT_TIME_CRITICAL" {
-		utils.Logger.Info("Invalid Type value")
-		http.Error(w, `{"status" : "Invalid Type value"}`, http.StatusBadRequest)
+	tenant, ok := s.tenant(w, r)
+	if !ok {
 		return
 	}
+	if tenant != nil {
+		job.Tenant = *tenant
+	}
+	// the trace context of the request replaces one given in the body unless it came with no traceparent header
+	traceParent, traceState := tracing.TraceParent(r.Context())
+	if traceParent != "" && (r.Header.Get("traceparent") != "" || job.TraceParent == "") {
+		job.TraceParent, job.TraceState = traceParent, traceState
+	}
 
-	// add job to the linked list and give it an ID
-	job.ID = nextID
-	nextID++
-	job.EnqueueTime = time.Now()
-	job.Status = QUEUED
-	queue.Insert(&job)
-	jobStore[job.ID] = &job
-	utils.Logger.Info("Returned response after enqueueing")
-	fmt.Fprintf(w, `{"id" : `+strconv.Itoa(job.ID)+`}`)
+	// the engine validates the job against the quota of its tenant, adds it to the queue and gives it an ID
+	id, err := s.engine.Enqueue(job)
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+	logging.AddFields(r.Context(), logrus.Fields{"job_id": id})
+	s.log(r).Debug("Returned response after enqueueing")
+	fmt.Fprintf(w, `{"id" : `+strconv.Itoa(id)+`}`)
 }
 
 // DequeueService godoc
 // @Summary      Dequeue Job
-// @Description  Dequeues a Job from the queue
+// @Description  Dequeues a Job from the queue, optionally waiting for one to be enqueued
 // @Produce      json
-// @Param        QUEUE_CONSUMER   header   int     true   "Queue Consumer ID"
-// @Success      200  {object}     models.Job
-// @Failure      400  string       http.StatusBadRequest
-// @Failure      404  string       http.StatusNotFound
+// @Param        QUEUE_CONSUMER   header   int       true   "Queue Consumer ID"
+// @Param        wait             query    string    false  "How long to wait for a job, e.g. 10s"
+// @Param        type             query    []string  false  "Only dequeue Jobs of these types" collectionFormat(multi)
+// @Param        QUEUE_TENANT     header   string    false  "Only act on the Jobs of this tenant"
+// @Success      200  {object}     jobqueue.Job
+// @Header       200  {string}     traceparent  "W3C trace context stored with the Job"
+// @Success      204  "No job available"
+// @Failure      400  {object}  apierror.Error  "Malformed request"
+// @Failure      403  {object}  apierror.Error  "Identity does not match the headers"
 // @Router       /dequeue [get]
-func DequeueService(w http.ResponseWriter, r *http.Request) {
-	mutex.Lock()
-	defer mutex.Unlock()
-	utils.Logger.WithFields(logrus.Fields{
-		"method": r.Method,
-		"url":    r.URL,
-	}).Info("Dequeue request received")
-
-	// get the next job from the queue
-	if job := queue.Poll(); job != nil {
-		for {
-			// check if job is not nill
-			if job == nil {
-				utils.Logger.Info("No job available")
-				http.Error(w, `{"status" : "No job available"}`, http.StatusBadRequest)
-				return
-			}
-
-			// calculate elapsed time from job was enqueued
-			elapsed := time.Now().Sub(job.EnqueueTime)
-			if job.Cancel || elapsed > time.Duration(enqueueTimeout) {
-				job = queue.Poll()
-			} else {
-				break
-			}
-		}
-		job.Status = IN_PROGRESS
-		queueConsumer, err := strconv.Atoi(r.Header.Get("QUEUE_CONSUMER"))
-		if err != nil {
-			utils.Logger.Info("Invalid QUEUE_CONSUMER: " + r.Header.Get("QUEUE_CONSUMER"))
-			http.Error(w, `{"status" : "Invalid QUEUE_CONSUMER"}`, http.StatusBadRequest)
-			return
-		}
-		job.ConsumedBy = queueConsumer
-		utils.Logger.Info("Returned response after dequeueing job")
-		json.NewEncoder(w).Encode(job)
+func (s *Server) DequeueService(w http.ResponseWriter, r *http.Request) {
+	queueConsumer, ok := s.consumer(w, r)
+	if !ok {
+		return
+	}
+	tenant, ok := s.tenant(w, r)
+	if !ok {
+		return
+	}
+	wait, ok := s.wait(w, r, r.URL.Query().Get("wait"))
+	if !ok {
+		return
+	}
+	job, err := s.dequeue(r.Context(), queueConsumer, jobqueue.Filter{Tenant: tenant, Types: r.URL.Query()["type"]}, wait)
+	// an empty queue is not an error, it is answered without content
+	if errors.Is(err, jobqueue.ErrNoJob) {
+		logging.AddFields(r.Context(), logrus.Fields{"error": "No job available"})
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+	s.tracer.RecordWait(job)
+	tracing.SetHeaders(w.Header(), job)
+	logging.AddFields(r.Context(), logrus.Fields{"job_id": job.ID})
+	s.log(r).Debug("Returned response after dequeueing job")
+	json.NewEncoder(w).Encode(job)
+}
+
+// wait parses the wait of a long-polling dequeue, capped at the server's maximum
+func (s *Server) wait(w http.ResponseWriter, r *http.Request, wait string) (time.Duration, bool) {
+	if wait == "" {
+		return 0, true
+	}
+	timeout, err := time.ParseDuration(wait)
+	if err != nil || timeout < 0 {
+		s.invalidRequest(w, r, "Invalid wait: "+wait)
+		return 0, false
+	}
+	if s.maxWait > 0 && timeout > s.maxWait {
+		timeout = s.maxWait
+	}
+	return timeout, true
+}
+
+// dequeue gets the next job matching filter from the queue, long-polling when a wait is given.
+// It returns jobqueue.ErrNoJob when there is none by the end of the wait.
+func (s *Server) dequeue(ctx context.Context, consumer int, filter jobqueue.Filter, wait time.Duration) (jobqueue.Job, error) {
+	var job jobqueue.Job
+	var err error
+	if wait == 0 {
+		job, err = s.engine.TryDequeueMatching(consumer, filter)
 	} else {
-		utils.Logger.Info("No job available")
-		http.Error(w, `{"status" : "No job available"}`, http.StatusBadRequest)
+		waitCtx, cancel := context.WithTimeout(ctx, wait)
+		defer cancel()
+		job, err = s.engine.DequeueMatching(waitCtx, consumer, filter)
+		if err != nil && waitCtx.Err() != nil {
+			return job, jobqueue.ErrNoJob
+		}
 	}
+	// a client that is gone, e.g. a cluster node that got a job elsewhere, cannot take the job
+	if err == nil && ctx.Err() != nil {
+		s.engine.Release(job.ID, consumer)
+		return jobqueue.Job{}, jobqueue.ErrNoJob
+	}
+	return job, err
 }
 
 // ConcludeService godoc
 // @Summary      Conclude Job
-// @Description  Concludes a Job by ID
+// @Description  Concludes a Job by ID, optionally storing its result. With a consumer, only the consumer that dequeued the Job may conclude it.
+// @Accept       json
 // @Produce      plain
-// @Param        job_id   path      int  true  "Job ID"
+// @Param        job_id           path     int  true   "Job ID"
+// @Param        QUEUE_CONSUMER   header   int  false  "Queue Consumer ID"
+// @Param        result   body      services.ConcludeRequest  false  "Job result"
 // @Success      200  string  "Job concluded successfully"
-// @Failure      400  string  http.StatusBadRequest
-// @Failure      404  string  http.StatusNotFound
+// @Failure      400  {object}  apierror.Error  "Malformed request"
+// @Failure      403  {object}  apierror.Error  "Identity does not match the headers"
+// @Failure      404  {object}  apierror.Error  "Job not found"
+// @Failure      409  {object}  apierror.Error  "Job is in the wrong state"
 // @Router       /{job_id}/conclude [put]
-func ConcludeService(w http.ResponseWriter, r *http.Request) {
-	mutex.Lock()
-	defer mutex.Unlock()
-	utils.Logger.WithFields(logrus.Fields{
-		"method": r.Method,
-		"url":    r.URL,
-	}).Info("Conclude request received")
-
-	// get job ID from URI path
-	vars := mux.Vars(r)
-	id, err := strconv.Atoi(vars["job_id"])
-	if err != nil {
-		utils.Logger.Error("Error in converting job_id: " + err.Error())
-		http.Error(w, `{"status" : "`+err.Error()+`"}`, http.StatusBadRequest)
+func (s *Server) ConcludeService(w http.ResponseWriter, r *http.Request) {
+	id, ok := s.jobID(w, r)
+	if !ok {
 		return
 	}
-
-	// check if job of this ID was created and if so conclude according to the flow
-	if job, exists := jobStore[id]; exists {
-		if job.Cancel {
-			utils.Logger.Info("Job already cancelled so cannot conclude")
-			http.Error(w, `{"status" : "Job already cancelled so cannot conclude"}`, http.StatusBadRequest)
-		}
-		switch job.Status {
-		case QUEUED:
-			utils.Logger.Info("Conclude requested before dequeue")
-			http.Error(w, `{"status" : "Dequeue job first in order to conclude"}`, http.StatusBadRequest)
-		case CONCLUDED:
-			utils.Logger.Info("Job already concluded")
-			http.Error(w, `{"status" : "Job already concluded"}`, http.StatusBadRequest)
-		default:
-			job.Status = CONCLUDED
-			utils.Logger.Info("Job concluded successfully")
-			fmt.Fprintf(w, `{"status" : "Job concluded successfully"}`)
+	var body ConcludeRequest
+	if !s.decodeOptional(w, r, &body) {
+		return
+	}
+	// a consumer, named by its identity or the header, may only conclude the jobs it dequeued
+	consumer := 0
+	if identity, ok := auth.FromContext(r.Context()); (ok && identity.Consumer != 0) || r.Header.Get(consumerHeader) != "" {
+		if consumer, ok = s.consumer(w, r); !ok {
+			return
 		}
-	} else {
-		utils.Logger.Info("Job not found")
-		http.Error(w, `{"status" : "Job not found"}`, http.StatusBadRequest)
 	}
+	if err := s.engine.ConcludeAs(id, consumer, body.Result); err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+	s.log(r).Debug("Job concluded successfully")
+	fmt.Fprintf(w, `{"status" : "Job concluded successfully"}`)
+}
+
+// ConcludeRequest is the optional body of a conclude request
+type ConcludeRequest struct {
+	Result interface{} `json:"Result,omitempty"`
+}
+
+// HeartbeatService godoc
+// @Summary      Job heartbeat
+// @Description  Extends the lease the consumer holds on a dequeued Job, fails once the Job is cancelled
+// @Produce      json
+// @Param        job_id           path     int  true  "Job ID"
+// @Param        QUEUE_CONSUMER   header   int  true  "Queue Consumer ID"
+// @Success      200  string  "Heartbeat received"
+// @Failure      400  {object}  apierror.Error  "Malformed request"
+// @Failure      403  {object}  apierror.Error  "Identity does not match the headers"
+// @Failure      404  {object}  apierror.Error  "Job not found"
+// @Failure      409  {object}  apierror.Error  "Job is in the wrong state"
+// @Router       /{job_id}/heartbeat [put]
+func (s *Server) HeartbeatService(w http.ResponseWriter, r *http.Request) {
+	id, ok := s.jobID(w, r)
+	if !ok {
+		return
+	}
+	queueConsumer, ok := s.consumer(w, r)
+	if !ok {
+		return
+	}
+	if err := s.engine.Heartbeat(id, queueConsumer); err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+	fmt.Fprintf(w, `{"status" : "Heartbeat received"}`)
+}
 
+// FailRequest is the body of a fail request
+type FailRequest struct {
+	Error string `json:"Error"`
+}
+
+// FailService godoc
+// @Summary      Fail Job
+// @Description  Marks a dequeued Job as failed, a failed Job can be retried
+// @Accept       json
+// @Produce      json
+// @Param        job_id           path     int                   true   "Job ID"
+// @Param        QUEUE_CONSUMER   header   int                   true   "Queue Consumer ID"
+// @Param        reason           body     services.FailRequest  false  "Failure reason"
+// @Success      200  string  "Job failed"
+// @Failure      400  {object}  apierror.Error  "Malformed request"
+// @Failure      403  {object}  apierror.Error  "Identity does not match the headers"
+// @Failure      404  {object}  apierror.Error  "Job not found"
+// @Failure      409  {object}  apierror.Error  "Job is in the wrong state"
+// @Router       /{job_id}/fail [put]
+func (s *Server) FailService(w http.ResponseWriter, r *http.Request) {
+	id, ok := s.jobID(w, r)
+	if !ok {
+		return
+	}
+	queueConsumer, ok := s.consumer(w, r)
+	if !ok {
+		return
+	}
+	var body FailRequest
+	if !s.decodeOptional(w, r, &body) {
+		return
+	}
+	if err := s.engine.Fail(id, queueConsumer, body.Error); err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+	fmt.Fprintf(w, `{"status" : "Job failed"}`)
 }
 
 // JobService godoc
@@ -188,89 +557,276 @@ func ConcludeService(w http.ResponseWriter, r *http.Request) {
 // @Description  Retrieves a Job by ID
 // @Produce      json
 // @Param        job_id   path      int  true  "Job ID"
-// @Success      200  {object}  models.Job
-// @Failure      400  string   http.StatusBadRequest
-// @Failure      404  string   http.StatusNotFound
+// @Success      200  {object}  jobqueue.Job
+// @Failure      400  {object}  apierror.Error  "Malformed request"
+// @Failure      403  {object}  apierror.Error  "Identity does not match the headers"
+// @Failure      404  {object}  apierror.Error  "Job not found"
 // @Router       /{job_id} [get]
-func JobService(w http.ResponseWriter, r *http.Request) {
-	mutex.Lock()
-	defer mutex.Unlock()
-	utils.Logger.WithFields(logrus.Fields{
-		"method": r.Method,
-		"url":    r.URL,
-	}).Info("Job Info request received")
-
-	//  get job ID from URI path
-	vars := mux.Vars(r)
-	id, err := strconv.Atoi(vars["job_id"])
+func (s *Server) JobService(w http.ResponseWriter, r *http.Request) {
+	id, ok := s.jobID(w, r)
+	if !ok {
+		return
+	}
+	job, err := s.engine.Job(id)
 	if err != nil {
-		utils.Logger.Error("Error in converting job_id: " + err.Error())
-		http.Error(w, `{"status" : "`+err.Error()+`"}`, http.StatusBadRequest)
+		s.writeError(w, r, err)
 		return
 	}
+	s.log(r).Debug("Response returned for job info")
+	json.NewEncoder(w).Encode(job)
+}
 
-	//  check if a job of this ID was created, if so return its data
-	if job, exists := jobStore[id]; exists {
-		utils.Logger.Info("Response returned for job info")
-		json.NewEncoder(w).Encode(job)
-	} else {
-		utils.Logger.Info("Job not found")
-		http.Error(w, `{"status" : "Job not found"}`, http.StatusBadRequest)
+// CancelService godoc
+// @Summary      Cancel Job
+// @Description  Cancels a Job by ID
+// @Produce      json
+// @Param        job_id   path      int  true  "Job ID"
+// @Success      200  string  "Job cancelled successfully"
+// @Failure      400  {object}  apierror.Error  "Malformed request"
+// @Failure      403  {object}  apierror.Error  "Identity does not match the headers"
+// @Failure      404  {object}  apierror.Error  "Job not found"
+// @Failure      409  {object}  apierror.Error  "Job is in the wrong state"
+// @Router       /{job_id}/cancel [delete]
+func (s *Server) CancelService(w http.ResponseWriter, r *http.Request) {
+	id, ok := s.jobID(w, r)
+	if !ok {
+		return
 	}
-
+	if err := s.engine.Cancel(id); err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+	fmt.Fprintf(w, `{"status" : "Job cancelled successfully"}`)
 }
 
-func CancelService(w http.ResponseWriter, r *http.Request) {
-	mutex.Lock()
-	defer mutex.Unlock()
-
-	utils.Logger.WithFields(logrus.Fields{
-		"method": r.Method,
-		"url":    r.URL,
-	}).Info("Job cancel request received")
-
-	vars := mux.Vars(r)
-	id, err := strconv.Atoi(vars["job_id"])
+// RetryService godoc
+// @Summary      Retry Job
+// @Description  Puts a Job that left the queue back into it
+// @Produce      json
+// @Param        job_id   path      int  true  "Job ID"
+// @Success      200  string  "Job enqueued for retry"
+// @Failure      400  {object}  apierror.Error  "Malformed request"
+// @Failure      403  {object}  apierror.Error  "Identity does not match the headers"
+// @Failure      404  {object}  apierror.Error  "Job not found"
+// @Failure      409  {object}  apierror.Error  "Job is in the wrong state"
+// @Router       /{job_id}/retry [put]
+func (s *Server) RetryService(w http.ResponseWriter, r *http.Request) {
+	id, ok := s.jobID(w, r)
+	if !ok {
+		return
+	}
+	if err := s.engine.Retry(id); err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+	fmt.Fprintf(w, `{"status" : "Job enqueued for retry"}`)
+}
 
+// RedriveService godoc
+// @Summary      Re-drive Job
+// @Description  Enqueues a copy of a Job that is concluded, failed or cancelled and returns the ID of the copy
+// @Produce      json
+// @Param        job_id   path      int  true  "Job ID"
+// @Success      200  string  jobqueue.Job.ID
+// @Failure      400  {object}  apierror.Error  "Malformed request"
+// @Failure      403  {object}  apierror.Error  "Identity does not match the headers"
+// @Failure      404  {object}  apierror.Error  "Job not found"
+// @Failure      409  {object}  apierror.Error  "Job is in the wrong state"
+// @Failure      429  {object}  apierror.Error  "Queue full or rate limited"
+// @Failure      503  {object}  apierror.Error  "Queue is draining"
+// @Router       /{job_id}/redrive [post]
+func (s *Server) RedriveService(w http.ResponseWriter, r *http.Request) {
+	id, ok := s.jobID(w, r)
+	if !ok {
+		return
+	}
+	copyID, err := s.engine.Redrive(id)
 	if err != nil {
-		utils.Logger.Error("Error in canceling job_id: " + err.Error())
-		http.Error(w, `{"status" : "`+err.Error()+`"}`, http.StatusBadRequest)
+		s.writeError(w, r, err)
 		return
 	}
+	logging.AddFields(r.Context(), logrus.Fields{"copy_id": copyID})
+	s.log(r).Debug("Returned response after re-driving")
+	fmt.Fprintf(w, `{"id" : `+strconv.Itoa(copyID)+`}`)
+}
 
-	if job, exists := jobStore[id]; exists {
-		job.Cancel = true
-		fmt.Fprintf(w, `{"status" : "Job cancelled successfully"}`)
-	} else {
-		http.Error(w, `{"status" : "Job not found"}`, http.StatusBadRequest)
+// HistoryService godoc
+// @Summary      Job history
+// @Description  Returns the changes recorded for a Job, oldest first
+// @Produce      json
+// @Param        job_id   path      int  true  "Job ID"
+// @Success      200  {array}   jobqueue.Change
+// @Failure      400  {object}  apierror.Error  "Malformed request"
+// @Failure      403  {object}  apierror.Error  "Identity does not match the headers"
+// @Failure      404  {object}  apierror.Error  "Job not found"
+// @Router       /{job_id}/history [get]
+func (s *Server) HistoryService(w http.ResponseWriter, r *http.Request) {
+	id, ok := s.jobID(w, r)
+	if !ok {
+		return
+	}
+	history, err := s.engine.History(id)
+	if err != nil {
+		s.writeError(w, r, err)
+		return
 	}
+	s.log(r).Debug("Response returned for job history")
+	json.NewEncoder(w).Encode(history)
 }
 
-func RetryService(w http.ResponseWriter, r *http.Request) {
-	mutex.Lock()
-	defer mutex.Unlock()
+// ResultService godoc
+// @Summary      Job result
+// @Description  Returns a Job once it is concluded, failed or cancelled, with its Result or Error. With a wait the request is held until then, capped at the server's maximum wait.
+// @Produce      json
+// @Param        job_id   path      int     true   "Job ID"
+// @Param        wait     query     string  false  "How long to wait for the Job to be done, e.g. 30s"
+// @Success      200  {object}  jobqueue.Job
+// @Success      204  "Job not done by the end of the wait"
+// @Failure      400  {object}  apierror.Error  "Malformed request"
+// @Failure      403  {object}  apierror.Error  "Identity does not match the headers"
+// @Failure      404  {object}  apierror.Error  "Job not found"
+// @Router       /{job_id}/result [get]
+func (s *Server) ResultService(w http.ResponseWriter, r *http.Request) {
+	id, ok := s.jobID(w, r)
+	if !ok {
+		return
+	}
+	wait, ok := s.wait(w, r, r.URL.Query().Get("wait"))
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), wait)
+	defer cancel()
+	job, err := s.engine.WaitDone(ctx, id)
+	// a job that is still running is not an error, it is answered without content
+	if errors.Is(err, jobqueue.ErrNotDone) {
+		logging.AddFields(r.Context(), logrus.Fields{"error": "Job not done yet"})
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+	s.log(r).Debug("Response returned for job result")
+	json.NewEncoder(w).Encode(job)
+}
 
-	vars := mux.Vars(r)
-	id, err := strconv.Atoi(vars["job_id"])
+// ListService godoc
+// @Summary      List Jobs
+// @Description  Lists Jobs, optionally filtered by status and type
+// @Produce      json
+// @Param        status   query     string  false  "Job status"
+// @Param        type     query     string  false  "Job type"
+// @Param        QUEUE_TENANT     header   string    false  "Only act on the Jobs of this tenant"
+// @Success      200  {array}   jobqueue.Job
+// @Router       / [get]
+func (s *Server) ListService(w http.ResponseWriter, r *http.Request) {
+	tenant, ok := s.tenant(w, r)
+	if !ok {
+		return
+	}
+	jobs := s.engine.List(r.URL.Query().Get("status"), r.URL.Query().Get("type"))
+	if tenant != nil {
+		jobs = filterTenant(jobs, *tenant, func(job jobqueue.Job) string { return job.Tenant })
+	}
+	s.log(r).Debug("Response returned for job list")
+	json.NewEncoder(w).Encode(jobs)
+}
 
-	if err != nil {
-		utils.Logger.Error("Error in retrying job_id: " + err.Error())
-		http.Error(w, `{"status" : "`+err.Error()+`"}`, http.StatusBadRequest)
+// StatsService godoc
+// @Summary      Job Stats
+// @Description  Counts Jobs by status and type
+// @Produce      json
+// @Param        QUEUE_TENANT     header   string    false  "Only act on the Jobs of this tenant"
+// @Success      200  {object}  jobqueue.Stats
+// @Router       /stats [get]
+func (s *Server) StatsService(w http.ResponseWriter, r *http.Request) {
+	tenant, ok := s.tenant(w, r)
+	if !ok {
 		return
 	}
+	stats := s.engine.Stats()
+	if tenant != nil {
+		stats = s.engine.TenantStats(*tenant)
+	}
+	s.log(r).Debug("Response returned for job stats")
+	json.NewEncoder(w).Encode(stats)
+}
+
+// ChangeFeed is a page of the change stream
+type ChangeFeed struct {
+	// Epoch identifies the stream, it changes when the server restarts
+	Epoch   string            `json:"Epoch"`
+	Seq     int               `json:"Seq"`
+	Changes []jobqueue.Change `json:"Changes"`
+}
 
-	if job, exists := jobStore[id]; exists {
-		if job.Cancel {
-			utils.Logger.Info("Job already cancelled so cannot retry")
-			http.Error(w, `{"status" : "Job already cancelled so cannot retry"}`, http.StatusBadRequest)
+// ChangesService godoc
+// @Summary      Change stream
+// @Description  Returns the changes recorded after the given sequence number
+// @Produce      json
+// @Param        since   query     int  false  "Last sequence number already seen"
+// @Param        limit   query     int  false  "Maximum number of changes to return"
+// @Param        QUEUE_TENANT     header   string    false  "Only act on the Jobs of this tenant"
+// @Success      200  {object}  services.ChangeFeed
+// @Failure      400  {object}  apierror.Error  "Malformed request"
+// @Router       /changes [get]
+func (s *Server) ChangesService(w http.ResponseWriter, r *http.Request) {
+	since, limit := 0, defaultChangesLimit
+	var err error
+	if v := r.URL.Query().Get("since"); v != "" {
+		if since, err = strconv.Atoi(v); err != nil {
+			s.invalidRequest(w, r, "Invalid since: "+v)
+			return
+		}
+	}
+	if v := r.URL.Query().Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 {
+			s.invalidRequest(w, r, "Invalid limit: "+v)
 			return
 		}
+	}
+
+	tenant, ok := s.tenant(w, r)
+	if !ok {
+		return
+	}
 
-		job.Status = QUEUED
-		job.EnqueueTime = time.Now()
+	// a tenant's page skips the changes of other tenants and its Seq is the last change
+	// looked at, so a page holding none of the tenant's changes still moves it forward
+	feed := ChangeFeed{Epoch: s.engine.Epoch()}
+	feed.Seq, feed.Changes = s.engine.Changes(since, limit)
+	if tenant != nil {
+		if len(feed.Changes) == limit {
+			feed.Seq = feed.Changes[len(feed.Changes)-1].Seq
+		}
+		feed.Changes = filterTenant(feed.Changes, *tenant, func(change jobqueue.Change) string { return change.Job.Tenant })
+	}
+	s.log(r).Debug("Response returned for change stream")
+	json.NewEncoder(w).Encode(feed)
+}
 
-		queue.Insert(job)
+// ReplicationService godoc
+// @Summary      Replication status
+// @Description  Reports the replication role of this node
+// @Produce      json
+// @Success      200  {object}  map[string]interface{}
+// @Router       /replication [get]
+func (s *Server) ReplicationService(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"Role": "primary",
+		"Seq":  s.engine.LastSeq(),
+	})
+}
 
-		fmt.Fprintf(w, `{"status" : "Job enqueued for retry"}`)
+// filterTenant returns the items that belong to tenant
+func filterTenant[T any](items []T, tenant string, tenantOf func(T) string) []T {
+	filtered := items[:0]
+	for _, item := range items {
+		if tenantOf(item) == tenant {
+			filtered = append(filtered, item)
+		}
 	}
+	return filtered
 }
@@ -0,0 +1,630 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	"github.com/varungujarathi9/job-queue/internal/apierror"
+	"github.com/varungujarathi9/job-queue/internal/logging"
+	"github.com/varungujarathi9/job-queue/internal/openapi"
+	"github.com/varungujarathi9/job-queue/internal/tracing"
+	"github.com/varungujarathi9/job-queue/pkg/jobqueue"
+)
+
+const (
+	// StatusCancelled is the status the v2 API reports cancelled jobs with
+	StatusCancelled = "CANCELLED"
+	// DefaultQueue names the queue of the jobs enqueued without one
+	DefaultQueue = "default"
+)
+
+// Queue counts the jobs of a queue by status
+type Queue struct {
+	Name       string `json:"Name"`
+	Queued     int    `json:"Queued"`
+	InProgress int    `json:"InProgress"`
+	Concluded  int    `json:"Concluded"`
+	Failed     int    `json:"Failed"`
+	Expired    int    `json:"Expired"`
+	Cancelled  int    `json:"Cancelled"`
+}
+
+// QueueList is the body listing the queues
+type QueueList struct {
+	Queues []Queue `json:"Queues"`
+}
+
+// JobResource is a job as the v2 API reports it
+type JobResource struct {
+	ID          int         `json:"ID"`
+	Queue       string      `json:"Queue"`
+	Type        string      `json:"Type"`
+	Key         string      `json:"Key,omitempty"`
+	Tenant      string      `json:"Tenant,omitempty"`
+	Status      string      `json:"Status"`
+	Consumer    int         `json:"Consumer,omitempty"`
+	Payload     interface{} `json:"Payload,omitempty"`
+	Result      interface{} `json:"Result,omitempty"`
+	Error       string      `json:"Error,omitempty"`
+	TraceParent string      `json:"TraceParent,omitempty"`
+	// Callback is sent the CallbackEvents of the job, see JobRequest
+	Callback       string     `json:"Callback,omitempty"`
+	CallbackEvents []string   `json:"CallbackEvents,omitempty"`
+	Enqueued       time.Time  `json:"Enqueued"`
+	Dequeued       *time.Time `json:"Dequeued,omitempty"`
+}
+
+// JobList is the body listing jobs
+type JobList struct {
+	Jobs []JobResource `json:"Jobs"`
+}
+
+// JobRequest is the body creating a job, a job without a Queue goes to the default queue.
+// Callback is sent a signed POST on the CallbackEvents of the job when webhooks are enabled.
+type JobRequest struct {
+	Queue          string      `json:"Queue,omitempty"`
+	Type           string      `json:"Type"`
+	Key            string      `json:"Key,omitempty"`
+	Payload        interface{} `json:"Payload,omitempty"`
+	Callback       string      `json:"Callback,omitempty"`
+	CallbackEvents []string    `json:"CallbackEvents,omitempty"`
+}
+
+// JobUpdate is the body changing the status of a job, CANCELLED cancels it and QUEUED retries it
+type JobUpdate struct {
+	Status string `json:"Status"`
+}
+
+// ChangeList is the body listing the changes of a job
+type ChangeList struct {
+	Changes []jobqueue.Change `json:"Changes"`
+}
+
+// AttemptList is the body listing the attempts of a job
+type AttemptList struct {
+	Attempts []jobqueue.Attempt `json:"Attempts"`
+}
+
+// AttemptUpdate is the body ending the attempt in progress, CONCLUDED stores the Result and
+// FAILED the Error
+type AttemptUpdate struct {
+	Status string      `json:"Status"`
+	Result interface{} `json:"Result,omitempty"`
+	Error  string      `json:"Error,omitempty"`
+}
+
+// Lease is the hold a consumer has on a job in progress, it lasts until Expires unless renewed
+type Lease struct {
+	JobID    int         `json:"JobID"`
+	Attempt  int         `json:"Attempt"`
+	Consumer int         `json:"Consumer"`
+	Acquired time.Time   `json:"Acquired"`
+	Renewed  time.Time   `json:"Renewed"`
+	Expires  time.Time   `json:"Expires"`
+	Job      JobResource `json:"Job"`
+}
+
+// LeaseRequest is the optional body acquiring a lease, it narrows the jobs down to Types and
+// waits up to Wait, e.g. 10s, for one
+type LeaseRequest struct {
+	Types []string `json:"Types,omitempty"`
+	Wait  string   `json:"Wait,omitempty"`
+}
+
+// V2 serves the resource-oriented v2 API on the engine of a Server
+type V2 struct {
+	server *Server
+
+	// the OpenAPI document is built from the routes on the first request for it
+	specOnce sync.Once
+	spec     openapi.Document
+}
+
+// V2 returns the v2 API of the server
+func (s *Server) V2() *V2 {
+	return &V2{server: s}
+}
+
+// queueName returns the name a job's queue is reported with
+func queueName(queue string) string {
+	if queue == "" {
+		return DefaultQueue
+	}
+	return queue
+}
+
+// queueOf returns the queue a job of the named queue is stored with
+func queueOf(name string) string {
+	if name == DefaultQueue {
+		return ""
+	}
+	return name
+}
+
+// status returns the status a job is reported with
+func status(job jobqueue.Job) string {
+	if job.Cancel {
+		return StatusCancelled
+	}
+	return job.Status
+}
+
+func resource(job jobqueue.Job) JobResource {
+	res := JobResource{
+		ID:             job.ID,
+		Queue:          queueName(job.Queue),
+		Type:           job.Type,
+		Key:            job.Key,
+		Tenant:         job.Tenant,
+		Status:         status(job),
+		Consumer:       job.ConsumedBy,
+		Payload:        job.Payload,
+		Result:         job.Result,
+		Error:          job.Error,
+		TraceParent:    job.TraceParent,
+		Callback:       job.Callback,
+		CallbackEvents: job.CallbackEvents,
+		Enqueued:       job.EnqueueTime,
+	}
+	if !job.DequeueTime.IsZero() {
+		dequeued := job.DequeueTime
+		res.Dequeued = &dequeued
+	}
+	return res
+}
+
+// created answers with 201 and the location of the resource
+func created(w http.ResponseWriter, location string, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", location)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(body)
+}
+
+func writeJSON(w http.ResponseWriter, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(body)
+}
+
+// jobs returns the jobs the request may see, an empty wanted status or jobType matches every job
+func (v *V2) jobs(tenant *string, wanted, jobType string) []jobqueue.Job {
+	// cancelled jobs keep the status they had in the engine
+	engineStatus := wanted
+	if wanted == StatusCancelled {
+		engineStatus = ""
+	}
+	jobs := v.server.engine.List(engineStatus, jobType)
+	if tenant != nil {
+		jobs = filterTenant(jobs, *tenant, func(job jobqueue.Job) string { return job.Tenant })
+	}
+	if wanted == "" {
+		return jobs
+	}
+	matching := jobs[:0]
+	for _, job := range jobs {
+		if status(job) == wanted {
+			matching = append(matching, job)
+		}
+	}
+	return matching
+}
+
+// queues counts the jobs the request may see by queue
+func (v *V2) queues(tenant *string) map[string]*Queue {
+	queues := map[string]*Queue{}
+	for _, job := range v.jobs(tenant, "", "") {
+		name := queueName(job.Queue)
+		queue, ok := queues[name]
+		if !ok {
+			queue = &Queue{Name: name}
+			queues[name] = queue
+		}
+		switch status(job) {
+		case StatusCancelled:
+			queue.Cancelled++
+		case jobqueue.StatusQueued:
+			queue.Queued++
+		case jobqueue.StatusInProgress:
+			queue.InProgress++
+		case jobqueue.StatusConcluded:
+			queue.Concluded++
+		case jobqueue.StatusFailed:
+			queue.Failed++
+		case jobqueue.StatusExpired:
+			queue.Expired++
+		}
+	}
+	return queues
+}
+
+// QueuesService lists the queues holding jobs with their counts
+func (v *V2) QueuesService(w http.ResponseWriter, r *http.Request) {
+	tenant, ok := v.server.tenant(w, r)
+	if !ok {
+		return
+	}
+	list := QueueList{Queues: []Queue{}}
+	for _, queue := range v.queues(tenant) {
+		list.Queues = append(list.Queues, *queue)
+	}
+	sort.Slice(list.Queues, func(i, j int) bool { return list.Queues[i].Name < list.Queues[j].Name })
+	writeJSON(w, list)
+}
+
+// QueueService counts the jobs of one queue, queues exist as soon as they are named so an
+// unused queue has no jobs
+func (v *V2) QueueService(w http.ResponseWriter, r *http.Request) {
+	tenant, ok := v.server.tenant(w, r)
+	if !ok {
+		return
+	}
+	name := mux.Vars(r)["queue"]
+	queue := Queue{Name: name}
+	if counted, ok := v.queues(tenant)[name]; ok {
+		queue = *counted
+	}
+	writeJSON(w, queue)
+}
+
+// AcquireLeaseService dequeues the next job of a queue for the consumer and returns the lease
+// on it, or answers with 204 when there is none by the end of the wait
+func (v *V2) AcquireLeaseService(w http.ResponseWriter, r *http.Request) {
+	s := v.server
+	consumer, ok := s.consumer(w, r)
+	if !ok {
+		return
+	}
+	tenant, ok := s.tenant(w, r)
+	if !ok {
+		return
+	}
+	var body LeaseRequest
+	if !s.decodeOptional(w, r, &body) {
+		return
+	}
+	wait, ok := s.wait(w, r, body.Wait)
+	if !ok {
+		return
+	}
+	queue := queueOf(mux.Vars(r)["queue"])
+	job, err := s.dequeue(r.Context(), consumer, jobqueue.Filter{Tenant: tenant, Queue: &queue, Types: body.Types}, wait)
+	if errors.Is(err, jobqueue.ErrNoJob) {
+		logging.AddFields(r.Context(), logrus.Fields{"error": "No job available"})
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+	s.tracer.RecordWait(job)
+	tracing.SetHeaders(w.Header(), job)
+	logging.AddFields(r.Context(), logrus.Fields{"job_id": job.ID})
+	lease, err := v.lease(job)
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+	s.log(r).Debug("Lease acquired")
+	created(w, "/v2/leases/"+strconv.Itoa(job.ID), lease)
+}
+
+// lease returns the lease on a job in progress
+func (v *V2) lease(job jobqueue.Job) (Lease, error) {
+	attempts, err := v.server.engine.Attempts(job.ID)
+	if err != nil {
+		return Lease{}, err
+	}
+	return Lease{
+		JobID:    job.ID,
+		Attempt:  len(attempts),
+		Consumer: job.ConsumedBy,
+		Acquired: job.DequeueTime,
+		Renewed:  job.HeartbeatTime,
+		Expires:  job.HeartbeatTime.Add(v.server.engine.LeaseTimeout()),
+		Job:      resource(job),
+	}, nil
+}
+
+// JobsService lists jobs, optionally filtered by queue, status and type
+func (v *V2) JobsService(w http.ResponseWriter, r *http.Request) {
+	tenant, ok := v.server.tenant(w, r)
+	if !ok {
+		return
+	}
+	query := r.URL.Query()
+	list := JobList{Jobs: []JobResource{}}
+	for _, job := range v.jobs(tenant, query.Get("status"), query.Get("type")) {
+		if query.Has("queue") && queueName(job.Queue) != query.Get("queue") {
+			continue
+		}
+		list.Jobs = append(list.Jobs, resource(job))
+	}
+	writeJSON(w, list)
+}
+
+// CreateJobService enqueues a job and returns it
+func (v *V2) CreateJobService(w http.ResponseWriter, r *http.Request) {
+	s := v.server
+	var body JobRequest
+	if err := s.decode(w, r, &body); err != nil {
+		s.decodeError(w, r, err)
+		return
+	}
+	tenant, ok := s.tenant(w, r)
+	if !ok {
+		return
+	}
+	job := jobqueue.Job{
+		Queue:          queueOf(body.Queue),
+		Type:           body.Type,
+		Key:            body.Key,
+		Status:         jobqueue.StatusQueued,
+		Payload:        body.Payload,
+		Callback:       body.Callback,
+		CallbackEvents: body.CallbackEvents,
+	}
+	if !s.checkCallback(w, r, job) {
+		return
+	}
+	if tenant != nil {
+		job.Tenant = *tenant
+	}
+	job.TraceParent, job.TraceState = tracing.TraceParent(r.Context())
+
+	id, err := s.engine.Enqueue(job)
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+	logging.AddFields(r.Context(), logrus.Fields{"job_id": id})
+	job, err = s.engine.Job(id)
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+	s.log(r).Debug("Job created")
+	created(w, "/v2/jobs/"+strconv.Itoa(id), resource(job))
+}
+
+// JobService returns a job
+func (v *V2) JobService(w http.ResponseWriter, r *http.Request) {
+	id, ok := v.server.jobID(w, r)
+	if !ok {
+		return
+	}
+	job, err := v.server.engine.Job(id)
+	if err != nil {
+		v.server.writeError(w, r, err)
+		return
+	}
+	writeJSON(w, resource(job))
+}
+
+// UpdateJobService cancels a job or puts it back into the queue and returns it
+func (v *V2) UpdateJobService(w http.ResponseWriter, r *http.Request) {
+	s := v.server
+	id, ok := s.jobID(w, r)
+	if !ok {
+		return
+	}
+	var body JobUpdate
+	if err := s.decode(w, r, &body); err != nil {
+		s.decodeError(w, r, err)
+		return
+	}
+	var err error
+	switch body.Status {
+	case StatusCancelled:
+		err = s.engine.Cancel(id)
+	case jobqueue.StatusQueued:
+		err = s.engine.Retry(id)
+	default:
+		s.writeAPIError(w, r, apierror.New(http.StatusBadRequest, apierror.CodeInvalidRequest, "Status must be CANCELLED or QUEUED").With("Status", body.Status))
+		return
+	}
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+	job, err := s.engine.Job(id)
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+	s.log(r).Info("Job status changed to " + body.Status)
+	writeJSON(w, resource(job))
+}
+
+// CopyJobService enqueues a copy of a job that is done and returns the copy
+func (v *V2) CopyJobService(w http.ResponseWriter, r *http.Request) {
+	s := v.server
+	id, ok := s.jobID(w, r)
+	if !ok {
+		return
+	}
+	copyID, err := s.engine.Redrive(id)
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+	logging.AddFields(r.Context(), logrus.Fields{"copy_id": copyID})
+	job, err := s.engine.Job(copyID)
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+	s.log(r).Debug("Job copied")
+	created(w, "/v2/jobs/"+strconv.Itoa(copyID), resource(job))
+}
+
+// ChangesService returns the changes recorded for a job, oldest first
+func (v *V2) ChangesService(w http.ResponseWriter, r *http.Request) {
+	id, ok := v.server.jobID(w, r)
+	if !ok {
+		return
+	}
+	history, err := v.server.engine.History(id)
+	if err != nil {
+		v.server.writeError(w, r, err)
+		return
+	}
+	writeJSON(w, ChangeList{Changes: history})
+}
+
+// AttemptsService lists the attempts of a job, oldest first
+func (v *V2) AttemptsService(w http.ResponseWriter, r *http.Request) {
+	id, ok := v.server.jobID(w, r)
+	if !ok {
+		return
+	}
+	attempts, err := v.server.engine.Attempts(id)
+	if err != nil {
+		v.server.writeError(w, r, err)
+		return
+	}
+	writeJSON(w, AttemptList{Attempts: attempts})
+}
+
+// attempt reads the job and attempt number from the URI path and returns the attempts of the job
+func (v *V2) attempt(w http.ResponseWriter, r *http.Request) (int, []jobqueue.Attempt, int, bool) {
+	id, ok := v.server.jobID(w, r)
+	if !ok {
+		return 0, nil, 0, false
+	}
+	attempts, err := v.server.engine.Attempts(id)
+	if err != nil {
+		v.server.writeError(w, r, err)
+		return 0, nil, 0, false
+	}
+	value := mux.Vars(r)["attempt"]
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		v.server.invalidRequest(w, r, "Invalid attempt: "+value)
+		return 0, nil, 0, false
+	}
+	if number < 1 || number > len(attempts) {
+		v.server.writeAPIError(w, r, apierror.New(http.StatusNotFound, apierror.CodeNotFound, "Attempt not found").With("JobID", id).With("Attempt", number))
+		return 0, nil, 0, false
+	}
+	return id, attempts, number, true
+}
+
+// AttemptService returns one attempt of a job
+func (v *V2) AttemptService(w http.ResponseWriter, r *http.Request) {
+	_, attempts, number, ok := v.attempt(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, attempts[number-1])
+}
+
+// UpdateAttemptService concludes or fails the attempt the consumer holds and returns it
+func (v *V2) UpdateAttemptService(w http.ResponseWriter, r *http.Request) {
+	s := v.server
+	consumer, ok := s.consumer(w, r)
+	if !ok {
+		return
+	}
+	id, attempts, number, ok := v.attempt(w, r)
+	if !ok {
+		return
+	}
+	var body AttemptUpdate
+	if err := s.decode(w, r, &body); err != nil {
+		s.decodeError(w, r, err)
+		return
+	}
+	// only the latest attempt can still be in progress
+	if number != len(attempts) || attempts[number-1].Outcome != jobqueue.OutcomeInProgress {
+		s.writeAPIError(w, r, apierror.New(http.StatusConflict, apierror.CodeJobNotInProgress, "Attempt not in progress").With("JobID", id).With("Attempt", number))
+		return
+	}
+	var err error
+	switch body.Status {
+	case jobqueue.OutcomeConcluded:
+		err = s.engine.ConcludeAs(id, consumer, body.Result)
+	case jobqueue.OutcomeFailed:
+		err = s.engine.Fail(id, consumer, body.Error)
+	default:
+		s.writeAPIError(w, r, apierror.New(http.StatusBadRequest, apierror.CodeInvalidRequest, "Status must be CONCLUDED or FAILED").With("Status", body.Status))
+		return
+	}
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+	if attempts, err = s.engine.Attempts(id); err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+	s.log(r).Debug("Attempt " + body.Status)
+	writeJSON(w, attempts[number-1])
+}
+
+// leased returns the job of a lease, a job that is not in progress has none
+func (v *V2) leased(w http.ResponseWriter, r *http.Request, id int) (jobqueue.Job, bool) {
+	job, err := v.server.engine.Job(id)
+	if err != nil {
+		v.server.writeError(w, r, err)
+		return job, false
+	}
+	if job.Status != jobqueue.StatusInProgress || job.Cancel {
+		v.server.writeAPIError(w, r, apierror.New(http.StatusNotFound, apierror.CodeNotFound, "Lease not found").With("JobID", id))
+		return job, false
+	}
+	return job, true
+}
+
+// LeaseService returns the lease on a job in progress
+func (v *V2) LeaseService(w http.ResponseWriter, r *http.Request) {
+	id, ok := v.server.jobID(w, r)
+	if !ok {
+		return
+	}
+	job, ok := v.leased(w, r, id)
+	if !ok {
+		return
+	}
+	lease, err := v.lease(job)
+	if err != nil {
+		v.server.writeError(w, r, err)
+		return
+	}
+	writeJSON(w, lease)
+}
+
+// RenewLeaseService extends the lease the consumer holds on a job and returns it, it fails
+// once the job is cancelled
+func (v *V2) RenewLeaseService(w http.ResponseWriter, r *http.Request) {
+	s := v.server
+	id, ok := s.jobID(w, r)
+	if !ok {
+		return
+	}
+	consumer, ok := s.consumer(w, r)
+	if !ok {
+		return
+	}
+	if err := s.engine.Heartbeat(id, consumer); err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+	job, ok := v.leased(w, r, id)
+	if !ok {
+		return
+	}
+	lease, err := v.lease(job)
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+	writeJSON(w, lease)
+}
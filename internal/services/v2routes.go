@@ -0,0 +1,171 @@
+package services
+
+import (
+	"net/http"
+
+	"github.com/varungujarathi9/job-queue/internal/apierror"
+	"github.com/varungujarathi9/job-queue/internal/openapi"
+	"github.com/varungujarathi9/job-queue/pkg/jobqueue"
+)
+
+// Route is a route of the v2 API with the handler serving it, the OpenAPI document is built from the routes
+type Route struct {
+	openapi.Operation
+	Handler http.HandlerFunc
+}
+
+// OpenAPIPath is where the OpenAPI document of the v2 API is served
+const OpenAPIPath = "/v2/openapi.json"
+
+var (
+	jobIDParam     = openapi.Param{Name: "job_id", In: openapi.InPath, Type: "integer", Description: "Job ID"}
+	attemptParam   = openapi.Param{Name: "attempt", In: openapi.InPath, Type: "integer", Description: "Attempt number, starting at 1"}
+	queueParam     = openapi.Param{Name: "queue", In: openapi.InPath, Description: "Queue name, jobs enqueued without one are in " + DefaultQueue}
+	tenantParam    = openapi.Param{Name: "QUEUE_TENANT", In: openapi.InHeader, Description: "Only act on the Jobs of this tenant"}
+	consumerParam  = openapi.Param{Name: "QUEUE_CONSUMER", In: openapi.InHeader, Type: "integer", Required: true, Description: "Queue Consumer ID"}
+	traceParam     = openapi.Param{Name: "traceparent", In: openapi.InHeader, Description: "W3C trace context stored with the Job"}
+	errorResponses = map[int]string{
+		http.StatusBadRequest:            "Malformed request",
+		http.StatusUnauthorized:          "Authentication required",
+		http.StatusForbidden:             "Identity may not use the route or does not match the headers",
+		http.StatusNotFound:              "Job, attempt or lease not found",
+		http.StatusConflict:              "Job is in the wrong state",
+		http.StatusRequestEntityTooLarge: "Payload too large",
+		http.StatusUnprocessableEntity:   "Missing fields or invalid Type",
+		http.StatusTooManyRequests:       "Queue full or rate limited",
+		http.StatusServiceUnavailable:    "Queue is draining",
+	}
+)
+
+// responses returns the success response of an operation with the errors it may answer with,
+// every operation may be refused by authentication
+func responses(status int, body interface{}, description string, errors ...int) map[int]openapi.Response {
+	resps := map[int]openapi.Response{status: {Description: description, Body: body}}
+	for _, code := range append([]int{http.StatusUnauthorized, http.StatusForbidden}, errors...) {
+		resps[code] = openapi.Response{Description: errorResponses[code], Body: apierror.Error{}}
+	}
+	return resps
+}
+
+// Routes lists the routes of the v2 API, the names are the route names used for authorization and metrics
+func (v *V2) Routes() []Route {
+	return []Route{
+		{openapi.Operation{
+			Method: http.MethodGet, Path: "/v2/queues", Name: "v2-queues",
+			Summary:   "Lists the queues holding Jobs with their counts by status",
+			Params:    []openapi.Param{tenantParam},
+			Responses: responses(http.StatusOK, QueueList{}, "Queues", http.StatusBadRequest),
+		}, v.QueuesService},
+		{openapi.Operation{
+			Method: http.MethodGet, Path: "/v2/queues/{queue}", Name: "v2-queue",
+			Summary:   "Counts the Jobs of a queue by status",
+			Params:    []openapi.Param{queueParam, tenantParam},
+			Responses: responses(http.StatusOK, Queue{}, "Queue", http.StatusBadRequest),
+		}, v.QueueService},
+		{openapi.Operation{
+			Method: http.MethodPost, Path: "/v2/queues/{queue}/leases", Name: "v2-lease-acquire",
+			Summary: "Dequeues the next Job of a queue and returns the lease on it, waiting up to Wait for one",
+			Params:  []openapi.Param{queueParam, consumerParam, tenantParam},
+			Body:    LeaseRequest{},
+			Responses: func() map[int]openapi.Response {
+				resps := responses(http.StatusCreated, Lease{}, "Lease acquired", http.StatusBadRequest, http.StatusServiceUnavailable)
+				resps[http.StatusNoContent] = openapi.Response{Description: "No job available"}
+				return resps
+			}(),
+		}, v.AcquireLeaseService},
+		{openapi.Operation{
+			Method: http.MethodGet, Path: "/v2/jobs", Name: "v2-jobs",
+			Summary: "Lists Jobs ordered by ID, optionally filtered by queue, status and type",
+			Params: []openapi.Param{
+				{Name: "queue", In: openapi.InQuery, Description: "Queue name"},
+				{Name: "status", In: openapi.InQuery, Description: "Job status, including " + StatusCancelled},
+				{Name: "type", In: openapi.InQuery, Description: "Job type"},
+				tenantParam,
+			},
+			Responses: responses(http.StatusOK, JobList{}, "Jobs", http.StatusBadRequest),
+		}, v.JobsService},
+		{openapi.Operation{
+			Method: http.MethodPost, Path: "/v2/jobs", Name: "v2-job-create",
+			Summary: "Enqueues a Job",
+			Params:  []openapi.Param{tenantParam, traceParam},
+			Body:    JobRequest{},
+			Responses: responses(http.StatusCreated, JobResource{}, "Job created", http.StatusBadRequest,
+				http.StatusRequestEntityTooLarge, http.StatusUnprocessableEntity, http.StatusTooManyRequests, http.StatusServiceUnavailable),
+		}, v.CreateJobService},
+		{openapi.Operation{
+			Method: http.MethodGet, Path: "/v2/jobs/{job_id}", Name: "v2-job",
+			Summary:   "Returns a Job",
+			Params:    []openapi.Param{jobIDParam, tenantParam},
+			Responses: responses(http.StatusOK, JobResource{}, "Job", http.StatusBadRequest, http.StatusNotFound),
+		}, v.JobService},
+		{openapi.Operation{
+			Method: http.MethodPatch, Path: "/v2/jobs/{job_id}", Name: "v2-job-update",
+			Summary:   "Cancels a Job with Status " + StatusCancelled + " or puts it back into the queue with Status " + jobqueue.StatusQueued,
+			Params:    []openapi.Param{jobIDParam, tenantParam},
+			Body:      JobUpdate{},
+			Responses: responses(http.StatusOK, JobResource{}, "Job updated", http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusServiceUnavailable),
+		}, v.UpdateJobService},
+		{openapi.Operation{
+			Method: http.MethodPost, Path: "/v2/jobs/{job_id}/copies", Name: "v2-job-copy",
+			Summary: "Enqueues a copy of a Job that is concluded, failed or cancelled",
+			Params:  []openapi.Param{jobIDParam, tenantParam},
+			Responses: responses(http.StatusCreated, JobResource{}, "Copy created", http.StatusBadRequest, http.StatusNotFound,
+				http.StatusConflict, http.StatusTooManyRequests, http.StatusServiceUnavailable),
+		}, v.CopyJobService},
+		{openapi.Operation{
+			Method: http.MethodGet, Path: "/v2/jobs/{job_id}/changes", Name: "v2-job-changes",
+			Summary:   "Returns the changes recorded for a Job, oldest first",
+			Params:    []openapi.Param{jobIDParam, tenantParam},
+			Responses: responses(http.StatusOK, ChangeList{}, "Changes", http.StatusBadRequest, http.StatusNotFound),
+		}, v.ChangesService},
+		{openapi.Operation{
+			Method: http.MethodGet, Path: "/v2/jobs/{job_id}/attempts", Name: "v2-attempts",
+			Summary:   "Lists the times a Job was handed to a consumer, oldest first",
+			Params:    []openapi.Param{jobIDParam, tenantParam},
+			Responses: responses(http.StatusOK, AttemptList{}, "Attempts", http.StatusBadRequest, http.StatusNotFound),
+		}, v.AttemptsService},
+		{openapi.Operation{
+			Method: http.MethodGet, Path: "/v2/jobs/{job_id}/attempts/{attempt}", Name: "v2-attempt",
+			Summary:   "Returns one attempt of a Job",
+			Params:    []openapi.Param{jobIDParam, attemptParam, tenantParam},
+			Responses: responses(http.StatusOK, jobqueue.Attempt{}, "Attempt", http.StatusBadRequest, http.StatusNotFound),
+		}, v.AttemptService},
+		{openapi.Operation{
+			Method: http.MethodPatch, Path: "/v2/jobs/{job_id}/attempts/{attempt}", Name: "v2-attempt-update",
+			Summary:   "Ends the attempt in progress with Status " + jobqueue.OutcomeConcluded + " or " + jobqueue.OutcomeFailed,
+			Params:    []openapi.Param{jobIDParam, attemptParam, consumerParam, tenantParam},
+			Body:      AttemptUpdate{},
+			Responses: responses(http.StatusOK, jobqueue.Attempt{}, "Attempt ended", http.StatusBadRequest, http.StatusNotFound, http.StatusConflict),
+		}, v.UpdateAttemptService},
+		{openapi.Operation{
+			Method: http.MethodGet, Path: "/v2/leases/{job_id}", Name: "v2-lease",
+			Summary:   "Returns the lease on a Job in progress",
+			Params:    []openapi.Param{jobIDParam, tenantParam},
+			Responses: responses(http.StatusOK, Lease{}, "Lease", http.StatusBadRequest, http.StatusNotFound),
+		}, v.LeaseService},
+		{openapi.Operation{
+			Method: http.MethodPut, Path: "/v2/leases/{job_id}", Name: "v2-lease-renew",
+			Summary:   "Renews the lease the consumer holds on a Job, fails once the Job is cancelled",
+			Params:    []openapi.Param{jobIDParam, consumerParam, tenantParam},
+			Responses: responses(http.StatusOK, Lease{}, "Lease renewed", http.StatusBadRequest, http.StatusNotFound, http.StatusConflict),
+		}, v.RenewLeaseService},
+		{openapi.Operation{
+			Method: http.MethodGet, Path: OpenAPIPath, Name: "v2-openapi",
+			Summary:   "Returns this OpenAPI document",
+			Responses: map[int]openapi.Response{http.StatusOK: {Description: "OpenAPI document"}},
+		}, v.OpenAPIService},
+	}
+}
+
+// OpenAPIService returns the OpenAPI document of the v2 API, built from its routes
+func (v *V2) OpenAPIService(w http.ResponseWriter, r *http.Request) {
+	v.specOnce.Do(func() {
+		routes := v.Routes()
+		ops := make([]openapi.Operation, 0, len(routes))
+		for _, route := range routes {
+			ops = append(ops, route.Operation)
+		}
+		v.spec = openapi.Build("Job Queue", "2.0", ops)
+	})
+	writeJSON(w, v.spec)
+}
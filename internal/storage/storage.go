@@ -0,0 +1,219 @@
+// Package storage keeps the state of a queue engine across restarts.
+//
+// The file backend appends the engine's change stream to a journal, one JSON change
+// per line, and replays it into the engine on startup. The memory backend keeps
+// nothing, a restarted server starts with an empty queue.
+package storage
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/varungujarathi9/job-queue/pkg/jobqueue"
+)
+
+const (
+	// Memory keeps jobs in memory only
+	Memory = "memory"
+	// File journals every change to a file
+	File = "file"
+
+	flushPage = 1000
+)
+
+// Backends lists the supported storage backends
+var Backends = []string{Memory, File}
+
+// Store persists the state of one engine
+type Store interface {
+	// Run writes new changes until Close is called
+	Run()
+	// Flush writes every change recorded so far
+	Flush() error
+	// Close flushes and stops the store
+	Close() error
+	// Check returns the error of the last write, nil while the store keeps up
+	Check() error
+}
+
+// Options selects and configures a storage backend
+type Options struct {
+	// Backend is one of Backends, empty means Memory
+	Backend string
+	// Path is the journal file of the file backend
+	Path string
+	// SyncInterval is how often the file backend writes new changes
+	SyncInterval time.Duration
+}
+
+// Open restores engine from the configured backend and returns the store that keeps it
+// up to date, it must be called before the engine is used
+func Open(opts Options, engine *jobqueue.Engine) (Store, error) {
+	switch opts.Backend {
+	case "", Memory:
+		return memoryStore{}, nil
+	case File:
+		return openFile(opts, engine)
+	}
+	return nil, fmt.Errorf("unknown storage backend %q", opts.Backend)
+}
+
+// memoryStore keeps nothing
+type memoryStore struct{}
+
+func (memoryStore) Run()         {}
+func (memoryStore) Flush() error { return nil }
+func (memoryStore) Close() error { return nil }
+func (memoryStore) Check() error { return nil }
+
+// fileStore appends the change stream of an engine to a journal file
+type fileStore struct {
+	engine   *jobqueue.Engine
+	interval time.Duration
+	done     chan struct{}
+	once     sync.Once
+
+	mutex  sync.Mutex
+	file   *os.File
+	writer *bufio.Writer
+	seq    int
+	closed bool
+	err    error
+}
+
+func openFile(opts Options, engine *jobqueue.Engine) (*fileStore, error) {
+	if opts.Path == "" {
+		return nil, fmt.Errorf("the file storage backend needs a path")
+	}
+	file, err := os.OpenFile(opts.Path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	changes, end, err := readJournal(file)
+	if info, statErr := file.Stat(); err == nil && statErr == nil && end > info.Size() {
+		end = info.Size()
+	}
+	if err == nil {
+		// drop a line cut short by a crash so new changes start on a line of their own
+		err = file.Truncate(end)
+	}
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("reading %s: %v", opts.Path, err)
+	}
+	engine.Restore(changes)
+
+	s := &fileStore{
+		engine:   engine,
+		interval: opts.SyncInterval,
+		done:     make(chan struct{}),
+		file:     file,
+		writer:   bufio.NewWriter(file),
+		seq:      engine.LastSeq(),
+	}
+	if s.interval <= 0 {
+		s.interval = time.Second
+	}
+	return s, nil
+}
+
+// readJournal decodes the changes of a journal along with the offset where the last
+// complete one ends, a line cut short by a crash ends the journal
+func readJournal(r io.Reader) ([]jobqueue.Change, int64, error) {
+	var changes []jobqueue.Change
+	var end int64
+	decoder := json.NewDecoder(r)
+	for {
+		var change jobqueue.Change
+		err := decoder.Decode(&change)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return changes, end, nil
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		changes = append(changes, change)
+		end = decoder.InputOffset() + 1
+	}
+}
+
+func (s *fileStore) Run() {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+		// a failed write is retried on the next tick and reported by Close
+		s.Flush()
+	}
+}
+
+func (s *fileStore) Flush() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.err = s.flush()
+	return s.err
+}
+
+func (s *fileStore) Check() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return errors.New("store is closed")
+	}
+	return s.err
+}
+
+// flush writes the changes after seq and syncs the file, the caller must hold mutex
+func (s *fileStore) flush() error {
+	if s.closed {
+		return nil
+	}
+	encoder := json.NewEncoder(s.writer)
+	seq := s.seq
+	for {
+		_, changes := s.engine.Changes(seq, flushPage)
+		if len(changes) == 0 {
+			break
+		}
+		for _, change := range changes {
+			if err := encoder.Encode(change); err != nil {
+				return err
+			}
+			seq = change.Seq
+		}
+	}
+	if err := s.writer.Flush(); err != nil {
+		return err
+	}
+	if err := s.file.Sync(); err != nil {
+		return err
+	}
+	s.seq = seq
+	return nil
+}
+
+func (s *fileStore) Close() error {
+	s.once.Do(func() { close(s.done) })
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	err := s.flush()
+	if s.closed {
+		return err
+	}
+	s.closed = true
+	if closeErr := s.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
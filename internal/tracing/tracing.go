@@ -0,0 +1,188 @@
+// Package tracing propagates W3C trace context through jobs and emits spans for the
+// requests of the REST API and for the time jobs wait in the queue.
+//
+// The traceparent of an enqueue request is stored with the job and handed to the
+// consumer that dequeues it, so the consumer's work joins the producer's trace. Spans
+// are exported to an OTLP/HTTP collector or to a file of JSON lines, with no exporter
+// the trace context is still propagated.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/gorilla/mux"
+	"github.com/varungujarathi9/job-queue/internal/utils"
+	"github.com/varungujarathi9/job-queue/pkg/jobqueue"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+const (
+	// None propagates trace context without exporting spans
+	None = "none"
+	// OTLP exports spans to an OTLP/HTTP collector
+	OTLP = "otlp"
+	// File writes spans to a file, one JSON span per line
+	File = "file"
+
+	serviceName = "job-queue"
+	scopeName   = "github.com/varungujarathi9/job-queue"
+
+	// DefaultEndpoint is where a local OpenTelemetry collector receives OTLP over HTTP
+	DefaultEndpoint = "http://localhost:4318"
+)
+
+// Exporters lists the supported span exporters
+var Exporters = []string{None, OTLP, File}
+
+// propagator reads and writes the traceparent and tracestate headers
+var propagator = propagation.TraceContext{}
+
+// Options selects and configures a span exporter
+type Options struct {
+	// Exporter is one of Exporters, empty means None
+	Exporter string
+	// Endpoint is the URL of the OTLP/HTTP collector, DefaultEndpoint when empty
+	Endpoint string
+	// Path is the file the file exporter appends to
+	Path string
+}
+
+// Tracer emits the spans of one server
+type Tracer struct {
+	tracer   trace.Tracer
+	provider *sdktrace.TracerProvider
+	file     *os.File
+}
+
+// New creates a tracer exporting spans as configured, Shutdown flushes them
+func New(opts Options) (*Tracer, error) {
+	var exporter sdktrace.SpanExporter
+	t := &Tracer{}
+	switch opts.Exporter {
+	case "", None:
+		t.tracer = noop.NewTracerProvider().Tracer(scopeName)
+		return t, nil
+	case OTLP:
+		endpoint := opts.Endpoint
+		if endpoint == "" {
+			endpoint = DefaultEndpoint
+		}
+		var err error
+		if exporter, err = otlptracehttp.New(context.Background(), otlptracehttp.WithEndpointURL(endpoint)); err != nil {
+			return nil, err
+		}
+	case File:
+		if opts.Path == "" {
+			return nil, fmt.Errorf("the file span exporter needs a path")
+		}
+		file, err := os.OpenFile(opts.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, err
+		}
+		if exporter, err = stdouttrace.New(stdouttrace.WithWriter(file)); err != nil {
+			file.Close()
+			return nil, err
+		}
+		t.file = file
+	default:
+		return nil, fmt.Errorf("unknown span exporter %q", opts.Exporter)
+	}
+
+	t.provider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", serviceName))),
+	)
+	t.tracer = t.provider.Tracer(scopeName)
+	return t, nil
+}
+
+// Shutdown exports the spans that are still buffered and stops the exporter
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	if t.provider == nil {
+		return nil
+	}
+	err := t.provider.Shutdown(ctx)
+	if t.file != nil {
+		if closeErr := t.file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// Middleware emits a span for every request served by the routes of a mux router, a
+// child of the span in the request's traceparent header when there is one
+func (t *Tracer) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := ""
+		if current := mux.CurrentRoute(r); current != nil {
+			route = current.GetName()
+		}
+		ctx := propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := t.tracer.Start(ctx, r.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", r.Method),
+				attribute.String("http.route", route),
+				attribute.String("url.path", r.URL.Path),
+			),
+		)
+		defer span.End()
+
+		recorder := utils.NewStatusRecorder(w)
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+
+		span.SetAttributes(attribute.Int("http.response.status_code", recorder.Status))
+		if recorder.Status >= 500 {
+			span.SetStatus(codes.Error, http.StatusText(recorder.Status))
+		}
+	})
+}
+
+// RecordWait emits a span for the time job waited in the queue, from its enqueue to its
+// dequeue, as a child of the trace context stored with the job
+func (t *Tracer) RecordWait(job jobqueue.Job) {
+	ctx := Context(context.Background(), job)
+	_, span := t.tracer.Start(ctx, "queue wait",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithTimestamp(job.EnqueueTime),
+		trace.WithAttributes(
+			attribute.Int("job.id", job.ID),
+			attribute.String("job.type", job.Type),
+			attribute.String("job.tenant", job.Tenant),
+			attribute.Int("job.consumer", job.ConsumedBy),
+		),
+	)
+	span.End(trace.WithTimestamp(job.DequeueTime))
+}
+
+// TraceParent returns the traceparent and tracestate of the span in ctx, empty when ctx has none
+func TraceParent(ctx context.Context) (string, string) {
+	carrier := propagation.MapCarrier{}
+	propagator.Inject(ctx, carrier)
+	return carrier.Get("traceparent"), carrier.Get("tracestate")
+}
+
+// Context returns ctx carrying the trace context stored with job as its remote parent
+func Context(ctx context.Context, job jobqueue.Job) context.Context {
+	if job.TraceParent == "" {
+		return ctx
+	}
+	return propagator.Extract(ctx, propagation.MapCarrier{"traceparent": job.TraceParent, "tracestate": job.TraceState})
+}
+
+// SetHeaders writes the trace context stored with job to the traceparent and tracestate headers
+func SetHeaders(header http.Header, job jobqueue.Job) {
+	propagator.Inject(Context(context.Background(), job), propagation.HeaderCarrier(header))
+}
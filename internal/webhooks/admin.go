@@ -0,0 +1,151 @@
+package webhooks
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/varungujarathi9/job-queue/internal/apierror"
+	"github.com/varungujarathi9/job-queue/internal/logging"
+	"github.com/varungujarathi9/job-queue/internal/utils"
+)
+
+// SubscriptionList is the body listing the subscriptions
+type SubscriptionList struct {
+	Subscriptions []Subscription `json:"Subscriptions"`
+}
+
+// DeliveryList is the body listing the logged deliveries
+type DeliveryList struct {
+	Deliveries []Delivery `json:"Deliveries"`
+}
+
+// pathID reads a positive integer ID from the route variable name
+func pathID(w http.ResponseWriter, r *http.Request, name string) (int, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)[name])
+	if err != nil || id <= 0 {
+		apierror.InvalidRequest(w, "Invalid "+name+": "+mux.Vars(r)[name])
+		return 0, false
+	}
+	return id, true
+}
+
+// queryID reads an optional positive integer from the query, zero when it is missing
+func queryID(w http.ResponseWriter, r *http.Request, name string) (int, bool) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return 0, true
+	}
+	id, err := strconv.Atoi(value)
+	if err != nil || id <= 0 {
+		apierror.InvalidRequest(w, "Invalid "+name+": "+value)
+		return 0, false
+	}
+	return id, true
+}
+
+// SubscriptionsService lists the subscriptions, their secrets are not returned
+func (d *Dispatcher) SubscriptionsService(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(SubscriptionList{Subscriptions: d.Subscriptions()})
+}
+
+// SubscriptionService returns one subscription without its secret
+func (d *Dispatcher) SubscriptionService(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "webhook_id")
+	if !ok {
+		return
+	}
+	for _, sub := range d.Subscriptions() {
+		if sub.ID == id {
+			json.NewEncoder(w).Encode(sub)
+			return
+		}
+	}
+	apierror.Write(w, apierror.New(http.StatusNotFound, apierror.CodeNotFound, "Webhook not found").With("ID", id))
+}
+
+// CreateSubscriptionService adds a subscription, it lasts until the server restarts
+func (d *Dispatcher) CreateSubscriptionService(w http.ResponseWriter, r *http.Request) {
+	var sub Subscription
+	if err := json.NewDecoder(r.Body).Decode(&sub); err != nil {
+		apierror.InvalidRequest(w, "Invalid body: "+err.Error())
+		return
+	}
+	if err := sub.Validate(); err != nil {
+		apierror.InvalidRequest(w, err.Error())
+		return
+	}
+	sub = d.Subscribe(sub)
+	sub.Secret = ""
+	logging.FromContext(r.Context(), utils.Logger).Info("Webhook " + strconv.Itoa(sub.ID) + " created for " + sub.URL)
+	w.Header().Set("Location", "/webhooks/"+strconv.Itoa(sub.ID))
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(sub)
+}
+
+// DeleteSubscriptionService removes a subscription
+func (d *Dispatcher) DeleteSubscriptionService(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "webhook_id")
+	if !ok {
+		return
+	}
+	if err := d.Unsubscribe(id); err != nil {
+		apierror.Write(w, apierror.New(http.StatusNotFound, apierror.CodeNotFound, "Webhook not found").With("ID", id))
+		return
+	}
+	logging.FromContext(r.Context(), utils.Logger).Info("Webhook " + strconv.Itoa(id) + " deleted")
+	w.Write([]byte(`{"status" : "Webhook deleted"}`))
+}
+
+// DeliveriesService lists the logged deliveries newest first, optionally filtered by
+// status, job and subscription
+func (d *Dispatcher) DeliveriesService(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	jobID, ok := queryID(w, r, "job_id")
+	if !ok {
+		return
+	}
+	subscription, ok := queryID(w, r, "webhook_id")
+	if !ok {
+		return
+	}
+	deliveries := d.Deliveries(func(delivery Delivery) bool {
+		return (status == "" || delivery.Status == status) &&
+			(jobID == 0 || delivery.JobID == jobID) &&
+			(subscription == 0 || delivery.Subscription == subscription)
+	})
+	json.NewEncoder(w).Encode(DeliveryList{Deliveries: deliveries})
+}
+
+// DeliveryService returns one logged delivery
+func (d *Dispatcher) DeliveryService(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "delivery_id")
+	if !ok {
+		return
+	}
+	delivery, err := d.Delivery(id)
+	if err != nil {
+		apierror.Write(w, apierror.New(http.StatusNotFound, apierror.CodeNotFound, "Delivery not found").With("ID", id))
+		return
+	}
+	json.NewEncoder(w).Encode(delivery)
+}
+
+// RedeliverService sends a logged delivery again and returns the new delivery, which is
+// sent in the background
+func (d *Dispatcher) RedeliverService(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "delivery_id")
+	if !ok {
+		return
+	}
+	delivery, err := d.Redeliver(id)
+	if err != nil {
+		apierror.Write(w, apierror.New(http.StatusNotFound, apierror.CodeNotFound, "Delivery not found").With("ID", id))
+		return
+	}
+	logging.FromContext(r.Context(), utils.Logger).Info("Delivery " + strconv.Itoa(id) + " redelivered as " + strconv.Itoa(delivery.ID))
+	w.Header().Set("Location", "/webhooks/deliveries/"+strconv.Itoa(delivery.ID))
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(delivery)
+}
@@ -0,0 +1,489 @@
+// Package webhooks sends a signed POST to callback URLs when jobs change state.
+//
+// A job enqueued with a Callback URL is sent its own changes, and subscriptions are sent
+// the changes of every job they match. The body is the jobqueue.Change as it was recorded
+// in the change stream, and it is signed as described in package webhook.
+//
+// A delivery that is not answered with a 2xx status is retried with a doubling backoff.
+// The dispatcher keeps a bounded log of the deliveries, which can be read and redelivered
+// through the admin API. The log, the retries and the subscriptions added through the API
+// are only kept in memory, so they are lost when the server stops.
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/varungujarathi9/job-queue/internal/utils"
+	"github.com/varungujarathi9/job-queue/pkg/jobqueue"
+	"github.com/varungujarathi9/job-queue/pkg/webhook"
+)
+
+// statuses of a delivery
+const (
+	StatusPending   = "PENDING"
+	StatusDelivered = "DELIVERED"
+	StatusFailed    = "FAILED"
+)
+
+var (
+	// Events are the operations of the change stream that callbacks and subscriptions may choose
+	Events = []string{jobqueue.OpEnqueue, jobqueue.OpDequeue, jobqueue.OpConclude, jobqueue.OpFail,
+		jobqueue.OpCancel, jobqueue.OpRetry, jobqueue.OpExpire}
+	// DefaultEvents are sent when a callback or subscription does not choose any
+	DefaultEvents = []string{jobqueue.OpConclude, jobqueue.OpFail, jobqueue.OpCancel, jobqueue.OpExpire}
+
+	// ErrNotFound is returned for an unknown subscription or delivery
+	ErrNotFound = errors.New("not found")
+)
+
+const (
+	defaultMaxAttempts = 5
+	defaultBackoff     = time.Second
+	defaultTimeout     = 10 * time.Second
+
+	// logSize is how many deliveries the log keeps, the oldest are dropped first
+	logSize = 1000
+	// workers is how many deliveries are sent at once
+	workers = 4
+	// changesBatch is how many changes the dispatcher reads from the engine at once
+	changesBatch = 100
+)
+
+// Options configures a Dispatcher
+type Options struct {
+	// Secret signs the deliveries of job callbacks and of subscriptions without a secret of
+	// their own, webhooks are disabled when it is empty
+	Secret string
+	// MaxAttempts is how many times a delivery is sent before it fails, 5 by default
+	MaxAttempts int
+	// Backoff is the wait before the first retry, it doubles for each retry after it, 1s by default
+	Backoff time.Duration
+	// Timeout bounds one attempt, 10s by default
+	Timeout time.Duration
+	// Subscriptions are created along with the dispatcher
+	Subscriptions []Subscription
+}
+
+// Subscription sends the changes of every job it matches to a URL
+type Subscription struct {
+	ID  int    `json:"ID"`
+	URL string `json:"URL"`
+	// Events are the operations sent, DefaultEvents when empty
+	Events []string `json:"Events,omitempty"`
+	// Queue, Types and Tenant narrow down the jobs, empty matches every job
+	Queue  string   `json:"Queue,omitempty"`
+	Types  []string `json:"Types,omitempty"`
+	Tenant string   `json:"Tenant,omitempty"`
+	// Secret signs the deliveries instead of the server's secret, it is never returned
+	Secret string `json:"Secret,omitempty"`
+}
+
+func (s Subscription) matches(change jobqueue.Change) bool {
+	job := change.Job
+	if !wants(s.Events, change.Op) || (s.Queue != "" && job.Queue != s.Queue) || (s.Tenant != "" && job.Tenant != s.Tenant) {
+		return false
+	}
+	return len(s.Types) == 0 || contains(s.Types, job.Type)
+}
+
+// Validate checks the URL and events of a subscription
+func (s Subscription) Validate() error {
+	if err := ValidateURL(s.URL); err != nil {
+		return err
+	}
+	return ValidateEvents(s.Events)
+}
+
+// wants reports whether events, or DefaultEvents when there are none, include op
+func wants(events []string, op string) bool {
+	if len(events) == 0 {
+		events = DefaultEvents
+	}
+	return contains(events, op)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateURL checks that a callback is an absolute http or https URL
+func ValidateURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("callback %q must be an absolute http or https URL", raw)
+	}
+	return nil
+}
+
+// ValidateEvents checks that every event may be chosen
+func ValidateEvents(events []string) error {
+	for _, event := range events {
+		if !contains(Events, event) {
+			return fmt.Errorf("event %q must be one of %s", event, strings.Join(Events, ", "))
+		}
+	}
+	return nil
+}
+
+// Delivery is one change sent to one URL, along with how sending it went
+type Delivery struct {
+	ID int `json:"ID"`
+	// Subscription is the ID of the subscription the delivery is for, zero for a job's callback
+	Subscription int `json:"Subscription,omitempty"`
+	// Redelivers is the ID of the delivery this one repeats
+	Redelivers int    `json:"Redelivers,omitempty"`
+	URL        string `json:"URL"`
+	Event      string `json:"Event"`
+	JobID      int    `json:"JobID"`
+	Seq        int    `json:"Seq"`
+	Status     string `json:"Status"`
+	Attempts   int    `json:"Attempts"`
+	// ResponseStatus is the HTTP status of the last attempt and Error why it failed
+	ResponseStatus int        `json:"ResponseStatus,omitempty"`
+	Error          string     `json:"Error,omitempty"`
+	Created        time.Time  `json:"Created"`
+	NextAttempt    *time.Time `json:"NextAttempt,omitempty"`
+	Delivered      *time.Time `json:"Delivered,omitempty"`
+
+	change jobqueue.Change
+	secret string
+}
+
+// Dispatcher tails the change stream of an engine and delivers the changes to the
+// callbacks of the jobs and to the subscriptions they match
+type Dispatcher struct {
+	engine *jobqueue.Engine
+	opts   Options
+	client *http.Client
+	slots  chan struct{}
+	since  int
+	ctx    context.Context
+	stop   context.CancelFunc
+	done   chan struct{}
+	sends  sync.WaitGroup
+
+	mu               sync.Mutex
+	subscriptions    []Subscription
+	nextSubscription int
+	deliveries       []*Delivery
+	nextDelivery     int
+	retries          map[int]*time.Timer
+	closed           bool
+}
+
+// New creates a dispatcher for the changes engine records from now on
+func New(engine *jobqueue.Engine, opts Options) *Dispatcher {
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = defaultMaxAttempts
+	}
+	if opts.Backoff <= 0 {
+		opts.Backoff = defaultBackoff
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaultTimeout
+	}
+	ctx, stop := context.WithCancel(context.Background())
+	d := &Dispatcher{
+		engine:           engine,
+		opts:             opts,
+		client:           &http.Client{Timeout: opts.Timeout},
+		slots:            make(chan struct{}, workers),
+		since:            engine.LastSeq(),
+		ctx:              ctx,
+		stop:             stop,
+		done:             make(chan struct{}),
+		nextSubscription: 1,
+		nextDelivery:     1,
+		retries:          map[int]*time.Timer{},
+	}
+	for _, sub := range opts.Subscriptions {
+		d.Subscribe(sub)
+	}
+	return d
+}
+
+// Run sends the deliveries of the changes as they are recorded until Close is called,
+// the changes recorded by then are still dispatched
+func (d *Dispatcher) Run() {
+	defer close(d.done)
+	for {
+		_, changes, err := d.engine.WaitChanges(d.ctx, d.since, changesBatch)
+		if err != nil {
+			if d.ctx.Err() == nil {
+				utils.Logger.Error("Webhook dispatcher stopped: " + err.Error())
+				return
+			}
+			// the changes left are read without waiting for more
+			if _, changes = d.engine.Changes(d.since, changesBatch); len(changes) == 0 {
+				return
+			}
+		}
+		d.since = changes[len(changes)-1].Seq
+		for _, change := range changes {
+			d.dispatch(change)
+		}
+	}
+}
+
+// Close stops Run and waits until it dispatched the changes recorded so far and the
+// deliveries being sent are done, or until ctx is done. Deliveries waiting for a retry
+// are dropped.
+func (d *Dispatcher) Close(ctx context.Context) error {
+	d.stop()
+	select {
+	case <-d.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	d.mu.Lock()
+	d.closed = true
+	for id, timer := range d.retries {
+		timer.Stop()
+		delete(d.retries, id)
+	}
+	d.mu.Unlock()
+
+	sent := make(chan struct{})
+	go func() {
+		d.sends.Wait()
+		close(sent)
+	}()
+	select {
+	case <-sent:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// dispatch logs and starts the deliveries of change
+func (d *Dispatcher) dispatch(change jobqueue.Change) {
+	d.mu.Lock()
+	var deliveries []*Delivery
+	if change.Job.Callback != "" && wants(change.Job.CallbackEvents, change.Op) {
+		deliveries = append(deliveries, d.add(&Delivery{URL: change.Job.Callback, change: change, secret: d.opts.Secret}))
+	}
+	for _, sub := range d.subscriptions {
+		if !sub.matches(change) {
+			continue
+		}
+		secret := sub.Secret
+		if secret == "" {
+			secret = d.opts.Secret
+		}
+		deliveries = append(deliveries, d.add(&Delivery{Subscription: sub.ID, URL: sub.URL, change: change, secret: secret}))
+	}
+	d.mu.Unlock()
+
+	for _, delivery := range deliveries {
+		d.start(delivery)
+	}
+}
+
+// add gives delivery an ID and appends it to the log, the caller must hold mu
+func (d *Dispatcher) add(delivery *Delivery) *Delivery {
+	delivery.ID = d.nextDelivery
+	d.nextDelivery++
+	delivery.Event = delivery.change.Op
+	delivery.JobID = delivery.change.Job.ID
+	delivery.Seq = delivery.change.Seq
+	delivery.Status = StatusPending
+	delivery.Created = time.Now()
+	d.deliveries = append(d.deliveries, delivery)
+	if len(d.deliveries) > logSize {
+		d.deliveries = d.deliveries[len(d.deliveries)-logSize:]
+	}
+	return delivery
+}
+
+// start sends delivery in the background once one of the workers' slots is free
+func (d *Dispatcher) start(delivery *Delivery) {
+	d.sends.Add(1)
+	go func() {
+		defer d.sends.Done()
+		d.slots <- struct{}{}
+		defer func() { <-d.slots }()
+		d.send(delivery)
+	}()
+}
+
+// send makes one attempt at delivery and schedules the next one when it fails
+func (d *Dispatcher) send(delivery *Delivery) {
+	d.mu.Lock()
+	delivery.Attempts++
+	attempt := delivery.Attempts
+	delivery.NextAttempt = nil
+	d.mu.Unlock()
+
+	status, err := d.post(delivery)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delivery.ResponseStatus = status
+	if err == nil {
+		now := time.Now()
+		delivery.Status = StatusDelivered
+		delivery.Delivered = &now
+		delivery.Error = ""
+		return
+	}
+	delivery.Error = err.Error()
+	if attempt >= d.opts.MaxAttempts {
+		delivery.Status = StatusFailed
+		utils.Logger.Warn("Webhook delivery " + strconv.Itoa(delivery.ID) + " to " + delivery.URL + " failed: " + err.Error())
+		return
+	}
+	if d.closed {
+		return
+	}
+	wait := d.opts.Backoff << (attempt - 1)
+	next := time.Now().Add(wait)
+	delivery.NextAttempt = &next
+	d.retries[delivery.ID] = time.AfterFunc(wait, func() {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		// a timer that fired as Close stopped the others is dropped the same way
+		if d.closed {
+			return
+		}
+		delete(d.retries, delivery.ID)
+		d.start(delivery)
+	})
+}
+
+// post sends the change of delivery to its URL and returns the status it was answered with
+func (d *Dispatcher) post(delivery *Delivery) (int, error) {
+	body, err := json.Marshal(delivery.change)
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequest(http.MethodPost, delivery.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhook.TimestampHeader, timestamp)
+	req.Header.Set(webhook.SignatureHeader, webhook.Sign(delivery.secret, timestamp, body))
+	req.Header.Set(webhook.DeliveryHeader, strconv.Itoa(delivery.ID))
+	req.Header.Set(webhook.EventHeader, delivery.Event)
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("callback answered %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// Subscribe adds a subscription and returns it with its ID, Validate must have accepted it
+func (d *Dispatcher) Subscribe(sub Subscription) Subscription {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	sub.ID = d.nextSubscription
+	d.nextSubscription++
+	d.subscriptions = append(d.subscriptions, sub)
+	return sub
+}
+
+// Unsubscribe removes a subscription, deliveries already started are still sent
+func (d *Dispatcher) Unsubscribe(id int) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for i, sub := range d.subscriptions {
+		if sub.ID == id {
+			d.subscriptions = append(d.subscriptions[:i], d.subscriptions[i+1:]...)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+// Subscriptions returns the subscriptions ordered by ID, without their secrets
+func (d *Dispatcher) Subscriptions() []Subscription {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	subs := make([]Subscription, 0, len(d.subscriptions))
+	for _, sub := range d.subscriptions {
+		sub.Secret = ""
+		subs = append(subs, sub)
+	}
+	return subs
+}
+
+// Deliveries returns the logged deliveries matching include, newest first
+func (d *Dispatcher) Deliveries(include func(Delivery) bool) []Delivery {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	deliveries := []Delivery{}
+	for i := len(d.deliveries) - 1; i >= 0; i-- {
+		if delivery := *d.deliveries[i]; include(delivery) {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+	return deliveries
+}
+
+// Delivery returns the logged delivery with the given ID
+func (d *Dispatcher) Delivery(id int) (Delivery, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if delivery := d.find(id); delivery != nil {
+		return *delivery, nil
+	}
+	return Delivery{}, ErrNotFound
+}
+
+// find returns the logged delivery with the given ID, the caller must hold mu
+func (d *Dispatcher) find(id int) *Delivery {
+	for _, delivery := range d.deliveries {
+		if delivery.ID == id {
+			return delivery
+		}
+	}
+	return nil
+}
+
+// Redeliver sends the change of a logged delivery again as a new delivery to the same
+// URL, whatever became of the first one, and returns the new delivery
+func (d *Dispatcher) Redeliver(id int) (Delivery, error) {
+	d.mu.Lock()
+	original := d.find(id)
+	if original == nil {
+		d.mu.Unlock()
+		return Delivery{}, ErrNotFound
+	}
+	delivery := d.add(&Delivery{
+		Subscription: original.Subscription,
+		Redelivers:   original.ID,
+		URL:          original.URL,
+		change:       original.change,
+		secret:       original.secret,
+	})
+	copied := *delivery
+	d.mu.Unlock()
+
+	d.start(delivery)
+	return copied, nil
+}
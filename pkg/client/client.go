@@ -0,0 +1,616 @@
+// Package client is the Go client for the job-queue REST API.
+//
+//	c := client.New("http://localhost:8080", client.WithTimeout(5*time.Second))
+//	id, err := c.Enqueue(ctx, jobqueue.Job{Type: jobqueue.TypeTimeCritical, Status: jobqueue.StatusQueued})
+//	job, err := c.Dequeue(ctx, consumerID, 10*time.Second)
+//	err = c.Conclude(ctx, job.ID)
+//
+// Error bodies returned by the server are turned into *APIError values, which
+// carry the code and details of the error and match the jobqueue.Err* values with errors.Is.
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/varungujarathi9/job-queue/internal/apierror"
+	"github.com/varungujarathi9/job-queue/pkg/jobqueue"
+	"github.com/varungujarathi9/job-queue/pkg/webhook"
+)
+
+const (
+	consumerHeader = "QUEUE_CONSUMER"
+	tenantHeader   = "QUEUE_TENANT"
+
+	defaultTimeout = 30 * time.Second
+	defaultBackoff = 200 * time.Millisecond
+)
+
+// Client talks to one job-queue server, it is safe for concurrent use
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	timeout    time.Duration
+	retries    int
+	backoff    time.Duration
+	token      string
+	tenant     string
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithHTTPClient sets the HTTP client used for requests, http.DefaultClient is used by default
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithTimeout bounds every request attempt, a dequeue gets its wait on top of it
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		c.timeout = timeout
+	}
+}
+
+// WithRetries retries requests that failed with a network error or a 5xx status up to
+// retries times, doubling the backoff between attempts. Enqueue and dequeue are never
+// retried because the server may have acted on a request whose response was lost.
+func WithRetries(retries int, backoff time.Duration) Option {
+	return func(c *Client) {
+		c.retries = retries
+		c.backoff = backoff
+	}
+}
+
+// WithToken authenticates every request with an API key or signed token
+func WithToken(token string) Option {
+	return func(c *Client) {
+		c.token = token
+	}
+}
+
+// WithTenant confines every request to the jobs of tenant
+func WithTenant(tenant string) Option {
+	return func(c *Client) {
+		c.tenant = tenant
+	}
+}
+
+// New creates a client for the server at baseURL, e.g. "http://localhost:8080"
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		httpClient: http.DefaultClient,
+		timeout:    defaultTimeout,
+		backoff:    defaultBackoff,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// APIError is returned when the server answers with an error status
+type APIError struct {
+	StatusCode int
+	// Code is the machine-readable code of the error, it is empty for servers older than the error envelope
+	Code    string
+	Message string
+	Details map[string]interface{}
+}
+
+func (e *APIError) Error() string {
+	return "job-queue: " + strconv.Itoa(e.StatusCode) + " " + e.Message
+}
+
+// apiErrors maps the codes of the server to the engine errors they report
+var apiErrors = map[string]error{
+	apierror.CodeMissingFields:    jobqueue.ErrMissingFields,
+	apierror.CodeInvalidType:      jobqueue.ErrInvalidType,
+	apierror.CodeNotFound:         jobqueue.ErrNotFound,
+	apierror.CodeJobNotDequeued:   jobqueue.ErrNotDequeued,
+	apierror.CodeJobConcluded:     jobqueue.ErrConcluded,
+	apierror.CodeJobQueued:        jobqueue.ErrQueued,
+	apierror.CodeJobNotInProgress: jobqueue.ErrNotInProgress,
+	apierror.CodeJobNotOwner:      jobqueue.ErrNotOwner,
+	apierror.CodeJobCancelled:     jobqueue.ErrCancelled,
+	apierror.CodeJobNotDone:       jobqueue.ErrNotDone,
+	apierror.CodeQueueFull:        jobqueue.ErrQueueFull,
+	apierror.CodeRateLimited:      jobqueue.ErrRateLimited,
+	apierror.CodePayloadTooLarge:  jobqueue.ErrPayloadTooLarge,
+	apierror.CodeDraining:         jobqueue.ErrDraining,
+}
+
+// legacyErrors maps the messages of servers older than the error envelope to the engine errors they report
+var legacyErrors = map[string]error{
+	"Missing required fields":                jobqueue.ErrMissingFields,
+	"Invalid Type value":                     jobqueue.ErrInvalidType,
+	"No job available":                       jobqueue.ErrNoJob,
+	"Job not found":                          jobqueue.ErrNotFound,
+	"Dequeue job first in order to conclude": jobqueue.ErrNotDequeued,
+	"Job already concluded":                  jobqueue.ErrConcluded,
+	"Job already queued":                     jobqueue.ErrQueued,
+	"Job not in progress":                    jobqueue.ErrNotInProgress,
+	"Job consumed by another consumer":       jobqueue.ErrNotOwner,
+	"Queue is full":                          jobqueue.ErrQueueFull,
+	"Enqueue rate limit exceeded":            jobqueue.ErrRateLimited,
+	"Payload too large":                      jobqueue.ErrPayloadTooLarge,
+	"Queue is draining":                      jobqueue.ErrDraining,
+	"Job not done yet":                       jobqueue.ErrNotDone,
+}
+
+// Unwrap returns the engine error the server reported, if any
+func (e *APIError) Unwrap() error {
+	if e.Code != "" {
+		return apiErrors[e.Code]
+	}
+	if strings.HasPrefix(e.Message, "Job already cancelled") {
+		return jobqueue.ErrCancelled
+	}
+	return legacyErrors[e.Message]
+}
+
+// newAPIError reads the error body of a response
+func newAPIError(resp *http.Response) error {
+	body, _ := io.ReadAll(resp.Body)
+	text := strings.TrimSpace(string(body))
+
+	var envelope apierror.Error
+	if err := json.Unmarshal([]byte(text), &envelope); err == nil && envelope.Code != "" {
+		return &APIError{StatusCode: resp.StatusCode, Code: envelope.Code, Message: envelope.Message, Details: envelope.Details}
+	}
+
+	// older servers answer with {"status" : "<message>"}
+	var status struct {
+		Status string `json:"status"`
+	}
+	if err := json.Unmarshal([]byte(text), &status); err == nil && status.Status != "" {
+		text = status.Status
+	} else {
+		// messages holding quotes are not valid JSON, so strip the envelope by hand
+		text = strings.TrimSuffix(strings.TrimPrefix(text, `{"status" : "`), `"}`)
+	}
+	return &APIError{StatusCode: resp.StatusCode, Message: text}
+}
+
+// request describes one API call
+type request struct {
+	method   string
+	path     string
+	query    url.Values
+	header   http.Header
+	body     interface{}
+	wait     time.Duration
+	retrying bool
+	// noContent is returned when the server answers with 204 No Content
+	noContent error
+}
+
+// do sends the request, retrying it when allowed, and decodes a successful response into out
+func (c *Client) do(ctx context.Context, req request, out interface{}) error {
+	var body []byte
+	if req.body != nil {
+		var err error
+		if body, err = json.Marshal(req.body); err != nil {
+			return err
+		}
+	}
+
+	backoff := c.backoff
+	for attempt := 0; ; attempt++ {
+		err := c.attempt(ctx, req, body, out)
+		if err == nil || !req.retrying || attempt >= c.retries || !retryable(err) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// attempt sends the request once
+func (c *Client) attempt(ctx context.Context, req request, body []byte, out interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout+req.wait)
+	defer cancel()
+
+	httpReq, err := c.newRequest(ctx, req, body)
+	if err != nil {
+		return err
+	}
+	resp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return newAPIError(resp)
+	}
+	if resp.StatusCode == http.StatusNoContent && req.noContent != nil {
+		return req.noContent
+	}
+	if out == nil {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// newRequest builds the HTTP request of req with the headers every request carries
+func (c *Client) newRequest(ctx context.Context, req request, body []byte) (*http.Request, error) {
+	target := c.baseURL + req.path
+	if len(req.query) > 0 {
+		target += "?" + req.query.Encode()
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, req.method, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range req.header {
+		httpReq.Header[key] = values
+	}
+	if c.token != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	if c.tenant != "" {
+		httpReq.Header.Set(tenantHeader, c.tenant)
+	}
+	if body != nil {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+	return httpReq, nil
+}
+
+// retryable reports whether a failed attempt may succeed when repeated
+func retryable(err error) bool {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode >= 500
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+func consumerHeaders(consumer int) http.Header {
+	return http.Header{consumerHeader: {strconv.Itoa(consumer)}}
+}
+
+func jobPath(id int, action string) string {
+	path := "/jobs/" + strconv.Itoa(id)
+	if action != "" {
+		path += "/" + action
+	}
+	return path
+}
+
+// Enqueue adds a job to the queue and returns the ID the server gave it
+func (c *Client) Enqueue(ctx context.Context, job jobqueue.Job) (int, error) {
+	var resp struct {
+		ID int `json:"id"`
+	}
+	err := c.do(ctx, request{method: http.MethodPost, path: "/jobs/enqueue", body: job}, &resp)
+	return resp.ID, err
+}
+
+// Dequeue takes the next job from the queue for consumer, only considering the given types
+// when there are any. When the queue is empty the server holds the request for up to wait,
+// a zero wait returns jobqueue.ErrNoJob at once.
+func (c *Client) Dequeue(ctx context.Context, consumer int, wait time.Duration, types ...string) (jobqueue.Job, error) {
+	req := request{
+		method: http.MethodGet,
+		path:   "/jobs/dequeue",
+		query:  url.Values{},
+		header: consumerHeaders(consumer),
+		wait:   wait,
+		// an empty queue is answered with 204
+		noContent: jobqueue.ErrNoJob,
+	}
+	if wait > 0 {
+		req.query.Set("wait", wait.String())
+	}
+	for _, jobType := range types {
+		req.query.Add("type", jobType)
+	}
+	var job jobqueue.Job
+	err := c.do(ctx, req, &job)
+	return job, err
+}
+
+// Conclude marks a dequeued job as concluded
+func (c *Client) Conclude(ctx context.Context, id int) error {
+	return c.ConcludeWithResult(ctx, id, nil)
+}
+
+// ConcludeWithResult marks a dequeued job as concluded and stores its result
+func (c *Client) ConcludeWithResult(ctx context.Context, id int, result interface{}) error {
+	return c.ConcludeAs(ctx, id, 0, result)
+}
+
+// ConcludeAs is like ConcludeWithResult but only concludes the job while consumer holds it.
+// A zero consumer concludes the job whoever dequeued it, when the client's identity allows that.
+func (c *Client) ConcludeAs(ctx context.Context, id, consumer int, result interface{}) error {
+	req := request{method: http.MethodPut, path: jobPath(id, "conclude"), retrying: true}
+	if consumer != 0 {
+		req.header = consumerHeaders(consumer)
+	}
+	if result != nil {
+		req.body = map[string]interface{}{"Result": result}
+	}
+	return c.do(ctx, req, nil)
+}
+
+// Heartbeat extends the lease consumer holds on a job, it fails with jobqueue.ErrCancelled once the job is cancelled
+func (c *Client) Heartbeat(ctx context.Context, id, consumer int) error {
+	return c.do(ctx, request{method: http.MethodPut, path: jobPath(id, "heartbeat"), header: consumerHeaders(consumer), retrying: true}, nil)
+}
+
+// Fail marks a job consumer holds as failed with the given reason
+func (c *Client) Fail(ctx context.Context, id, consumer int, reason string) error {
+	req := request{
+		method:   http.MethodPut,
+		path:     jobPath(id, "fail"),
+		header:   consumerHeaders(consumer),
+		body:     map[string]string{"Error": reason},
+		retrying: true,
+	}
+	return c.do(ctx, req, nil)
+}
+
+// Cancel flags a job as cancelled
+func (c *Client) Cancel(ctx context.Context, id int) error {
+	return c.do(ctx, request{method: http.MethodDelete, path: jobPath(id, "cancel"), retrying: true}, nil)
+}
+
+// Retry puts a job that left the queue back into it
+func (c *Client) Retry(ctx context.Context, id int) error {
+	return c.do(ctx, request{method: http.MethodPut, path: jobPath(id, "retry"), retrying: true}, nil)
+}
+
+// Redrive enqueues a copy of a job that is done and returns the ID of the copy
+func (c *Client) Redrive(ctx context.Context, id int) (int, error) {
+	var resp struct {
+		ID int `json:"id"`
+	}
+	err := c.do(ctx, request{method: http.MethodPost, path: jobPath(id, "redrive")}, &resp)
+	return resp.ID, err
+}
+
+// History returns the changes recorded for a job, oldest first
+func (c *Client) History(ctx context.Context, id int) ([]jobqueue.Change, error) {
+	var history []jobqueue.Change
+	err := c.do(ctx, request{method: http.MethodGet, path: jobPath(id, "history"), retrying: true}, &history)
+	return history, err
+}
+
+// Job returns the job with the given ID
+func (c *Client) Job(ctx context.Context, id int) (jobqueue.Job, error) {
+	var job jobqueue.Job
+	err := c.do(ctx, request{method: http.MethodGet, path: jobPath(id, ""), retrying: true}, &job)
+	return job, err
+}
+
+// Result returns the job with the given ID once it is concluded, failed or cancelled, waiting
+// up to wait for that. It fails with jobqueue.ErrNotDone when the job is still running by then.
+func (c *Client) Result(ctx context.Context, id int, wait time.Duration) (jobqueue.Job, error) {
+	req := request{
+		method:   http.MethodGet,
+		path:     jobPath(id, "result"),
+		query:    url.Values{},
+		wait:     wait,
+		retrying: true,
+		// a job that is not done by the end of the wait is answered with 204
+		noContent: jobqueue.ErrNotDone,
+	}
+	if wait > 0 {
+		req.query.Set("wait", wait.String())
+	}
+	var job jobqueue.Job
+	err := c.do(ctx, req, &job)
+	return job, err
+}
+
+// List returns the jobs ordered by ID, an empty status or jobType matches every job
+func (c *Client) List(ctx context.Context, status, jobType string) ([]jobqueue.Job, error) {
+	query := url.Values{}
+	if status != "" {
+		query.Set("status", status)
+	}
+	if jobType != "" {
+		query.Set("type", jobType)
+	}
+	var jobs []jobqueue.Job
+	err := c.do(ctx, request{method: http.MethodGet, path: "/jobs", query: query, retrying: true}, &jobs)
+	return jobs, err
+}
+
+// Stats counts the jobs by status and type
+func (c *Client) Stats(ctx context.Context) (jobqueue.Stats, error) {
+	var stats jobqueue.Stats
+	err := c.do(ctx, request{method: http.MethodGet, path: "/jobs/stats", retrying: true}, &stats)
+	return stats, err
+}
+
+// Changes returns up to limit changes recorded after since, along with the newest sequence number
+func (c *Client) Changes(ctx context.Context, since, limit int) (int, []jobqueue.Change, error) {
+	query := url.Values{"since": {strconv.Itoa(since)}, "limit": {strconv.Itoa(limit)}}
+	var feed struct {
+		Seq     int               `json:"Seq"`
+		Changes []jobqueue.Change `json:"Changes"`
+	}
+	err := c.do(ctx, request{method: http.MethodGet, path: "/jobs/changes", query: query, retrying: true}, &feed)
+	return feed.Seq, feed.Changes, err
+}
+
+// EventFilter narrows down the changes Events streams, its zero value streams every change
+type EventFilter struct {
+	// Queues are queue names, default for the jobs enqueued without one
+	Queues []string
+	Types  []string
+	JobIDs []int
+	// Ops are the operations of the changes, e.g. jobqueue.OpConclude
+	Ops []string
+}
+
+func (f EventFilter) query() url.Values {
+	query := url.Values{"queue": f.Queues, "type": f.Types, "op": f.Ops}
+	for _, id := range f.JobIDs {
+		query.Add("job_id", strconv.Itoa(id))
+	}
+	return query
+}
+
+// Events follows the server's event stream and calls fn with every change matching filter
+// that was recorded after since, or from now on when since is negative. A stream that drops
+// is opened again after the last change seen, so no change is missed or repeated. It returns
+// when ctx is done, fn returns an error or the server refuses the stream.
+func (c *Client) Events(ctx context.Context, since int, filter EventFilter, fn func(jobqueue.Change) error) error {
+	query := filter.query()
+	if since >= 0 {
+		query.Set("since", strconv.Itoa(since))
+	}
+	lastID := ""
+	streamed := false
+	backoff := c.backoff
+	for {
+		opened, err := c.stream(ctx, query, &lastID, fn)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		var stop stopError
+		var apiErr *APIError
+		switch {
+		case errors.As(err, &stop):
+			return stop.err
+		case errors.As(err, &apiErr) && apiErr.StatusCode < 500:
+			return err
+		case !opened && !streamed:
+			// a server that cannot be reached at first is likely misconfigured
+			return err
+		}
+		if opened {
+			streamed = true
+			backoff = c.backoff
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// stopError ends Events with the error it wraps instead of opening the stream again
+type stopError struct {
+	err error
+}
+
+func (e stopError) Error() string {
+	return e.err.Error()
+}
+
+// stream reads one connection of the event stream, resuming after lastID and keeping it
+// up to date. It reports whether the server accepted the stream.
+func (c *Client) stream(ctx context.Context, query url.Values, lastID *string, fn func(jobqueue.Change) error) (bool, error) {
+	header := http.Header{"Accept": {"text/event-stream"}}
+	if *lastID != "" {
+		header.Set("Last-Event-ID", *lastID)
+	}
+	httpReq, err := c.newRequest(ctx, request{method: http.MethodGet, path: "/events", query: query, header: header}, nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, newAPIError(resp)
+	}
+
+	reader := bufio.NewReader(resp.Body)
+	var id, event, data string
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return true, err
+		}
+		field, value, _ := strings.Cut(strings.TrimRight(line, "\r\n"), ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "id":
+			id = value
+		case "event":
+			event = value
+		case "data":
+			data = value
+		case "":
+			// a blank line ends an event, a line starting with a colon is a comment
+			if line != "\n" && line != "\r\n" {
+				continue
+			}
+			if id != "" {
+				*lastID = id
+			}
+			if data != "" && (event == "" || event == "change") {
+				var change jobqueue.Change
+				if err := json.Unmarshal([]byte(data), &change); err != nil {
+					return true, stopError{err}
+				}
+				if err := fn(change); err != nil {
+					return true, stopError{err}
+				}
+			}
+			id, event, data = "", "", ""
+		}
+	}
+}
+
+// webhookTolerance is how far from now the timestamp of a webhook delivery may be
+const webhookTolerance = 5 * time.Minute
+
+// VerifyWebhook checks that a webhook delivery received by a callback URL was signed with
+// secret less than five minutes ago and returns the change it carries. Deliveries may
+// arrive more than once and out of order, the Seq of the change tells them apart.
+func VerifyWebhook(r *http.Request, secret string) (jobqueue.Change, error) {
+	var change jobqueue.Change
+	err := verify(r, secret, &change)
+	return change, err
+}
+
+// VerifyPush checks that a job pushed to an HTTP endpoint was signed with secret less than
+// five minutes ago and returns the job. A job is pushed again when an attempt at it fails.
+func VerifyPush(r *http.Request, secret string) (jobqueue.Job, error) {
+	var job jobqueue.Job
+	err := verify(r, secret, &job)
+	return job, err
+}
+
+// verify checks the signature of a request sent by the server and decodes its body into out
+func verify(r *http.Request, secret string, out interface{}) error {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	err = webhook.Verify(secret, r.Header.Get(webhook.TimestampHeader), r.Header.Get(webhook.SignatureHeader), body, time.Now(), webhookTolerance)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(body, out)
+}
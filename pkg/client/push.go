@@ -0,0 +1,202 @@
+package client
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/websocket"
+	"github.com/varungujarathi9/job-queue/internal/apierror"
+	"github.com/varungujarathi9/job-queue/pkg/jobqueue"
+)
+
+// operations of the push frames
+const (
+	pushJob       = "JOB"
+	pushAck       = "ACK"
+	pushFail      = "FAIL"
+	pushHeartbeat = "HEARTBEAT"
+)
+
+// pushFrame is one message of a push connection
+type pushFrame struct {
+	Op     string          `json:"Op"`
+	JobID  int             `json:"JobID,omitempty"`
+	Job    *jobqueue.Job   `json:"Job,omitempty"`
+	Result interface{}     `json:"Result,omitempty"`
+	Reason string          `json:"Reason,omitempty"`
+	Status int             `json:"Status,omitempty"`
+	Error  *apierror.Error `json:"Error,omitempty"`
+}
+
+// PushStream is the push connection of one consumer, see Client.Push. It is safe for
+// concurrent use.
+type PushStream struct {
+	conn       *websocket.Conn
+	jobs       chan jobqueue.Job
+	writeMutex sync.Mutex
+
+	mutex sync.Mutex
+	// answers holds the callers waiting for the answer to a frame, by operation and job ID
+	answers map[string][]chan error
+	done    chan struct{}
+	err     error
+}
+
+// Push opens a WebSocket over which the server pushes the jobs of the given types, or of
+// every type when there are none, to consumer as they arrive. The consumer holds up to
+// prefetch jobs at once, acknowledging or failing one of them lets the server push the next.
+func (c *Client) Push(ctx context.Context, consumer, prefetch int, types ...string) (*PushStream, error) {
+	query := url.Values{"prefetch": {strconv.Itoa(prefetch)}}
+	for _, jobType := range types {
+		query.Add("type", jobType)
+	}
+	// http:// becomes ws:// and https:// wss://
+	target := "ws" + strings.TrimPrefix(c.baseURL, "http") + "/jobs/push?" + query.Encode()
+	header := consumerHeaders(consumer)
+	if c.token != "" {
+		header.Set("Authorization", "Bearer "+c.token)
+	}
+	if c.tenant != "" {
+		header.Set(tenantHeader, c.tenant)
+	}
+	dialer := websocket.Dialer{Proxy: http.ProxyFromEnvironment, HandshakeTimeout: c.timeout}
+	if transport, ok := c.httpClient.Transport.(*http.Transport); ok {
+		dialer.TLSClientConfig = transport.TLSClientConfig
+	}
+
+	conn, resp, err := dialer.DialContext(ctx, target, header)
+	if err != nil {
+		if resp != nil && resp.StatusCode >= 300 {
+			return nil, newAPIError(resp)
+		}
+		return nil, err
+	}
+	p := &PushStream{
+		conn:    conn,
+		jobs:    make(chan jobqueue.Job, prefetch),
+		answers: map[string][]chan error{},
+		done:    make(chan struct{}),
+	}
+	go p.read()
+	return p, nil
+}
+
+// Next returns the next job pushed to the consumer, waiting for one until ctx is done
+func (p *PushStream) Next(ctx context.Context) (jobqueue.Job, error) {
+	select {
+	case job := <-p.jobs:
+		return job, nil
+	case <-p.done:
+		return jobqueue.Job{}, p.Err()
+	case <-ctx.Done():
+		return jobqueue.Job{}, ctx.Err()
+	}
+}
+
+// Ack concludes a pushed job and stores its result
+func (p *PushStream) Ack(ctx context.Context, id int, result interface{}) error {
+	return p.call(ctx, pushFrame{Op: pushAck, JobID: id, Result: result})
+}
+
+// Fail marks a pushed job as failed
+func (p *PushStream) Fail(ctx context.Context, id int, reason string) error {
+	return p.call(ctx, pushFrame{Op: pushFail, JobID: id, Reason: reason})
+}
+
+// Heartbeat extends the lease on a pushed job, it fails with jobqueue.ErrCancelled once the job is cancelled
+func (p *PushStream) Heartbeat(ctx context.Context, id int) error {
+	return p.call(ctx, pushFrame{Op: pushHeartbeat, JobID: id})
+}
+
+// Err returns why the connection ended, nil while it is open
+func (p *PushStream) Err() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.err
+}
+
+// Close closes the connection, the jobs the consumer holds go back into the queue once
+// their lease expires
+func (p *PushStream) Close() error {
+	p.end(net.ErrClosed)
+	p.writeMutex.Lock()
+	p.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	p.writeMutex.Unlock()
+	return p.conn.Close()
+}
+
+// call sends a frame and waits for its answer
+func (p *PushStream) call(ctx context.Context, frame pushFrame) error {
+	key := frame.Op + " " + strconv.Itoa(frame.JobID)
+	answer := make(chan error, 1)
+	p.mutex.Lock()
+	if p.err != nil {
+		p.mutex.Unlock()
+		return p.err
+	}
+	p.answers[key] = append(p.answers[key], answer)
+	p.mutex.Unlock()
+
+	p.writeMutex.Lock()
+	err := p.conn.WriteJSON(frame)
+	p.writeMutex.Unlock()
+	if err != nil {
+		p.end(err)
+		return err
+	}
+	select {
+	case err := <-answer:
+		return err
+	case <-p.done:
+		return p.Err()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// read hands the pushed jobs to Next and the answers to their callers until the
+// connection ends
+func (p *PushStream) read() {
+	for {
+		var frame pushFrame
+		if err := p.conn.ReadJSON(&frame); err != nil {
+			p.end(err)
+			return
+		}
+		if frame.Op == pushJob && frame.Job != nil {
+			// the server pushes no more jobs than fit in the channel
+			p.jobs <- *frame.Job
+			continue
+		}
+
+		var err error
+		if frame.Error != nil {
+			err = &APIError{StatusCode: frame.Status, Code: frame.Error.Code, Message: frame.Error.Message, Details: frame.Error.Details}
+		}
+		key := frame.Op + " " + strconv.Itoa(frame.JobID)
+		p.mutex.Lock()
+		if waiting := p.answers[key]; len(waiting) > 0 {
+			waiting[0] <- err
+			p.answers[key] = waiting[1:]
+			if len(p.answers[key]) == 0 {
+				delete(p.answers, key)
+			}
+		}
+		p.mutex.Unlock()
+	}
+}
+
+// end records why the connection ended, the first reason wins
+func (p *PushStream) end(err error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.err == nil {
+		p.err = err
+		close(p.done)
+	}
+}
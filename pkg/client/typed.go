@@ -0,0 +1,49 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/varungujarathi9/job-queue/pkg/jobqueue"
+)
+
+// EnqueuePayload enqueues a job of the given type carrying payload
+func EnqueuePayload[T any](ctx context.Context, c *Client, jobType string, payload T) (int, error) {
+	return c.Enqueue(ctx, jobqueue.Job{Type: jobType, Status: jobqueue.StatusQueued, Payload: payload})
+}
+
+// DequeuePayload dequeues a job like Client.Dequeue and decodes its payload into a T
+func DequeuePayload[T any](ctx context.Context, c *Client, consumer int, wait time.Duration) (jobqueue.Job, T, error) {
+	job, err := c.Dequeue(ctx, consumer, wait)
+	if err != nil {
+		var zero T
+		return job, zero, err
+	}
+	payload, err := Payload[T](job)
+	return job, payload, err
+}
+
+// Payload decodes the payload of a job into a T
+func Payload[T any](job jobqueue.Job) (T, error) {
+	return convert[T](job.Payload)
+}
+
+// Result decodes the result of a job into a T
+func Result[T any](job jobqueue.Job) (T, error) {
+	return convert[T](job.Result)
+}
+
+// convert turns a value decoded from JSON into a T by encoding it again
+func convert[T any](value interface{}) (T, error) {
+	var typed T
+	if value == nil {
+		return typed, nil
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return typed, err
+	}
+	err = json.Unmarshal(data, &typed)
+	return typed, err
+}
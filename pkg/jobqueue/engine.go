@@ -0,0 +1,1021 @@
+// Package jobqueue is an in-process job queue engine. It is the engine behind the
+// job-queue REST API and can be embedded in any Go program.
+//
+//	engine := jobqueue.New()
+//	id, err := engine.Enqueue(jobqueue.Job{Type: jobqueue.TypeTimeCritical, Status: jobqueue.StatusQueued})
+//	job, err := engine.Dequeue(ctx, consumerID)
+//	err = engine.Conclude(job.ID)
+package jobqueue
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	defaultEnqueueTimeout = 60 * time.Second
+	defaultDequeueTimeout = 30 * time.Second
+	defaultMaxChanges     = 100000
+)
+
+// Engine holds the state of one job queue, all of its methods are safe for concurrent use
+type Engine struct {
+	mutex          sync.Mutex
+	queue          fairQueue
+	firstID        int
+	nextID         int
+	idStride       int
+	jobStore       map[int]*Job
+	leased         map[int]*Job
+	depth          int
+	tenantDepths   map[string]int
+	changes        []Change
+	epoch          string
+	maxChanges     int
+	compactAt      int
+	enqueueTimeout time.Duration
+	dequeueTimeout time.Duration
+	maxDepth       int
+	quotas         map[string]Quota
+	defaultQuota   Quota
+	buckets        map[string]*bucket
+	draining       bool
+
+	// available is closed and replaced whenever a job is added to the queue, waking blocked dequeues
+	available chan struct{}
+	// changed is closed and replaced whenever a change is recorded, waking WaitChanges
+	changed chan struct{}
+}
+
+// Option configures an Engine
+type Option func(*Engine)
+
+// WithEnqueueTimeout sets how long a job may wait in the queue before it expires
+func WithEnqueueTimeout(timeout time.Duration) Option {
+	return func(e *Engine) {
+		e.enqueueTimeout = timeout
+	}
+}
+
+// WithDequeueTimeout sets how long a dequeued job may stay in progress without a heartbeat
+// before it goes back into the queue
+func WithDequeueTimeout(timeout time.Duration) Option {
+	return func(e *Engine) {
+		e.dequeueTimeout = timeout
+	}
+}
+
+// WithMaxDepth limits how many jobs may wait in the queue, enqueue fails with ErrQueueFull
+// once the limit is reached. Zero, the default, leaves the queue unbounded.
+func WithMaxDepth(depth int) Option {
+	return func(e *Engine) {
+		e.maxDepth = depth
+	}
+}
+
+// WithIDSpace makes the engine hand out the job IDs first, first+stride, first+2*stride and so on,
+// so that nodes of a cluster never hand out the same ID
+func WithIDSpace(first, stride int) Option {
+	return func(e *Engine) {
+		e.firstID = first
+		e.nextID = first
+		e.idStride = stride
+	}
+}
+
+// WithMaxChanges sets how many changes the change stream keeps in full. Older changes are
+// compacted to the newest change of each job, so replaying the stream still rebuilds every
+// job but their history only goes back that far. Zero keeps every change.
+func WithMaxChanges(changes int) Option {
+	return func(e *Engine) {
+		e.maxChanges = changes
+	}
+}
+
+// New creates an empty engine
+func New(opts ...Option) *Engine {
+	e := &Engine{
+		firstID:        1,
+		nextID:         1,
+		idStride:       1,
+		epoch:          newEpoch(),
+		maxChanges:     defaultMaxChanges,
+		jobStore:       make(map[int]*Job),
+		leased:         make(map[int]*Job),
+		tenantDepths:   make(map[string]int),
+		quotas:         make(map[string]Quota),
+		buckets:        make(map[string]*bucket),
+		enqueueTimeout: defaultEnqueueTimeout,
+		dequeueTimeout: defaultDequeueTimeout,
+		available:      make(chan struct{}),
+		changed:        make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+	return e
+}
+
+// newEpoch returns a random ID for the change stream of a new engine
+func newEpoch() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// push adds job to the queue and wakes the blocked dequeues, the caller must hold mutex
+func (e *Engine) push(job *Job) {
+	e.queue.insert(job)
+	e.count(job, 1)
+	close(e.available)
+	e.available = make(chan struct{})
+}
+
+// count adds delta to the depth of the queue and to that of job's tenant, the caller must hold mutex
+func (e *Engine) count(job *Job, delta int) {
+	e.depth += delta
+	e.tenantDepths[job.Tenant] += delta
+	if e.tenantDepths[job.Tenant] == 0 {
+		delete(e.tenantDepths, job.Tenant)
+	}
+}
+
+// recordChange appends a snapshot of job to the change stream, the caller must hold mutex
+func (e *Engine) recordChange(op string, job *Job) {
+	e.appendChange(Change{
+		Seq:  e.lastSeq() + 1,
+		Time: time.Now(),
+		Op:   op,
+		Job:  *job,
+	})
+}
+
+// appendChange adds change to the change stream and wakes WaitChanges, the caller must hold mutex
+func (e *Engine) appendChange(change Change) {
+	e.changes = append(e.changes, change)
+	if e.maxChanges > 0 && len(e.changes) >= 2*e.maxChanges && len(e.changes) >= e.compactAt {
+		e.compact()
+	}
+	close(e.changed)
+	e.changed = make(chan struct{})
+}
+
+// compact drops the changes older than the newest maxChanges that a newer change of the
+// same job supersedes, the caller must hold mutex
+func (e *Engine) compact() {
+	old := len(e.changes) - e.maxChanges
+	newest := make(map[int]int, old)
+	for i, change := range e.changes {
+		newest[change.Job.ID] = i
+	}
+	kept := make([]Change, 0, len(e.changes))
+	for i, change := range e.changes {
+		if i >= old || newest[change.Job.ID] == i {
+			kept = append(kept, change)
+		}
+	}
+	e.changes = kept
+	// a stream made mostly of jobs that changed once is compacted again only once it doubled
+	e.compactAt = 2 * len(kept)
+}
+
+// lastSeq returns the sequence number of the newest change, the caller must hold mutex
+func (e *Engine) lastSeq() int {
+	if len(e.changes) == 0 {
+		return 0
+	}
+	return e.changes[len(e.changes)-1].Seq
+}
+
+// Enqueue validates job against the engine's limits and the quota of its tenant, adds it
+// to the queue and returns the ID it was given
+func (e *Engine) Enqueue(job Job) (int, error) {
+	if job.Type == "" || job.Status == "" {
+		return 0, ErrMissingFields
+	}
+	if job.Type != TypeTimeCritical && job.Type != TypeNotTimeCritical {
+		return 0, ErrInvalidType
+	}
+	if limit := e.Quota(job.Tenant).MaxPayloadBytes; limit > 0 && payloadSize(&job) > limit {
+		return 0, ErrPayloadTooLarge
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if e.draining {
+		return 0, ErrDraining
+	}
+	e.expireQueued()
+	if e.maxDepth > 0 && e.depth >= e.maxDepth {
+		return 0, ErrQueueFull
+	}
+	now := time.Now()
+	if err := e.admit(&job, now); err != nil {
+		return 0, err
+	}
+	job.ID = e.nextID
+	e.nextID += e.idStride
+	job.EnqueueTime = now
+	job.Status = StatusQueued
+	e.jobStore[job.ID] = &job
+	e.push(&job)
+	e.recordChange(OpEnqueue, &job)
+	return job.ID, nil
+}
+
+// drop returns the function the queue uses to unlink cancelled jobs and the jobs that waited
+// longer than the enqueue timeout, which it expires on the way. The caller must hold mutex.
+func (e *Engine) drop(now time.Time) func(*Job) bool {
+	return func(job *Job) bool {
+		if job.Cancel {
+			return true
+		}
+		if now.Sub(job.EnqueueTime) <= e.enqueueTimeout {
+			return false
+		}
+		e.expire(job)
+		return true
+	}
+}
+
+// expire ends a job that waited in the queue longer than the enqueue timeout, the caller
+// must hold mutex and unlink the job from the queue
+func (e *Engine) expire(job *Job) {
+	job.Status = StatusExpired
+	e.count(job, -1)
+	e.recordChange(OpExpire, job)
+}
+
+// expireQueued expires the jobs that waited in the queue longer than the enqueue timeout,
+// the caller must hold mutex. Jobs join the queue in the order they are enqueued, so only
+// the front of each tenant's list is looked at, dequeue expires the others it walks past.
+func (e *Engine) expireQueued() {
+	e.queue.prune(e.drop(time.Now()))
+}
+
+// Filter narrows down the jobs a dequeue considers, its zero value matches every job
+type Filter struct {
+	// Tenant only matches the jobs of one tenant when it is not nil
+	Tenant *string
+	// Queue only matches the jobs of one named queue when it is not nil, an empty name
+	// matches the jobs enqueued without a queue
+	Queue *string
+	// Types only matches the jobs of one of these types when there are any
+	Types []string
+}
+
+// matches reports whether job passes the queue and type parts of the filter, the tenant
+// part is left to the fair queue
+func (f Filter) matches(job *Job) bool {
+	if f.Queue != nil && job.Queue != *f.Queue {
+		return false
+	}
+	if len(f.Types) == 0 {
+		return true
+	}
+	for _, jobType := range f.Types {
+		if job.Type == jobType {
+			return true
+		}
+	}
+	return false
+}
+
+// TryDequeue hands the next job in the queue to consumer, or returns ErrNoJob when the queue
+// is empty. When types are given only jobs of one of those types are considered. The
+// tenants with jobs waiting take turns in proportion to the weights of their quotas.
+func (e *Engine) TryDequeue(consumer int, types ...string) (Job, error) {
+	return e.TryDequeueMatching(consumer, Filter{Types: types})
+}
+
+// TryDequeueFrom is like TryDequeue but only hands out the jobs of tenant
+func (e *Engine) TryDequeueFrom(tenant string, consumer int, types ...string) (Job, error) {
+	return e.TryDequeueMatching(consumer, Filter{Tenant: &tenant, Types: types})
+}
+
+// TryDequeueMatching is like TryDequeue but only hands out the jobs matching filter
+func (e *Engine) TryDequeueMatching(consumer int, filter Filter) (Job, error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if e.draining {
+		return Job{}, ErrDraining
+	}
+	job, _ := e.tryDequeue(filter, consumer)
+	if job == nil {
+		return Job{}, ErrNoJob
+	}
+	return *job, nil
+}
+
+// tryDequeue skips cancelled jobs, expires the ones that waited too long and hands the
+// next remaining one matching filter to consumer. When there is none it returns the
+// channel that is closed on the next enqueue. The caller must hold mutex.
+func (e *Engine) tryDequeue(filter Filter, consumer int) (*Job, chan struct{}) {
+	e.expireLeases()
+
+	weight := func(tenant string) int { return e.Quota(tenant).weight() }
+	job := e.queue.take(e.drop(time.Now()), filter.matches, filter.Tenant, weight)
+	if job == nil {
+		return nil, e.available
+	}
+	e.count(job, -1)
+	job.Status = StatusInProgress
+	job.ConsumedBy = consumer
+	job.Attempts++
+	job.DequeueTime = time.Now()
+	job.HeartbeatTime = job.DequeueTime
+	e.leased[job.ID] = job
+	e.recordChange(OpDequeue, job)
+	return job, nil
+}
+
+// expireLeases puts the in-progress jobs that missed their heartbeat back into the queue,
+// the caller must hold mutex
+func (e *Engine) expireLeases() {
+	for id, job := range e.leased {
+		if time.Since(job.HeartbeatTime) <= e.dequeueTimeout {
+			continue
+		}
+		delete(e.leased, id)
+		if job.Cancel {
+			continue
+		}
+		job.Status = StatusQueued
+		job.ConsumedBy = 0
+		job.EnqueueTime = time.Now()
+		e.push(job)
+		e.recordChange(OpExpire, job)
+	}
+}
+
+// nextLeaseExpiry returns how long until the first lease expires, the caller must hold mutex
+func (e *Engine) nextLeaseExpiry() (time.Duration, bool) {
+	var next time.Duration
+	found := false
+	for _, job := range e.leased {
+		wait := e.dequeueTimeout - time.Since(job.HeartbeatTime)
+		if !found || wait < next {
+			next, found = wait, true
+		}
+	}
+	return next, found
+}
+
+// Dequeue hands the next job in the queue to consumer, waiting for one to be enqueued
+// when the queue is empty. When types are given only jobs of one of those types are
+// considered. It returns the context's error when ctx is done first, and ErrDraining once
+// the engine is draining.
+func (e *Engine) Dequeue(ctx context.Context, consumer int, types ...string) (Job, error) {
+	return e.DequeueMatching(ctx, consumer, Filter{Types: types})
+}
+
+// DequeueFrom is like Dequeue but only hands out the jobs of tenant
+func (e *Engine) DequeueFrom(ctx context.Context, tenant string, consumer int, types ...string) (Job, error) {
+	return e.DequeueMatching(ctx, consumer, Filter{Tenant: &tenant, Types: types})
+}
+
+// DequeueMatching is like Dequeue but only hands out the jobs matching filter
+func (e *Engine) DequeueMatching(ctx context.Context, consumer int, filter Filter) (Job, error) {
+	for {
+		e.mutex.Lock()
+		if e.draining {
+			e.mutex.Unlock()
+			return Job{}, ErrDraining
+		}
+		job, available := e.tryDequeue(filter, consumer)
+		var dequeued Job
+		if job != nil {
+			dequeued = *job
+		}
+		leaseExpiry, leased := e.nextLeaseExpiry()
+		e.mutex.Unlock()
+
+		if job != nil {
+			return dequeued, nil
+		}
+
+		// an expiring lease puts a job back into the queue without an enqueue
+		if !leased {
+			leaseExpiry = time.Hour
+		}
+		timer := time.NewTimer(leaseExpiry + time.Millisecond)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return Job{}, ctx.Err()
+		case <-available:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// inProgress returns a job the consumer holds, the caller must hold mutex
+func (e *Engine) inProgress(op string, id, consumer int) (*Job, error) {
+	job, exists := e.jobStore[id]
+	if !exists {
+		return nil, &JobError{ID: id, Op: op, Err: ErrNotFound}
+	}
+	if job.Cancel {
+		return nil, &JobError{ID: id, Op: op, Err: ErrCancelled}
+	}
+	if job.Status != StatusInProgress {
+		return nil, &JobError{ID: id, Op: op, Err: ErrNotInProgress}
+	}
+	if job.ConsumedBy != consumer {
+		return nil, &JobError{ID: id, Op: op, Err: ErrNotOwner}
+	}
+	return job, nil
+}
+
+// LeaseTimeout returns how long a dequeued job may stay in progress without a heartbeat
+func (e *Engine) LeaseTimeout() time.Duration {
+	return e.dequeueTimeout
+}
+
+// Heartbeat extends the lease consumer holds on a job. It returns an error wrapping
+// ErrCancelled once the job is cancelled, so the consumer can stop working on it.
+func (e *Engine) Heartbeat(id, consumer int) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	job, err := e.inProgress("heartbeat", id, consumer)
+	if err != nil {
+		return err
+	}
+	job.HeartbeatTime = time.Now()
+	return nil
+}
+
+// Release puts a job consumer holds back into the queue as if its lease had expired,
+// e.g. when the consumer is gone before it reported the job
+func (e *Engine) Release(id, consumer int) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	job, err := e.inProgress("release", id, consumer)
+	if err != nil {
+		return err
+	}
+	delete(e.leased, id)
+	job.Status = StatusQueued
+	job.ConsumedBy = 0
+	job.EnqueueTime = time.Now()
+	e.push(job)
+	e.recordChange(OpExpire, job)
+	return nil
+}
+
+// Fail marks a job consumer holds as failed with the given reason, a failed job can be retried
+func (e *Engine) Fail(id, consumer int, reason string) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	job, err := e.inProgress("fail", id, consumer)
+	if err != nil {
+		return err
+	}
+	job.Status = StatusFailed
+	job.Error = reason
+	delete(e.leased, id)
+	e.recordChange(OpFail, job)
+	return nil
+}
+
+// Conclude marks a dequeued job as concluded
+func (e *Engine) Conclude(id int) error {
+	return e.ConcludeWithResult(id, nil)
+}
+
+// ConcludeWithResult marks a dequeued job as concluded and stores its result
+func (e *Engine) ConcludeWithResult(id int, result interface{}) error {
+	return e.conclude(id, 0, result)
+}
+
+// ConcludeAs concludes a job like ConcludeWithResult, but only when consumer dequeued it.
+// A zero consumer skips the check.
+func (e *Engine) ConcludeAs(id, consumer int, result interface{}) error {
+	return e.conclude(id, consumer, result)
+}
+
+// conclude marks a job as concluded, a non-zero consumer must be the one that dequeued it
+func (e *Engine) conclude(id, consumer int, result interface{}) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	job, exists := e.jobStore[id]
+	if !exists {
+		return &JobError{ID: id, Op: "conclude", Err: ErrNotFound}
+	}
+	if job.Cancel {
+		return &JobError{ID: id, Op: "conclude", Err: ErrCancelled}
+	}
+	// only a job in progress can be concluded, a failed or expired job has to be retried first
+	switch job.Status {
+	case StatusInProgress:
+	case StatusQueued:
+		return &JobError{ID: id, Op: "conclude", Err: ErrNotDequeued}
+	case StatusConcluded:
+		return &JobError{ID: id, Op: "conclude", Err: ErrConcluded}
+	default:
+		return &JobError{ID: id, Op: "conclude", Err: ErrNotInProgress}
+	}
+	if consumer != 0 && job.ConsumedBy != consumer {
+		return &JobError{ID: id, Op: "conclude", Err: ErrNotOwner}
+	}
+	job.Status = StatusConcluded
+	if result != nil {
+		job.Result = result
+	}
+	delete(e.leased, id)
+	e.recordChange(OpConclude, job)
+	return nil
+}
+
+// Cancel flags a job as cancelled, a cancelled job is skipped by dequeue and cannot be concluded or retried
+func (e *Engine) Cancel(id int) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	job, exists := e.jobStore[id]
+	if !exists {
+		return &JobError{ID: id, Op: "cancel", Err: ErrNotFound}
+	}
+	if job.Status == StatusQueued && !job.Cancel {
+		// the job stays linked until dequeue walks past it but no longer counts
+		e.count(job, -1)
+	}
+	job.Cancel = true
+	e.recordChange(OpCancel, job)
+	return nil
+}
+
+// Retry puts a job that already left the queue back at its end
+func (e *Engine) Retry(id int) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	job, exists := e.jobStore[id]
+	if !exists {
+		return &JobError{ID: id, Op: "retry", Err: ErrNotFound}
+	}
+	if job.Cancel {
+		return &JobError{ID: id, Op: "retry", Err: ErrCancelled}
+	}
+	if job.Status == StatusQueued {
+		return &JobError{ID: id, Op: "retry", Err: ErrQueued}
+	}
+	if e.draining {
+		return &JobError{ID: id, Op: "retry", Err: ErrDraining}
+	}
+	job.Status = StatusQueued
+	job.EnqueueTime = time.Now()
+	delete(e.leased, id)
+	e.push(job)
+	e.recordChange(OpRetry, job)
+	return nil
+}
+
+// Redrive enqueues a copy of a job that is done, i.e. concluded, failed, expired or cancelled, and
+// returns the ID of the copy. The copy keeps the type, queue, key, tenant, payload,
+// callback and trace context of the job and is subject to the same limits as any enqueued job.
+func (e *Engine) Redrive(id int) (int, error) {
+	job, err := e.Job(id)
+	if err != nil {
+		return 0, &JobError{ID: id, Op: "redrive", Err: ErrNotFound}
+	}
+	if !job.Done() {
+		return 0, &JobError{ID: id, Op: "redrive", Err: ErrNotDone}
+	}
+	return e.Enqueue(Job{
+		Type:           job.Type,
+		Queue:          job.Queue,
+		Key:            job.Key,
+		Tenant:         job.Tenant,
+		Status:         StatusQueued,
+		Payload:        job.Payload,
+		Callback:       job.Callback,
+		CallbackEvents: job.CallbackEvents,
+		TraceParent:    job.TraceParent,
+		TraceState:     job.TraceState,
+	})
+}
+
+// Drain stops the engine from taking new jobs and handing out queued ones, blocked dequeues
+// return ErrDraining at once. Jobs in progress can still be concluded, failed and sent
+// heartbeats, so their consumers can finish them.
+func (e *Engine) Drain() {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if e.draining {
+		return
+	}
+	e.draining = true
+	close(e.available)
+	e.available = make(chan struct{})
+}
+
+// Resume ends draining
+func (e *Engine) Resume() {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.draining = false
+}
+
+// Draining reports whether the engine is draining
+func (e *Engine) Draining() bool {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.draining
+}
+
+// InProgress counts the jobs that are dequeued and not yet concluded or failed
+func (e *Engine) InProgress() int {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return len(e.leased)
+}
+
+// RequeueInProgress puts every job in progress back into the queue as if its lease had
+// expired and returns how many it requeued
+func (e *Engine) RequeueInProgress() int {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	requeued := 0
+	for _, job := range e.leased {
+		job.HeartbeatTime = time.Time{}
+		if !job.Cancel {
+			requeued++
+		}
+	}
+	e.expireLeases()
+	return requeued
+}
+
+// Job returns a snapshot of the job with the given ID
+func (e *Engine) Job(id int) (Job, error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.expireQueued()
+	job, exists := e.jobStore[id]
+	if !exists {
+		return Job{}, &JobError{ID: id, Op: "get", Err: ErrNotFound}
+	}
+	return *job, nil
+}
+
+// WaitDone returns a snapshot of the job with the given ID once it is done, waiting for that
+// while it is queued or in progress. When ctx is done first it returns the job as it is then
+// along with ErrNotDone.
+func (e *Engine) WaitDone(ctx context.Context, id int) (Job, error) {
+	for {
+		e.mutex.Lock()
+		e.expireQueued()
+		stored, exists := e.jobStore[id]
+		var job Job
+		var expiry time.Duration
+		if exists {
+			expiry = e.enqueueTimeout - time.Since(stored.EnqueueTime)
+			if stored.Status == StatusQueued && !stored.Cancel && expiry < 0 && e.queue.remove(stored) {
+				// the job waited too long behind one that did not
+				e.expire(stored)
+			}
+			job = *stored
+		}
+		changed := e.changed
+		e.mutex.Unlock()
+
+		if !exists {
+			return Job{}, &JobError{ID: id, Op: "wait", Err: ErrNotFound}
+		}
+		if job.Done() {
+			return job, nil
+		}
+		// a queued job only expires when the engine is next used, so wake up to expire it
+		if job.Status != StatusQueued || expiry < 0 {
+			expiry = time.Hour
+		}
+		timer := time.NewTimer(expiry + time.Millisecond)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return job, &JobError{ID: id, Op: "wait", Err: ErrNotDone}
+		case <-changed:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// Has reports whether a job with the given ID is stored in the engine
+func (e *Engine) Has(id int) bool {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	_, exists := e.jobStore[id]
+	return exists
+}
+
+// List returns the jobs ordered by ID, an empty status or jobType matches every job
+func (e *Engine) List(status, jobType string) []Job {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.expireQueued()
+	jobs := []Job{}
+	for _, job := range e.jobStore {
+		if status != "" && job.Status != status {
+			continue
+		}
+		if jobType != "" && job.Type != jobType {
+			continue
+		}
+		jobs = append(jobs, *job)
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].ID < jobs[j].ID })
+	return jobs
+}
+
+// Stats counts the jobs by status and type
+func (e *Engine) Stats() Stats {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.stats(func(*Job) bool { return true })
+}
+
+// stats counts the jobs for which include returns true, the caller must hold mutex
+func (e *Engine) stats(include func(*Job) bool) Stats {
+	e.expireQueued()
+	stats := Stats{ByStatus: map[string]int{}, ByType: map[string]int{}}
+	for _, job := range e.jobStore {
+		if !include(job) {
+			continue
+		}
+		stats.Total++
+		if job.Cancel {
+			stats.Cancelled++
+		}
+		stats.ByStatus[job.Status]++
+		stats.ByType[job.Type]++
+	}
+	return stats
+}
+
+// Counts counts the jobs by status and type together, ordered by status and type
+func (e *Engine) Counts() []Count {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.expireQueued()
+	index := map[Count]int{}
+	for _, job := range e.jobStore {
+		index[Count{Status: job.Status, Type: job.Type, Cancelled: job.Cancel}]++
+	}
+	counts := make([]Count, 0, len(index))
+	for count, jobs := range index {
+		count.Jobs = jobs
+		counts = append(counts, count)
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Status != counts[j].Status {
+			return counts[i].Status < counts[j].Status
+		}
+		if counts[i].Type != counts[j].Type {
+			return counts[i].Type < counts[j].Type
+		}
+		return !counts[i].Cancelled && counts[j].Cancelled
+	})
+	return counts
+}
+
+// Epoch identifies the change stream of this engine, an engine created later, e.g. after a
+// restart, has another one even when it restores the same changes
+func (e *Engine) Epoch() string {
+	return e.epoch
+}
+
+// LastSeq returns the sequence number of the newest change applied to the engine
+func (e *Engine) LastSeq() int {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.lastSeq()
+}
+
+// Changes returns up to limit changes recorded after since, along with the newest sequence number
+func (e *Engine) Changes(since, limit int) (int, []Change) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	// changes are ordered by sequence number so the page starts at the first newer one
+	start := sort.Search(len(e.changes), func(i int) bool { return e.changes[i].Seq > since })
+	end := start + limit
+	if end > len(e.changes) {
+		end = len(e.changes)
+	}
+	page := make([]Change, end-start)
+	copy(page, e.changes[start:end])
+	return e.lastSeq(), page
+}
+
+// WaitChanges is like Changes but waits for a change to be recorded after since when
+// there is none yet. It returns the context's error when ctx is done first.
+func (e *Engine) WaitChanges(ctx context.Context, since, limit int) (int, []Change, error) {
+	for {
+		e.mutex.Lock()
+		changed := e.changed
+		e.mutex.Unlock()
+
+		seq, changes := e.Changes(since, limit)
+		if len(changes) > 0 {
+			return seq, changes, nil
+		}
+		select {
+		case <-ctx.Done():
+			return seq, nil, ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// History returns the changes recorded for the job with the given ID, oldest first
+func (e *Engine) History(id int) ([]Change, error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if _, exists := e.jobStore[id]; !exists {
+		return nil, &JobError{ID: id, Op: "history", Err: ErrNotFound}
+	}
+	history := []Change{}
+	for _, change := range e.changes {
+		if change.Job.ID == id {
+			history = append(history, change)
+		}
+	}
+	return history, nil
+}
+
+// Attempts returns the times the job with the given ID was handed to a consumer, oldest
+// first. They are read from the change stream: every dequeue starts an attempt and the
+// next conclude, fail, expiry, retry or cancel ends it.
+func (e *Engine) Attempts(id int) ([]Attempt, error) {
+	history, err := e.History(id)
+	if err != nil {
+		return nil, err
+	}
+	attempts := []Attempt{}
+	var current *Attempt
+	for _, change := range history {
+		if change.Op == OpDequeue {
+			attempts = append(attempts, Attempt{
+				Number:   len(attempts) + 1,
+				Consumer: change.Job.ConsumedBy,
+				Started:  change.Time,
+				Outcome:  OutcomeInProgress,
+			})
+			current = &attempts[len(attempts)-1]
+			continue
+		}
+		if current == nil {
+			continue
+		}
+		outcome, ends := attemptOutcomes[change.Op]
+		if !ends {
+			continue
+		}
+		ended := change.Time
+		current.Ended = &ended
+		current.Outcome = outcome
+		current.Error = change.Job.Error
+		current = nil
+	}
+	return attempts, nil
+}
+
+// Apply applies a change read from another engine's change stream to the job store.
+// Changes that were already applied are ignored so a follower can safely re-read a page.
+func (e *Engine) Apply(change Change) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if change.Seq <= e.lastSeq() {
+		return
+	}
+	job := change.Job
+	if change.Op == OpExport {
+		delete(e.jobStore, job.ID)
+	} else {
+		e.jobStore[job.ID] = &job
+	}
+	if job.ID >= e.nextID {
+		e.nextID = job.ID + e.idStride
+	}
+	e.appendChange(change)
+}
+
+// Reset empties the engine so that it can apply a change stream from the start again,
+// e.g. on a follower whose primary restarted with a shorter stream
+func (e *Engine) Reset() {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.queue = fairQueue{}
+	e.nextID = e.firstID
+	e.jobStore = make(map[int]*Job)
+	e.leased = make(map[int]*Job)
+	e.depth = 0
+	e.tenantDepths = make(map[string]int)
+	e.changes = nil
+	e.compactAt = 0
+	close(e.changed)
+	e.changed = make(chan struct{})
+}
+
+// Restore rebuilds the engine from a change stream saved earlier, it must be called
+// before the engine is used. Queued jobs go back into the queue in ID order and jobs
+// that were in progress get a fresh lease, so they are queued again unless their
+// consumer keeps sending heartbeats.
+func (e *Engine) Restore(changes []Change) {
+	for _, change := range changes {
+		e.Apply(change)
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	ids := make([]int, 0, len(e.jobStore))
+	for id := range e.jobStore {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	now := time.Now()
+	for _, id := range ids {
+		job := e.jobStore[id]
+		switch {
+		case job.Status == StatusQueued && !job.Cancel:
+			e.push(job)
+		case job.Status == StatusInProgress:
+			job.HeartbeatTime = now
+			e.leased[id] = job
+		}
+	}
+}
+
+// Queued returns a snapshot of the jobs waiting in the queue
+func (e *Engine) Queued() []Job {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.expireQueued()
+	jobs := []Job{}
+	for _, job := range e.jobStore {
+		if job.Status == StatusQueued && !job.Cancel {
+			jobs = append(jobs, *job)
+		}
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].ID < jobs[j].ID })
+	return jobs
+}
+
+// Export removes a queued job from the engine so it can be moved to another one.
+// It returns false when the job is unknown or no longer waiting in the queue.
+func (e *Engine) Export(id int) (Job, bool) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	job, exists := e.jobStore[id]
+	if !exists || job.Status != StatusQueued || job.Cancel {
+		return Job{}, false
+	}
+	if e.queue.remove(job) {
+		e.count(job, -1)
+	}
+	delete(e.jobStore, id)
+	e.recordChange(OpExport, job)
+	return *job, true
+}
+
+// Import adds a job moved from another engine to the queue, keeping its ID. It fails with
+// ErrIDInUse when the engine holds a job with that ID, or would hand the ID out itself.
+func (e *Engine) Import(job Job) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	_, exists := e.jobStore[job.ID]
+	unissued := job.ID >= e.nextID && (job.ID-e.nextID)%e.idStride == 0
+	if exists || unissued || job.ID <= 0 {
+		return &JobError{ID: job.ID, Op: "import", Err: ErrIDInUse}
+	}
+	job.Status = StatusQueued
+	e.jobStore[job.ID] = &job
+	e.push(&job)
+	e.recordChange(OpImport, &job)
+	return nil
+}
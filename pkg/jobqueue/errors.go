@@ -0,0 +1,57 @@
+package jobqueue
+
+import (
+	"errors"
+	"strconv"
+)
+
+var (
+	// ErrMissingFields is returned when a job is enqueued without a Type or Status
+	ErrMissingFields = errors.New("missing required fields")
+	// ErrInvalidType is returned when a job is enqueued with an unknown Type
+	ErrInvalidType = errors.New("invalid Type value")
+	// ErrNoJob is returned when there is no job to dequeue
+	ErrNoJob = errors.New("no job available")
+	// ErrNotFound is returned when no job has the requested ID
+	ErrNotFound = errors.New("job not found")
+	// ErrCancelled is returned when an operation needs a job that was not cancelled
+	ErrCancelled = errors.New("job already cancelled")
+	// ErrNotDequeued is returned when a job is concluded before it was dequeued
+	ErrNotDequeued = errors.New("job not dequeued")
+	// ErrConcluded is returned when a job is concluded twice
+	ErrConcluded = errors.New("job already concluded")
+	// ErrQueued is returned when a job that is still waiting in the queue is retried
+	ErrQueued = errors.New("job already queued")
+	// ErrNotInProgress is returned when a heartbeat, failure or conclusion is reported for a job that is not in progress
+	ErrNotInProgress = errors.New("job not in progress")
+	// ErrNotOwner is returned when a consumer reports on a job dequeued by another consumer
+	ErrNotOwner = errors.New("job consumed by another consumer")
+	// ErrNotDone is returned when a job that is queued or in progress is re-driven, or
+	// is still not done when a wait for it ends
+	ErrNotDone = errors.New("job not done")
+	// ErrQueueFull is returned when a job is enqueued while the queue holds its maximum number of jobs
+	ErrQueueFull = errors.New("queue is full")
+	// ErrRateLimited is returned when a tenant enqueues jobs faster than its quota allows
+	ErrRateLimited = errors.New("enqueue rate limit exceeded")
+	// ErrPayloadTooLarge is returned when a job's Payload is larger than its tenant's quota allows
+	ErrPayloadTooLarge = errors.New("payload too large")
+	// ErrDraining is returned when a job is enqueued or dequeued while the engine is draining
+	ErrDraining = errors.New("queue is draining")
+	// ErrIDInUse is returned when a job is imported with an ID the engine holds or hands out itself
+	ErrIDInUse = errors.New("job ID already in use")
+)
+
+// JobError describes why an operation on a single job failed, it wraps one of the Err values
+type JobError struct {
+	ID  int
+	Op  string
+	Err error
+}
+
+func (e *JobError) Error() string {
+	return e.Op + " job " + strconv.Itoa(e.ID) + ": " + e.Err.Error()
+}
+
+func (e *JobError) Unwrap() error {
+	return e.Err
+}
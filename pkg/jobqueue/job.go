@@ -0,0 +1,177 @@
+package jobqueue
+
+import "time"
+
+const (
+	StatusQueued     = "QUEUED"
+	StatusInProgress = "IN_PROGRESS"
+	StatusConcluded  = "CONCLUDED"
+	StatusFailed     = "FAILED"
+	// StatusExpired is the status of a job that waited in the queue longer than the enqueue timeout
+	StatusExpired = "EXPIRED"
+
+	TypeTimeCritical    = "TIME_CRITICAL"
+	TypeNotTimeCritical = "NOT_TIME_CRITICAL"
+)
+
+type Job struct {
+	ID     int    `json:"ID"`
+	Type   string `json:"Type"`
+	Queue  string `json:"Queue,omitempty"`
+	Key    string `json:"Key,omitempty"`
+	Tenant string `json:"Tenant,omitempty"`
+	// TraceParent and TraceState hold the W3C trace context of the request that enqueued the job
+	TraceParent string      `json:"TraceParent,omitempty"`
+	TraceState  string      `json:"TraceState,omitempty"`
+	Status      string      `json:"Status"`
+	ConsumedBy  int         `json:"ConsumedBy,omitempty"`
+	Payload     interface{} `json:"Payload,omitempty"`
+	Result      interface{} `json:"Result,omitempty"`
+	Error       string      `json:"Error,omitempty"`
+	Cancel      bool        `json:"Cancel,omitempty"`
+	// Callback is a URL that is sent a signed POST when the job goes through one of the
+	// CallbackEvents, by default when it is concluded, failed, cancelled or expired, or its lease expires
+	Callback       string   `json:"Callback,omitempty"`
+	CallbackEvents []string `json:"CallbackEvents,omitempty"`
+	EnqueueTime    time.Time
+	DequeueTime    time.Time
+	// HeartbeatTime is when the consumer last reported progress, a job whose heartbeat
+	// is older than the dequeue timeout goes back into the queue
+	HeartbeatTime time.Time
+	// Attempts counts how often the job was dequeued
+	Attempts int `json:"Attempts,omitempty"`
+}
+
+// Done reports whether the job reached a state it only leaves when retried or re-driven,
+// i.e. it is concluded, failed, expired or cancelled
+func (job Job) Done() bool {
+	return job.Cancel || job.Status == StatusConcluded || job.Status == StatusFailed || job.Status == StatusExpired
+}
+
+// operations recorded in the change stream
+const (
+	OpEnqueue  = "ENQUEUE"
+	OpDequeue  = "DEQUEUE"
+	OpConclude = "CONCLUDE"
+	OpCancel   = "CANCEL"
+	OpRetry    = "RETRY"
+	OpFail     = "FAIL"
+	OpExpire   = "EXPIRE"
+	OpImport   = "IMPORT"
+	OpExport   = "EXPORT"
+)
+
+// Change is one entry of an engine's change stream. It carries a snapshot of the
+// job as it was right after the operation was applied.
+type Change struct {
+	Seq  int       `json:"Seq"`
+	Time time.Time `json:"Time"`
+	Op   string    `json:"Op"`
+	Job  Job       `json:"Job"`
+}
+
+// outcomes of an attempt
+const (
+	OutcomeInProgress = "IN_PROGRESS"
+	OutcomeConcluded  = "CONCLUDED"
+	OutcomeFailed     = "FAILED"
+	OutcomeExpired    = "EXPIRED"
+	OutcomeRetried    = "RETRIED"
+	OutcomeCancelled  = "CANCELLED"
+)
+
+// attemptOutcomes maps the operations that end an attempt to its outcome
+var attemptOutcomes = map[string]string{
+	OpConclude: OutcomeConcluded,
+	OpFail:     OutcomeFailed,
+	OpExpire:   OutcomeExpired,
+	OpRetry:    OutcomeRetried,
+	OpCancel:   OutcomeCancelled,
+}
+
+// Attempt is one time a job was handed to a consumer
+type Attempt struct {
+	Number   int        `json:"Number"`
+	Consumer int        `json:"Consumer"`
+	Started  time.Time  `json:"Started"`
+	Ended    *time.Time `json:"Ended,omitempty"`
+	Outcome  string     `json:"Outcome"`
+	Error    string     `json:"Error,omitempty"`
+}
+
+// Stats holds the number of known jobs grouped by status and type
+type Stats struct {
+	Total     int            `json:"Total"`
+	Cancelled int            `json:"Cancelled"`
+	ByStatus  map[string]int `json:"ByStatus"`
+	ByType    map[string]int `json:"ByType"`
+}
+
+// Count is the number of jobs that share a status and type, cancelled jobs are counted apart
+type Count struct {
+	Status    string `json:"Status"`
+	Type      string `json:"Type"`
+	Cancelled bool   `json:"Cancelled,omitempty"`
+	Jobs      int    `json:"Jobs"`
+}
+
+type node struct {
+	val  *Job
+	next *node
+}
+
+type jobList struct {
+	// a linked list structure for jobs
+	head *node
+}
+
+func (list *jobList) insert(job *Job) {
+	newNode := &node{val: job}
+	if list.head == nil {
+		list.head = newNode
+	} else {
+		curr := list.head
+		for curr.next != nil {
+			curr = curr.next
+		}
+		curr.next = newNode
+	}
+}
+
+// find returns the first job for which match returns true without unlinking it, every
+// job before it for which drop returns true is unlinked and discarded on the way
+func (list *jobList) find(drop, match func(*Job) bool) *Job {
+	var prev *node
+	for curr := list.head; curr != nil; curr = curr.next {
+		if drop(curr.val) {
+			if prev == nil {
+				list.head = curr.next
+			} else {
+				prev.next = curr.next
+			}
+			continue
+		}
+		if match(curr.val) {
+			return curr.val
+		}
+		prev = curr
+	}
+	return nil
+}
+
+// remove unlinks job from the list and reports whether it was found
+func (list *jobList) remove(job *Job) bool {
+	var prev *node
+	for curr := list.head; curr != nil; curr = curr.next {
+		if curr.val == job {
+			if prev == nil {
+				list.head = curr.next
+			} else {
+				prev.next = curr.next
+			}
+			return true
+		}
+		prev = curr
+	}
+	return false
+}
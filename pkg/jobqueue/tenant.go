@@ -0,0 +1,217 @@
+package jobqueue
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+	"time"
+)
+
+// Quota limits the jobs of one tenant, a zero field leaves that limit off
+type Quota struct {
+	// MaxDepth limits how many of the tenant's jobs may wait in the queue
+	MaxDepth int `json:"MaxDepth,omitempty"`
+	// Rate limits how many jobs the tenant may enqueue per second, Burst of them at once
+	Rate  float64 `json:"Rate,omitempty"`
+	Burst int     `json:"Burst,omitempty"`
+	// MaxPayloadBytes limits the size of a job's JSON encoded Payload
+	MaxPayloadBytes int64 `json:"MaxPayloadBytes,omitempty"`
+	// Weight is the tenant's share of the dequeues when several tenants have jobs waiting, 1 by default
+	Weight int `json:"Weight,omitempty"`
+}
+
+// weight returns the dequeue weight of the quota
+func (q Quota) weight() int {
+	if q.Weight <= 0 {
+		return 1
+	}
+	return q.Weight
+}
+
+// WithTenantQuota sets the quota of one tenant, jobs without a Tenant belong to the tenant ""
+func WithTenantQuota(tenant string, quota Quota) Option {
+	return func(e *Engine) {
+		e.quotas[tenant] = quota
+	}
+}
+
+// WithDefaultQuota sets the quota of the tenants that have none of their own
+func WithDefaultQuota(quota Quota) Option {
+	return func(e *Engine) {
+		e.defaultQuota = quota
+	}
+}
+
+// Quota returns the quota that applies to tenant
+func (e *Engine) Quota(tenant string) Quota {
+	if quota, ok := e.quotas[tenant]; ok {
+		return quota
+	}
+	return e.defaultQuota
+}
+
+// admit checks job against the quota of its tenant and takes a token from the tenant's
+// rate limit, the caller must hold mutex
+func (e *Engine) admit(job *Job, now time.Time) error {
+	quota := e.Quota(job.Tenant)
+	if quota.MaxDepth > 0 && e.tenantDepth(job.Tenant) >= quota.MaxDepth {
+		return ErrQueueFull
+	}
+	if quota.Rate <= 0 {
+		return nil
+	}
+	b, ok := e.buckets[job.Tenant]
+	if !ok {
+		b = &bucket{tokens: float64(quota.burst()), last: now}
+		e.buckets[job.Tenant] = b
+	}
+	if !b.take(quota, now) {
+		return ErrRateLimited
+	}
+	return nil
+}
+
+// payloadSize returns the size of a job's JSON encoded Payload
+func payloadSize(job *Job) int64 {
+	if job.Payload == nil {
+		return 0
+	}
+	data, err := json.Marshal(job.Payload)
+	if err != nil {
+		return 0
+	}
+	return int64(len(data))
+}
+
+// tenantDepth returns how many jobs of tenant wait in the queue, the caller must hold mutex
+func (e *Engine) tenantDepth(tenant string) int {
+	return e.tenantDepths[tenant]
+}
+
+// burst returns how many jobs may be enqueued at once, at least one
+func (q Quota) burst() int {
+	if q.Burst > 0 {
+		return q.Burst
+	}
+	return int(math.Max(1, math.Ceil(q.Rate)))
+}
+
+// bucket is the token bucket behind a tenant's enqueue rate
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// take refills the bucket for the time passed since the last call and takes one token
+func (b *bucket) take(quota Quota, now time.Time) bool {
+	b.tokens = math.Min(float64(quota.burst()), b.tokens+now.Sub(b.last).Seconds()*quota.Rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// TenantStats counts the jobs of one tenant by status and type
+func (e *Engine) TenantStats(tenant string) Stats {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.stats(func(job *Job) bool { return job.Tenant == tenant })
+}
+
+// Tenants returns the names of the tenants that have jobs, in order
+func (e *Engine) Tenants() []string {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	seen := map[string]bool{}
+	tenants := []string{}
+	for _, job := range e.jobStore {
+		if !seen[job.Tenant] {
+			seen[job.Tenant] = true
+			tenants = append(tenants, job.Tenant)
+		}
+	}
+	sort.Strings(tenants)
+	return tenants
+}
+
+// fairQueue keeps one list of jobs per tenant and takes from them by weighted round robin
+type fairQueue struct {
+	lists map[string]*jobList
+	// credit of the smooth weighted round robin, the tenant with the most goes next
+	credit map[string]int
+}
+
+func (q *fairQueue) insert(job *Job) {
+	if q.lists == nil {
+		q.lists = make(map[string]*jobList)
+		q.credit = make(map[string]int)
+	}
+	list, ok := q.lists[job.Tenant]
+	if !ok {
+		list = &jobList{}
+		q.lists[job.Tenant] = list
+	}
+	list.insert(job)
+}
+
+// remove unlinks job from the list of its tenant and reports whether it was found
+func (q *fairQueue) remove(job *Job) bool {
+	list, ok := q.lists[job.Tenant]
+	return ok && list.remove(job)
+}
+
+// prune unlinks the jobs at the front of each list for which drop returns true
+func (q *fairQueue) prune(drop func(*Job) bool) {
+	for _, list := range q.lists {
+		list.find(drop, func(*Job) bool { return true })
+	}
+}
+
+// take unlinks and returns the next job for which match returns true, discarding the jobs
+// for which drop returns true on the way. Every tenant with a matching job earns its
+// weight in credit and the one with the most credit gives up a job, so over time each
+// tenant gets a share of the dequeues in proportion to its weight. When only is not
+// nil only the jobs of that tenant are considered.
+func (q *fairQueue) take(drop, match func(*Job) bool, only *string, weight func(string) int) *Job {
+	if only != nil {
+		list, ok := q.lists[*only]
+		if !ok {
+			return nil
+		}
+		job := list.find(drop, match)
+		if job != nil {
+			list.remove(job)
+		}
+		return job
+	}
+
+	tenants := make([]string, 0, len(q.lists))
+	for tenant := range q.lists {
+		tenants = append(tenants, tenant)
+	}
+	sort.Strings(tenants)
+
+	var next *Job
+	nextTenant, total := "", 0
+	for _, tenant := range tenants {
+		job := q.lists[tenant].find(drop, match)
+		if job == nil {
+			continue
+		}
+		w := weight(tenant)
+		q.credit[tenant] += w
+		total += w
+		if next == nil || q.credit[tenant] > q.credit[nextTenant] {
+			next, nextTenant = job, tenant
+		}
+	}
+	if next == nil {
+		return nil
+	}
+	q.credit[nextTenant] -= total
+	q.lists[nextTenant].remove(next)
+	return next
+}
@@ -0,0 +1,52 @@
+// Package webhook signs and verifies the requests a job-queue server sends to webhook,
+// callback and push URLs.
+//
+// The body is signed with HMAC-SHA256 over the timestamp and the body:
+//
+//	X-Queue-Timestamp: 1714557600
+//	X-Queue-Signature: sha256=<hex HMAC-SHA256 of "1714557600." + body>
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// headers of a delivery
+const (
+	SignatureHeader = "X-Queue-Signature"
+	TimestampHeader = "X-Queue-Timestamp"
+	DeliveryHeader  = "X-Queue-Delivery"
+	EventHeader     = "X-Queue-Event"
+)
+
+// ErrInvalidSignature is returned for a delivery that is not signed with the secret or too old
+var ErrInvalidSignature = errors.New("invalid signature")
+
+// Sign returns the signature of a delivery body sent at timestamp, a Unix time in seconds
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the signature of a delivery body and that its timestamp is no further
+// than tolerance from now, which stops old deliveries from being replayed
+func Verify(secret, timestamp, signature string, body []byte, now time.Time, tolerance time.Duration) error {
+	if !hmac.Equal([]byte(signature), []byte(Sign(secret, timestamp, body))) {
+		return ErrInvalidSignature
+	}
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	if age := now.Sub(time.Unix(seconds, 0)); age > tolerance || age < -tolerance {
+		return ErrInvalidSignature
+	}
+	return nil
+}
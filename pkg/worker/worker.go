@@ -0,0 +1,258 @@
+// Package worker runs job handlers against a job-queue server.
+//
+// A Worker dequeues jobs of the types it has handlers for, keeps their leases alive
+// with heartbeats while the handler runs and concludes or fails each job from the
+// handler's return value:
+//
+//	w := worker.New(client.New("http://localhost:8080"), consumerID, worker.WithConcurrency(4))
+//	w.Handle(jobqueue.TypeTimeCritical, func(ctx context.Context, job jobqueue.Job) (interface{}, error) {
+//		return process(ctx, job.Payload)
+//	})
+//	err := w.Run(ctx)
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/varungujarathi9/job-queue/pkg/client"
+	"github.com/varungujarathi9/job-queue/pkg/jobqueue"
+)
+
+const (
+	defaultHeartbeat = 10 * time.Second
+	defaultPollWait  = 20 * time.Second
+	defaultBackoff   = time.Second
+	// reportTimeout bounds reporting the result of a job that finished as the worker stopped
+	reportTimeout = 5 * time.Second
+)
+
+// HandlerFunc processes one job. The context is cancelled when the job is cancelled,
+// when its lease is lost or when the worker stops. A nil error concludes the job with
+// the returned result, any other error fails it.
+type HandlerFunc func(ctx context.Context, job jobqueue.Job) (interface{}, error)
+
+// Worker pulls jobs for the registered types and runs their handlers
+type Worker struct {
+	client      *client.Client
+	consumer    int
+	concurrency int
+	heartbeat   time.Duration
+	pollWait    time.Duration
+	backoff     time.Duration
+	onError     func(job jobqueue.Job, err error)
+
+	mutex    sync.Mutex
+	handlers map[string]HandlerFunc
+}
+
+// Option configures a Worker
+type Option func(*Worker)
+
+// WithConcurrency sets how many jobs are processed at the same time, 1 by default
+func WithConcurrency(concurrency int) Option {
+	return func(w *Worker) {
+		w.concurrency = concurrency
+	}
+}
+
+// WithHeartbeatInterval sets how often the lease of a running job is extended, it must
+// be shorter than the server's dequeue timeout
+func WithHeartbeatInterval(interval time.Duration) Option {
+	return func(w *Worker) {
+		w.heartbeat = interval
+	}
+}
+
+// WithPollWait sets how long a dequeue waits on the server for a job to arrive
+func WithPollWait(wait time.Duration) Option {
+	return func(w *Worker) {
+		w.pollWait = wait
+	}
+}
+
+// WithErrorHandler sets a function called for errors that do not stop the worker, such
+// as a failed dequeue or a conclude that did not reach the server. The job is the zero
+// Job when the error is not about a particular job.
+func WithErrorHandler(onError func(job jobqueue.Job, err error)) Option {
+	return func(w *Worker) {
+		w.onError = onError
+	}
+}
+
+// New creates a worker that dequeues as the given consumer through c
+func New(c *client.Client, consumer int, opts ...Option) *Worker {
+	w := &Worker{
+		client:      c,
+		consumer:    consumer,
+		concurrency: 1,
+		heartbeat:   defaultHeartbeat,
+		pollWait:    defaultPollWait,
+		backoff:     defaultBackoff,
+		onError:     func(jobqueue.Job, error) {},
+		handlers:    make(map[string]HandlerFunc),
+	}
+	for _, opt := range opts {
+		opt(w)
+	}
+	return w
+}
+
+// Handle registers the handler for jobs of the given type
+func (w *Worker) Handle(jobType string, handler HandlerFunc) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.handlers[jobType] = handler
+}
+
+// types returns the job types that have a handler
+func (w *Worker) types() []string {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	types := make([]string, 0, len(w.handlers))
+	for jobType := range w.handlers {
+		types = append(types, jobType)
+	}
+	return types
+}
+
+func (w *Worker) handler(jobType string) (HandlerFunc, bool) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	handler, ok := w.handlers[jobType]
+	return handler, ok
+}
+
+// Run processes jobs until ctx is done. Jobs whose handlers still succeed once ctx is done
+// are concluded, the others are neither concluded nor failed, their leases expire and the
+// server queues them again.
+func (w *Worker) Run(ctx context.Context) error {
+	types := w.types()
+	if len(types) == 0 {
+		return errors.New("worker: no handlers registered")
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < w.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.loop(ctx, types)
+		}()
+	}
+	wg.Wait()
+	return nil
+}
+
+// loop dequeues and processes jobs one at a time until ctx is done
+func (w *Worker) loop(ctx context.Context, types []string) {
+	for ctx.Err() == nil {
+		job, err := w.client.Dequeue(ctx, w.consumer, w.pollWait, types...)
+		if errors.Is(err, jobqueue.ErrNoJob) {
+			continue
+		}
+		if err != nil {
+			if ctx.Err() == nil {
+				w.onError(jobqueue.Job{}, err)
+				sleep(ctx, w.backoff)
+			}
+			continue
+		}
+		w.process(ctx, job)
+	}
+}
+
+// process runs the handler of a job while sending heartbeats, then reports the outcome
+func (w *Worker) process(ctx context.Context, job jobqueue.Job) {
+	handler, ok := w.handler(job.Type)
+	if !ok {
+		w.report(ctx, job, nil, fmt.Errorf("no handler for job type %s", job.Type))
+		return
+	}
+
+	jobCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// heartbeats stop the job once the server says it was cancelled or handed to someone else
+	lost := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(w.heartbeat)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-jobCtx.Done():
+				return
+			case <-ticker.C:
+			}
+			err := w.client.Heartbeat(jobCtx, job.ID, w.consumer)
+			var apiErr *client.APIError
+			if errors.As(err, &apiErr) && apiErr.StatusCode < 500 {
+				close(lost)
+				cancel()
+				return
+			}
+			if err != nil && jobCtx.Err() == nil {
+				w.onError(job, err)
+			}
+		}
+	}()
+
+	result, err := run(jobCtx, handler, job)
+	cancel()
+	<-done
+
+	select {
+	case <-lost:
+		// the job is not ours anymore, so there is nothing to report
+		return
+	default:
+	}
+	if ctx.Err() != nil {
+		// the error may only be the handler giving up as the worker stops, a result is
+		// reported so that the job does not run again
+		if err != nil {
+			return
+		}
+		reportCtx, cancelReport := context.WithTimeout(context.Background(), reportTimeout)
+		defer cancelReport()
+		ctx = reportCtx
+	}
+	w.report(ctx, job, result, err)
+}
+
+// report concludes or fails the job
+func (w *Worker) report(ctx context.Context, job jobqueue.Job, result interface{}, err error) {
+	if err != nil {
+		err = w.client.Fail(ctx, job.ID, w.consumer, err.Error())
+	} else {
+		err = w.client.ConcludeAs(ctx, job.ID, w.consumer, result)
+	}
+	if err != nil {
+		w.onError(job, err)
+	}
+}
+
+// run calls the handler, turning a panic into an error
+func run(ctx context.Context, handler HandlerFunc, job jobqueue.Job) (result interface{}, err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = fmt.Errorf("handler panicked: %v", recovered)
+		}
+	}()
+	return handler(ctx, job)
+}
+
+func sleep(ctx context.Context, d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+}
@@ -0,0 +1,168 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/varungujarathi9/job-queue/internal/auth"
+	"github.com/varungujarathi9/job-queue/internal/handlers"
+	"github.com/varungujarathi9/job-queue/pkg/client"
+	"github.com/varungujarathi9/job-queue/pkg/jobqueue"
+)
+
+var testSecret = []byte("0123456789abcdef0123456789abcdef")
+
+func TestAuth_Tokens(t *testing.T) {
+	now := time.Now()
+	token, err := auth.SignToken(testSecret, auth.Claims{
+		Identity: auth.Identity{Name: "worker", Role: auth.RoleConsumer, Consumer: 3},
+		Expires:  now.Add(time.Minute).Unix(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err := auth.VerifyToken(testSecret, token, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Name != "worker" || claims.Role != auth.RoleConsumer || claims.Consumer != 3 {
+		t.Errorf("unexpected claims %+v", claims)
+	}
+	if _, err := auth.VerifyToken(testSecret, token, now.Add(2*time.Minute)); !errors.Is(err, auth.ErrExpiredToken) {
+		t.Errorf("expected %v, got %v", auth.ErrExpiredToken, err)
+	}
+	if _, err := auth.VerifyToken([]byte("another secret of thirty-two bytes"), token, now); !errors.Is(err, auth.ErrInvalidToken) {
+		t.Errorf("expected %v, got %v", auth.ErrInvalidToken, err)
+	}
+	if _, err := auth.VerifyToken(testSecret, token+"x", now); !errors.Is(err, auth.ErrInvalidToken) {
+		t.Errorf("expected %v, got %v", auth.ErrInvalidToken, err)
+	}
+}
+
+func TestAuth_KeysFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.yaml")
+	writeFile(t, path, []byte("keys:\n  - name: ops\n    role: admin\n    key: ops-secret\n"))
+
+	keys, err := auth.LoadKeys(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity, ok := keys.Lookup("ops-secret"); !ok || identity.Role != auth.RoleAdmin {
+		t.Errorf("expected the hand-written key to be an admin, got %+v", identity)
+	}
+	secret, err := keys.Add(auth.Identity{Name: "producer", Role: auth.RoleProducer})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := keys.Add(auth.Identity{Name: "producer", Role: auth.RoleViewer}); !errors.Is(err, auth.ErrKeyExists) {
+		t.Errorf("expected %v, got %v", auth.ErrKeyExists, err)
+	}
+
+	// added keys are saved as hashes and survive a reload
+	keys, err = auth.LoadKeys(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity, ok := keys.Lookup(secret); !ok || identity.Name != "producer" {
+		t.Errorf("expected the added key to be saved, got %+v", identity)
+	}
+	if _, ok := keys.Lookup("ops-secret"); !ok {
+		t.Error("expected the hand-written key to be kept")
+	}
+	if err := keys.Remove("producer"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := keys.Lookup(secret); ok {
+		t.Error("expected the removed key to be refused")
+	}
+	if err := keys.Remove("producer"); !errors.Is(err, auth.ErrKeyNotFound) {
+		t.Errorf("expected %v, got %v", auth.ErrKeyNotFound, err)
+	}
+}
+
+func TestAuth_Roles(t *testing.T) {
+	t.Parallel()
+	keys, err := auth.LoadKeys("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	adminKey, err := keys.Add(auth.Identity{Name: "ops", Role: auth.RoleAdmin})
+	if err != nil {
+		t.Fatal(err)
+	}
+	router, err := handlers.NewRouter(handlers.Options{Auth: auth.NewAuthenticator(keys, testSecret)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+
+	ctx := context.Background()
+	newKey := func(identity auth.Identity) string {
+		body, _ := json.Marshal(auth.KeyRequest{Identity: identity})
+		req, _ := http.NewRequest("POST", server.URL+"/admin/keys", bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+adminKey)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusCreated {
+			t.Fatalf("expected key creation to return %d, got %d", http.StatusCreated, resp.StatusCode)
+		}
+		var created auth.KeyResponse
+		if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+			t.Fatal(err)
+		}
+		return created.Key
+	}
+	producer := client.New(server.URL, client.WithToken(newKey(auth.Identity{Name: "producer", Role: auth.RoleProducer})))
+	workerA := client.New(server.URL, client.WithToken(newKey(auth.Identity{Name: "worker-a", Role: auth.RoleConsumer, Consumer: 1})))
+	token, err := auth.SignToken(testSecret, auth.Claims{Identity: auth.Identity{Name: "worker-b", Role: auth.RoleConsumer, Consumer: 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	workerB := client.New(server.URL, client.WithToken(token))
+
+	var apiErr *client.APIError
+	if _, err := client.New(server.URL).Stats(ctx); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected anonymous request to be unauthorized, got %v", err)
+	}
+	if _, err := client.New(server.URL, client.WithToken("wrong")).Stats(ctx); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected unknown key to be unauthorized, got %v", err)
+	}
+
+	id, err := producer.Enqueue(ctx, jobqueue.Job{Type: jobqueue.TypeTimeCritical, Status: jobqueue.StatusQueued})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := producer.Dequeue(ctx, 1, 0); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusForbidden {
+		t.Errorf("expected producer dequeue to be forbidden, got %v", err)
+	}
+	if _, err := workerA.Enqueue(ctx, jobqueue.Job{Type: jobqueue.TypeTimeCritical, Status: jobqueue.StatusQueued}); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusForbidden {
+		t.Errorf("expected consumer enqueue to be forbidden, got %v", err)
+	}
+	if err := workerA.Cancel(ctx, id); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusForbidden {
+		t.Errorf("expected consumer cancel to be forbidden, got %v", err)
+	}
+
+	// consumers only report on their own jobs
+	job, err := workerA.Dequeue(ctx, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := workerB.Conclude(ctx, job.ID); !errors.Is(err, jobqueue.ErrNotOwner) {
+		t.Errorf("expected %v, got %v", jobqueue.ErrNotOwner, err)
+	}
+	if err := workerA.Conclude(ctx, job.ID); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,116 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/varungujarathi9/job-queue/internal/handlers"
+	"github.com/varungujarathi9/job-queue/pkg/client"
+	"github.com/varungujarathi9/job-queue/pkg/jobqueue"
+)
+
+type emailPayload struct {
+	To      string `json:"to"`
+	Subject string `json:"subject"`
+}
+
+// newTestClient starts a REST API server on a fresh engine and returns a client for it
+func newTestClient(t *testing.T) *client.Client {
+	router, err := handlers.NewRouter(handlers.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+	return client.New(server.URL)
+}
+
+func TestClient_TypedPayloadRoundTrip(t *testing.T) {
+	t.Parallel()
+	c := newTestClient(t)
+	ctx := context.Background()
+
+	sent := emailPayload{To: "ops@example.com", Subject: "report"}
+	id, err := client.EnqueuePayload(ctx, c, jobqueue.TypeTimeCritical, sent)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	job, received, err := client.DequeuePayload[emailPayload](ctx, c, 3, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if job.ID != id {
+		t.Errorf("expected job ID %d, got %d", id, job.ID)
+	}
+
+	if received != sent {
+		t.Errorf("expected payload %+v, got %+v", sent, received)
+	}
+
+	if err := c.Conclude(ctx, id); err != nil {
+		t.Errorf("expected conclude to succeed, got %v", err)
+	}
+}
+
+func TestClient_ErrorsMatchEngineErrors(t *testing.T) {
+	t.Parallel()
+	c := newTestClient(t)
+	ctx := context.Background()
+
+	_, err := c.Job(ctx, 42)
+	var apiErr *client.APIError
+	if !errors.As(err, &apiErr) || !errors.Is(err, jobqueue.ErrNotFound) {
+		t.Errorf("expected not found API error, got %v", err)
+	}
+
+	if _, err := c.Dequeue(ctx, 1, 0); !errors.Is(err, jobqueue.ErrNoJob) {
+		t.Errorf("expected error %v, got %v", jobqueue.ErrNoJob, err)
+	}
+
+	id, err := c.Enqueue(ctx, jobqueue.Job{Type: jobqueue.TypeTimeCritical, Status: jobqueue.StatusQueued})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Cancel(ctx, id)
+	if err := c.Retry(ctx, id); !errors.Is(err, jobqueue.ErrCancelled) {
+		t.Errorf("expected error %v, got %v", jobqueue.ErrCancelled, err)
+	}
+}
+
+func TestClient_ResultWaitsForConclude(t *testing.T) {
+	t.Parallel()
+	c := newTestClient(t)
+	ctx := context.Background()
+
+	id, err := c.Enqueue(ctx, jobqueue.Job{Type: jobqueue.TypeTimeCritical, Status: jobqueue.StatusQueued})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Result(ctx, id, 20*time.Millisecond); !errors.Is(err, jobqueue.ErrNotDone) {
+		t.Errorf("expected error %v before the job is concluded, got %v", jobqueue.ErrNotDone, err)
+	}
+
+	if _, err := c.Dequeue(ctx, 5, 0); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		c.ConcludeWithResult(ctx, id, "sent")
+	}()
+	job, err := c.Result(ctx, id, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != jobqueue.StatusConcluded || job.Result != "sent" {
+		t.Errorf("expected the concluded job with its result, got %+v", job)
+	}
+
+	if _, err := c.Result(ctx, id+1, time.Second); !errors.Is(err, jobqueue.ErrNotFound) {
+		t.Errorf("expected error %v, got %v", jobqueue.ErrNotFound, err)
+	}
+}
@@ -0,0 +1,344 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/varungujarathi9/job-queue/internal/cluster"
+	"github.com/varungujarathi9/job-queue/internal/handlers"
+	"github.com/varungujarathi9/job-queue/pkg/client"
+	"github.com/varungujarathi9/job-queue/pkg/jobqueue"
+)
+
+// newTestCluster starts a node for each of the IDs, all of them members of one cluster,
+// and returns their base URLs by node ID
+func newTestCluster(t *testing.T, opts handlers.Options, ids ...int) map[int]string {
+	servers := map[int]*httptest.Server{}
+	nodes := map[int]string{}
+	for _, id := range ids {
+		server := httptest.NewUnstartedServer(nil)
+		servers[id] = server
+		nodes[id] = "http://" + server.Listener.Addr().String()
+	}
+	for id, server := range servers {
+		// every node changes its own member list as nodes join and leave
+		peers := map[int]string{}
+		for peer, base := range nodes {
+			peers[peer] = base
+		}
+		nodeOpts := opts
+		nodeOpts.Node, nodeOpts.Peers = id, peers
+		router, err := handlers.NewRouter(nodeOpts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		server.Config.Handler = router
+		server.Start()
+		t.Cleanup(server.Close)
+	}
+	return nodes
+}
+
+// keyOwnedBy returns a shard key that the ring over members assigns to node
+func keyOwnedBy(t *testing.T, node int, members ...int) string {
+	ring := cluster.NewRing(members)
+	for i := 0; i < 1000; i++ {
+		key := "key-" + strconv.Itoa(i)
+		if owner, _ := ring.Owner(key); owner == node {
+			return key
+		}
+	}
+	t.Fatalf("no key owned by node %d", node)
+	return ""
+}
+
+func TestCluster_DequeueWaitSpansNodes(t *testing.T) {
+	t.Parallel()
+	nodes := newTestCluster(t, handlers.Options{MaxWait: time.Second}, 1, 2)
+	first, second := client.New(nodes[1]), client.New(nodes[2])
+	ctx := context.Background()
+
+	// a miss waits for the capped wait once, not once per node
+	start := time.Now()
+	if _, err := first.Dequeue(ctx, 1, 5*time.Second); !errors.Is(err, jobqueue.ErrNoJob) {
+		t.Fatalf("expected error %v, got %v", jobqueue.ErrNoJob, err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second || elapsed > 1600*time.Millisecond {
+		t.Errorf("expected the miss to take about 1s, took %v", elapsed)
+	}
+
+	// a job enqueued on the other node while the dequeue waits is handed out
+	key := keyOwnedBy(t, 2, 1, 2)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		second.Enqueue(ctx, jobqueue.Job{Type: jobqueue.TypeTimeCritical, Status: jobqueue.StatusQueued, Key: key})
+	}()
+	job, err := first.Dequeue(ctx, 1, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Key != key || job.ID%cluster.MaxNodes != 2 {
+		t.Errorf("expected the job enqueued on node 2, got %+v", job)
+	}
+}
+
+func TestCluster_DequeueWaitsOnAllNodesAtOnce(t *testing.T) {
+	t.Parallel()
+	nodes := newTestCluster(t, handlers.Options{MaxWait: 3 * time.Second}, 1, 2, 3)
+	ctx := context.Background()
+
+	// the job arriving on the last node is handed out right away, not after the other nodes waited
+	key := keyOwnedBy(t, 3, 1, 2, 3)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		client.New(nodes[3]).Enqueue(ctx, jobqueue.Job{Type: jobqueue.TypeTimeCritical, Status: jobqueue.StatusQueued, Key: key})
+	}()
+	start := time.Now()
+	job, err := client.New(nodes[1]).Dequeue(ctx, 1, 3*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); job.Key != key || elapsed > time.Second {
+		t.Errorf("expected the job of node 3 within 1s, got %+v after %v", job, elapsed)
+	}
+
+	// the other nodes stopped waiting, so a job enqueued now stays queued
+	id, err := client.New(nodes[2]).Enqueue(ctx, jobqueue.Job{Type: jobqueue.TypeTimeCritical, Status: jobqueue.StatusQueued, Key: keyOwnedBy(t, 2, 1, 2, 3)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if job, err := client.New(nodes[2]).Job(ctx, id); err != nil || job.Status != jobqueue.StatusQueued {
+		t.Errorf("expected job %d to stay queued, got %+v %v", id, job, err)
+	}
+}
+
+func TestCluster_ReleaseRequeuesWonJob(t *testing.T) {
+	t.Parallel()
+	nodes := newTestCluster(t, handlers.Options{}, 1, 2)
+	c := client.New(nodes[1])
+	ctx := context.Background()
+	id, err := c.Enqueue(ctx, jobqueue.Job{Type: jobqueue.TypeTimeCritical, Status: jobqueue.StatusQueued})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Dequeue(ctx, 4, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// only the consumer that won the job can have it released
+	if status := clusterRequest(t, http.MethodPost, nodes[1]+"/cluster/release", cluster.Release{JobID: id, Consumer: 5}, nil, nil); status != http.StatusConflict {
+		t.Errorf("expected status %d for another consumer, got %d", http.StatusConflict, status)
+	}
+	if status := clusterRequest(t, http.MethodPost, nodes[1]+"/cluster/release", cluster.Release{JobID: id, Consumer: 4}, nil, nil); status != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, status)
+	}
+	if job, err := c.Job(ctx, id); err != nil || job.Status != jobqueue.StatusQueued {
+		t.Errorf("expected job %d queued again, got %+v %v", id, job, err)
+	}
+}
+
+// clusterRequest sends a request to a node and returns the status it answered with
+func clusterRequest(t *testing.T, method, target string, body interface{}, header http.Header, out interface{}) int {
+	t.Helper()
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req, err := http.NewRequest(method, target, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, values := range header {
+		req.Header.Set(key, values[0])
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if out != nil && resp.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatalf("%s %s: %v", method, target, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+// waitForJob polls a node until it stores the job locally
+func waitForJob(t *testing.T, base string, id int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		var jobs []jobqueue.Job
+		clusterRequest(t, http.MethodGet, base+"/jobs", nil, nil, &jobs)
+		for _, job := range jobs {
+			if job.ID == id {
+				return
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected job %d on %s", id, base)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestCluster_RingOnlyMovesKeysOfChangedNode(t *testing.T) {
+	t.Parallel()
+	before, after := cluster.NewRing([]int{1, 2, 3}), cluster.NewRing([]int{1, 2})
+	owners := map[int]int{}
+	for i := 0; i < 1000; i++ {
+		key := "key-" + strconv.Itoa(i)
+		owner, _ := before.Owner(key)
+		owners[owner]++
+		moved, _ := after.Owner(key)
+		if owner != 3 && moved != owner {
+			t.Fatalf("expected key %s to stay on node %d, moved to %d", key, owner, moved)
+		}
+	}
+	for node := 1; node <= 3; node++ {
+		if owners[node] < 150 {
+			t.Errorf("expected node %d to own a fair share of the keys, got %v", node, owners)
+		}
+	}
+	if _, ok := cluster.NewRing(nil).Owner("key"); ok {
+		t.Error("expected an empty ring to own no key")
+	}
+}
+
+func TestCluster_ForwardsToOwner(t *testing.T) {
+	t.Parallel()
+	nodes := newTestCluster(t, handlers.Options{PeerToken: "peer-secret"}, 1, 2)
+	job := jobqueue.Job{Type: jobqueue.TypeTimeCritical, Status: jobqueue.StatusQueued, Key: keyOwnedBy(t, 2, 1, 2)}
+
+	// a client cannot skip the routing by claiming to be a node
+	var created struct {
+		ID int `json:"id"`
+	}
+	spoofed := http.Header{cluster.ForwardedHeader: {"2"}, cluster.PeerHeader: {"guess"}}
+	if status := clusterRequest(t, http.MethodPost, nodes[1]+"/jobs/enqueue", job, spoofed, &created); status != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, status)
+	}
+	if created.ID%cluster.MaxNodes != 2 {
+		t.Errorf("expected the job to be created on node 2, got ID %d", created.ID)
+	}
+
+	// either node answers for the job, and a forwarded request only looks locally
+	var found jobqueue.Job
+	if status := clusterRequest(t, http.MethodGet, nodes[1]+"/jobs/"+strconv.Itoa(created.ID), nil, nil, &found); status != http.StatusOK || found.Key != job.Key {
+		t.Errorf("expected the job through node 1, got %d %+v", status, found)
+	}
+	forwarded := http.Header{cluster.ForwardedHeader: {"2"}, cluster.PeerHeader: {"peer-secret"}}
+	if status := clusterRequest(t, http.MethodGet, nodes[1]+"/jobs/"+strconv.Itoa(created.ID), nil, forwarded, nil); status != http.StatusNotFound {
+		t.Errorf("expected status %d for a forwarded request, got %d", http.StatusNotFound, status)
+	}
+}
+
+func TestCluster_LeaveMovesQueuedJobs(t *testing.T) {
+	t.Parallel()
+	nodes := newTestCluster(t, handlers.Options{}, 1, 2, 3)
+	c := client.New(nodes[3])
+	ctx := context.Background()
+
+	// jobs without a key stay on node 3 until it leaves
+	id, err := c.Enqueue(ctx, jobqueue.Job{Type: jobqueue.TypeTimeCritical, Status: jobqueue.StatusQueued})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := clusterRequest(t, http.MethodDelete, nodes[1]+"/cluster/members/3", nil, nil, nil); status != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, status)
+	}
+	owner, _ := cluster.NewRing([]int{1, 2}).Owner(strconv.Itoa(id))
+	waitForJob(t, nodes[owner], id)
+
+	// the node that left points at the new node, the others look for the job
+	for _, base := range nodes {
+		if job, err := client.New(base).Job(ctx, id); err != nil || job.Status != jobqueue.StatusQueued {
+			t.Errorf("expected the queued job through %s, got %+v %v", base, job, err)
+		}
+	}
+	if status := clusterRequest(t, http.MethodDelete, nodes[1]+"/cluster/members/9", nil, nil, nil); status != http.StatusNotFound {
+		t.Errorf("expected status %d for an unknown node, got %d", http.StatusNotFound, status)
+	}
+}
+
+func TestCluster_ImportRefusesIDInUse(t *testing.T) {
+	t.Parallel()
+	nodes := newTestCluster(t, handlers.Options{}, 1, 2)
+	id, err := client.New(nodes[1]).Enqueue(context.Background(), jobqueue.Job{Type: jobqueue.TypeTimeCritical, Status: jobqueue.StatusQueued})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, imported := range []int{id, id + 10*cluster.MaxNodes} {
+		job := jobqueue.Job{ID: imported, Type: jobqueue.TypeTimeCritical, Status: jobqueue.StatusQueued}
+		if status := clusterRequest(t, http.MethodPost, nodes[1]+"/cluster/import", job, nil, nil); status != http.StatusConflict {
+			t.Errorf("expected status %d importing job %d, got %d", http.StatusConflict, imported, status)
+		}
+	}
+	job := jobqueue.Job{ID: 2 + 10*cluster.MaxNodes, Type: jobqueue.TypeTimeCritical, Status: jobqueue.StatusQueued}
+	if status := clusterRequest(t, http.MethodPost, nodes[1]+"/cluster/import", job, nil, nil); status != http.StatusOK {
+		t.Errorf("expected status %d importing a job of node 2, got %d", http.StatusOK, status)
+	}
+}
+
+func TestCluster_RebalanceMovesJobOnce(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name string
+		// answers are the statuses of the node the job moves to, zero answers too late
+		answers []int
+		moved   bool
+	}{
+		{name: "applied", answers: []int{http.StatusOK}, moved: true},
+		{name: "already held", answers: []int{http.StatusConflict}, moved: true},
+		{name: "answer lost", answers: []int{0, http.StatusConflict}, moved: true},
+		{name: "refused", answers: []int{http.StatusInternalServerError}, moved: false},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			var calls atomic.Int32
+			peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				status := tt.answers[calls.Add(1)-1]
+				if status == 0 {
+					time.Sleep(100 * time.Millisecond)
+					status = http.StatusOK
+				}
+				w.WriteHeader(status)
+			}))
+			t.Cleanup(peer.Close)
+
+			engine := jobqueue.New(cluster.IDSpace(1))
+			c, err := cluster.New(1, map[int]string{1: "http://127.0.0.1:1", 2: peer.URL}, engine,
+				cluster.WithHTTPClient(&http.Client{Timeout: 50 * time.Millisecond}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			id, err := engine.Enqueue(jobqueue.Job{Type: jobqueue.TypeTimeCritical, Status: jobqueue.StatusQueued, Key: keyOwnedBy(t, 2, 1, 2)})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			c.Rebalance()
+			if int(calls.Load()) != len(tt.answers) {
+				t.Errorf("expected %d transfers, got %d", len(tt.answers), calls.Load())
+			}
+			if _, err := engine.Job(id); errors.Is(err, jobqueue.ErrNotFound) != tt.moved {
+				t.Errorf("expected the job moved %v, got %v", tt.moved, err)
+			}
+		})
+	}
+}
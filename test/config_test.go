@@ -0,0 +1,108 @@
+package test
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/varungujarathi9/job-queue/internal/config"
+)
+
+func writeConfig(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestConfig_Precedence(t *testing.T) {
+	t.Parallel()
+	path := writeConfig(t, "job-queue.yaml", `
+addr: file:8080
+log:
+  level: info
+  format: text
+timeouts:
+  dequeue: 45s
+limits:
+  max_queue_depth: 10
+`)
+	env := map[string]string{
+		"JQ_CONFIG":          path,
+		"JQ_ADDR":            "env:8080",
+		"JQ_LOG_LEVEL":       "debug",
+		"JQ_MAX_QUEUE_DEPTH": "20",
+	}
+
+	cfg, err := config.Load("job-queue", []string{"-addr", "flag:8080"}, func(key string) string { return env[key] }, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Addr != "flag:8080" {
+		t.Errorf("expected flag to win, got addr %s", cfg.Addr)
+	}
+	if cfg.Log.Level != "debug" || cfg.Limits.MaxQueueDepth != 20 {
+		t.Errorf("expected environment to win over the file, got level %s and depth %d", cfg.Log.Level, cfg.Limits.MaxQueueDepth)
+	}
+	if cfg.Log.Format != "text" || cfg.Timeouts.Dequeue != 45*time.Second {
+		t.Errorf("expected file values, got format %s and dequeue timeout %s", cfg.Log.Format, cfg.Timeouts.Dequeue)
+	}
+	if cfg.Timeouts.Enqueue != 60*time.Second {
+		t.Errorf("expected default enqueue timeout, got %s", cfg.Timeouts.Enqueue)
+	}
+}
+
+func TestConfig_JSONFile(t *testing.T) {
+	t.Parallel()
+	path := writeConfig(t, "job-queue.json", `{"storage": {"backend": "file", "path": "jobs.journal"}, "cluster": {"node": 1, "peers": "1=http://a:8080,2=http://b:8080"}}`)
+
+	cfg, err := config.Load("job-queue", []string{"-config", path}, func(string) string { return "" }, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Storage.Backend != "file" || cfg.Storage.Path != "jobs.journal" {
+		t.Errorf("expected file storage at jobs.journal, got %s at %s", cfg.Storage.Backend, cfg.Storage.Path)
+	}
+	if len(cfg.Peers()) != 2 {
+		t.Errorf("expected %d peers, got %d", 2, len(cfg.Peers()))
+	}
+}
+
+func TestConfig_Invalid(t *testing.T) {
+	t.Parallel()
+	unknownKey := writeConfig(t, "unknown.yaml", "adress: localhost:8080\n")
+	unsigned := writeConfig(t, "unsigned.yaml", "webhooks:\n  subscriptions:\n    - url: https://example.com/jobs\n")
+	anonymous := writeConfig(t, "anonymous.yaml", "push:\n  endpoints:\n    - url: https://example.com/jobs\n      types: [TIME_CRITICAL]\n")
+
+	tests := []struct {
+		args []string
+		env  map[string]string
+		want string
+	}{
+		{args: []string{"-log-level", "loud"}, want: `log level "loud"`},
+		{args: []string{"-log-output", "syslog"}, want: `log output "syslog"`},
+		{args: []string{"-log-max-backups", "-1"}, want: "log rotation settings must not be negative"},
+		{args: []string{"-storage", "s3"}, want: `storage backend "s3"`},
+		{args: []string{"-dequeue-timeout", "0s"}, want: "dequeue timeout must be positive"},
+		{args: []string{"-write-timeout", "10s"}, want: "write timeout 10s must be longer than max wait 1m0s"},
+		{args: []string{"-node", "3", "-peers", "1=http://a:8080"}, want: "node 3 is not one of the peers"},
+		{args: []string{"-auth", "-auth-token-secret", strings.Repeat("s", 32), "-node", "1", "-peers", "1=http://a:8080,2=http://b:8080"}, want: "a cluster with auth needs a peer token"},
+		{env: map[string]string{"JQ_MAX_WAIT": "forever"}, want: `invalid JQ_MAX_WAIT "forever"`},
+		{args: []string{"-config", unknownKey}, want: "field adress not found"},
+		{args: []string{"-webhook-secret", "short"}, want: "webhook secret must be at least 32 bytes"},
+		{args: []string{"-config", unsigned}, want: "webhook subscriptions need a webhook secret"},
+		{args: []string{"-push-max-attempts", "0"}, want: "push max attempts, backoff and timeout must be positive"},
+		{args: []string{"-config", anonymous}, want: "push endpoint 1: endpoint https://example.com/jobs needs a positive consumer ID"},
+	}
+	for _, tt := range tests {
+		_, err := config.Load("job-queue", tt.args, func(key string) string { return tt.env[key] }, io.Discard)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("expected error containing %q, got %v", tt.want, err)
+		}
+	}
+}
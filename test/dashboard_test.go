@@ -0,0 +1,172 @@
+package test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/varungujarathi9/job-queue/internal/handlers"
+	"github.com/varungujarathi9/job-queue/pkg/client"
+	"github.com/varungujarathi9/job-queue/pkg/jobqueue"
+)
+
+func TestDashboard_ServesEmbeddedFiles(t *testing.T) {
+	t.Parallel()
+	router, err := handlers.NewRouter(handlers.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/dashboard", nil))
+	if rr.Code != http.StatusMovedPermanently || rr.Header().Get("Location") != "/dashboard/" {
+		t.Errorf("expected a redirect to /dashboard/, got %d to %q", rr.Code, rr.Header().Get("Location"))
+	}
+
+	for path, want := range map[string]string{
+		"/dashboard/":          "<title>Job Queue</title>",
+		"/dashboard/app.js":    "new EventSource(",
+		"/dashboard/style.css": "#depth",
+	} {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+		if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), want) {
+			t.Errorf("expected %s to serve %q, got %d", path, want, rr.Code)
+		}
+	}
+}
+
+func TestDashboard_HistoryAndRedrive(t *testing.T) {
+	t.Parallel()
+	router, err := handlers.NewRouter(handlers.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+
+	ctx := context.Background()
+	c := client.New(server.URL)
+	id, err := c.Enqueue(ctx, jobqueue.Job{Type: jobqueue.TypeTimeCritical, Status: jobqueue.StatusQueued, Payload: "report"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Redrive(ctx, id); !errors.Is(err, jobqueue.ErrNotDone) {
+		t.Errorf("expected a queued job not to be re-driven, got %v", err)
+	}
+	if _, err := c.Dequeue(ctx, 1, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Conclude(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+
+	history, err := c.History(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ops []string
+	for _, change := range history {
+		ops = append(ops, change.Op)
+	}
+	if got := strings.Join(ops, ","); got != "ENQUEUE,DEQUEUE,CONCLUDE" {
+		t.Errorf("expected history ENQUEUE,DEQUEUE,CONCLUDE, got %s", got)
+	}
+
+	copyID, err := c.Redrive(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	job, err := c.Job(ctx, copyID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if copyID == id || job.Status != jobqueue.StatusQueued || job.Payload != "report" {
+		t.Errorf("expected a queued copy of job %d, got %+v", id, job)
+	}
+	if _, err := c.History(ctx, 999); !errors.Is(err, jobqueue.ErrNotFound) {
+		t.Errorf("expected the history of an unknown job to be not found, got %v", err)
+	}
+}
+
+func TestDashboard_EventStream(t *testing.T) {
+	t.Parallel()
+	router, err := handlers.NewRouter(handlers.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c := client.New(server.URL)
+	// changes made before the stream is opened are not replayed
+	if _, err := c.Enqueue(ctx, jobqueue.Job{Type: jobqueue.TypeTimeCritical, Status: jobqueue.StatusQueued}); err != nil {
+		t.Fatal(err)
+	}
+
+	req, _ := http.NewRequestWithContext(ctx, "GET", server.URL+"/events", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("expected an event stream, got %s", resp.Header.Get("Content-Type"))
+	}
+
+	id, err := c.Enqueue(ctx, jobqueue.Job{Type: jobqueue.TypeNotTimeCritical, Status: jobqueue.StatusQueued})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Cancel(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+
+	reader := bufio.NewReader(resp.Body)
+	for _, want := range []string{jobqueue.OpEnqueue, jobqueue.OpCancel} {
+		change := readEvent(t, reader)
+		if change.Op != want || change.Job.ID != id {
+			t.Errorf("expected %s of job %d, got %s of job %d", want, id, change.Op, change.Job.ID)
+		}
+	}
+}
+
+// readEvent reads the next change event of a server-sent event stream
+func readEvent(t *testing.T, reader *bufio.Reader) jobqueue.Change {
+	t.Helper()
+	var event, data string
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			t.Fatal(err)
+		}
+		line = strings.TrimRight(line, "\n")
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		case line == "" && data != "":
+			if event != "change" {
+				t.Fatalf("expected a change event, got %q", event)
+			}
+			var change jobqueue.Change
+			if err := json.Unmarshal([]byte(data), &change); err != nil {
+				t.Fatal(err)
+			}
+			return change
+		}
+		if err == io.EOF {
+			t.Fatal("event stream ended")
+		}
+	}
+}
@@ -0,0 +1,306 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/varungujarathi9/job-queue/pkg/jobqueue"
+)
+
+func TestEngine_DequeueWaitsForEnqueue(t *testing.T) {
+	t.Parallel()
+	engine := jobqueue.New()
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		engine.Enqueue(jobqueue.Job{Type: jobqueue.TypeTimeCritical, Status: jobqueue.StatusQueued})
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	job, err := engine.Dequeue(ctx, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if job.ID != 1 {
+		t.Errorf("expected job ID %d, got %d", 1, job.ID)
+	}
+
+	if job.ConsumedBy != 7 {
+		t.Errorf("expected job consumed by %d, got %d", 7, job.ConsumedBy)
+	}
+}
+
+func TestEngine_DequeueContextDone(t *testing.T) {
+	t.Parallel()
+	engine := jobqueue.New()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err := engine.Dequeue(ctx, 1)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected error %v, got %v", context.DeadlineExceeded, err)
+	}
+}
+
+func TestEngine_WaitDone(t *testing.T) {
+	t.Parallel()
+	engine := jobqueue.New()
+	id, _ := engine.Enqueue(jobqueue.Job{Type: jobqueue.TypeTimeCritical, Status: jobqueue.StatusQueued})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if job, err := engine.WaitDone(ctx, id); !errors.Is(err, jobqueue.ErrNotDone) || job.Status != jobqueue.StatusQueued {
+		t.Errorf("expected the queued job with error %v, got %+v %v", jobqueue.ErrNotDone, job, err)
+	}
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		engine.Cancel(id)
+	}()
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	job, err := engine.WaitDone(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !job.Cancel {
+		t.Errorf("expected the cancelled job, got %+v", job)
+	}
+}
+
+func TestEngine_TypedErrors(t *testing.T) {
+	t.Parallel()
+	engine := jobqueue.New()
+
+	if _, err := engine.Enqueue(jobqueue.Job{Type: "UNKNOWN", Status: jobqueue.StatusQueued}); !errors.Is(err, jobqueue.ErrInvalidType) {
+		t.Errorf("expected error %v, got %v", jobqueue.ErrInvalidType, err)
+	}
+
+	if _, err := engine.TryDequeue(1); !errors.Is(err, jobqueue.ErrNoJob) {
+		t.Errorf("expected error %v, got %v", jobqueue.ErrNoJob, err)
+	}
+
+	id, err := engine.Enqueue(jobqueue.Job{Type: jobqueue.TypeTimeCritical, Status: jobqueue.StatusQueued})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := engine.Conclude(id); !errors.Is(err, jobqueue.ErrNotDequeued) {
+		t.Errorf("expected error %v, got %v", jobqueue.ErrNotDequeued, err)
+	}
+
+	engine.Cancel(id)
+	err = engine.Retry(id)
+	var jobErr *jobqueue.JobError
+	if !errors.As(err, &jobErr) || !errors.Is(err, jobqueue.ErrCancelled) || jobErr.ID != id {
+		t.Errorf("expected cancelled job error for job %d, got %v", id, err)
+	}
+}
+
+func TestEngine_MaxDepth(t *testing.T) {
+	t.Parallel()
+	engine := jobqueue.New(jobqueue.WithMaxDepth(1))
+
+	if _, err := engine.Enqueue(jobqueue.Job{Type: jobqueue.TypeTimeCritical, Status: jobqueue.StatusQueued}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := engine.Enqueue(jobqueue.Job{Type: jobqueue.TypeTimeCritical, Status: jobqueue.StatusQueued}); !errors.Is(err, jobqueue.ErrQueueFull) {
+		t.Errorf("expected error %v, got %v", jobqueue.ErrQueueFull, err)
+	}
+
+	// a dequeued job no longer counts against the limit
+	if _, err := engine.TryDequeue(1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := engine.Enqueue(jobqueue.Job{Type: jobqueue.TypeTimeCritical, Status: jobqueue.StatusQueued}); err != nil {
+		t.Errorf("expected enqueue to succeed, got %v", err)
+	}
+}
+
+func TestEngine_EnqueueTimeoutExpires(t *testing.T) {
+	t.Parallel()
+	engine := jobqueue.New(jobqueue.WithMaxDepth(1), jobqueue.WithEnqueueTimeout(10*time.Millisecond))
+	id, _ := engine.Enqueue(jobqueue.Job{Type: jobqueue.TypeTimeCritical, Status: jobqueue.StatusQueued})
+
+	// the wait expires the job by itself, nothing else uses the engine meanwhile
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	job, err := engine.WaitDone(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != jobqueue.StatusExpired {
+		t.Errorf("expected status %s, got %s", jobqueue.StatusExpired, job.Status)
+	}
+	if history, _ := engine.History(id); history[len(history)-1].Op != jobqueue.OpExpire {
+		t.Errorf("expected the last change to be %s, got %+v", jobqueue.OpExpire, history)
+	}
+
+	// an expired job no longer counts against the limit
+	next, err := engine.Enqueue(jobqueue.Job{Type: jobqueue.TypeTimeCritical, Status: jobqueue.StatusQueued})
+	if err != nil {
+		t.Fatalf("expected enqueue to succeed, got %v", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if _, err := engine.Enqueue(jobqueue.Job{Type: jobqueue.TypeTimeCritical, Status: jobqueue.StatusQueued}); err != nil {
+		t.Errorf("expected enqueue to succeed, got %v", err)
+	}
+	if job, _ := engine.Job(next); job.Status != jobqueue.StatusExpired {
+		t.Errorf("expected status %s, got %s", jobqueue.StatusExpired, job.Status)
+	}
+	if err := engine.Retry(id); err != nil {
+		t.Errorf("expected an expired job to be retried, got %v", err)
+	}
+}
+
+func TestEngine_ConcludeNeedsJobInProgress(t *testing.T) {
+	t.Parallel()
+	engine := jobqueue.New(jobqueue.WithEnqueueTimeout(10 * time.Millisecond))
+	failed, _ := engine.Enqueue(jobqueue.Job{Type: jobqueue.TypeTimeCritical, Status: jobqueue.StatusQueued})
+	engine.TryDequeue(1)
+	engine.Fail(failed, 1, "timeout")
+	expired, _ := engine.Enqueue(jobqueue.Job{Type: jobqueue.TypeTimeCritical, Status: jobqueue.StatusQueued})
+	time.Sleep(20 * time.Millisecond)
+
+	// neither the consumer that failed the job nor a caller without a consumer may conclude it
+	for _, tt := range []struct {
+		id, consumer int
+		status       string
+	}{{failed, 1, jobqueue.StatusFailed}, {failed, 0, jobqueue.StatusFailed}, {expired, 0, jobqueue.StatusExpired}} {
+		if err := engine.ConcludeAs(tt.id, tt.consumer, "done"); !errors.Is(err, jobqueue.ErrNotInProgress) {
+			t.Errorf("expected error %v concluding job %d as %d, got %v", jobqueue.ErrNotInProgress, tt.id, tt.consumer, err)
+		}
+		if job, _ := engine.Job(tt.id); job.Status != tt.status || job.Result != nil {
+			t.Errorf("expected job %d to stay %s, got %+v", tt.id, tt.status, job)
+		}
+	}
+}
+
+func TestEngine_Drain(t *testing.T) {
+	t.Parallel()
+	engine := jobqueue.New()
+	for i := 0; i < 2; i++ {
+		if _, err := engine.Enqueue(jobqueue.Job{Type: jobqueue.TypeTimeCritical, Status: jobqueue.StatusQueued}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	job, err := engine.TryDequeue(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a blocked dequeue is released as soon as the engine drains
+	released := make(chan error, 1)
+	go func() {
+		_, err := engine.Dequeue(context.Background(), 2, "UNKNOWN")
+		released <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	engine.Drain()
+	select {
+	case err := <-released:
+		if !errors.Is(err, jobqueue.ErrDraining) {
+			t.Errorf("expected error %v, got %v", jobqueue.ErrDraining, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the blocked dequeue to be released")
+	}
+
+	if _, err := engine.Enqueue(jobqueue.Job{Type: jobqueue.TypeTimeCritical, Status: jobqueue.StatusQueued}); !errors.Is(err, jobqueue.ErrDraining) {
+		t.Errorf("expected error %v, got %v", jobqueue.ErrDraining, err)
+	}
+	if _, err := engine.TryDequeue(2); !errors.Is(err, jobqueue.ErrDraining) {
+		t.Errorf("expected error %v, got %v", jobqueue.ErrDraining, err)
+	}
+
+	// the job in progress can still be reported on, then it is queued again
+	if err := engine.Heartbeat(job.ID, 1); err != nil {
+		t.Errorf("expected heartbeat to succeed, got %v", err)
+	}
+	if requeued := engine.RequeueInProgress(); requeued != 1 {
+		t.Errorf("expected %d requeued job, got %d", 1, requeued)
+	}
+	if engine.InProgress() != 0 {
+		t.Errorf("expected no job in progress, got %d", engine.InProgress())
+	}
+
+	engine.Resume()
+	if _, err := engine.TryDequeue(2); err != nil {
+		t.Errorf("expected dequeue to succeed after resume, got %v", err)
+	}
+}
+
+func TestEngine_DequeueMatchingQueue(t *testing.T) {
+	t.Parallel()
+	engine := jobqueue.New()
+	engine.Enqueue(jobqueue.Job{Type: jobqueue.TypeTimeCritical, Status: jobqueue.StatusQueued})
+	engine.Enqueue(jobqueue.Job{Type: jobqueue.TypeTimeCritical, Status: jobqueue.StatusQueued, Queue: "emails"})
+
+	emails := "emails"
+	job, err := engine.TryDequeueMatching(1, jobqueue.Filter{Queue: &emails})
+	if err != nil || job.ID != 2 {
+		t.Errorf("expected job 2 from the emails queue, got %d, %v", job.ID, err)
+	}
+	if _, err := engine.TryDequeueMatching(1, jobqueue.Filter{Queue: &emails}); !errors.Is(err, jobqueue.ErrNoJob) {
+		t.Errorf("expected error %v, got %v", jobqueue.ErrNoJob, err)
+	}
+}
+
+func TestEngine_Attempts(t *testing.T) {
+	t.Parallel()
+	engine := jobqueue.New()
+	id, _ := engine.Enqueue(jobqueue.Job{Type: jobqueue.TypeTimeCritical, Status: jobqueue.StatusQueued})
+
+	engine.TryDequeue(1)
+	engine.Fail(id, 1, "timeout")
+	engine.Retry(id)
+	engine.TryDequeue(2)
+
+	attempts, err := engine.Attempts(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(attempts) != 2 {
+		t.Fatalf("expected 2 attempts, got %+v", attempts)
+	}
+	if first := attempts[0]; first.Consumer != 1 || first.Outcome != jobqueue.OutcomeFailed || first.Error != "timeout" || first.Ended == nil {
+		t.Errorf("expected a failed first attempt by consumer 1, got %+v", first)
+	}
+	if second := attempts[1]; second.Number != 2 || second.Consumer != 2 || second.Outcome != jobqueue.OutcomeInProgress || second.Ended != nil {
+		t.Errorf("expected a second attempt in progress by consumer 2, got %+v", second)
+	}
+	if _, err := engine.Attempts(99); !errors.Is(err, jobqueue.ErrNotFound) {
+		t.Errorf("expected error %v, got %v", jobqueue.ErrNotFound, err)
+	}
+}
+
+func TestEngine_CompactsChanges(t *testing.T) {
+	t.Parallel()
+	engine := jobqueue.New(jobqueue.WithMaxChanges(2))
+	first, _ := engine.Enqueue(jobqueue.Job{Type: jobqueue.TypeTimeCritical, Status: jobqueue.StatusQueued})
+	engine.TryDequeue(1)
+	engine.Conclude(first)
+	second, _ := engine.Enqueue(jobqueue.Job{Type: jobqueue.TypeTimeCritical, Status: jobqueue.StatusQueued})
+
+	// the changes older than the newest two only keep the last one of each job
+	seq, changes := engine.Changes(0, 100)
+	if seq != 4 || len(changes) != 2 {
+		t.Fatalf("expected 2 changes up to 4, got %d %+v", seq, changes)
+	}
+	if history, _ := engine.History(first); len(history) != 1 || history[0].Op != jobqueue.OpConclude {
+		t.Errorf("expected only the conclude in the history, got %+v", history)
+	}
+
+	// replaying the compacted stream still rebuilds every job
+	restored := jobqueue.New()
+	restored.Restore(changes)
+	if job, err := restored.Job(first); err != nil || job.Status != jobqueue.StatusConcluded || job.Attempts != 1 {
+		t.Errorf("expected job %d concluded after 1 attempt, got %+v %v", first, job, err)
+	}
+	if queued := restored.Queued(); len(queued) != 1 || queued[0].ID != second || restored.LastSeq() != 4 {
+		t.Errorf("expected job %d queued at change 4, got %+v at %d", second, queued, restored.LastSeq())
+	}
+}
@@ -0,0 +1,93 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/varungujarathi9/job-queue/internal/apierror"
+	"github.com/varungujarathi9/job-queue/internal/handlers"
+	"github.com/varungujarathi9/job-queue/pkg/client"
+	"github.com/varungujarathi9/job-queue/pkg/jobqueue"
+)
+
+func TestErrors_Envelope(t *testing.T) {
+	t.Parallel()
+	router, err := handlers.NewRouter(handlers.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		method string
+		target string
+		body   string
+		status int
+		code   string
+	}{
+		{"unknown job", "GET", "/jobs/42", "", http.StatusNotFound, apierror.CodeNotFound},
+		{"quote in the job ID", "PUT", `/jobs/a"b/conclude`, "", http.StatusBadRequest, apierror.CodeInvalidRequest},
+		{"missing fields", "POST", "/jobs/enqueue", `{"Type": "TIME_CRITICAL"}`, http.StatusUnprocessableEntity, apierror.CodeMissingFields},
+		{"invalid type", "POST", "/jobs/enqueue", `{"Type": "SOON", "Status": "QUEUED"}`, http.StatusUnprocessableEntity, apierror.CodeInvalidType},
+		{"malformed body", "POST", "/jobs/enqueue", `{"Type": "`, http.StatusBadRequest, apierror.CodeInvalidRequest},
+		{"unknown route", "GET", "/nowhere", "", http.StatusNotFound, apierror.CodeNotFound},
+		{"wrong method", "PUT", "/admin/drain", "", http.StatusMethodNotAllowed, apierror.CodeMethodNotAllowed},
+	} {
+		req := httptest.NewRequest(tc.method, tc.target, strings.NewReader(tc.body))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		if rr.Code != tc.status {
+			t.Errorf("%s: expected status code %d, got %d", tc.name, tc.status, rr.Code)
+		}
+		if got := rr.Header().Get("Content-Type"); got != "application/json" {
+			t.Errorf("%s: expected Content-Type application/json, got %q", tc.name, got)
+		}
+		var body apierror.Error
+		if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+			t.Errorf("%s: expected a JSON body, got %v", tc.name, err)
+			continue
+		}
+		if body.Code != tc.code || body.Message == "" {
+			t.Errorf("%s: expected code %s with a message, got %+v", tc.name, tc.code, body)
+		}
+	}
+}
+
+func TestErrors_ClientReadsCodeAndDetails(t *testing.T) {
+	t.Parallel()
+	c := newTestClient(t)
+	ctx := context.Background()
+
+	id, err := c.Enqueue(ctx, jobqueue.Job{Type: jobqueue.TypeTimeCritical, Status: jobqueue.StatusQueued})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.Conclude(ctx, id)
+	var apiErr *client.APIError
+	if !errors.As(err, &apiErr) || !errors.Is(err, jobqueue.ErrNotDequeued) {
+		t.Fatalf("expected a not dequeued API error, got %v", err)
+	}
+	if apiErr.StatusCode != http.StatusConflict || apiErr.Code != apierror.CodeJobNotDequeued || apiErr.Details["JobID"] != float64(id) {
+		t.Errorf("expected 409 %s for job %d, got %+v", apierror.CodeJobNotDequeued, id, apiErr)
+	}
+}
+
+func TestErrors_ClientReadsLegacyBodies(t *testing.T) {
+	t.Parallel()
+	// servers older than the error envelope answer with a status message and 400
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"status" : "No job available"}`, http.StatusBadRequest)
+	}))
+	t.Cleanup(server.Close)
+
+	_, err := client.New(server.URL).Dequeue(context.Background(), 1, 0)
+	if !errors.Is(err, jobqueue.ErrNoJob) {
+		t.Errorf("expected error %v, got %v", jobqueue.ErrNoJob, err)
+	}
+}
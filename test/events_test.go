@@ -0,0 +1,142 @@
+package test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/varungujarathi9/job-queue/internal/handlers"
+	"github.com/varungujarathi9/job-queue/pkg/client"
+	"github.com/varungujarathi9/job-queue/pkg/jobqueue"
+)
+
+func TestEvents_FiltersAndResume(t *testing.T) {
+	t.Parallel()
+	router, err := handlers.NewRouter(handlers.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	c := client.New(server.URL)
+	var ids []int
+	for _, job := range []jobqueue.Job{
+		{Type: jobqueue.TypeTimeCritical, Status: jobqueue.StatusQueued, Queue: "emails"},
+		{Type: jobqueue.TypeNotTimeCritical, Status: jobqueue.StatusQueued, Queue: "emails"},
+		{Type: jobqueue.TypeTimeCritical, Status: jobqueue.StatusQueued},
+	} {
+		id, err := c.Enqueue(ctx, job)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	if err := c.Cancel(ctx, ids[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	// the stream resumes after Last-Event-ID and only holds the changes passing every filter
+	req, _ := http.NewRequestWithContext(ctx, "GET", server.URL+"/events?queue=emails&type="+jobqueue.TypeTimeCritical+"&since=3", nil)
+	req.Header.Set("Last-Event-ID", "0")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	reader := bufio.NewReader(resp.Body)
+	for _, want := range []string{jobqueue.OpEnqueue, jobqueue.OpCancel} {
+		if change := readEvent(t, reader); change.Op != want || change.Job.ID != ids[0] {
+			t.Errorf("expected %s of job %d, got %s of job %d", want, ids[0], change.Op, change.Job.ID)
+		}
+	}
+
+	for _, target := range []string{"/events?since=soon", "/events?job_id=one"} {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", target, nil))
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected %d, got %d", target, http.StatusBadRequest, rr.Code)
+		}
+	}
+}
+
+func TestEvents_ClientResumesDroppedStream(t *testing.T) {
+	t.Parallel()
+	router, err := handlers.NewRouter(handlers.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// the first stream ends after sending the first change, the client opens it again after that change
+	var lastEventIDs []string
+	var firstChange []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/events" {
+			router.ServeHTTP(w, r)
+			return
+		}
+		lastEventIDs = append(lastEventIDs, r.Header.Get("Last-Event-ID"))
+		if len(lastEventIDs) == 1 {
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprintf(w, "id: 0\n\n: keep-alive\n\nid: 1\nevent: change\ndata: %s\n\n", firstChange)
+			return
+		}
+		router.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	c := client.New(server.URL, client.WithRetries(0, 10*time.Millisecond))
+	first, _ := c.Enqueue(ctx, jobqueue.Job{Type: jobqueue.TypeTimeCritical, Status: jobqueue.StatusQueued})
+	second, _ := c.Enqueue(ctx, jobqueue.Job{Type: jobqueue.TypeTimeCritical, Status: jobqueue.StatusQueued})
+	history, err := c.History(ctx, first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	firstChange, _ = json.Marshal(history[0])
+
+	var seen []int
+	done := errors.New("done")
+	err = c.Events(ctx, 0, client.EventFilter{Ops: []string{jobqueue.OpEnqueue}}, func(change jobqueue.Change) error {
+		seen = append(seen, change.Job.ID)
+		if len(seen) == 2 {
+			return done
+		}
+		return nil
+	})
+	if !errors.Is(err, done) {
+		t.Fatalf("expected the stream to end with the callback's error, got %v", err)
+	}
+	if len(seen) != 2 || seen[0] != first || seen[1] != second {
+		t.Errorf("expected jobs %d and %d once each, got %v", first, second, seen)
+	}
+	if len(lastEventIDs) != 2 || lastEventIDs[1] != "1" {
+		t.Errorf("expected the stream to be resumed after change 1, got %q", lastEventIDs)
+	}
+}
+
+func TestEvents_ClientRefused(t *testing.T) {
+	t.Parallel()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	t.Cleanup(server.Close)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// a refused stream is not opened again
+	err := client.New(server.URL).Events(ctx, -1, client.EventFilter{}, func(jobqueue.Change) error { return nil })
+	var apiErr *client.APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusForbidden {
+		t.Errorf("expected a 403 error, got %v", err)
+	}
+}
@@ -0,0 +1,82 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/varungujarathi9/job-queue/internal/handlers"
+	"github.com/varungujarathi9/job-queue/internal/health"
+)
+
+// getHealth requests a health endpoint of router
+func getHealth(router http.Handler, target string) *httptest.ResponseRecorder {
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", target, nil))
+	return rr
+}
+
+func TestHealth_ReadinessFollowsDrain(t *testing.T) {
+	router, err := handlers.NewRouter(handlers.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rr := getHealth(router, "/healthz"); rr.Code != http.StatusOK {
+		t.Errorf("expected liveness status %d, got %d", http.StatusOK, rr.Code)
+	}
+	if rr := getHealth(router, "/readyz"); rr.Code != http.StatusOK {
+		t.Errorf("expected readiness status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body)
+	}
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("POST", "/admin/drain", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("drain failed with status code %d", rr.Code)
+	}
+
+	rr = getHealth(router, "/readyz")
+	if rr.Code != http.StatusServiceUnavailable || !strings.Contains(rr.Body.String(), "not ready: drain") {
+		t.Errorf("expected a draining server not to be ready, got %d: %s", rr.Code, rr.Body)
+	}
+	// the process is still alive while it drains
+	if rr := getHealth(router, "/healthz"); rr.Code != http.StatusOK {
+		t.Errorf("expected liveness status %d, got %d", http.StatusOK, rr.Code)
+	}
+
+	var report health.Report
+	if err := json.NewDecoder(getHealth(router, "/readyz?verbose").Body).Decode(&report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Status != "fail" || len(report.Checks) != 1 || report.Checks[0].Name != "drain" || report.Checks[0].Latency == "" {
+		t.Errorf("unexpected verbose report %+v", report)
+	}
+}
+
+func TestHealth_ReadinessFollowsReplication(t *testing.T) {
+	t.Parallel()
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusInternalServerError)
+	}))
+	t.Cleanup(primary.Close)
+
+	router, err := handlers.NewRouter(handlers.Options{Primary: primary.URL, SyncInterval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		rr := getHealth(router, "/readyz")
+		if rr.Code == http.StatusServiceUnavailable && strings.Contains(rr.Body.String(), "replication") {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected a follower that cannot sync not to be ready, got %d: %s", rr.Code, rr.Body)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,150 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/varungujarathi9/job-queue/internal/handlers"
+	"github.com/varungujarathi9/job-queue/pkg/jobqueue"
+)
+
+// buildJqctl builds the jqctl binary into a temporary directory of the test
+func buildJqctl(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "jqctl")
+	if out, err := exec.Command("go", "build", "-o", path, "../cmd/jqctl").CombinedOutput(); err != nil {
+		t.Fatalf("building jqctl: %v\n%s", err, out)
+	}
+	return path
+}
+
+// newJqctl starts a server and returns a function running jqctl against it, which
+// returns the standard output, the standard error and the exit status
+func newJqctl(t *testing.T) func(stdin string, args ...string) (string, string, int) {
+	path := buildJqctl(t)
+	router, err := handlers.NewRouter(handlers.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+
+	return func(stdin string, args ...string) (string, string, int) {
+		var stdout, stderr bytes.Buffer
+		cmd := exec.Command(path, append([]string{"-server", server.URL}, args...)...)
+		cmd.Stdin = strings.NewReader(stdin)
+		cmd.Stdout, cmd.Stderr = &stdout, &stderr
+		cmd.Env = append(os.Environ(), "JQ_TOKEN=", "JQ_TENANT=")
+		err := cmd.Run()
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return stdout.String(), stderr.String(), exitErr.ExitCode()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		return stdout.String(), stderr.String(), 0
+	}
+}
+
+func TestJqctl_EnqueueListAndStats(t *testing.T) {
+	t.Parallel()
+	jqctl := newJqctl(t)
+
+	// jobs come from arguments and from stdin, and get the QUEUED status by default
+	stdin := `{"Type": "NOT_TIME_CRITICAL"} {"Type": "TIME_CRITICAL", "Queue": "emails"}`
+	out, stderr, code := jqctl(stdin, "enqueue", `{"Type": "TIME_CRITICAL", "Queue": "emails", "Key": "k1"}`, "-")
+	if code != 0 || out != "ID\n1\n2\n3\n" {
+		t.Fatalf("expected the IDs 1 to 3, got %d %q %s", code, out, stderr)
+	}
+
+	out, _, _ = jqctl("", "list", "-status", "queued", "-queue", "emails")
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], "ID  ") || !strings.Contains(lines[0], "CONSUMER") {
+		t.Fatalf("expected a header and two rows, got %q", out)
+	}
+	if fields := strings.Fields(lines[1]); len(fields) < 7 || fields[0] != "1" || fields[3] != "emails" || fields[4] != "k1" || fields[5] != "-" || fields[6] != "false" {
+		t.Errorf("unexpected row %q", lines[1])
+	}
+
+	out, _, _ = jqctl("", "stats")
+	for _, want := range []string{"GROUP", "total", "3", "status", "QUEUED", "type", "NOT_TIME_CRITICAL"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in the stats, got %q", want, out)
+		}
+	}
+
+	out, _, _ = jqctl("", "-o", "json", "get", "3")
+	var job jobqueue.Job
+	if err := json.Unmarshal([]byte(out), &job); err != nil || job.ID != 3 || job.Queue != "emails" || job.Status != jobqueue.StatusQueued {
+		t.Errorf("expected job 3 as JSON, got %q %v", out, err)
+	}
+}
+
+func TestJqctl_DequeueAndConclude(t *testing.T) {
+	t.Parallel()
+	jqctl := newJqctl(t)
+	jqctl("", "enqueue", `{"Type": "TIME_CRITICAL", "Payload": {"to": "ops"}}`)
+
+	out, _, code := jqctl("", "dequeue", "-consumer", "7")
+	if code != 0 || !strings.Contains(out, "IN_PROGRESS") || !strings.Contains(out, "Payload:\n{\n  \"to\": \"ops\"\n}") {
+		t.Fatalf("expected the job in progress with its payload, got %d %q", code, out)
+	}
+
+	// only the consumer that dequeued the job may conclude it
+	if _, stderr, code := jqctl("", "conclude", "-consumer", "8", "1"); code != 1 || !strings.HasPrefix(stderr, "conclude: ") {
+		t.Errorf("expected another consumer to be refused, got %d %q", code, stderr)
+	}
+	if out, stderr, code := jqctl("", "conclude", "-consumer", "7", "-result", `{"sent": true}`, "1"); code != 0 || out != "job 1 concluded\n" {
+		t.Errorf("expected the job concluded, got %d %q %s", code, out, stderr)
+	}
+	out, _, _ = jqctl("", "get", "1")
+	if !strings.Contains(out, "CONCLUDED") || !strings.Contains(out, "Result:\n{\n  \"sent\": true\n}") {
+		t.Errorf("expected the concluded job with its result, got %q", out)
+	}
+
+	out, _, _ = jqctl("", "history", "1")
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[0], "SEQ") {
+		t.Fatalf("expected a header and three changes, got %q", out)
+	}
+	for i, op := range []string{jobqueue.OpEnqueue, jobqueue.OpDequeue, jobqueue.OpConclude} {
+		if fields := strings.Fields(lines[i+1]); len(fields) < 5 || fields[3] != op || fields[4] != "1" {
+			t.Errorf("expected change %d to be %s of job 1, got %q", i+1, op, lines[i+1])
+		}
+	}
+}
+
+func TestJqctl_UsageErrors(t *testing.T) {
+	t.Parallel()
+	jqctl := newJqctl(t)
+
+	tests := []struct {
+		args []string
+		code int
+		want string
+	}{
+		{args: nil, code: 2, want: "Usage:"},
+		{args: []string{"purge"}, code: 2, want: "unknown command purge, expected one of cancel, conclude"},
+		{args: []string{"-o", "yaml", "stats"}, code: 2, want: "unknown output format yaml"},
+		{args: []string{"dequeue"}, code: 2, want: "dequeue: -consumer is required"},
+		{args: []string{"get", "one"}, code: 2, want: "get: invalid job ID one"},
+		{args: []string{"cancel", "1", "2"}, code: 2, want: "cancel: expected exactly one job ID"},
+		{args: []string{"conclude", "-result", "{", "1"}, code: 2, want: "conclude: invalid -result JSON"},
+		{args: []string{"enqueue"}, code: 2, want: "enqueue: expected a JSON job"},
+		{args: []string{"enqueue", "{"}, code: 1, want: "enqueue: invalid job JSON"},
+		{args: []string{"get", "99"}, code: 1, want: "get: "},
+	}
+	for _, tt := range tests {
+		_, stderr, code := jqctl("", tt.args...)
+		if code != tt.code || !strings.Contains(stderr, tt.want) {
+			t.Errorf("jqctl %v: expected status %d and %q, got %d %q", tt.args, tt.code, tt.want, code, stderr)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	"github.com/varungujarathi9/job-queue/internal/handlers"
+	"github.com/varungujarathi9/job-queue/internal/logging"
+	"github.com/varungujarathi9/job-queue/internal/services"
+	"github.com/varungujarathi9/job-queue/internal/utils"
+	"github.com/varungujarathi9/job-queue/pkg/jobqueue"
+)
+
+// accessLines decodes the JSON lines of buf that are access lines
+func accessLines(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	t.Helper()
+	var lines []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var fields map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &fields); err != nil {
+			t.Fatal(err)
+		}
+		if fields["msg"] == "Request served" {
+			lines = append(lines, fields)
+		}
+	}
+	return lines
+}
+
+func TestLogging_AccessLines(t *testing.T) {
+	var buf bytes.Buffer
+	logger := logrus.New()
+	logger.Out = &buf
+	logger.SetFormatter(&logrus.JSONFormatter{})
+	logger.SetLevel(logrus.DebugLevel)
+
+	server := services.New(services.WithLogger(logger))
+	router := mux.NewRouter()
+	router.Use(logging.Middleware(logger))
+	router.HandleFunc("/jobs/enqueue", server.EnqueueService).Methods("POST").Name("enqueue")
+	router.HandleFunc("/jobs/dequeue", server.DequeueService).Methods("GET").Name("dequeue")
+	router.HandleFunc("/jobs/{job_id}", server.JobService).Methods("GET").Name("job")
+
+	body, _ := json.Marshal(jobqueue.Job{Type: jobqueue.TypeTimeCritical, Status: jobqueue.StatusQueued})
+	req := httptest.NewRequest("POST", "/jobs/enqueue", bytes.NewReader(body))
+	req.Header.Set(logging.RequestIDHeader, "req-1")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if got := rr.Header().Get(logging.RequestIDHeader); got != "req-1" {
+		t.Errorf("expected the request ID of the client to be returned, got %q", got)
+	}
+
+	req = httptest.NewRequest("GET", "/jobs/dequeue", nil)
+	req.Header.Set("QUEUE_CONSUMER", "7")
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	generated := rr.Header().Get(logging.RequestIDHeader)
+	if len(generated) != 32 {
+		t.Errorf("expected a generated request ID, got %q", generated)
+	}
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/jobs/999", nil))
+
+	lines := accessLines(t, &buf)
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 access lines, got %d: %s", len(lines), buf.String())
+	}
+	for i, want := range []map[string]interface{}{
+		{"request_id": "req-1", "route": "enqueue", "status": 200.0, "job_id": 1.0},
+		{"request_id": generated, "route": "dequeue", "status": 200.0, "job_id": 1.0, "consumer": 7.0},
+		{"route": "job", "status": 404.0, "job_id": 999.0, "error": "Job not found"},
+	} {
+		for key, value := range want {
+			if lines[i][key] != value {
+				t.Errorf("expected access line %d to have %s %v, got %v", i, key, value, lines[i][key])
+			}
+		}
+		if _, ok := lines[i]["latency_ms"]; !ok {
+			t.Errorf("expected access line %d to have a latency", i)
+		}
+	}
+
+	// the handlers' own lines carry the request ID as well
+	if !strings.Contains(buf.String(), `"msg":"Returned response after enqueueing","request_id":"req-1"`) {
+		t.Errorf("expected the handler's line to carry the request ID: %s", buf.String())
+	}
+}
+
+func TestLogging_RedactsAccessToken(t *testing.T) {
+	var buf bytes.Buffer
+	logger := logrus.New()
+	logger.Out = &buf
+	logger.SetFormatter(&logrus.JSONFormatter{})
+
+	router := mux.NewRouter()
+	router.Use(logging.Middleware(logger))
+	router.HandleFunc("/events", func(w http.ResponseWriter, r *http.Request) {}).Name("events")
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/events?access_token=secret", nil))
+
+	if strings.Contains(buf.String(), "secret") || !strings.Contains(buf.String(), "access_token=REDACTED") {
+		t.Errorf("expected the access token to be redacted: %s", buf.String())
+	}
+}
+
+// waitForFiles waits until dir holds count files matching pattern
+func waitForFiles(t *testing.T, dir, pattern string, count int) []string {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		matches, _ := filepath.Glob(filepath.Join(dir, pattern))
+		if len(matches) >= count {
+			return matches
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d files matching %s, got %v", count, pattern, matches)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestLogging_RotatesByAgeAndCompresses(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	logger := logrus.New()
+	closer, err := logging.Setup(logger, logging.Options{
+		Path:        filepath.Join(dir, "job-queue.log"),
+		Format:      logging.FormatText,
+		Level:       "info",
+		RotateEvery: 50 * time.Millisecond,
+		Compress:    true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer.Close()
+
+	logger.Info("before rotation")
+	if _, ok := logger.Formatter.(*logrus.TextFormatter); !ok {
+		t.Errorf("expected the text formatter, got %T", logger.Formatter)
+	}
+	waitForFiles(t, dir, "job-queue-*.log.gz", 1)
+	logger.Info("after rotation")
+
+	data, err := os.ReadFile(filepath.Join(dir, "job-queue.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "before rotation") {
+		t.Errorf("expected the current file to start after the rotation, got %s", data)
+	}
+	info, err := os.Stat(filepath.Join(dir, "job-queue.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm()&0o022 != 0 {
+		t.Errorf("expected a log file only its owner can write, got %v", info.Mode())
+	}
+}
+
+func TestLogging_RetainsBackups(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	logger := logrus.New()
+	closer, err := logging.Setup(logger, logging.Options{
+		Path:       filepath.Join(dir, "job-queue.log"),
+		Level:      "info",
+		RotateSize: 1,
+		MaxBackups: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer.Close()
+
+	// every line is a bit over half a megabyte so each one past the first rotates the file
+	line := strings.Repeat("x", 600*1024)
+	for i := 0; i < 5; i++ {
+		logger.Info(line)
+		// lumberjack sorts backups by the timestamp in their names, which has millisecond precision
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		matches, _ := filepath.Glob(filepath.Join(dir, "job-queue-*.log"))
+		if len(matches) == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected 2 backups to be kept, got %v", matches)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestLogging_ChangesLevelAtRuntime(t *testing.T) {
+	level := utils.Logger.GetLevel()
+	t.Cleanup(func() { utils.Logger.SetLevel(level) })
+
+	router, err := handlers.NewRouter(handlers.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("PUT", "/admin/log-level", strings.NewReader(`{"Level": "debug"}`)))
+	if rr.Code != http.StatusOK || utils.Logger.GetLevel() != logrus.DebugLevel {
+		t.Fatalf("expected the level to change to debug, got %d and %s", rr.Code, utils.Logger.GetLevel())
+	}
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/admin/log-level", nil))
+	var status logging.LevelStatus
+	if err := json.NewDecoder(rr.Body).Decode(&status); err != nil || status.Level != "debug" {
+		t.Errorf("expected level debug, got %+v, %v", status, err)
+	}
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("PUT", "/admin/log-level", strings.NewReader(`{"Level": "loud"}`)))
+	if rr.Code != http.StatusBadRequest || utils.Logger.GetLevel() != logrus.DebugLevel {
+		t.Errorf("expected an invalid level to be refused, got %d and %s", rr.Code, utils.Logger.GetLevel())
+	}
+}
@@ -0,0 +1,70 @@
+package test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/varungujarathi9/job-queue/internal/handlers"
+	"github.com/varungujarathi9/job-queue/pkg/client"
+	"github.com/varungujarathi9/job-queue/pkg/jobqueue"
+)
+
+func TestMetrics_Exposition(t *testing.T) {
+	t.Parallel()
+	router, err := handlers.NewRouter(handlers.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+
+	ctx := context.Background()
+	c := client.New(server.URL)
+	for i := 0; i < 2; i++ {
+		if _, err := c.Enqueue(ctx, jobqueue.Job{Type: jobqueue.TypeTimeCritical, Status: jobqueue.StatusQueued}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	job, err := c.Dequeue(ctx, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Conclude(ctx, job.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Cancel(ctx, job.ID+1); err != nil {
+		t.Fatal(err)
+	}
+	c.Job(ctx, 999)
+
+	resp, err := http.Get(server.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/plain") {
+		t.Errorf("expected the text exposition format, got %s", resp.Header.Get("Content-Type"))
+	}
+
+	for _, line := range []string{
+		`job_queue_jobs{status="CONCLUDED",type="TIME_CRITICAL"} 1`,
+		`job_queue_jobs{status="CANCELLED",type="TIME_CRITICAL"} 1`,
+		`job_queue_operations_total{operation="enqueue",type="TIME_CRITICAL"} 2`,
+		`job_queue_operations_total{operation="dequeue",type="TIME_CRITICAL"} 1`,
+		`job_queue_operations_total{operation="conclude",type="TIME_CRITICAL"} 1`,
+		`job_queue_operations_total{operation="cancel",type="TIME_CRITICAL"} 1`,
+		`job_queue_wait_seconds_count{type="TIME_CRITICAL"} 1`,
+		`job_queue_processing_seconds_count{outcome="conclude",type="TIME_CRITICAL"} 1`,
+		`job_queue_http_request_duration_seconds_count{method="POST",route="enqueue"} 2`,
+		`job_queue_http_request_errors_total{code="404",method="GET",route="job"} 1`,
+	} {
+		if !strings.Contains(string(body), line+"\n") {
+			t.Errorf("expected metrics to contain %s", line)
+		}
+	}
+}
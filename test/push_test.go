@@ -0,0 +1,359 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/varungujarathi9/job-queue/internal/handlers"
+	"github.com/varungujarathi9/job-queue/internal/push"
+	"github.com/varungujarathi9/job-queue/internal/services"
+	"github.com/varungujarathi9/job-queue/pkg/client"
+	"github.com/varungujarathi9/job-queue/pkg/jobqueue"
+)
+
+func TestPush_PrefetchWindow(t *testing.T) {
+	t.Parallel()
+	c := newTestClient(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stream, err := c.Push(ctx, 4, 2, jobqueue.TypeTimeCritical)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+	var ids []int
+	for _, jobType := range []string{jobqueue.TypeTimeCritical, jobqueue.TypeNotTimeCritical, jobqueue.TypeTimeCritical, jobqueue.TypeTimeCritical} {
+		id, err := c.Enqueue(ctx, jobqueue.Job{Type: jobType, Status: jobqueue.StatusQueued})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+
+	// only the two jobs of the window are pushed, both of the subscribed type
+	first, err := stream.Next(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := stream.Next(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.ID != ids[0] || second.ID != ids[2] || first.ConsumedBy != 4 || first.Status != jobqueue.StatusInProgress {
+		t.Fatalf("expected jobs %d and %d in progress for consumer 4, got %+v and %+v", ids[0], ids[2], first, second)
+	}
+	short, cancelShort := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancelShort()
+	if job, err := stream.Next(short); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected no job beyond the window, got %+v %v", job, err)
+	}
+
+	// acknowledging a job frees its place in the window
+	if err := stream.Heartbeat(ctx, first.ID); err != nil {
+		t.Errorf("expected the heartbeat to be accepted, got %v", err)
+	}
+	if err := stream.Ack(ctx, first.ID, "done"); err != nil {
+		t.Fatal(err)
+	}
+	third, err := stream.Next(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if third.ID != ids[3] {
+		t.Errorf("expected job %d after the ack, got %d", ids[3], third.ID)
+	}
+	if err := stream.Fail(ctx, second.ID, "no recipient"); err != nil {
+		t.Fatal(err)
+	}
+
+	if job, _ := c.Job(ctx, first.ID); job.Status != jobqueue.StatusConcluded || job.Result != "done" {
+		t.Errorf("expected job %d concluded with its result, got %+v", first.ID, job)
+	}
+	if job, _ := c.Job(ctx, second.ID); job.Status != jobqueue.StatusFailed || job.Error != "no recipient" {
+		t.Errorf("expected job %d failed with its reason, got %+v", second.ID, job)
+	}
+	if err := stream.Heartbeat(ctx, second.ID); !errors.Is(err, jobqueue.ErrNotInProgress) {
+		t.Errorf("expected error %v for a failed job, got %v", jobqueue.ErrNotInProgress, err)
+	}
+	if err := stream.Ack(ctx, 99, nil); !errors.Is(err, jobqueue.ErrNotFound) {
+		t.Errorf("expected error %v, got %v", jobqueue.ErrNotFound, err)
+	}
+}
+
+func TestPush_ClosedConnectionRequeuesJobs(t *testing.T) {
+	t.Parallel()
+	c := newTestClient(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stream, err := c.Push(ctx, 4, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := c.Enqueue(ctx, jobqueue.Job{Type: jobqueue.TypeTimeCritical, Status: jobqueue.StatusQueued}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	acked, err := stream.Next(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	held, err := stream.Next(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.Ack(ctx, acked.ID, nil); err != nil {
+		t.Fatal(err)
+	}
+	stream.Close()
+
+	// the job held without an ack is queued again long before its lease would expire
+	waitForStatus(t, c, held.ID, jobqueue.StatusQueued)
+	if job, _ := c.Job(ctx, acked.ID); job.Status != jobqueue.StatusConcluded {
+		t.Errorf("expected job %d to stay concluded, got %+v", acked.ID, job)
+	}
+	job, err := c.Dequeue(ctx, 5, 0)
+	if err != nil || job.ID != held.ID {
+		t.Errorf("expected job %d for the next consumer, got %+v %v", held.ID, job, err)
+	}
+}
+
+func TestPush_ClosePushRequeuesJobs(t *testing.T) {
+	t.Parallel()
+	engine := jobqueue.New()
+	server := services.New(services.WithEngine(engine))
+	receiver := httptest.NewServer(http.HandlerFunc(server.PushService))
+	t.Cleanup(receiver.Close)
+	c := client.New(receiver.URL)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stream, err := c.Push(ctx, 4, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := engine.Enqueue(jobqueue.Job{Type: jobqueue.TypeTimeCritical, Status: jobqueue.StatusQueued})
+	if job, err := stream.Next(ctx); err != nil || job.ID != id {
+		t.Fatalf("expected job %d pushed, got %+v %v", id, job, err)
+	}
+
+	// the held job is back in the queue once ClosePush returns, and the consumer sees the close
+	if err := server.ClosePush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if job, _ := engine.Job(id); job.Status != jobqueue.StatusQueued {
+		t.Errorf("expected job %d queued again, got %+v", id, job)
+	}
+	if _, err := stream.Next(ctx); err == nil {
+		t.Error("expected the push connection to be closed")
+	}
+
+	// connections opened later are closed without being pushed anything
+	late, err := c.Push(ctx, 5, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := late.Next(ctx); err == nil {
+		t.Error("expected a push connection opened after ClosePush to be closed")
+	}
+	if job, _ := engine.Job(id); job.Status != jobqueue.StatusQueued {
+		t.Errorf("expected job %d to stay queued, got %+v", id, job)
+	}
+}
+
+func TestPush_InvalidPrefetch(t *testing.T) {
+	t.Parallel()
+	c := newTestClient(t)
+
+	_, err := c.Push(context.Background(), 1, 0)
+	var apiErr *client.APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected a 400 error, got %v", err)
+	}
+}
+
+func TestPush_HTTPEndpointRetries(t *testing.T) {
+	t.Parallel()
+	// the endpoint refuses the first attempt and answers the second with a result
+	var calls atomic.Int32
+	attempts := make(chan string, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if job, err := client.VerifyPush(r, webhookSecret); err != nil || job.Queue != "emails" {
+			t.Errorf("expected a signed job of the emails queue, got %+v %v", job, err)
+		}
+		attempts <- r.Header.Get(push.AttemptHeader)
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"sent":true}`))
+	}))
+	defer receiver.Close()
+
+	router, err := handlers.NewRouter(handlers.Options{Push: push.Options{
+		Secret:    webhookSecret,
+		Backoff:   10 * time.Millisecond,
+		Endpoints: []push.Endpoint{{URL: receiver.URL, Consumer: 9, Queue: "emails"}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+	c := client.New(server.URL)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	other, _ := c.Enqueue(ctx, jobqueue.Job{Type: jobqueue.TypeTimeCritical, Status: jobqueue.StatusQueued})
+	id, err := c.Enqueue(ctx, jobqueue.Job{Type: jobqueue.TypeTimeCritical, Status: jobqueue.StatusQueued, Queue: "emails"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the job is failed between the attempts, so its result is read after the second one
+	if first, second := <-attempts, <-attempts; first != "1" || second != "2" {
+		t.Errorf("expected attempts 1 and 2, got %s and %s", first, second)
+	}
+	job, err := c.Result(ctx, id, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != jobqueue.StatusConcluded || job.ConsumedBy != 9 || job.Result.(map[string]interface{})["sent"] != true {
+		t.Errorf("expected the job concluded by consumer 9 with the answer as result, got %+v", job)
+	}
+	if job, _ := c.Job(ctx, other); job.Status != jobqueue.StatusQueued {
+		t.Errorf("expected the job of another queue to stay queued, got %+v", job)
+	}
+}
+
+func TestPush_HTTPEndpointRetriesSurviveRestartAndDrain(t *testing.T) {
+	t.Parallel()
+	attempts := make(chan string, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts <- r.Header.Get(push.AttemptHeader)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer receiver.Close()
+
+	// a job failed by the endpoint's consumer before a restart, whose history was compacted
+	engine := jobqueue.New(jobqueue.WithMaxChanges(1))
+	id, _ := engine.Enqueue(jobqueue.Job{Type: jobqueue.TypeTimeCritical, Status: jobqueue.StatusQueued, Queue: "emails"})
+	engine.TryDequeue(9)
+	engine.Fail(id, 9, "endpoint answered 502 Bad Gateway")
+	engine.Drain()
+
+	pusher := push.New(engine, push.Options{
+		MaxAttempts: 3,
+		Backoff:     10 * time.Millisecond,
+		Endpoints:   []push.Endpoint{{URL: receiver.URL, Consumer: 9, Queue: "emails"}},
+	})
+	t.Cleanup(func() { pusher.Close(context.Background()) })
+	time.Sleep(100 * time.Millisecond)
+	if job, _ := engine.Job(id); job.Status != jobqueue.StatusFailed {
+		t.Fatalf("expected the job to wait for the drained queue, got %+v", job)
+	}
+
+	// the retries carry on once the queue resumes, up to the last attempt
+	engine.Resume()
+	for _, want := range []string{"2", "3"} {
+		select {
+		case attempt := <-attempts:
+			if attempt != want {
+				t.Errorf("expected attempt %s, got %s", want, attempt)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected attempt %s", want)
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+	if job, _ := engine.Job(id); job.Status != jobqueue.StatusFailed || len(attempts) != 0 {
+		t.Errorf("expected the job to stay failed after 3 attempts, got %+v", job)
+	}
+}
+
+func TestPush_CloseStopsEndpoints(t *testing.T) {
+	t.Parallel()
+	var calls atomic.Int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+	}))
+	defer receiver.Close()
+
+	engine := jobqueue.New()
+	pusher := push.New(engine, push.Options{
+		Endpoints: []push.Endpoint{{URL: receiver.URL, Consumer: 9, Queue: "emails", MaxConcurrency: 2}},
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := pusher.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// neither the endpoints of a closed pusher nor the ones added later take jobs
+	pusher.Add(push.Endpoint{URL: receiver.URL, Consumer: 10, Queue: "emails"})
+	id, _ := engine.Enqueue(jobqueue.Job{Type: jobqueue.TypeTimeCritical, Status: jobqueue.StatusQueued, Queue: "emails"})
+	time.Sleep(50 * time.Millisecond)
+	if job, _ := engine.Job(id); job.Status != jobqueue.StatusQueued || calls.Load() != 0 {
+		t.Errorf("expected the job to stay queued, got %+v after %d pushes", job, calls.Load())
+	}
+}
+
+func TestPush_HTTPEndpointConcurrency(t *testing.T) {
+	t.Parallel()
+	var inFlight, most atomic.Int32
+	release := make(chan struct{})
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for current := most.Load(); n > current && !most.CompareAndSwap(current, n); current = most.Load() {
+		}
+		<-release
+	}))
+	defer receiver.Close()
+
+	router, err := handlers.NewRouter(handlers.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	endpoint := push.Endpoint{URL: receiver.URL, Consumer: 3, Types: []string{jobqueue.TypeNotTimeCritical}, MaxConcurrency: 2}
+	var created push.Endpoint
+	if rr := v2Request(t, router, "POST", "/push/endpoints", endpoint, nil, &created); rr.Code != http.StatusCreated || created.ID != 1 {
+		t.Fatalf("expected endpoint 1 to be created, got %d %+v", rr.Code, created)
+	}
+	var ids []int
+	for i := 0; i < 4; i++ {
+		var job services.JobResource
+		v2Request(t, router, "POST", "/v2/jobs", services.JobRequest{Type: jobqueue.TypeNotTimeCritical}, nil, &job)
+		ids = append(ids, job.ID)
+	}
+
+	// two jobs are sent at once and the others wait in the queue
+	deadline := time.Now().Add(5 * time.Second)
+	for inFlight.Load() < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	var queued []jobqueue.Job
+	v2Request(t, router, "GET", "/jobs?status="+jobqueue.StatusQueued, nil, nil, &queued)
+	if most.Load() != 2 || len(queued) != 2 {
+		t.Errorf("expected 2 jobs in flight and 2 queued, got %d and %d", most.Load(), len(queued))
+	}
+	close(release)
+
+	if rr := v2Request(t, router, "DELETE", "/push/endpoints/1", nil, nil, nil); rr.Code != http.StatusOK {
+		t.Errorf("expected the endpoint to be deleted, got %d", rr.Code)
+	}
+	if rr := v2Request(t, router, "GET", "/push/endpoints/1", nil, nil, nil); rr.Code != http.StatusNotFound {
+		t.Errorf("expected the endpoint to be gone, got %d", rr.Code)
+	}
+	if rr := v2Request(t, router, "POST", "/push/endpoints", push.Endpoint{URL: receiver.URL, Consumer: 3}, nil, nil); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected an endpoint without types or queue to be refused, got %d", rr.Code)
+	}
+}
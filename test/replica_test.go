@@ -0,0 +1,164 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/varungujarathi9/job-queue/internal/handlers"
+	"github.com/varungujarathi9/job-queue/internal/replica"
+	"github.com/varungujarathi9/job-queue/pkg/client"
+	"github.com/varungujarathi9/job-queue/pkg/jobqueue"
+)
+
+// newTestFollower starts a follower of primary and returns its router
+func newTestFollower(t *testing.T, primary string) http.Handler {
+	router, err := handlers.NewRouter(handlers.Options{Primary: primary, SyncInterval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return router
+}
+
+// waitForSeq polls a follower until it applied the primary's changes up to seq
+func waitForSeq(t *testing.T, follower http.Handler, seq int) replica.Status {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		var status replica.Status
+		rr := getHealth(follower, "/jobs/replication")
+		if err := json.NewDecoder(rr.Body).Decode(&status); err != nil {
+			t.Fatal(err)
+		}
+		if status.AppliedSeq == seq && status.PrimarySeq == seq {
+			return status
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the follower at change %d, got %+v", seq, status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestReplica_FollowerServesReadsAndRedirectsWrites(t *testing.T) {
+	t.Parallel()
+	router, err := handlers.NewRouter(handlers.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	primary := httptest.NewServer(router)
+	t.Cleanup(primary.Close)
+	follower := newTestFollower(t, primary.URL)
+
+	id, err := client.New(primary.URL).Enqueue(context.Background(), jobqueue.Job{Type: jobqueue.TypeTimeCritical, Status: jobqueue.StatusQueued})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForSeq(t, follower, 1)
+	if rr := getHealth(follower, "/jobs/"+strconv.Itoa(id)); rr.Code != http.StatusOK {
+		t.Errorf("expected status %d reading the job from the follower, got %d", http.StatusOK, rr.Code)
+	}
+
+	rr := httptest.NewRecorder()
+	follower.ServeHTTP(rr, httptest.NewRequest("POST", "/jobs/enqueue?x=1", nil))
+	if rr.Code != http.StatusTemporaryRedirect || rr.Header().Get("Location") != primary.URL+"/jobs/enqueue?x=1" {
+		t.Errorf("expected a redirect to the primary, got %d %q", rr.Code, rr.Header().Get("Location"))
+	}
+}
+
+func TestReplica_FollowerResyncsAfterPrimaryRestart(t *testing.T) {
+	t.Parallel()
+	var current atomic.Value
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		current.Load().(http.Handler).ServeHTTP(w, r)
+	}))
+	t.Cleanup(primary.Close)
+	start := func() {
+		router, err := handlers.NewRouter(handlers.Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		current.Store(http.Handler(router))
+	}
+	start()
+	follower := newTestFollower(t, primary.URL)
+
+	c := client.New(primary.URL)
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		if _, err := c.Enqueue(ctx, jobqueue.Job{Type: jobqueue.TypeTimeCritical, Status: jobqueue.StatusQueued}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitForSeq(t, follower, 3)
+
+	// a primary without a journal starts over with a shorter change stream
+	start()
+	id, err := c.Enqueue(ctx, jobqueue.Job{Type: jobqueue.TypeNotTimeCritical, Status: jobqueue.StatusQueued})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForSeq(t, follower, 1)
+
+	var job jobqueue.Job
+	rr := getHealth(follower, "/jobs/"+strconv.Itoa(id))
+	if err := json.NewDecoder(rr.Body).Decode(&job); err != nil || job.Type != jobqueue.TypeNotTimeCritical {
+		t.Errorf("expected the job of the restarted primary, got %+v %v", job, err)
+	}
+	if rr := getHealth(follower, "/jobs/3"); rr.Code != http.StatusNotFound {
+		t.Errorf("expected status %d for a job the primary lost, got %d", http.StatusNotFound, rr.Code)
+	}
+	if rr := getHealth(follower, "/readyz"); rr.Code != http.StatusOK {
+		t.Errorf("expected the resynced follower to be ready, got %d: %s", rr.Code, rr.Body)
+	}
+}
+
+func TestReplica_FollowerResyncsOnNewStream(t *testing.T) {
+	t.Parallel()
+	var current atomic.Value
+	var down atomic.Bool
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if down.Load() {
+			http.Error(w, "restarting", http.StatusServiceUnavailable)
+			return
+		}
+		current.Load().(http.Handler).ServeHTTP(w, r)
+	}))
+	t.Cleanup(primary.Close)
+	start := func(jobType string, jobs int) {
+		router, err := handlers.NewRouter(handlers.Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < jobs; i++ {
+			v2Request(t, router, "POST", "/jobs/enqueue", jobqueue.Job{Type: jobType, Status: jobqueue.StatusQueued}, nil, nil)
+		}
+		current.Store(http.Handler(router))
+	}
+
+	// reads are refused until the follower first synced
+	down.Store(true)
+	follower := newTestFollower(t, primary.URL)
+	if rr := getHealth(follower, "/jobs/1"); rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected status %d before the first sync, got %d", http.StatusServiceUnavailable, rr.Code)
+	}
+	start(jobqueue.TypeTimeCritical, 1)
+	down.Store(false)
+	waitForSeq(t, follower, 1)
+
+	// the restarted primary is already past the applied change when the follower polls again
+	down.Store(true)
+	start(jobqueue.TypeNotTimeCritical, 3)
+	down.Store(false)
+	waitForSeq(t, follower, 3)
+	var job jobqueue.Job
+	rr := getHealth(follower, "/jobs/1")
+	if err := json.NewDecoder(rr.Body).Decode(&job); err != nil || job.Type != jobqueue.TypeNotTimeCritical {
+		t.Errorf("expected job 1 of the restarted primary, got %+v %v", job, err)
+	}
+}
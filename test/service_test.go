@@ -9,11 +9,64 @@ import (
 	"testing"
 
 	"github.com/gorilla/mux"
-	"github.com/varungujarathi9/job-queue/internal/models"
 	"github.com/varungujarathi9/job-queue/internal/services"
+	"github.com/varungujarathi9/job-queue/pkg/jobqueue"
 )
 
+// enqueueJob adds a TIME_CRITICAL job to the server's queue
+func enqueueJob(t *testing.T, server *services.Server) {
+	payload := []byte(`{
+        "Type": "TIME_CRITICAL",
+        "Status": "IN_PROGRESS"
+       }`)
+	req, err := http.NewRequest("POST", "/jobs/enqueue", bytes.NewBuffer(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	server.EnqueueService(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("enqueue failed with status code %d", rr.Code)
+	}
+}
+
+// dequeueJob takes the next job from the server's queue as consumer 1
+func dequeueJob(t *testing.T, server *services.Server) {
+	req, err := http.NewRequest("GET", "/jobs/dequeue", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("QUEUE_CONSUMER", strconv.Itoa(1))
+	rr := httptest.NewRecorder()
+	server.DequeueService(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("dequeue failed with status code %d", rr.Code)
+	}
+}
+
+// concludeJob sends a conclude request for the given job ID
+func concludeJob(t *testing.T, server *services.Server, id string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest("PUT", "/jobs/"+id+"/conclude", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+
+	vars := map[string]string{
+		"job_id": id,
+	}
+
+	req = mux.SetURLVars(req, vars)
+
+	server.ConcludeService(rr, req)
+	return rr
+}
+
 func TestEnqueueService(t *testing.T) {
+	t.Parallel()
+	server := services.New()
+
 	payload := []byte(`{
         "Type": "TIME_CRITICAL",
         "Status": "IN_PROGRESS"
@@ -25,13 +78,13 @@ func TestEnqueueService(t *testing.T) {
 
 	rr := httptest.NewRecorder()
 
-	services.EnqueueService(rr, req)
+	server.EnqueueService(rr, req)
 
 	if rr.Code != http.StatusOK {
 		t.Errorf("expected status code %d, got %d", http.StatusOK, rr.Code)
 	}
 
-	expectedBody := "1"
+	expectedBody := `{"id" : 1}`
 	if rr.Body.String() != expectedBody {
 		t.Errorf("expected response body %q, got %q", expectedBody, rr.Body.String())
 	}
@@ -39,14 +92,9 @@ func TestEnqueueService(t *testing.T) {
 }
 
 func TestDequeueService(t *testing.T) {
-	// Create a mock job and queue
-	job := models.Job{
-		ID:     1,
-		Type:   "TIME_CRITICAL",
-		Status: "IN_PROGRESS",
-	}
-	queue := models.JobQueue{}
-	queue.Insert(&job)
+	t.Parallel()
+	server := services.New()
+	enqueueJob(t, server)
 
 	req, err := http.NewRequest("GET", "/jobs/dequeue", nil)
 	if err != nil {
@@ -56,156 +104,131 @@ func TestDequeueService(t *testing.T) {
 
 	rr := httptest.NewRecorder()
 
-	services.DequeueService(rr, req)
+	server.DequeueService(rr, req)
 
 	if rr.Code != http.StatusOK {
 		t.Errorf("expected status code %d, got %d", http.StatusOK, rr.Code)
 	}
 
-	var responseJob models.Job
+	var responseJob jobqueue.Job
 	err = json.NewDecoder(rr.Body).Decode(&responseJob)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	if responseJob.ID != job.ID {
-		t.Errorf("expected job type %q, got %q", job.Type, responseJob.Type)
+	if responseJob.ID != 1 {
+		t.Errorf("expected job ID %d, got %d", 1, responseJob.ID)
 	}
 
-	if responseJob.Type != job.Type {
-		t.Errorf("expected job type %q, got %q", job.Type, responseJob.Type)
+	if responseJob.Type != "TIME_CRITICAL" {
+		t.Errorf("expected job type %q, got %q", "TIME_CRITICAL", responseJob.Type)
 	}
 
-	if responseJob.Status != job.Status {
-		t.Errorf("expected job status %q, got %q", job.Status, responseJob.Status)
+	if responseJob.Status != jobqueue.StatusInProgress {
+		t.Errorf("expected job status %q, got %q", jobqueue.StatusInProgress, responseJob.Status)
 	}
 
 	if responseJob.ConsumedBy != 1 {
-		t.Errorf("expected job consumed by %d, got %d", job.ConsumedBy, responseJob.ConsumedBy)
+		t.Errorf("expected job consumed by %d, got %d", 1, responseJob.ConsumedBy)
 	}
 }
 
-func TestConcludeService(t *testing.T) {
-	// Create a mock job and add it to the jobStore
-	job := models.Job{
-		ID:     1,
-		Type:   "TIME_CRITICAL",
-		Status: "IN_PROGRESS",
-	}
-	var jobStore map[int]*models.Job = make(map[int]*models.Job)
-	jobStore[1] = &job
+func TestDequeueService_Empty(t *testing.T) {
+	t.Parallel()
+	server := services.New()
 
-	req, err := http.NewRequest("PUT", "/jobs/1/conclude", nil)
+	req, err := http.NewRequest("GET", "/jobs/dequeue", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
+	req.Header.Set("QUEUE_CONSUMER", strconv.Itoa(1))
 
 	rr := httptest.NewRecorder()
 
-	vars := map[string]string{
-		"job_id": "1",
+	server.DequeueService(rr, req)
+
+	if rr.Code != http.StatusNoContent || rr.Body.Len() != 0 {
+		t.Errorf("expected status code %d without a body, got %d %q", http.StatusNoContent, rr.Code, rr.Body.String())
 	}
+}
 
-	req = mux.SetURLVars(req, vars)
+func TestConcludeService(t *testing.T) {
+	t.Parallel()
+	server := services.New()
+	enqueueJob(t, server)
+	dequeueJob(t, server)
 
-	services.ConcludeService(rr, req)
+	rr := concludeJob(t, server, "1")
 
 	if rr.Code != http.StatusOK {
 		t.Errorf("expected status code %d, got %d", http.StatusOK, rr.Code)
 	}
 
-	expectedBody := "Job concluded successfully"
+	expectedBody := `{"status" : "Job concluded successfully"}`
 	if rr.Body.String() != expectedBody {
 		t.Errorf("expected response body %q, got %q", expectedBody, rr.Body.String())
 	}
 }
 
 func TestConcludeService_InvalidJobID(t *testing.T) {
-	req, err := http.NewRequest("GET", "/jobs/2/conclude", nil)
-	if err != nil {
-		t.Fatal(err)
-	}
+	t.Parallel()
+	server := services.New()
 
-	rr := httptest.NewRecorder()
-
-	vars := map[string]string{
-		"job_id": "ID3",
-	}
-
-	req = mux.SetURLVars(req, vars)
-
-	services.ConcludeService(rr, req)
+	rr := concludeJob(t, server, "ID3")
 
 	if rr.Code != http.StatusBadRequest {
 		t.Errorf("expected status code %d, got %d", http.StatusBadRequest, rr.Code)
 	}
 
-	expectedBody := "strconv.Atoi: parsing \"ID3\": invalid syntax\n"
+	expectedBody := `{"Code":"invalid_request","Message":"Invalid job_id: ID3"}` + "\n"
 	if rr.Body.String() != expectedBody {
 		t.Errorf("expected response body %q, got %q", expectedBody, rr.Body.String())
 	}
 }
 
 func TestConcludeService_JobNotFound(t *testing.T) {
-	req, err := http.NewRequest("GET", "/jobs/2/conclude", nil)
-	if err != nil {
-		t.Fatal(err)
-	}
+	t.Parallel()
+	server := services.New()
 
-	rr := httptest.NewRecorder()
-
-	vars := map[string]string{
-		"job_id": "2",
-	}
-
-	req = mux.SetURLVars(req, vars)
-
-	services.ConcludeService(rr, req)
+	rr := concludeJob(t, server, "2")
 
 	if rr.Code != http.StatusNotFound {
 		t.Errorf("expected status code %d, got %d", http.StatusNotFound, rr.Code)
 	}
+	if got := rr.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("expected a JSON error, got Content-Type %q", got)
+	}
 
-	expectedBody := "Job not found\n"
+	expectedBody := `{"Code":"not_found","Message":"Job not found","Details":{"JobID":2}}` + "\n"
 	if rr.Body.String() != expectedBody {
 		t.Errorf("expected response body %q, got %q", expectedBody, rr.Body.String())
 	}
 }
 
 func TestConcludeService_AlreadyConcluded(t *testing.T) {
-	job := models.Job{
-		ID:     1,
-		Type:   "TIME_CRITICAL",
-		Status: "CONCLUDED",
-	}
-	var jobStore map[int]*models.Job = make(map[int]*models.Job)
-	jobStore[1] = &job
+	t.Parallel()
+	server := services.New()
+	enqueueJob(t, server)
+	dequeueJob(t, server)
+	concludeJob(t, server, "1")
 
-	req, err := http.NewRequest("GET", "/jobs/1/conclude", nil)
-	if err != nil {
-		t.Fatal(err)
-	}
+	rr := concludeJob(t, server, "1")
 
-	rr := httptest.NewRecorder()
-
-	vars := map[string]string{
-		"job_id": "1",
+	if rr.Code != http.StatusConflict {
+		t.Errorf("expected status code %d, got %d", http.StatusConflict, rr.Code)
 	}
 
-	req = mux.SetURLVars(req, vars)
-
-	services.ConcludeService(rr, req)
-
-	if rr.Code != http.StatusBadRequest {
-		t.Errorf("expected status code %d, got %d", http.StatusBadRequest, rr.Code)
-	}
-
-	expectedBody := "Job already concluded\n"
+	expectedBody := `{"Code":"job_concluded","Message":"Job already concluded","Details":{"JobID":1}}` + "\n"
 	if rr.Body.String() != expectedBody {
 		t.Errorf("expected response body %q, got %q", expectedBody, rr.Body.String())
 	}
 }
+
 func TestJobService(t *testing.T) {
+	t.Parallel()
+	server := services.New()
+	enqueueJob(t, server)
+
 	req, err := http.NewRequest("GET", "/jobs/1", nil)
 	if err != nil {
 		t.Fatal(err)
@@ -219,13 +242,13 @@ func TestJobService(t *testing.T) {
 
 	req = mux.SetURLVars(req, vars)
 
-	services.JobService(rr, req)
+	server.JobService(rr, req)
 
 	if rr.Code != http.StatusOK {
 		t.Errorf("expected status code %d, got %d", http.StatusOK, rr.Code)
 	}
 
-	var responseJob models.Job
+	var responseJob jobqueue.Job
 	err = json.NewDecoder(rr.Body).Decode(&responseJob)
 	if err != nil {
 		t.Fatal(err)
@@ -236,3 +259,44 @@ func TestJobService(t *testing.T) {
 	}
 
 }
+
+func TestDrainService(t *testing.T) {
+	t.Parallel()
+	server := services.New()
+	enqueueJob(t, server)
+	dequeueJob(t, server)
+
+	req, err := http.NewRequest("POST", "/admin/drain", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	server.DrainService(rr, req)
+
+	var status services.DrainStatus
+	if err := json.NewDecoder(rr.Body).Decode(&status); err != nil {
+		t.Fatal(err)
+	}
+	if !status.Draining || status.InProgress != 1 {
+		t.Errorf("expected draining with %d job in progress, got %+v", 1, status)
+	}
+
+	payload := []byte(`{"Type": "TIME_CRITICAL", "Status": "QUEUED"}`)
+	req, err = http.NewRequest("POST", "/jobs/enqueue", bytes.NewBuffer(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr = httptest.NewRecorder()
+	server.EnqueueService(rr, req)
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected status code %d, got %d", http.StatusServiceUnavailable, rr.Code)
+	}
+	if expected := `{"Code":"draining","Message":"Queue is draining"}` + "\n"; rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v", rr.Body.String(), expected)
+	}
+
+	// the job in progress can still be concluded
+	if rr := concludeJob(t, server, "1"); rr.Code != http.StatusOK {
+		t.Errorf("conclude failed with status code %d", rr.Code)
+	}
+}
@@ -0,0 +1,73 @@
+package test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/varungujarathi9/job-queue/internal/storage"
+	"github.com/varungujarathi9/job-queue/pkg/jobqueue"
+)
+
+func TestStorage_FileRestoresJobs(t *testing.T) {
+	t.Parallel()
+	opts := storage.Options{Backend: storage.File, Path: filepath.Join(t.TempDir(), "journal")}
+
+	engine := jobqueue.New()
+	store, err := storage.Open(opts, engine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := engine.Enqueue(jobqueue.Job{Type: jobqueue.TypeTimeCritical, Status: jobqueue.StatusQueued}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	job, _ := engine.TryDequeue(1)
+	engine.Conclude(job.ID)
+	job, _ = engine.TryDequeue(2)
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// simulate a crash in the middle of writing a change
+	file, err := os.OpenFile(opts.Path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString(`{"Seq":99,"Op":"ENQ`)
+	file.Close()
+
+	restored := jobqueue.New()
+	store, err = storage.Open(opts, restored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	stats := restored.Stats()
+	if stats.ByStatus[jobqueue.StatusConcluded] != 1 || stats.ByStatus[jobqueue.StatusInProgress] != 1 || stats.ByStatus[jobqueue.StatusQueued] != 1 {
+		t.Errorf("expected one concluded, one in progress and one queued job, got %v", stats.ByStatus)
+	}
+
+	// the in-progress job keeps its lease and the queued one can be dequeued
+	if err := restored.Heartbeat(job.ID, 2); err != nil {
+		t.Errorf("expected heartbeat to succeed, got %v", err)
+	}
+	next, err := restored.TryDequeue(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next.ID != 3 {
+		t.Errorf("expected job ID %d, got %d", 3, next.ID)
+	}
+
+	// new IDs continue after the restored ones
+	id, err := restored.Enqueue(jobqueue.Job{Type: jobqueue.TypeTimeCritical, Status: jobqueue.StatusQueued})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != 4 {
+		t.Errorf("expected job ID %d, got %d", 4, id)
+	}
+}
@@ -0,0 +1,210 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/varungujarathi9/job-queue/internal/auth"
+	"github.com/varungujarathi9/job-queue/internal/handlers"
+	"github.com/varungujarathi9/job-queue/pkg/client"
+	"github.com/varungujarathi9/job-queue/pkg/jobqueue"
+)
+
+func tenantJob(tenant string) jobqueue.Job {
+	return jobqueue.Job{Type: jobqueue.TypeTimeCritical, Status: jobqueue.StatusQueued, Tenant: tenant}
+}
+
+func TestTenant_Quotas(t *testing.T) {
+	engine := jobqueue.New(
+		jobqueue.WithTenantQuota("small", jobqueue.Quota{MaxDepth: 1, MaxPayloadBytes: 8}),
+		jobqueue.WithTenantQuota("slow", jobqueue.Quota{Rate: 0.001, Burst: 2}),
+	)
+
+	if _, err := engine.Enqueue(tenantJob("small")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := engine.Enqueue(tenantJob("small")); !errors.Is(err, jobqueue.ErrQueueFull) {
+		t.Errorf("expected %v, got %v", jobqueue.ErrQueueFull, err)
+	}
+	// other tenants are not affected by a full tenant
+	if _, err := engine.Enqueue(tenantJob("other")); err != nil {
+		t.Fatal(err)
+	}
+
+	big := tenantJob("small")
+	big.Payload = strings.Repeat("x", 16)
+	if _, err := engine.Enqueue(big); !errors.Is(err, jobqueue.ErrPayloadTooLarge) {
+		t.Errorf("expected %v, got %v", jobqueue.ErrPayloadTooLarge, err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := engine.Enqueue(tenantJob("slow")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := engine.Enqueue(tenantJob("slow")); !errors.Is(err, jobqueue.ErrRateLimited) {
+		t.Errorf("expected %v, got %v", jobqueue.ErrRateLimited, err)
+	}
+}
+
+func TestTenant_ExpiredJobsLeaveQuota(t *testing.T) {
+	engine := jobqueue.New(
+		jobqueue.WithTenantQuota("small", jobqueue.Quota{MaxDepth: 2}),
+		jobqueue.WithEnqueueTimeout(10*time.Millisecond),
+	)
+
+	// the first job is cancelled and the second expires, neither keeps a place in the quota
+	first, _ := engine.Enqueue(tenantJob("small"))
+	second, _ := engine.Enqueue(tenantJob("small"))
+	engine.Cancel(first)
+	time.Sleep(20 * time.Millisecond)
+	for i := 0; i < 2; i++ {
+		if _, err := engine.Enqueue(tenantJob("small")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := engine.Enqueue(tenantJob("small")); !errors.Is(err, jobqueue.ErrQueueFull) {
+		t.Errorf("expected %v, got %v", jobqueue.ErrQueueFull, err)
+	}
+	if job, _ := engine.Job(second); job.Status != jobqueue.StatusExpired {
+		t.Errorf("expected status %s, got %s", jobqueue.StatusExpired, job.Status)
+	}
+	if queued := engine.Queued(); len(queued) != 2 {
+		t.Errorf("expected 2 queued jobs, got %+v", queued)
+	}
+}
+
+func TestTenant_WeightedDequeue(t *testing.T) {
+	engine := jobqueue.New(jobqueue.WithTenantQuota("big", jobqueue.Quota{Weight: 3}))
+
+	// the burst of one tenant is queued ahead of the other's jobs
+	for i := 0; i < 20; i++ {
+		if _, err := engine.Enqueue(tenantJob("big")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 20; i++ {
+		if _, err := engine.Enqueue(tenantJob("small")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	counts := map[string]int{}
+	for i := 0; i < 8; i++ {
+		job, err := engine.TryDequeue(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts[job.Tenant]++
+	}
+	if counts["big"] != 6 || counts["small"] != 2 {
+		t.Errorf("expected 6 jobs of big and 2 of small, got %v", counts)
+	}
+
+	job, err := engine.TryDequeueFrom("small", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Tenant != "small" {
+		t.Errorf("expected a job of tenant %q, got %q", "small", job.Tenant)
+	}
+	if _, err := engine.TryDequeueFrom("none", 1); !errors.Is(err, jobqueue.ErrNoJob) {
+		t.Errorf("expected %v, got %v", jobqueue.ErrNoJob, err)
+	}
+}
+
+func TestTenant_Isolation(t *testing.T) {
+	t.Parallel()
+	router, err := handlers.NewRouter(handlers.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+
+	ctx := context.Background()
+	teamA := client.New(server.URL, client.WithTenant("team-a"))
+	teamB := client.New(server.URL, client.WithTenant("team-b"))
+
+	id, err := teamA.Enqueue(ctx, jobqueue.Job{Type: jobqueue.TypeTimeCritical, Status: jobqueue.StatusQueued})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := teamB.Job(ctx, id); !errors.Is(err, jobqueue.ErrNotFound) {
+		t.Errorf("expected %v, got %v", jobqueue.ErrNotFound, err)
+	}
+	if err := teamB.Cancel(ctx, id); !errors.Is(err, jobqueue.ErrNotFound) {
+		t.Errorf("expected %v, got %v", jobqueue.ErrNotFound, err)
+	}
+	if _, err := teamB.Dequeue(ctx, 1, 0); !errors.Is(err, jobqueue.ErrNoJob) {
+		t.Errorf("expected %v, got %v", jobqueue.ErrNoJob, err)
+	}
+	if jobs, err := teamB.List(ctx, "", ""); err != nil || len(jobs) != 0 {
+		t.Errorf("expected no jobs for team-b, got %v, %v", jobs, err)
+	}
+	if stats, err := teamB.Stats(ctx); err != nil || stats.Total != 0 {
+		t.Errorf("expected empty stats for team-b, got %+v, %v", stats, err)
+	}
+
+	job, err := teamA.Job(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Tenant != "team-a" {
+		t.Errorf("expected tenant %q, got %q", "team-a", job.Tenant)
+	}
+	// clients without a tenant see every tenant
+	if stats, err := client.New(server.URL).Stats(ctx); err != nil || stats.Total != 1 {
+		t.Errorf("expected 1 job in total, got %+v, %v", stats, err)
+	}
+}
+
+func TestTenant_AuthenticatedClientsCannotPickTenant(t *testing.T) {
+	t.Parallel()
+	keys, err := auth.LoadKeys("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	adminKey, err := keys.Add(auth.Identity{Name: "ops", Role: auth.RoleAdmin})
+	if err != nil {
+		t.Fatal(err)
+	}
+	teamKey, err := keys.Add(auth.Identity{Name: "team-a", Role: auth.RoleAdmin, Tenant: "team-a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	router, err := handlers.NewRouter(handlers.Options{Auth: auth.NewAuthenticator(keys, testSecret)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+
+	ctx := context.Background()
+	teamA := client.New(server.URL, client.WithToken(teamKey))
+	if _, err := teamA.Enqueue(ctx, tenantJob("")); err != nil {
+		t.Fatal(err)
+	}
+
+	// the header cannot confine a client without a tenant, nor move a client to another tenant
+	var apiErr *client.APIError
+	for _, c := range []*client.Client{
+		client.New(server.URL, client.WithToken(adminKey), client.WithTenant("team-a")),
+		client.New(server.URL, client.WithToken(teamKey), client.WithTenant("team-b")),
+	} {
+		if _, err := c.Stats(ctx); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusForbidden {
+			t.Errorf("expected the tenant header to be forbidden, got %v", err)
+		}
+	}
+	if stats, err := client.New(server.URL, client.WithToken(adminKey)).Stats(ctx); err != nil || stats.Total != 1 {
+		t.Errorf("expected the admin without a tenant to see every job, got %+v, %v", stats, err)
+	}
+	if stats, err := client.New(server.URL, client.WithToken(teamKey), client.WithTenant("team-a")).Stats(ctx); err != nil || stats.Total != 1 {
+		t.Errorf("expected the header naming the client's own tenant to be accepted, got %+v, %v", stats, err)
+	}
+}
@@ -0,0 +1,202 @@
+package test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/varungujarathi9/job-queue/internal/certs"
+	"github.com/varungujarathi9/job-queue/internal/handlers"
+	"github.com/varungujarathi9/job-queue/pkg/client"
+	"github.com/varungujarathi9/job-queue/pkg/jobqueue"
+)
+
+// testCA signs the certificates of a test
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns the PEM certificate and key of a new certificate for name
+func (ca *testCA) issue(t *testing.T, name string, serial int64, usage x509.ExtKeyUsage) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+// tlsClient returns a client for server presenting the certificate issued to name
+func tlsClient(t *testing.T, ca *testCA, server string, name string) *client.Client {
+	certPEM, keyPEM := ca.issue(t, name, 10, x509.ExtKeyUsageClientAuth)
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	transport := &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: []tls.Certificate{cert}}}
+	return client.New(server, client.WithHTTPClient(&http.Client{Transport: transport}))
+}
+
+func writeFile(t *testing.T, path string, data []byte) {
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTLS_ClientCertificateIdentity(t *testing.T) {
+	t.Parallel()
+	ca := newTestCA(t)
+	dir := t.TempDir()
+	opts := certs.Options{
+		CertFile:     filepath.Join(dir, "server.pem"),
+		KeyFile:      filepath.Join(dir, "server-key.pem"),
+		ClientCAFile: filepath.Join(dir, "ca.pem"),
+		ClientAuth:   certs.ClientAuthRequire,
+	}
+	certPEM, keyPEM := ca.issue(t, "server", 2, x509.ExtKeyUsageServerAuth)
+	writeFile(t, opts.CertFile, certPEM)
+	writeFile(t, opts.KeyFile, keyPEM)
+	writeFile(t, opts.ClientCAFile, ca.pem)
+
+	reloader, err := certs.New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	router, err := handlers.NewRouter(handlers.Options{Consumers: map[string]int{"worker-a": 7}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewUnstartedServer(router)
+	server.TLS = reloader.TLSConfig()
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	ctx := context.Background()
+	producer := tlsClient(t, ca, server.URL, "producer")
+	worker := tlsClient(t, ca, server.URL, "worker-a")
+
+	if _, err := producer.Enqueue(ctx, jobqueue.Job{Type: jobqueue.TypeTimeCritical, Status: jobqueue.StatusQueued}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the consumer ID comes from the certificate, not from the header
+	var apiErr *client.APIError
+	if _, err := producer.Dequeue(ctx, 7, 0); !errors.As(err, &apiErr) || apiErr.Message != "Client is not a consumer" {
+		t.Errorf("expected producer dequeue to be refused, got %v", err)
+	}
+	if _, err := worker.Dequeue(ctx, 8, 0); !errors.As(err, &apiErr) || apiErr.Message != "QUEUE_CONSUMER does not match client identity" {
+		t.Errorf("expected mismatched consumer to be refused, got %v", err)
+	}
+	job, err := worker.Dequeue(ctx, 7, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.ConsumedBy != 7 {
+		t.Errorf("expected job consumed by %d, got %d", 7, job.ConsumedBy)
+	}
+}
+
+func TestTLS_ReloadsRotatedCertificate(t *testing.T) {
+	t.Parallel()
+	ca := newTestCA(t)
+	dir := t.TempDir()
+	opts := certs.Options{CertFile: filepath.Join(dir, "server.pem"), KeyFile: filepath.Join(dir, "server-key.pem")}
+	certPEM, keyPEM := ca.issue(t, "server", 2, x509.ExtKeyUsageServerAuth)
+	writeFile(t, opts.CertFile, certPEM)
+	writeFile(t, opts.KeyFile, keyPEM)
+
+	reloader, err := certs.New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewUnstartedServer(http.NotFoundHandler())
+	server.TLS = reloader.TLSConfig()
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	serial := func() int64 {
+		conn, err := tls.Dial("tcp", server.Listener.Addr().String(), &tls.Config{RootCAs: roots})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		return conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64()
+	}
+	if got := serial(); got != 2 {
+		t.Fatalf("expected certificate serial %d, got %d", 2, got)
+	}
+
+	certPEM, keyPEM = ca.issue(t, "server", 3, x509.ExtKeyUsageServerAuth)
+	writeFile(t, opts.CertFile, certPEM)
+	writeFile(t, opts.KeyFile, keyPEM)
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(opts.CertFile, later, later)
+	os.Chtimes(opts.KeyFile, later, later)
+
+	// changes are picked up on the first handshake after the check interval
+	deadline := time.Now().Add(3 * time.Second)
+	for serial() != 3 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the rotated certificate to be served")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
@@ -0,0 +1,115 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/varungujarathi9/job-queue/internal/handlers"
+	"github.com/varungujarathi9/job-queue/internal/tracing"
+	"github.com/varungujarathi9/job-queue/pkg/client"
+	"github.com/varungujarathi9/job-queue/pkg/jobqueue"
+)
+
+const (
+	testTraceID     = "4bf92f3577b34da6a3ce929d0e0e4736"
+	testTraceParent = "00-" + testTraceID + "-00f067aa0ba902b7-01"
+)
+
+func TestTracing_PropagatesTraceParent(t *testing.T) {
+	t.Parallel()
+	router, err := handlers.NewRouter(handlers.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+
+	body, _ := json.Marshal(jobqueue.Job{Type: jobqueue.TypeTimeCritical, Status: jobqueue.StatusQueued})
+	req, _ := http.NewRequest("POST", server.URL+"/jobs/enqueue", bytes.NewReader(body))
+	req.Header.Set("traceparent", testTraceParent)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	req, _ = http.NewRequest("GET", server.URL+"/jobs/dequeue", nil)
+	req.Header.Set("QUEUE_CONSUMER", "1")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var job jobqueue.Job
+	if err := json.NewDecoder(resp.Body).Decode(&job); err != nil {
+		t.Fatal(err)
+	}
+
+	// without an exporter the producer's trace context is handed on unchanged
+	if job.TraceParent != testTraceParent {
+		t.Errorf("expected job trace parent %s, got %s", testTraceParent, job.TraceParent)
+	}
+	if got := resp.Header.Get("traceparent"); got != testTraceParent {
+		t.Errorf("expected traceparent header %s, got %s", testTraceParent, got)
+	}
+
+	// jobs enqueued without a trace context have none
+	c := client.New(server.URL)
+	if _, err := c.Enqueue(context.Background(), jobqueue.Job{Type: jobqueue.TypeTimeCritical, Status: jobqueue.StatusQueued}); err != nil {
+		t.Fatal(err)
+	}
+	if job, err := c.Dequeue(context.Background(), 1, 0); err != nil || job.TraceParent != "" {
+		t.Errorf("expected a job without trace context, got %q, %v", job.TraceParent, err)
+	}
+}
+
+func TestTracing_FileExporter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traces.json")
+	tracer, err := tracing.New(tracing.Options{Exporter: tracing.File, Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := mux.NewRouter()
+	router.Use(tracer.Middleware)
+	router.HandleFunc("/ping", func(w http.ResponseWriter, r *http.Request) {}).Name("ping")
+	req := httptest.NewRequest("GET", "/ping", nil)
+	req.Header.Set("traceparent", testTraceParent)
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	now := time.Now()
+	tracer.RecordWait(jobqueue.Job{ID: 1, Type: jobqueue.TypeTimeCritical, TraceParent: testTraceParent, EnqueueTime: now.Add(-time.Second), DequeueTime: now})
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(lines))
+	}
+	for i, name := range []string{"GET ping", "queue wait"} {
+		var span struct {
+			Name        string
+			SpanContext struct{ TraceID string }
+		}
+		if err := json.Unmarshal([]byte(lines[i]), &span); err != nil {
+			t.Fatal(err)
+		}
+		if span.Name != name || span.SpanContext.TraceID != testTraceID {
+			t.Errorf("expected span %q in trace %s, got %q in trace %s", name, testTraceID, span.Name, span.SpanContext.TraceID)
+		}
+	}
+}
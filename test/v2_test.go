@@ -0,0 +1,186 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/varungujarathi9/job-queue/internal/apierror"
+	"github.com/varungujarathi9/job-queue/internal/handlers"
+	"github.com/varungujarathi9/job-queue/internal/services"
+	"github.com/varungujarathi9/job-queue/pkg/jobqueue"
+)
+
+// v2Request sends a request to router and decodes a JSON response into out when it is not nil
+func v2Request(t *testing.T, router *mux.Router, method, target string, body interface{}, header http.Header, out interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req := httptest.NewRequest(method, target, &buf)
+	for key, values := range header {
+		req.Header.Set(key, values[0])
+	}
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if out != nil && rr.Code < 300 && rr.Code != http.StatusNoContent {
+		if err := json.NewDecoder(bytes.NewReader(rr.Body.Bytes())).Decode(out); err != nil {
+			t.Fatalf("%s %s: %v", method, target, err)
+		}
+	}
+	return rr
+}
+
+func TestV2_JobLifecycle(t *testing.T) {
+	t.Parallel()
+	router, err := handlers.NewRouter(handlers.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	consumer := http.Header{"QUEUE_CONSUMER": {"3"}}
+
+	var job services.JobResource
+	rr := v2Request(t, router, "POST", "/v2/jobs", services.JobRequest{Queue: "emails", Type: jobqueue.TypeTimeCritical, Payload: "hello"}, nil, &job)
+	if rr.Code != http.StatusCreated || rr.Header().Get("Location") != "/v2/jobs/1" {
+		t.Fatalf("expected job 1 to be created, got %d at %q", rr.Code, rr.Header().Get("Location"))
+	}
+	if job.Queue != "emails" || job.Status != jobqueue.StatusQueued || job.Payload != "hello" {
+		t.Errorf("expected a queued job in emails, got %+v", job)
+	}
+
+	// a job enqueued through the v1 routes lands in the default queue of the same engine
+	v2Request(t, router, "POST", "/jobs/enqueue", jobqueue.Job{Type: jobqueue.TypeTimeCritical, Status: jobqueue.StatusQueued}, nil, nil)
+	var queues services.QueueList
+	v2Request(t, router, "GET", "/v2/queues", nil, nil, &queues)
+	if len(queues.Queues) != 2 || queues.Queues[0].Name != services.DefaultQueue || queues.Queues[1] != (services.Queue{Name: "emails", Queued: 1}) {
+		t.Errorf("expected the default and emails queues with one job each, got %+v", queues.Queues)
+	}
+
+	if rr := v2Request(t, router, "POST", "/v2/queues/reports/leases", nil, consumer, nil); rr.Code != http.StatusNoContent {
+		t.Errorf("expected no lease on an empty queue, got %d", rr.Code)
+	}
+	var lease services.Lease
+	rr = v2Request(t, router, "POST", "/v2/queues/emails/leases", services.LeaseRequest{Types: []string{jobqueue.TypeTimeCritical}}, consumer, &lease)
+	if rr.Code != http.StatusCreated || lease.JobID != 1 || lease.Attempt != 1 || lease.Consumer != 3 || !lease.Expires.After(lease.Renewed) {
+		t.Fatalf("expected a lease on job 1, got %d %+v", rr.Code, lease)
+	}
+	if rr := v2Request(t, router, "PUT", "/v2/leases/1", nil, consumer, &lease); rr.Code != http.StatusOK || lease.Job.Status != jobqueue.StatusInProgress {
+		t.Errorf("expected the lease to be renewed, got %d %+v", rr.Code, lease)
+	}
+	if rr := v2Request(t, router, "PUT", "/v2/leases/1", nil, http.Header{"QUEUE_CONSUMER": {"4"}}, nil); rr.Code != http.StatusConflict {
+		t.Errorf("expected another consumer not to renew the lease, got %d", rr.Code)
+	}
+
+	var attempt jobqueue.Attempt
+	rr = v2Request(t, router, "PATCH", "/v2/jobs/1/attempts/1", services.AttemptUpdate{Status: jobqueue.OutcomeConcluded, Result: "sent"}, consumer, &attempt)
+	if rr.Code != http.StatusOK || attempt.Outcome != jobqueue.OutcomeConcluded || attempt.Ended == nil {
+		t.Errorf("expected the attempt to be concluded, got %d %+v", rr.Code, attempt)
+	}
+	if rr := v2Request(t, router, "GET", "/v2/leases/1", nil, nil, nil); rr.Code != http.StatusNotFound {
+		t.Errorf("expected no lease on a concluded job, got %d", rr.Code)
+	}
+	if rr := v2Request(t, router, "PATCH", "/v2/jobs/1/attempts/1", services.AttemptUpdate{Status: jobqueue.OutcomeFailed}, consumer, nil); rr.Code != http.StatusConflict {
+		t.Errorf("expected an ended attempt not to be changed, got %d", rr.Code)
+	}
+
+	var copied services.JobResource
+	rr = v2Request(t, router, "POST", "/v2/jobs/1/copies", nil, nil, &copied)
+	if rr.Code != http.StatusCreated || copied.Queue != "emails" || copied.Status != jobqueue.StatusQueued {
+		t.Errorf("expected a queued copy in emails, got %d %+v", rr.Code, copied)
+	}
+	rr = v2Request(t, router, "PATCH", "/v2/jobs/"+strconv.Itoa(copied.ID), services.JobUpdate{Status: services.StatusCancelled}, nil, &copied)
+	if rr.Code != http.StatusOK || copied.Status != services.StatusCancelled {
+		t.Errorf("expected the copy to be cancelled, got %d %+v", rr.Code, copied)
+	}
+
+	var list services.JobList
+	v2Request(t, router, "GET", "/v2/jobs?queue=emails&status=CANCELLED", nil, nil, &list)
+	if len(list.Jobs) != 1 || list.Jobs[0].ID != copied.ID {
+		t.Errorf("expected the cancelled copy to be listed, got %+v", list.Jobs)
+	}
+
+	var attempts services.AttemptList
+	v2Request(t, router, "GET", "/v2/jobs/1/attempts", nil, nil, &attempts)
+	if len(attempts.Attempts) != 1 || attempts.Attempts[0].Consumer != 3 {
+		t.Errorf("expected one attempt by consumer 3, got %+v", attempts.Attempts)
+	}
+	var job1 services.JobResource
+	v2Request(t, router, "GET", "/v2/jobs/1", nil, nil, &job1)
+	if job1.Status != jobqueue.StatusConcluded || job1.Result != "sent" {
+		t.Errorf("expected job 1 concluded with its result, got %+v", job1)
+	}
+}
+
+func TestV2_Errors(t *testing.T) {
+	t.Parallel()
+	router, err := handlers.NewRouter(handlers.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		method, target string
+		body           interface{}
+		status         int
+		code           string
+	}{
+		{"GET", "/v2/jobs/9", nil, http.StatusNotFound, apierror.CodeNotFound},
+		{"POST", "/v2/jobs", services.JobRequest{Type: "SOON"}, http.StatusUnprocessableEntity, apierror.CodeInvalidType},
+		{"PATCH", "/v2/jobs/9", services.JobUpdate{Status: jobqueue.StatusConcluded}, http.StatusBadRequest, apierror.CodeInvalidRequest},
+		{"GET", "/v2/jobs/9/attempts/1", nil, http.StatusNotFound, apierror.CodeNotFound},
+		{"POST", "/v2/queues/emails/leases", services.LeaseRequest{Wait: "soon"}, http.StatusBadRequest, apierror.CodeInvalidRequest},
+	} {
+		header := http.Header{"QUEUE_CONSUMER": {"1"}}
+		rr := v2Request(t, router, tc.method, tc.target, tc.body, header, nil)
+		var body apierror.Error
+		json.NewDecoder(rr.Body).Decode(&body)
+		if rr.Code != tc.status || body.Code != tc.code {
+			t.Errorf("%s %s: expected %d %s, got %d %+v", tc.method, tc.target, tc.status, tc.code, rr.Code, body)
+		}
+	}
+}
+
+func TestV2_OpenAPI(t *testing.T) {
+	t.Parallel()
+	router, err := handlers.NewRouter(handlers.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var doc struct {
+		OpenAPI    string                                `json:"openapi"`
+		Paths      map[string]map[string]json.RawMessage `json:"paths"`
+		Components struct {
+			Schemas map[string]struct {
+				Properties map[string]interface{} `json:"properties"`
+			} `json:"schemas"`
+		} `json:"components"`
+	}
+	if rr := v2Request(t, router, "GET", services.OpenAPIPath, nil, nil, &doc); rr.Code != http.StatusOK {
+		t.Fatalf("expected the OpenAPI document, got %d", rr.Code)
+	}
+	if doc.OpenAPI == "" {
+		t.Error("expected an OpenAPI version")
+	}
+
+	// every v2 route is documented
+	routes := services.New().V2().Routes()
+	for _, route := range routes {
+		if _, ok := doc.Paths[route.Path][strings.ToLower(route.Method)]; !ok {
+			t.Errorf("expected %s %s to be documented", route.Method, route.Path)
+		}
+	}
+	for name, property := range map[string]string{"Lease": "Expires", "JobResource": "Status", "Error": "Code", "Attempt": "Outcome"} {
+		if _, ok := doc.Components.Schemas[name].Properties[property]; !ok {
+			t.Errorf("expected schema %s with property %s, got %+v", name, property, doc.Components.Schemas[name])
+		}
+	}
+}
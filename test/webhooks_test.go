@@ -0,0 +1,235 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/varungujarathi9/job-queue/internal/handlers"
+	"github.com/varungujarathi9/job-queue/internal/services"
+	"github.com/varungujarathi9/job-queue/internal/webhooks"
+	"github.com/varungujarathi9/job-queue/pkg/client"
+	"github.com/varungujarathi9/job-queue/pkg/jobqueue"
+	"github.com/varungujarathi9/job-queue/pkg/webhook"
+)
+
+const webhookSecret = "0123456789abcdef0123456789abcdef"
+
+// waitDelivery polls the delivery log until a delivery matching query has the wanted status
+func waitDelivery(t *testing.T, handler http.Handler, query, status string) webhooks.Delivery {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var list webhooks.DeliveryList
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", "/webhooks/deliveries?"+query, nil))
+		json.NewDecoder(rr.Body).Decode(&list)
+		for _, delivery := range list.Deliveries {
+			if delivery.Status == status {
+				return delivery
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("no %s delivery for %s, got %+v", status, query, list.Deliveries)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWebhooks_JobCallback(t *testing.T) {
+	t.Parallel()
+	// the receiver refuses the first attempt and records what it verified after that
+	var calls atomic.Int32
+	changes := make(chan jobqueue.Change, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		change, err := client.VerifyWebhook(r, webhookSecret)
+		if err != nil {
+			t.Errorf("expected a valid signature, got %v", err)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.Header.Get(webhook.EventHeader) != change.Op {
+			t.Errorf("expected event header %s, got %s", change.Op, r.Header.Get(webhook.EventHeader))
+		}
+		changes <- change
+	}))
+	defer receiver.Close()
+
+	router, err := handlers.NewRouter(handlers.Options{Webhooks: webhooks.Options{Secret: webhookSecret, Backoff: 10 * time.Millisecond}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	consumer := http.Header{"QUEUE_CONSUMER": {"1"}}
+	var job services.JobResource
+	v2Request(t, router, "POST", "/v2/jobs", services.JobRequest{Type: jobqueue.TypeTimeCritical, Callback: receiver.URL}, nil, &job)
+	v2Request(t, router, "POST", "/v2/queues/default/leases", nil, consumer, nil)
+	v2Request(t, router, "PATCH", "/v2/jobs/"+strconv.Itoa(job.ID)+"/attempts/1", services.AttemptUpdate{Status: jobqueue.OutcomeConcluded}, consumer, nil)
+
+	// only the conclude is sent by default, after one retry
+	select {
+	case change := <-changes:
+		if change.Op != jobqueue.OpConclude || change.Job.ID != job.ID || change.Job.Status != jobqueue.StatusConcluded {
+			t.Errorf("expected the conclude of job %d, got %+v", job.ID, change)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the callback to be delivered")
+	}
+	delivered := waitDelivery(t, router, "job_id="+strconv.Itoa(job.ID), webhooks.StatusDelivered)
+	if delivered.Attempts != 2 || delivered.Event != jobqueue.OpConclude || delivered.Subscription != 0 {
+		t.Errorf("expected the conclude delivered on the second attempt, got %+v", delivered)
+	}
+
+	// a redelivery is a new delivery of the same change
+	rr := v2Request(t, router, "POST", "/webhooks/deliveries/"+strconv.Itoa(delivered.ID)+"/redeliver", nil, nil, nil)
+	var redelivery webhooks.Delivery
+	json.NewDecoder(rr.Body).Decode(&redelivery)
+	if rr.Code != http.StatusAccepted || redelivery.Redelivers != delivered.ID || redelivery.Seq != delivered.Seq {
+		t.Fatalf("expected a redelivery of %d, got %d %+v", delivered.ID, rr.Code, redelivery)
+	}
+	select {
+	case change := <-changes:
+		if change.Seq != delivered.Seq {
+			t.Errorf("expected change %d again, got %+v", delivered.Seq, change)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the redelivery to be sent")
+	}
+	if rr := v2Request(t, router, "POST", "/webhooks/deliveries/99/redeliver", nil, nil, nil); rr.Code != http.StatusNotFound {
+		t.Errorf("expected an unknown delivery not to be found, got %d", rr.Code)
+	}
+}
+
+func TestWebhooks_Subscription(t *testing.T) {
+	t.Parallel()
+	received := make(chan *http.Request, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer receiver.Close()
+
+	router, err := handlers.NewRouter(handlers.Options{Webhooks: webhooks.Options{Secret: webhookSecret, MaxAttempts: 2, Backoff: 10 * time.Millisecond}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sub webhooks.Subscription
+	rr := v2Request(t, router, "POST", "/webhooks", webhooks.Subscription{URL: receiver.URL, Events: []string{jobqueue.OpCancel}, Queue: "emails", Secret: "own secret"}, nil, &sub)
+	if rr.Code != http.StatusCreated || sub.ID != 1 || sub.Secret != "" {
+		t.Fatalf("expected subscription 1 without its secret, got %d %+v", rr.Code, sub)
+	}
+	if rr := v2Request(t, router, "POST", "/webhooks", webhooks.Subscription{URL: receiver.URL, Events: []string{"SOON"}}, nil, nil); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected an unknown event to be refused, got %d", rr.Code)
+	}
+
+	var other, email services.JobResource
+	v2Request(t, router, "POST", "/v2/jobs", services.JobRequest{Type: jobqueue.TypeTimeCritical}, nil, &other)
+	v2Request(t, router, "POST", "/v2/jobs", services.JobRequest{Queue: "emails", Type: jobqueue.TypeTimeCritical}, nil, &email)
+	v2Request(t, router, "PATCH", "/v2/jobs/"+strconv.Itoa(other.ID), services.JobUpdate{Status: services.StatusCancelled}, nil, nil)
+	v2Request(t, router, "PATCH", "/v2/jobs/"+strconv.Itoa(email.ID), services.JobUpdate{Status: services.StatusCancelled}, nil, nil)
+
+	// the delivery fails after both attempts, signed with the subscription's secret
+	failed := waitDelivery(t, router, "webhook_id=1&status="+webhooks.StatusFailed, webhooks.StatusFailed)
+	if failed.JobID != email.ID || failed.Attempts != 2 || failed.ResponseStatus != http.StatusInternalServerError || failed.Error == "" {
+		t.Errorf("expected the cancel of job %d to fail twice, got %+v", email.ID, failed)
+	}
+	r := <-received
+	if r.Header.Get(webhook.SignatureHeader) == "" || r.Header.Get(webhook.DeliveryHeader) != strconv.Itoa(failed.ID) {
+		t.Errorf("expected a signed delivery %d, got headers %v", failed.ID, r.Header)
+	}
+	var all webhooks.DeliveryList
+	v2Request(t, router, "GET", "/webhooks/deliveries", nil, nil, &all)
+	if len(all.Deliveries) != 1 {
+		t.Errorf("expected only the emails job to be delivered, got %+v", all.Deliveries)
+	}
+
+	if rr := v2Request(t, router, "DELETE", "/webhooks/1", nil, nil, nil); rr.Code != http.StatusOK {
+		t.Errorf("expected the subscription to be deleted, got %d", rr.Code)
+	}
+	if rr := v2Request(t, router, "GET", "/webhooks/1", nil, nil, nil); rr.Code != http.StatusNotFound {
+		t.Errorf("expected the subscription to be gone, got %d", rr.Code)
+	}
+}
+
+func TestWebhooks_Disabled(t *testing.T) {
+	t.Parallel()
+	router, err := handlers.NewRouter(handlers.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	job := jobqueue.Job{Type: jobqueue.TypeTimeCritical, Status: jobqueue.StatusQueued, Callback: "http://localhost:9/callback"}
+	if rr := v2Request(t, router, "POST", "/jobs/enqueue", job, nil, nil); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected a callback to be refused without webhooks, got %d", rr.Code)
+	}
+	if rr := v2Request(t, router, "GET", "/webhooks", nil, nil, nil); rr.Code != http.StatusNotFound {
+		t.Errorf("expected no webhook routes without webhooks, got %d", rr.Code)
+	}
+}
+
+func TestWebhooks_CloseSendsRecordedChanges(t *testing.T) {
+	t.Parallel()
+	var calls atomic.Int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer receiver.Close()
+
+	engine := jobqueue.New()
+	dispatcher := webhooks.New(engine, webhooks.Options{
+		Secret:        webhookSecret,
+		Backoff:       time.Hour,
+		Subscriptions: []webhooks.Subscription{{URL: receiver.URL, Events: []string{jobqueue.OpEnqueue}}},
+	})
+	go dispatcher.Run()
+	for i := 0; i < 3; i++ {
+		engine.Enqueue(jobqueue.Job{Type: jobqueue.TypeTimeCritical, Status: jobqueue.StatusQueued})
+	}
+
+	// the changes recorded before Close are sent once, their retries are dropped
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	start := time.Now()
+	if err := dispatcher.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("expected Close not to wait for the retries, took %v", elapsed)
+	}
+	if calls.Load() != 3 {
+		t.Errorf("expected 3 deliveries sent before Close returned, got %d", calls.Load())
+	}
+	for _, delivery := range dispatcher.Deliveries(func(webhooks.Delivery) bool { return true }) {
+		if delivery.Attempts != 1 || delivery.NextAttempt != nil || delivery.Status != webhooks.StatusPending {
+			t.Errorf("expected one attempt and no retry, got %+v", delivery)
+		}
+	}
+}
+
+func TestWebhooks_Verify(t *testing.T) {
+	t.Parallel()
+	body := []byte(`{"Seq":1}`)
+	now := time.Now()
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	signature := webhook.Sign(webhookSecret, timestamp, body)
+	if err := webhook.Verify(webhookSecret, timestamp, signature, body, now, time.Minute); err != nil {
+		t.Errorf("expected a valid signature, got %v", err)
+	}
+	for name, err := range map[string]error{
+		"other secret": webhook.Verify("other", timestamp, signature, body, now, time.Minute),
+		"other body":   webhook.Verify(webhookSecret, timestamp, signature, []byte(`{"Seq":2}`), now, time.Minute),
+		"too old":      webhook.Verify(webhookSecret, timestamp, signature, body, now.Add(2*time.Minute), time.Minute),
+	} {
+		if err != webhook.ErrInvalidSignature {
+			t.Errorf("%s: expected %v, got %v", name, webhook.ErrInvalidSignature, err)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/varungujarathi9/job-queue/pkg/client"
+	"github.com/varungujarathi9/job-queue/pkg/jobqueue"
+	"github.com/varungujarathi9/job-queue/pkg/worker"
+)
+
+// waitForStatus polls the job until it reaches the expected status
+func waitForStatus(t *testing.T, c *client.Client, id int, status string) jobqueue.Job {
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		job, err := c.Job(context.Background(), id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job.Status == status {
+			return job
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected job status %q, got %q", status, job.Status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWorker_ConcludesAndFails(t *testing.T) {
+	t.Parallel()
+	c := newTestClient(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	w := worker.New(c, 5, worker.WithConcurrency(2), worker.WithPollWait(50*time.Millisecond))
+	w.Handle(jobqueue.TypeTimeCritical, func(ctx context.Context, job jobqueue.Job) (interface{}, error) {
+		return "done", nil
+	})
+	w.Handle(jobqueue.TypeNotTimeCritical, func(ctx context.Context, job jobqueue.Job) (interface{}, error) {
+		return nil, errors.New("boom")
+	})
+	go w.Run(ctx)
+
+	concludedID, _ := c.Enqueue(ctx, jobqueue.Job{Type: jobqueue.TypeTimeCritical, Status: jobqueue.StatusQueued})
+	failedID, _ := c.Enqueue(ctx, jobqueue.Job{Type: jobqueue.TypeNotTimeCritical, Status: jobqueue.StatusQueued})
+
+	concluded := waitForStatus(t, c, concludedID, jobqueue.StatusConcluded)
+	if concluded.Result != "done" {
+		t.Errorf("expected job result %q, got %v", "done", concluded.Result)
+	}
+
+	failed := waitForStatus(t, c, failedID, jobqueue.StatusFailed)
+	if failed.Error != "boom" {
+		t.Errorf("expected job error %q, got %q", "boom", failed.Error)
+	}
+}
+
+func TestWorker_CancelStopsHandler(t *testing.T) {
+	t.Parallel()
+	c := newTestClient(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	started := make(chan int, 1)
+	stopped := make(chan error, 1)
+	w := worker.New(c, 6, worker.WithHeartbeatInterval(20*time.Millisecond), worker.WithPollWait(50*time.Millisecond))
+	w.Handle(jobqueue.TypeTimeCritical, func(ctx context.Context, job jobqueue.Job) (interface{}, error) {
+		started <- job.ID
+		<-ctx.Done()
+		stopped <- ctx.Err()
+		return nil, ctx.Err()
+	})
+	go w.Run(ctx)
+
+	id, _ := c.Enqueue(ctx, jobqueue.Job{Type: jobqueue.TypeTimeCritical, Status: jobqueue.StatusQueued})
+	<-started
+	if err := c.Cancel(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-stopped:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("expected handler context error %v, got %v", context.Canceled, err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected handler context to be cancelled")
+	}
+}
+
+func TestWorker_ReportsResultOnShutdown(t *testing.T) {
+	t.Parallel()
+	c := newTestClient(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	started := make(chan struct{})
+	w := worker.New(c, 7, worker.WithPollWait(50*time.Millisecond))
+	w.Handle(jobqueue.TypeTimeCritical, func(ctx context.Context, job jobqueue.Job) (interface{}, error) {
+		close(started)
+		// the handler finishes its work even though the worker stops meanwhile
+		<-ctx.Done()
+		return "done", nil
+	})
+	stopped := make(chan struct{})
+	go func() {
+		w.Run(ctx)
+		close(stopped)
+	}()
+
+	id, _ := c.Enqueue(ctx, jobqueue.Job{Type: jobqueue.TypeTimeCritical, Status: jobqueue.StatusQueued})
+	<-started
+	cancel()
+	<-stopped
+
+	job, err := c.Job(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != jobqueue.StatusConcluded || job.Result != "done" {
+		t.Errorf("expected the job concluded with result %q, got %+v", "done", job)
+	}
+}
+
+func TestWorker_LostLeaseCannotConclude(t *testing.T) {
+	t.Parallel()
+	c := newTestClient(t)
+	ctx := context.Background()
+
+	id, _ := c.Enqueue(ctx, jobqueue.Job{Type: jobqueue.TypeTimeCritical, Status: jobqueue.StatusQueued})
+	if _, err := c.Dequeue(ctx, 8, 0); err != nil {
+		t.Fatal(err)
+	}
+	// a consumer whose lease expired before the job went to consumer 8 reports late
+	if err := c.ConcludeAs(ctx, id, 9, "stale"); !errors.Is(err, jobqueue.ErrNotOwner) {
+		t.Errorf("expected error %v, got %v", jobqueue.ErrNotOwner, err)
+	}
+	if err := c.ConcludeAs(ctx, id, 8, "fresh"); err != nil {
+		t.Fatal(err)
+	}
+	if job, _ := c.Job(ctx, id); job.Result != "fresh" {
+		t.Errorf("expected result %q, got %v", "fresh", job.Result)
+	}
+}